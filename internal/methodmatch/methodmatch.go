@@ -0,0 +1,49 @@
+/*
+Copyright 2026 Chainguard, Inc.
+SPDX-License-Identifier: Apache-2.0
+*/
+
+// Package methodmatch matches gRPC method names, or other names, against
+// patterns. A pattern is either an exact name, such as "/pkg.Service/Method",
+// or a prefix ending in "*", such as "/pkg.Service/*".
+package methodmatch
+
+import "strings"
+
+// Match reports whether name matches pattern.
+func Match(pattern, name string) bool {
+	if prefix, ok := strings.CutSuffix(pattern, "*"); ok {
+		return strings.HasPrefix(name, prefix)
+	}
+	return pattern == name
+}
+
+// Any reports whether name matches any of patterns.
+func Any(patterns []string, name string) bool {
+	for _, p := range patterns {
+		if Match(p, name) {
+			return true
+		}
+	}
+	return false
+}
+
+// Best returns the value in patterns of the pattern that best matches name:
+// name itself, or else the longest matching prefix.
+func Best[V any](patterns map[string]V, name string) (V, bool) {
+	if v, ok := patterns[name]; ok {
+		return v, true
+	}
+	var (
+		best  V
+		found bool
+		size  = -1
+	)
+	for pattern, v := range patterns {
+		prefix, ok := strings.CutSuffix(pattern, "*")
+		if ok && strings.HasPrefix(name, prefix) && len(prefix) > size {
+			best, found, size = v, true, len(prefix)
+		}
+	}
+	return best, found
+}
@@ -0,0 +1,74 @@
+/*
+Copyright 2026 Chainguard, Inc.
+SPDX-License-Identifier: Apache-2.0
+*/
+
+package methodmatch
+
+import "testing"
+
+func TestMatch(t *testing.T) {
+	tests := []struct {
+		pattern, name string
+		want          bool
+	}{
+		{"/pkg.Service/Get", "/pkg.Service/Get", true},
+		{"/pkg.Service/Get", "/pkg.Service/GetAll", false},
+		{"/pkg.Service/*", "/pkg.Service/Get", true},
+		{"/pkg.Service/*", "/pkg.Other/Get", false},
+		{"/pkg.Service/Get*", "/pkg.Service/GetAll", true},
+		{"*", "/pkg.Service/Get", true},
+		{"", "/pkg.Service/Get", false},
+	}
+	for _, tt := range tests {
+		if got := Match(tt.pattern, tt.name); got != tt.want {
+			t.Errorf("Match(%q, %q) = %v, want %v", tt.pattern, tt.name, got, tt.want)
+		}
+	}
+}
+
+func TestAny(t *testing.T) {
+	patterns := []string{"/pkg.Service/Get", "/pkg.Admin/*"}
+	tests := []struct {
+		name string
+		want bool
+	}{
+		{"/pkg.Service/Get", true},
+		{"/pkg.Admin/Delete", true},
+		{"/pkg.Service/Delete", false},
+	}
+	for _, tt := range tests {
+		if got := Any(patterns, tt.name); got != tt.want {
+			t.Errorf("Any(%q) = %v, want %v", tt.name, got, tt.want)
+		}
+	}
+	if Any(nil, "/pkg.Service/Get") {
+		t.Error("Any(nil) = true, want false")
+	}
+}
+
+func TestBest(t *testing.T) {
+	patterns := map[string]int{
+		"/pkg.Service/Get":  1,
+		"/pkg.Service/Get*": 2,
+		"/pkg.Service/*":    3,
+		"/*":                4,
+	}
+	tests := []struct {
+		name    string
+		want    int
+		wantHit bool
+	}{
+		{"/pkg.Service/Get", 1, true},
+		{"/pkg.Service/GetAll", 2, true},
+		{"/pkg.Service/List", 3, true},
+		{"/pkg.Other/List", 4, true},
+		{"pkg.Other/List", 0, false},
+	}
+	for _, tt := range tests {
+		got, ok := Best(patterns, tt.name)
+		if got != tt.want || ok != tt.wantHit {
+			t.Errorf("Best(%q) = %v, %v; want %v, %v", tt.name, got, ok, tt.want, tt.wantHit)
+		}
+	}
+}
@@ -0,0 +1,156 @@
+/*
+Copyright 2026 Chainguard, Inc.
+SPDX-License-Identifier: Apache-2.0
+*/
+
+package duplex
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"sync"
+
+	middleware "github.com/grpc-ecosystem/go-grpc-middleware/v2"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	healthpb "google.golang.org/grpc/health/grpc_health_v1"
+	"google.golang.org/grpc/status"
+)
+
+const (
+	// LivenessPath is the HTTP path that reports whether the process is up.
+	// It answers 200 for as long as the server is serving, including while it
+	// drains, so a liveness probe does not restart a pod that is shutting down.
+	LivenessPath = "/healthz"
+
+	// ReadinessPath is the HTTP path that reports whether the server should
+	// receive traffic. It mirrors the gRPC health service: 200 while the
+	// checked service is SERVING, 503 otherwise. The service defaults to the
+	// overall server status ("") and may be selected with ?service=<name>.
+	ReadinessPath = "/readyz"
+)
+
+// serveLiveness answers LivenessPath.
+func (d *Duplex) serveLiveness(w http.ResponseWriter, _ *http.Request) {
+	w.Header().Set("Content-Type", "text/plain; charset=utf-8")
+	w.WriteHeader(http.StatusOK)
+	_, _ = w.Write([]byte("ok\n"))
+}
+
+// serveReadiness answers ReadinessPath from the gRPC health service, so the
+// HTTP and gRPC views of readiness never disagree.
+func (d *Duplex) serveReadiness(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "text/plain; charset=utf-8")
+
+	resp, err := d.Health.Check(r.Context(), &healthpb.HealthCheckRequest{
+		Service: r.URL.Query().Get("service"),
+	})
+	if err != nil {
+		// An unknown service is reported as NotFound by the health server.
+		w.WriteHeader(http.StatusNotFound)
+		_, _ = w.Write([]byte(err.Error() + "\n"))
+		return
+	}
+
+	status := resp.GetStatus()
+	if status != healthpb.HealthCheckResponse_SERVING {
+		w.WriteHeader(http.StatusServiceUnavailable)
+	} else {
+		w.WriteHeader(http.StatusOK)
+	}
+	_, _ = w.Write([]byte(status.String() + "\n"))
+}
+
+// watchTracker ends the health Watch streams being served once Shutdown has
+// told them their service is NOT_SERVING, so they do not hold the drain open.
+// Its zero value is ready to use.
+type watchTracker struct {
+	mu       sync.Mutex
+	watches  map[*watchStream]struct{}
+	draining bool
+}
+
+// watchStream is a health Watch stream, which records the last status it sent.
+type watchStream struct {
+	grpc.ServerStream
+	tracker *watchTracker
+	cancel  context.CancelCauseFunc
+
+	// serving is set until the stream sends a status other than SERVING. A
+	// stream is taken to be serving until it sends its first status, which
+	// the health server does at once.
+	serving bool
+}
+
+// interceptor returns the stream server interceptor that tracks health Watch
+// streams. A Watch ended by the tracker ends with codes.Unavailable, so its
+// client watches another server.
+func (t *watchTracker) interceptor() grpc.StreamServerInterceptor {
+	return func(srv any, ss grpc.ServerStream, info *grpc.StreamServerInfo, handler grpc.StreamHandler) error {
+		if info.FullMethod != healthpb.Health_Watch_FullMethodName {
+			return handler(srv, ss)
+		}
+		ctx, cancel := context.WithCancelCause(ss.Context())
+		defer cancel(nil)
+
+		wrapped := middleware.WrapServerStream(ss)
+		wrapped.WrappedContext = ctx
+		w := &watchStream{ServerStream: wrapped, tracker: t, cancel: cancel, serving: true}
+		t.add(w)
+		defer t.remove(w)
+
+		err := handler(srv, w)
+		if errors.Is(context.Cause(ctx), errShuttingDown) {
+			return status.Error(codes.Unavailable, errShuttingDown.Error())
+		}
+		return err
+	}
+}
+
+func (t *watchTracker) add(w *watchStream) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	if t.watches == nil {
+		t.watches = map[*watchStream]struct{}{}
+	}
+	t.watches[w] = struct{}{}
+}
+
+func (t *watchTracker) remove(w *watchStream) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	delete(t.watches, w)
+}
+
+// drain ends the Watch streams that have sent a status other than SERVING,
+// and the others, and any started later, as soon as they do. Shutdown drains
+// once the health server reports every service NOT_SERVING.
+func (t *watchTracker) drain() {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	t.draining = true
+	for w := range t.watches {
+		if !w.serving {
+			w.cancel(errShuttingDown)
+		}
+	}
+}
+
+func (w *watchStream) SendMsg(m any) error {
+	err := w.ServerStream.SendMsg(m)
+
+	t := w.tracker
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	if resp, ok := m.(*healthpb.HealthCheckResponse); ok {
+		w.serving = resp.GetStatus() == healthpb.HealthCheckResponse_SERVING
+	}
+	if t.draining && !w.serving {
+		w.cancel(errShuttingDown)
+	}
+	return err
+}
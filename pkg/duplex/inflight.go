@@ -7,33 +7,108 @@ package duplex
 
 import (
 	"context"
+	"encoding/json"
+	"net/http"
+	"slices"
 	"sync"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+
+	"chainguard.dev/go-grpc-kit/pkg/interceptors/clientid"
+)
+
+// RequestsPath is the path, on the metrics server or under the prefix
+// configured WithMetricsPath, of the debug endpoint that lists the requests in
+// flight as JSON. Like pprof, it is served only when pprof is enabled.
+const RequestsPath = "/debug/requests"
+
+// Protocols of in-flight requests, as labelled in duplex_inflight_requests.
+const (
+	protocolGRPC    = "grpc"
+	protocolGateway = "gateway"
+)
+
+var (
+	inflightRequests = prometheus.NewGaugeVec(prometheus.GaugeOpts{
+		Name: "duplex_inflight_requests",
+		Help: "Number of requests being served, by protocol (grpc or gateway). A gateway request's loopback call is also counted as grpc while it runs.",
+	}, []string{"protocol"})
+	drainDuration = prometheus.NewHistogramVec(prometheus.HistogramOpts{
+		Name:    "duplex_shutdown_drain_duration_seconds",
+		Help:    "Time Shutdown waited for in-flight requests to finish, by result (drained, or incomplete when its context ended first).",
+		Buckets: []float64{0.01, 0.05, 0.1, 0.5, 1, 2.5, 5, 10, 30, 60, 120, 300},
+	}, []string{"result"})
 )
 
-// inflightTracker counts in-flight requests and lets a caller wait for them to
+func init() {
+	prometheus.MustRegister(inflightRequests, drainDuration)
+}
+
+// inflightRequest describes a request being served.
+type inflightRequest struct {
+	Protocol  string    `json:"protocol"`
+	Method    string    `json:"method"`
+	Start     time.Time `json:"start"`
+	ClientID  string    `json:"clientID,omitempty"`
+	RequestID string    `json:"requestID,omitempty"`
+}
+
+// newInflightRequest describes r, a gRPC request when isGRPC is set, starting
+// now. A gRPC request is described by its full method name, and a gateway
+// request by its HTTP method and path.
+func newInflightRequest(r *http.Request, isGRPC bool) inflightRequest {
+	req := inflightRequest{
+		Protocol:  protocolGateway,
+		Method:    r.Method + " " + r.URL.Path,
+		Start:     time.Now(),
+		ClientID:  r.Header.Get(clientid.CGClientID),
+		RequestID: r.Header.Get(clientid.CGRequestID),
+	}
+	if isGRPC {
+		req.Protocol, req.Method = protocolGRPC, r.URL.Path
+	}
+	return req
+}
+
+// inflightTracker tracks in-flight requests and lets a caller wait for them to
 // drain. Its zero value is ready to use.
 type inflightTracker struct {
-	mu    sync.Mutex
-	count int
+	mu       sync.Mutex
+	nextID   uint64
+	requests map[uint64]inflightRequest
 
-	// idle is created by the first waiter and closed when count reaches zero, so
-	// waiters can select on it alongside their context.
+	// idle is created by the first waiter and closed when no requests remain,
+	// so waiters can select on it alongside their context.
 	idle chan struct{}
 }
 
-func (t *inflightTracker) add() {
+// add records req as in flight, returning the ID to pass done when it ends.
+func (t *inflightTracker) add(req inflightRequest) uint64 {
 	t.mu.Lock()
 	defer t.mu.Unlock()
 
-	t.count++
+	if t.requests == nil {
+		t.requests = map[uint64]inflightRequest{}
+	}
+	t.nextID++
+	t.requests[t.nextID] = req
+	inflightRequests.WithLabelValues(req.Protocol).Inc()
+	return t.nextID
 }
 
-func (t *inflightTracker) done() {
+// done records the end of the request add returned id for.
+func (t *inflightTracker) done(id uint64) {
 	t.mu.Lock()
 	defer t.mu.Unlock()
 
-	t.count--
-	if t.count == 0 && t.idle != nil {
+	req, ok := t.requests[id]
+	if !ok {
+		return
+	}
+	delete(t.requests, id)
+	inflightRequests.WithLabelValues(req.Protocol).Dec()
+	if len(t.requests) == 0 && t.idle != nil {
 		close(t.idle)
 		t.idle = nil
 	}
@@ -41,10 +116,10 @@ func (t *inflightTracker) done() {
 
 // wait blocks until no requests are in flight, returning nil, or until ctx is
 // done, returning ctx.Err(). A ctx without a deadline waits indefinitely for
-// the count to reach zero.
+// the requests to finish.
 func (t *inflightTracker) wait(ctx context.Context) error {
 	t.mu.Lock()
-	if t.count == 0 {
+	if len(t.requests) == 0 {
 		t.mu.Unlock()
 		return nil
 	}
@@ -62,3 +137,48 @@ func (t *inflightTracker) wait(ctx context.Context) error {
 		return ctx.Err()
 	}
 }
+
+// snapshot returns the requests in flight, oldest first.
+func (t *inflightTracker) snapshot() []inflightRequest {
+	t.mu.Lock()
+	reqs := make([]inflightRequest, 0, len(t.requests))
+	for _, req := range t.requests {
+		reqs = append(reqs, req)
+	}
+	t.mu.Unlock()
+
+	slices.SortFunc(reqs, func(a, b inflightRequest) int { return a.Start.Compare(b.Start) })
+	return reqs
+}
+
+// serveRequests answers RequestsPath with the requests in flight, oldest first,
+// each with how long it has been running.
+func (t *inflightTracker) serveRequests(w http.ResponseWriter, _ *http.Request) {
+	type entry struct {
+		inflightRequest
+		Duration string `json:"duration"`
+	}
+	now := time.Now()
+	reqs := t.snapshot()
+	entries := make([]entry, 0, len(reqs))
+	for _, req := range reqs {
+		entries = append(entries, entry{inflightRequest: req, Duration: now.Sub(req.Start).String()})
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	enc := json.NewEncoder(w)
+	enc.SetIndent("", "  ")
+	_ = enc.Encode(struct {
+		Count    int     `json:"count"`
+		Requests []entry `json:"requests"`
+	}{len(entries), entries})
+}
+
+// observeDrain records a drain that took d and ended with err.
+func observeDrain(d time.Duration, err error) {
+	result := "drained"
+	if err != nil {
+		result = "incomplete"
+	}
+	drainDuration.WithLabelValues(result).Observe(d.Seconds())
+}
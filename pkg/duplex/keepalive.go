@@ -0,0 +1,207 @@
+/*
+Copyright 2026 Chainguard, Inc.
+SPDX-License-Identifier: Apache-2.0
+*/
+
+package duplex
+
+import (
+	"context"
+	"math/rand/v2"
+	"net"
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"google.golang.org/grpc/keepalive"
+)
+
+// The defaults grpc.KeepaliveParams applies to a zero keepalive.ServerParameters
+// field, which WithKeepalive mirrors.
+const (
+	defaultKeepaliveTime    = 2 * time.Hour
+	defaultKeepaliveTimeout = 20 * time.Second
+	minKeepaliveTime        = time.Second
+)
+
+// Reasons a connection is ended for its age, as labelled in
+// duplex_connections_aged_out_total.
+const (
+	agedOutGoAway = "goaway"
+	agedOutIdle   = "idle"
+	agedOutClosed = "closed"
+)
+
+var connectionsAgedOut = prometheus.NewCounterVec(prometheus.CounterOpts{
+	Name: "duplex_connections_aged_out_total",
+	Help: "Connections ended for exceeding their maximum age, by how (goaway, when told to reconnect, idle, when closed with no requests in flight, or closed, when still open after the grace period).",
+}, []string{"how"})
+
+func init() {
+	prometheus.MustRegister(connectionsAgedOut)
+}
+
+// applyKeepalive sets the keepalive settings of kp on server: server pings and
+// the idle timeout. Maximum connection age is enforced by a connAger.
+func applyKeepalive(kp keepalive.ServerParameters, server *http.Server) {
+	ping := kp.Time
+	if ping == 0 {
+		ping = defaultKeepaliveTime
+	}
+	timeout := kp.Timeout
+	if timeout == 0 {
+		timeout = defaultKeepaliveTimeout
+	}
+	if server.HTTP2 == nil {
+		server.HTTP2 = &http.HTTP2Config{}
+	}
+	server.HTTP2.SendPingTimeout = max(ping, minKeepaliveTime)
+	server.HTTP2.PingTimeout = timeout
+
+	if kp.MaxConnectionIdle > 0 {
+		server.IdleTimeout = kp.MaxConnectionIdle
+	}
+}
+
+// connAgeKey is the context key of a connection's *connDeadline.
+type connAgeKey struct{}
+
+// connDeadline is when a connection reaches its maximum age.
+type connDeadline struct {
+	at time.Time
+
+	// told is done once the connection's client is first told to leave it, by a
+	// GOAWAY or by closing it while idle.
+	told sync.Once
+}
+
+// tell counts the connection as aged out how, unless its client was already
+// told to leave it.
+func (d *connDeadline) tell(how string) {
+	d.told.Do(func() { connectionsAgedOut.WithLabelValues(how).Inc() })
+}
+
+// agedConn is the state a connAger keeps for an open connection.
+type agedConn struct {
+	deadline *connDeadline
+	timers   []*time.Timer
+
+	// active is set while the connection has requests in flight, and expired
+	// once it is past its deadline.
+	active, expired bool
+}
+
+// connAger enforces a maximum connection age on an http.Server. Each connection
+// is given a deadline of the age, jittered by +/-10% so connections made
+// together do not all end together. A connection with no requests in flight at
+// its deadline, or once its last request finishes after it, is closed, as
+// net/http closes idle connections. The first response written on a busy
+// connection past its deadline carries "Connection: close", which makes
+// net/http send an HTTP/2 connection a GOAWAY, so its client reconnects,
+// perhaps to another replica, while the requests already on it finish. A
+// connection still open grace after its deadline is closed.
+type connAger struct {
+	age   time.Duration
+	grace time.Duration // Zero is infinite.
+
+	mu    sync.Mutex
+	conns map[net.Conn]*agedConn
+}
+
+// newConnAger returns a connAger for the maximum age set in kp, or nil if none
+// is set.
+func newConnAger(kp keepalive.ServerParameters) *connAger {
+	if kp.MaxConnectionAge == 0 {
+		return nil
+	}
+	return &connAger{age: kp.MaxConnectionAge, grace: kp.MaxConnectionAgeGrace}
+}
+
+// connContext gives c a jittered deadline, at which it is expired, and closes
+// it grace after that.
+func (a *connAger) connContext(ctx context.Context, c net.Conn) context.Context {
+	jitter := a.age / 10
+	age := a.age
+	if jitter > 0 {
+		age += time.Duration(rand.Int64N(int64(2*jitter))) - jitter
+	}
+	deadline := &connDeadline{at: time.Now().Add(age)}
+
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	if a.conns == nil {
+		a.conns = map[net.Conn]*agedConn{}
+	}
+	ac := &agedConn{deadline: deadline}
+	ac.timers = append(ac.timers, time.AfterFunc(age, func() { a.expireConn(c) }))
+	if a.grace > 0 {
+		ac.timers = append(ac.timers, time.AfterFunc(age+a.grace, func() {
+			connectionsAgedOut.WithLabelValues(agedOutClosed).Inc()
+			_ = c.Close()
+		}))
+	}
+	a.conns[c] = ac
+	return context.WithValue(ctx, connAgeKey{}, deadline)
+}
+
+// expireConn marks c past its deadline, and closes it if it is idle.
+func (a *connAger) expireConn(c net.Conn) {
+	a.mu.Lock()
+	ac, ok := a.conns[c]
+	if ok {
+		ac.expired = true
+	}
+	idle := ok && !ac.active
+	a.mu.Unlock()
+
+	if idle {
+		closeIdle(c, ac)
+	}
+}
+
+// connState tracks whether c has requests in flight, closing it when it falls
+// idle past its deadline, and forgets c once it is closed, or hijacked from the
+// server.
+func (a *connAger) connState(c net.Conn, state http.ConnState) {
+	a.mu.Lock()
+	ac, ok := a.conns[c]
+	if !ok {
+		a.mu.Unlock()
+		return
+	}
+	switch state {
+	case http.StateActive:
+		ac.active = true
+	case http.StateIdle:
+		ac.active = false
+	case http.StateClosed, http.StateHijacked:
+		for _, t := range ac.timers {
+			t.Stop()
+		}
+		delete(a.conns, c)
+	}
+	idle := state == http.StateIdle && ac.expired
+	a.mu.Unlock()
+
+	if idle {
+		closeIdle(c, ac)
+	}
+}
+
+// closeIdle closes c, which is idle past its deadline.
+func closeIdle(c net.Conn, ac *agedConn) {
+	ac.deadline.tell(agedOutIdle)
+	_ = c.Close()
+}
+
+// expire marks the response to r to close its connection if the connection is
+// past its deadline.
+func (a *connAger) expire(w http.ResponseWriter, r *http.Request) {
+	deadline, ok := r.Context().Value(connAgeKey{}).(*connDeadline)
+	if !ok || time.Now().Before(deadline.at) {
+		return
+	}
+	deadline.tell(agedOutGoAway)
+	w.Header().Set("Connection", "close")
+}
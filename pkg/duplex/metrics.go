@@ -0,0 +1,167 @@
+/*
+Copyright 2026 Chainguard, Inc.
+SPDX-License-Identifier: Apache-2.0
+*/
+
+package duplex
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net"
+	"net/http"
+	"strings"
+	"sync"
+
+	"chainguard.dev/go-grpc-kit/pkg/metrics"
+)
+
+// metricsServer is the Duplex's own /metrics (and pprof) HTTP server on a
+// second port. It is started alongside the main server and stopped by Shutdown.
+type metricsServer struct {
+	mu sync.Mutex
+
+	// listener, when set, is served instead of listening on the port.
+	listener    net.Listener
+	port        int
+	enablePprof bool
+
+	// handler returns the handler to serve, given whether pprof is enabled.
+	handler func(enablePprof bool) http.Handler
+
+	server *http.Server
+	// errc receives the error that stopped the server, other than
+	// http.ErrServerClosed. It is buffered so the server never blocks on it.
+	errc   chan error
+	closed bool
+}
+
+// configure records what the metrics server should serve, if it has not
+// started yet.
+func (m *metricsServer) configure(port int, listener net.Listener, enablePprof bool) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if m.server == nil {
+		m.port, m.listener, m.enablePprof = port, listener, enablePprof
+	}
+}
+
+// start starts the metrics server, if one is configured and it has not been
+// started or shut down, and returns the channel its failure is reported on. It
+// returns a nil channel, which never receives, when there is no server.
+func (m *metricsServer) start(host string) <-chan error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if m.server != nil || m.closed {
+		return m.errc
+	}
+	if m.port == 0 && m.listener == nil {
+		return nil
+	}
+
+	m.errc = make(chan error, 1)
+	m.server = &http.Server{
+		Handler:           m.handler(m.enablePprof),
+		ReadHeaderTimeout: defaultReadHeaderTimeout,
+	}
+
+	lis := m.listener
+	if lis == nil {
+		var err error
+		lis, err = net.Listen("tcp", fmt.Sprintf("%s:%d", host, m.port))
+		if err != nil {
+			m.errc <- err
+			return m.errc
+		}
+	}
+
+	go func(server *http.Server, errc chan<- error) {
+		if err := server.Serve(lis); !errors.Is(err, http.ErrServerClosed) {
+			errc <- err
+		}
+	}(m.server, m.errc)
+
+	return m.errc
+}
+
+// shutdown stops the metrics server gracefully, bounded by ctx, or closes it
+// outright when force is set. A server that has not started never will.
+func (m *metricsServer) shutdown(ctx context.Context, force bool) error {
+	m.mu.Lock()
+	server := m.server
+	m.closed = true
+	m.mu.Unlock()
+
+	if server == nil {
+		return nil
+	}
+	if force {
+		return server.Close()
+	}
+	if err := server.Shutdown(ctx); err != nil {
+		_ = server.Close()
+		return fmt.Errorf("metrics server shutdown: %w", err)
+	}
+	return nil
+}
+
+// observabilityHandler returns the handler for the metrics server: Prometheus
+// metrics, and when enablePprof is set, pprof and the RequestsPath debug
+// endpoint.
+func (d *Duplex) observabilityHandler(enablePprof bool) http.Handler {
+	h := metrics.Handler(enablePprof)
+	if !enablePprof {
+		return h
+	}
+	mux := http.NewServeMux()
+	mux.HandleFunc(RequestsPath, d.inflight.serveRequests)
+	mux.Handle("/", h)
+	return mux
+}
+
+// metricsHandler returns the handler for a request to path under the prefix
+// configured WithMetricsPath, or nil if path is not under it.
+func (d *Duplex) metricsHandler(path string) http.Handler {
+	prefix := d.cfg.metricsPath
+	if d.metricsOnMainPort == nil || (path != prefix && !strings.HasPrefix(path, prefix+"/")) {
+		return nil
+	}
+	return d.metricsOnMainPort
+}
+
+// serve runs the HTTP server via run together with the metrics server, if one
+// is configured, returning when run returns. If the metrics server fails
+// first, the main server is closed and the metrics error returned.
+func (d *Duplex) serve(run func() error) error {
+	if d.metricsOnMainPort != nil || d.metrics.configured() {
+		metrics.Initialize(d.Server)
+	}
+
+	metricsErr := d.metrics.start(d.Host)
+	if metricsErr == nil {
+		return run()
+	}
+
+	errc := make(chan error, 1)
+	go func() { errc <- run() }()
+
+	select {
+	case err := <-errc:
+		return err
+	case err := <-metricsErr:
+		_ = d.httpServerInstance().Close()
+		<-errc
+		return fmt.Errorf("metrics server: %w", err)
+	}
+}
+
+// configured reports whether a metrics server has been configured.
+func (m *metricsServer) configured() bool {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	return m.port != 0 || m.listener != nil
+}
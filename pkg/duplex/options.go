@@ -0,0 +1,327 @@
+/*
+Copyright 2026 Chainguard, Inc.
+SPDX-License-Identifier: Apache-2.0
+*/
+
+package duplex
+
+import (
+	"crypto/tls"
+	"errors"
+	"fmt"
+	"net"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/grpc-ecosystem/grpc-gateway/v2/runtime"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/keepalive"
+
+	"chainguard.dev/go-grpc-kit/pkg/interceptors/loadshed"
+)
+
+// Option configures a Duplex created by NewWithOptions. An Option reports an
+// invalid setting as an error rather than panicking.
+type Option func(*config) error
+
+// config collects the settings applied by Options.
+type config struct {
+	host       string
+	serverOpts []grpc.ServerOption
+	muxOpts    []runtime.ServeMuxOption
+	dialOpts   []grpc.DialOption
+	middleware []HTTPMiddleware
+	httpServer HTTPServerConfig
+	listener   net.Listener
+	tls        *tls.Config
+	loadShed   *loadshed.Limiter
+	keepalive  *keepalive.ServerParameters
+
+	enforcement *keepalive.EnforcementPolicy
+
+	// maxTimeout is set, to the ceiling or 0 for none, when deadlines are
+	// enforced.
+	maxTimeout *time.Duration
+
+	streamGracePeriod time.Duration
+
+	metricsPort  int
+	metricsPprof bool
+
+	metricsPath      string
+	metricsPathPprof bool
+}
+
+// HTTPServerConfig holds the http.Server settings a Duplex serves with. A zero
+// field keeps the default: a ReadHeaderTimeout of 10 seconds, and net/http's
+// defaults for the rest.
+type HTTPServerConfig struct {
+	ReadTimeout       time.Duration
+	ReadHeaderTimeout time.Duration
+	WriteTimeout      time.Duration
+	IdleTimeout       time.Duration
+	MaxHeaderBytes    int
+}
+
+// defaultReadHeaderTimeout bounds how long a client may take to send request
+// headers, guarding against slowloris-style connections.
+const defaultReadHeaderTimeout = 10 * time.Second
+
+// apply sets the non-zero fields of c on server.
+func (c HTTPServerConfig) apply(server *http.Server) {
+	server.ReadHeaderTimeout = defaultReadHeaderTimeout
+	if c.ReadHeaderTimeout > 0 {
+		server.ReadHeaderTimeout = c.ReadHeaderTimeout
+	}
+	server.ReadTimeout = c.ReadTimeout
+	server.WriteTimeout = c.WriteTimeout
+	server.IdleTimeout = c.IdleTimeout
+	server.MaxHeaderBytes = c.MaxHeaderBytes
+}
+
+// WithServerOptions adds options for grpc.NewServer. As gRPC is served through
+// an http.Server, grpc.KeepaliveParams and grpc.KeepaliveEnforcementPolicy have
+// no effect; use WithKeepalive and WithKeepaliveEnforcement.
+func WithServerOptions(opts ...grpc.ServerOption) Option {
+	return func(c *config) error {
+		c.serverOpts = append(c.serverOpts, opts...)
+		return nil
+	}
+}
+
+// WithMuxOptions adds options for the gateway's runtime.NewServeMux.
+func WithMuxOptions(opts ...runtime.ServeMuxOption) Option {
+	return func(c *config) error {
+		c.muxOpts = append(c.muxOpts, opts...)
+		return nil
+	}
+}
+
+// WithDialOptions adds options for the gateway's loopback connection to the
+// gRPC server, applied after the defaults from options.LoopbackDialOptions.
+func WithDialOptions(opts ...grpc.DialOption) Option {
+	return func(c *config) error {
+		c.dialOpts = append(c.dialOpts, opts...)
+		return nil
+	}
+}
+
+// WithHTTPMiddleware adds middleware around the gateway MUX. Middleware is
+// applied in the order given, the first outermost.
+func WithHTTPMiddleware(mws ...HTTPMiddleware) Option {
+	return func(c *config) error {
+		for _, mw := range mws {
+			if mw == nil {
+				return errors.New("nil HTTPMiddleware")
+			}
+		}
+		c.middleware = append(c.middleware, mws...)
+		return nil
+	}
+}
+
+// WithHost sets the host ListenAndServe listens on. The default, the empty
+// string, listens on all interfaces. The gateway loopback dials host, or
+// localhost when it is every interface.
+func WithHost(host string) Option {
+	return func(c *config) error {
+		c.host = host
+		return nil
+	}
+}
+
+// WithHTTPServerConfig sets the timeouts and limits of the http.Server the
+// Duplex serves with.
+func WithHTTPServerConfig(cfg HTTPServerConfig) Option {
+	return func(c *config) error {
+		if cfg.ReadTimeout < 0 || cfg.ReadHeaderTimeout < 0 || cfg.WriteTimeout < 0 || cfg.IdleTimeout < 0 {
+			return fmt.Errorf("negative timeout in %+v", cfg)
+		}
+		if cfg.MaxHeaderBytes < 0 {
+			return fmt.Errorf("negative MaxHeaderBytes: %d", cfg.MaxHeaderBytes)
+		}
+		c.httpServer = cfg
+		return nil
+	}
+}
+
+// WithLoadShedding sheds calls with l: while the calls in flight are at its
+// adaptive limit, new ones are refused with codes.Unavailable, which the
+// gateway returns to REST callers as a 503. Its interceptors run just inside
+// panic recovery, ahead of any passed in, so a shed call costs little. Health
+// checks are never shed; see loadshed.WithPriority.
+func WithLoadShedding(l *loadshed.Limiter) Option {
+	return func(c *config) error {
+		if l == nil {
+			return errors.New("nil loadshed.Limiter")
+		}
+		c.loadShed = l
+		return nil
+	}
+}
+
+// WithDeadlines enforces call deadlines: calls whose deadline passed before
+// they arrived are refused with codes.DeadlineExceeded (a 504 through the
+// gateway), unary calls with no deadline, or a later one, are bounded to
+// maxTimeout, and REST callers can set a deadline with the
+// deadline.TimeoutHeader, which the gateway sends on to the server as
+// grpc-timeout. A maxTimeout of 0 leaves deadlines uncapped. Its interceptors
+// run after load shedding, ahead of any passed in. See the deadline package.
+func WithDeadlines(maxTimeout time.Duration) Option {
+	return func(c *config) error {
+		if maxTimeout < 0 {
+			return fmt.Errorf("negative maximum timeout: %v", maxTimeout)
+		}
+		c.maxTimeout = &maxTimeout
+		return nil
+	}
+}
+
+// WithStreamGracePeriod makes Shutdown cut off the gRPC streams still open
+// grace after draining begins: their handlers' contexts are cancelled, and
+// their clients get codes.Unavailable, so they reconnect to another server.
+// Without it, a long-lived stream holds the drain open until Shutdown's context
+// ends; health Watch streams are ended by Shutdown itself. Unary calls are left
+// to finish.
+func WithStreamGracePeriod(grace time.Duration) Option {
+	return func(c *config) error {
+		if grace <= 0 {
+			return fmt.Errorf("stream grace period must be positive, got %v", grace)
+		}
+		c.streamGracePeriod = grace
+		return nil
+	}
+}
+
+// WithKeepalive sets the keepalive parameters of the connections the Duplex
+// serves, with the same meaning and defaults as grpc.KeepaliveParams gives them
+// on a grpc.Server serving its own listener, which does not apply here because
+// gRPC is served through an http.Server:
+//
+//   - Time and Timeout set when the server pings an idle HTTP/2 connection, and
+//     how long it waits for the ack before closing it.
+//   - MaxConnectionIdle closes a connection with no requests for that long,
+//     overriding HTTPServerConfig.IdleTimeout.
+//   - MaxConnectionAge, jittered by +/-10%, rotates connections so clients
+//     reconnect and load rebalances across replicas. A connection with no
+//     requests in flight when it reaches the age is closed then; a busy one
+//     sends its client a GOAWAY on its next response, while the requests
+//     already on it finish, and is closed as soon as it falls idle. A
+//     connection still open MaxConnectionAgeGrace after the age is closed; the
+//     zero grace never closes it. A connection carrying only long-lived streams
+//     sees no new response, so it ends at the grace period, if one is set.
+//
+// How often clients may ping is set WithKeepaliveEnforcement.
+func WithKeepalive(kp keepalive.ServerParameters) Option {
+	return func(c *config) error {
+		if kp.Time < 0 || kp.Timeout < 0 || kp.MaxConnectionIdle < 0 || kp.MaxConnectionAge < 0 || kp.MaxConnectionAgeGrace < 0 {
+			return fmt.Errorf("negative duration in %+v", kp)
+		}
+		c.keepalive = &kp
+		return nil
+	}
+}
+
+// WithKeepaliveEnforcement limits how often clients may ping the connections
+// the Duplex serves, with the same meaning and defaults as
+// grpc.KeepaliveEnforcementPolicy gives ep on a grpc.Server: a ping sooner than
+// MinTime (5 minutes if zero) after the last one, or, unless
+// PermitWithoutStream is set, any ping within 2 hours of the last while the
+// connection has no requests in flight, is a strike against the client, which
+// is forgiven once the server sends it headers or data. Past two strikes, the
+// connection is sent a GOAWAY with ENHANCE_YOUR_CALM and "too_many_pings", as a
+// grpc.Server sends, so gRPC clients slow their pings, and closed. Such
+// connections are counted in duplex_connections_too_many_pings_total.
+//
+// net/http's HTTP/2 server acknowledges every ping without exposing them, so
+// the policy is enforced by following the frames on each connection, which can
+// only be done in cleartext: served WithTLS, the policy has no effect and every
+// ping is accepted. Without this option, every ping is accepted too, as by a
+// grpc.Server with a MinTime of 0 and PermitWithoutStream set, so clients
+// dialled with options.KeepaliveDialOption work against any Duplex.
+func WithKeepaliveEnforcement(ep keepalive.EnforcementPolicy) Option {
+	return func(c *config) error {
+		if ep.MinTime < 0 {
+			return fmt.Errorf("negative MinTime: %v", ep.MinTime)
+		}
+		c.enforcement = &ep
+		return nil
+	}
+}
+
+// WithListener makes ListenAndServe serve on lis instead of listening on the
+// host and port. When the port passed to NewWithOptions is 0, the loopback
+// dials the port lis is bound to.
+func WithListener(lis net.Listener) Option {
+	return func(c *config) error {
+		if lis == nil {
+			return errors.New("nil listener")
+		}
+		c.listener = lis
+		return nil
+	}
+}
+
+// WithMetrics serves Prometheus /metrics, and /debug/pprof/ when enablePprof
+// is set, on the given port of the Duplex host. The metrics server starts when
+// the Duplex starts serving and is shut down by Shutdown, after in-flight
+// requests have drained; if it fails, serving stops and the error is returned.
+func WithMetrics(port int, enablePprof bool) Option {
+	return func(c *config) error {
+		if port == 0 {
+			return errors.New("metrics port must be set")
+		}
+		if err := validatePort(port); err != nil {
+			return fmt.Errorf("metrics port: %w", err)
+		}
+		c.metricsPort = port
+		c.metricsPprof = enablePprof
+		return nil
+	}
+}
+
+// WithMetricsPath serves Prometheus metrics, and pprof when enablePprof is set,
+// on the main port under prefix, for platforms that expose a single port (e.g.
+// Cloud Run). With a prefix of "/_admin", metrics are at /_admin/metrics and
+// pprof at /_admin/debug/pprof/. Requests under the prefix bypass the gateway
+// and its middleware, and are not counted as in-flight by Shutdown.
+func WithMetricsPath(prefix string, enablePprof bool) Option {
+	return func(c *config) error {
+		prefix = strings.TrimSuffix(prefix, "/")
+		if !strings.HasPrefix(prefix, "/") {
+			return fmt.Errorf("metrics path prefix %q must start with a non-root path", prefix)
+		}
+		c.metricsPath = prefix
+		c.metricsPathPprof = enablePprof
+		return nil
+	}
+}
+
+func validatePort(port int) error {
+	if port < 0 || port > 65535 {
+		return fmt.Errorf("port %d out of range", port)
+	}
+	return nil
+}
+
+// legacyOption converts an option accepted by New's untyped variadic form into
+// an Option.
+func legacyOption(o interface{}) (Option, error) {
+	switch opt := o.(type) {
+	case Option:
+		return opt, nil
+	case grpc.ServerOption:
+		return WithServerOptions(opt), nil
+	case runtime.ServeMuxOption:
+		return WithMuxOptions(opt), nil
+	case grpc.DialOption:
+		return WithDialOptions(opt), nil
+	case HTTPMiddleware:
+		return WithHTTPMiddleware(opt), nil
+	case func(http.Handler) http.Handler:
+		return WithHTTPMiddleware(opt), nil
+	default:
+		return nil, fmt.Errorf("unknown type: %T", o)
+	}
+}
@@ -0,0 +1,261 @@
+/*
+Copyright 2026 Chainguard, Inc.
+SPDX-License-Identifier: Apache-2.0
+*/
+
+package duplex
+
+import (
+	"encoding/binary"
+	"net"
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"golang.org/x/net/http2"
+	"google.golang.org/grpc/keepalive"
+)
+
+// The defaults and limits a grpc.Server applies to a
+// keepalive.EnforcementPolicy, which WithKeepaliveEnforcement mirrors.
+const (
+	defaultEnforcementMinTime = 5 * time.Minute
+	idlePingInterval          = 2 * time.Hour
+	maxPingStrikes            = 2
+)
+
+var connectionsTooManyPings = prometheus.NewCounter(prometheus.CounterOpts{
+	Name: "duplex_connections_too_many_pings_total",
+	Help: "Connections closed for pinging more often than the keepalive enforcement policy permits.",
+})
+
+func init() {
+	prometheus.MustRegister(connectionsTooManyPings)
+}
+
+// pingPolicy enforces a keepalive.EnforcementPolicy on the cleartext
+// connections accepted by the listeners it wraps.
+type pingPolicy struct {
+	minTime             time.Duration
+	permitWithoutStream bool
+}
+
+// newPingPolicy returns the pingPolicy of ep, with its defaults applied.
+func newPingPolicy(ep keepalive.EnforcementPolicy) *pingPolicy {
+	minTime := ep.MinTime
+	if minTime == 0 {
+		minTime = defaultEnforcementMinTime
+	}
+	return &pingPolicy{minTime: minTime, permitWithoutStream: ep.PermitWithoutStream}
+}
+
+// listener returns lis with p enforced on the connections it accepts.
+func (p *pingPolicy) listener(lis net.Listener) net.Listener {
+	return &policedListener{Listener: lis, policy: p}
+}
+
+// policedListener accepts policedConns.
+type policedListener struct {
+	net.Listener
+	policy *pingPolicy
+}
+
+func (l *policedListener) Accept() (net.Conn, error) {
+	c, err := l.Listener.Accept()
+	if err != nil {
+		return nil, err
+	}
+	return &policedConn{Conn: c, policy: l.policy, out: frameScanner{inFrames: true}}, nil
+}
+
+// policedConn enforces a pingPolicy on the HTTP/2 connection it wraps, which
+// net/http serves without exposing the pings it receives. It follows the frames
+// read from and written to the connection, and strikes a ping, as a grpc.Server
+// does, when it comes sooner than the policy permits, forgiving the strikes
+// once the server sends headers or data. Past maxPingStrikes, it sends a
+// GOAWAY, if no frame is partly written, and closes the connection. A
+// connection that does not open with the HTTP/2 client preface, such as
+// HTTP/1, is left alone.
+type policedConn struct {
+	net.Conn
+	policy *pingPolicy
+
+	// in follows the frames read, by the connection's one reader.
+	in frameScanner
+
+	// writeMu serializes writes, so a GOAWAY is never written inside another
+	// frame, and guards out, which follows the frames written.
+	writeMu sync.Mutex
+	out     frameScanner
+
+	mu           sync.Mutex
+	http2        bool      // The client sent the HTTP/2 preface.
+	active       bool      // The connection has requests in flight.
+	lastPing     time.Time // When the client last pinged.
+	strikes      int
+	resetStrikes bool   // Headers or data were sent since the last ping.
+	lastStream   uint32 // The last stream the client opened.
+	tooManyPings bool
+}
+
+func (c *policedConn) Read(b []byte) (int, error) {
+	n, err := c.Conn.Read(b)
+	if c.in.scan(b[:n], c.read) {
+		c.mu.Lock()
+		c.http2 = true
+		c.mu.Unlock()
+	}
+	return n, err
+}
+
+func (c *policedConn) Write(b []byte) (int, error) {
+	c.writeMu.Lock()
+	defer c.writeMu.Unlock()
+
+	n, err := c.Conn.Write(b)
+	c.mu.Lock()
+	h2 := c.http2
+	c.mu.Unlock()
+	if h2 {
+		c.out.scan(b[:n], c.written)
+	}
+	return n, err
+}
+
+// setState records whether the connection has requests in flight.
+func (c *policedConn) setState(state http.ConnState) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.active = state == http.StateActive
+}
+
+// read handles a frame header the client sent.
+func (c *policedConn) read(h http2.FrameHeader) {
+	switch {
+	case h.Type == http2.FrameHeaders:
+		c.mu.Lock()
+		c.lastStream = max(c.lastStream, h.StreamID)
+		c.mu.Unlock()
+	case h.Type == http2.FramePing && !h.Flags.Has(http2.FlagPingAck):
+		if c.ping(time.Now()) {
+			// Writing the GOAWAY may wait on a write, so it is not done on the
+			// connection's reader.
+			go c.closeTooManyPings()
+		}
+	}
+}
+
+// written handles a frame header the server sent.
+func (c *policedConn) written(h http2.FrameHeader) {
+	if h.Type == http2.FrameHeaders || h.Type == http2.FrameData {
+		c.mu.Lock()
+		c.resetStrikes = true
+		c.mu.Unlock()
+	}
+}
+
+// ping strikes a ping the client sent at now if the policy does not permit it,
+// and reports whether the client has pinged too often.
+func (c *policedConn) ping(now time.Time) bool {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	defer func() { c.lastPing = now }()
+
+	if c.resetStrikes {
+		c.resetStrikes = false
+		c.strikes = 0
+		return false
+	}
+	interval := c.policy.minTime
+	if !c.active && !c.policy.permitWithoutStream {
+		interval = idlePingInterval
+	}
+	if now.Sub(c.lastPing) < interval {
+		c.strikes++
+	}
+	if c.strikes <= maxPingStrikes || c.tooManyPings {
+		return false
+	}
+	c.tooManyPings = true
+	return true
+}
+
+// closeTooManyPings tells the client it pinged too often, as a grpc.Server
+// does, and closes the connection.
+func (c *policedConn) closeTooManyPings() {
+	connectionsTooManyPings.Inc()
+
+	c.mu.Lock()
+	lastStream := c.lastStream
+	c.mu.Unlock()
+
+	c.writeMu.Lock()
+	if c.out.atBoundary() {
+		_ = http2.NewFramer(c.Conn, nil).WriteGoAway(lastStream, http2.ErrCodeEnhanceYourCalm, []byte("too_many_pings"))
+	}
+	c.writeMu.Unlock()
+	_ = c.Close()
+}
+
+// frameScanner follows the HTTP/2 frames in a stream of bytes given to it in
+// pieces of any size. Unless inFrames is set, it first expects the client
+// preface, and stops following bytes that do not start with it.
+type frameScanner struct {
+	preface  int  // Bytes of the client preface seen.
+	inFrames bool // The preface is done, or not expected.
+	off      bool // The bytes are not HTTP/2.
+
+	header   [9]byte
+	buffered int    // Bytes of the next frame header seen.
+	skip     uint32 // Bytes of the current frame's payload still to come.
+}
+
+// atBoundary reports whether the bytes followed end between frames.
+func (s *frameScanner) atBoundary() bool {
+	return s.inFrames && s.buffered == 0 && s.skip == 0
+}
+
+// scan follows b, calling frame with each frame header completed in it, and
+// reports whether b completed the client preface.
+func (s *frameScanner) scan(b []byte, frame func(http2.FrameHeader)) (prefaced bool) {
+	for len(b) > 0 && !s.off {
+		if !s.inFrames {
+			n := min(len(b), len(http2.ClientPreface)-s.preface)
+			if string(b[:n]) != http2.ClientPreface[s.preface:s.preface+n] {
+				s.off = true
+				return false
+			}
+			s.preface += n
+			b = b[n:]
+			if s.preface == len(http2.ClientPreface) {
+				s.inFrames = true
+				prefaced = true
+			}
+			continue
+		}
+
+		if s.skip > 0 {
+			n := min(uint32(len(b)), s.skip)
+			s.skip -= n
+			b = b[n:]
+			continue
+		}
+		n := copy(s.header[s.buffered:], b)
+		s.buffered += n
+		b = b[n:]
+		if s.buffered < len(s.header) {
+			continue
+		}
+		s.buffered = 0
+		h := http2.FrameHeader{
+			Length:   uint32(s.header[0])<<16 | uint32(s.header[1])<<8 | uint32(s.header[2]),
+			Type:     http2.FrameType(s.header[3]),
+			Flags:    http2.Flags(s.header[4]),
+			StreamID: binary.BigEndian.Uint32(s.header[5:]) & (1<<31 - 1),
+		}
+		s.skip = h.Length
+		frame(h)
+	}
+	return prefaced
+}
@@ -0,0 +1,95 @@
+/*
+Copyright 2026 Chainguard, Inc.
+SPDX-License-Identifier: Apache-2.0
+*/
+
+package duplex
+
+import (
+	"errors"
+	"net/http"
+	"strconv"
+
+	"github.com/grpc-ecosystem/grpc-gateway/v2/runtime"
+	"google.golang.org/grpc/codes"
+
+	"chainguard.dev/go-grpc-kit/pkg/interceptors/recovery"
+)
+
+// recoverHTTP recovers from a panic raised while handler serves r, outside any
+// gRPC interceptor: in HTTPMiddleware, the gateway MUX, or the gRPC transport
+// itself. It must be deferred directly. The panic is logged and counted as the
+// recovery interceptors do, and the caller gets a codes.Internal status in the
+// form it expects: gRPC status headers for a gRPC request, or a gateway error
+// for anything else. If the response has already started, the connection is
+// aborted instead, since no well-formed status can follow.
+func (d *Duplex) recoverHTTP(w *panicResponseWriter, r *http.Request, isGRPC bool) {
+	p := recover()
+	if p == nil {
+		return
+	}
+	if errors.Is(asError(p), http.ErrAbortHandler) {
+		// net/http's sentinel for a deliberate abort is not a fault.
+		panic(p)
+	}
+
+	// gRPC methods are a bounded set; gateway paths are not, so they share one
+	// label rather than risk unbounded metric cardinality.
+	method := "HTTP"
+	if isGRPC {
+		method = r.URL.Path
+	}
+	err := recovery.HandlePanic(r.Context(), method, p)
+
+	if w.wroteHeader {
+		panic(http.ErrAbortHandler)
+	}
+
+	if isGRPC {
+		// A trailers-only response: the status travels in the headers.
+		w.Header().Set("Content-Type", "application/grpc")
+		w.Header().Set("Grpc-Status", strconv.Itoa(int(codes.Internal)))
+		w.Header().Set("Grpc-Message", "internal error")
+		w.WriteHeader(http.StatusOK)
+		return
+	}
+
+	_, outbound := runtime.MarshalerForRequest(d.MUX, r)
+	runtime.HTTPError(r.Context(), d.MUX, outbound, w, r, err)
+}
+
+func asError(p any) error {
+	err, _ := p.(error)
+	return err
+}
+
+// panicResponseWriter is an http.ResponseWriter that records whether the
+// response has started, so recoverHTTP knows whether it can still write one.
+type panicResponseWriter struct {
+	http.ResponseWriter
+
+	wroteHeader bool
+}
+
+func (w *panicResponseWriter) WriteHeader(status int) {
+	w.wroteHeader = true
+	w.ResponseWriter.WriteHeader(status)
+}
+
+func (w *panicResponseWriter) Write(b []byte) (int, error) {
+	w.wroteHeader = true
+	return w.ResponseWriter.Write(b)
+}
+
+// Flush is required by grpc.Server.ServeHTTP and by streaming gateway methods.
+func (w *panicResponseWriter) Flush() {
+	w.wroteHeader = true
+	if f, ok := w.ResponseWriter.(http.Flusher); ok {
+		f.Flush()
+	}
+}
+
+// Unwrap lets http.ResponseController reach the underlying writer.
+func (w *panicResponseWriter) Unwrap() http.ResponseWriter {
+	return w.ResponseWriter
+}
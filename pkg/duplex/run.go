@@ -0,0 +1,154 @@
+/*
+Copyright 2026 Chainguard, Inc.
+SPDX-License-Identifier: Apache-2.0
+*/
+
+package duplex
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"os"
+	"os/signal"
+	"syscall"
+	"time"
+)
+
+const (
+	// defaultDrainTimeout bounds how long Run waits for in-flight requests to
+	// finish. It fits within Cloud Run's 10 second SIGTERM grace period.
+	defaultDrainTimeout = 10 * time.Second
+)
+
+// RunOption configures Run. Run returns the error of an invalid option without
+// serving.
+type RunOption func(*runConfig) error
+
+type runConfig struct {
+	signals        []os.Signal
+	preStopDelay   time.Duration
+	drainTimeout   time.Duration
+	tracerShutdown func()
+}
+
+// WithSignals sets the signals that start a graceful shutdown. The default is
+// SIGTERM and SIGINT.
+func WithSignals(sigs ...os.Signal) RunOption {
+	return func(c *runConfig) error {
+		if len(sigs) == 0 {
+			return errors.New("no shutdown signals")
+		}
+		c.signals = sigs
+		return nil
+	}
+}
+
+// WithPreStopDelay sets how long Run keeps serving after reporting NOT_SERVING
+// and before it starts draining, giving load balancers time to notice and stop
+// routing new requests. The default is no delay.
+func WithPreStopDelay(delay time.Duration) RunOption {
+	return func(c *runConfig) error {
+		if delay < 0 {
+			return fmt.Errorf("pre-stop delay must not be negative, got %v", delay)
+		}
+		c.preStopDelay = delay
+		return nil
+	}
+}
+
+// WithDrainTimeout sets how long Shutdown may wait for in-flight requests to
+// finish before they are cut off. The default is 10 seconds.
+func WithDrainTimeout(timeout time.Duration) RunOption {
+	return func(c *runConfig) error {
+		if timeout <= 0 {
+			return fmt.Errorf("drain timeout must be positive, got %v", timeout)
+		}
+		c.drainTimeout = timeout
+		return nil
+	}
+}
+
+// WithTracerShutdown sets a function Run calls once the Duplex has shut down,
+// to flush buffered spans, such as the one returned by metrics.SetupTracer.
+func WithTracerShutdown(shutdown func()) RunOption {
+	return func(c *runConfig) error {
+		c.tracerShutdown = shutdown
+		return nil
+	}
+}
+
+// Run serves d with ListenAndServe until a shutdown signal arrives or ctx is
+// cancelled, then shuts it down in order: it marks every service NOT_SERVING,
+// keeps serving for the pre-stop delay, drains in-flight requests with
+// Shutdown within the drain timeout, and finally flushes the tracer. If
+// serving fails first, d is shut down without the delay. A second signal
+// during shutdown terminates the process as usual. The errors from serving and
+// shutting down are returned together; a clean shutdown returns nil.
+//
+// Expected usage:
+//
+//	if err := duplex.Run(ctx, d, duplex.WithTracerShutdown(metrics.SetupTracer(ctx))); err != nil {
+//		log.Fatalf("Run() = %v", err)
+//	}
+func Run(ctx context.Context, d *Duplex, opts ...RunOption) error {
+	cfg := runConfig{
+		signals:      []os.Signal{syscall.SIGTERM, os.Interrupt},
+		drainTimeout: defaultDrainTimeout,
+	}
+	for _, o := range opts {
+		if err := o(&cfg); err != nil {
+			return err
+		}
+	}
+
+	sigCtx, stop := signal.NotifyContext(ctx, cfg.signals...)
+	defer stop()
+
+	serveErr := make(chan error, 1)
+	go func() { serveErr <- d.ListenAndServe(ctx) }()
+
+	var errs []error
+	served := false
+	select {
+	case err := <-serveErr:
+		served = true
+		errs = append(errs, serveError(err))
+	case <-sigCtx.Done():
+		// Restore default signal handling, so a second signal is not swallowed.
+		stop()
+
+		d.Health.Shutdown()
+		if cfg.preStopDelay > 0 {
+			time.Sleep(cfg.preStopDelay)
+		}
+	}
+
+	// ctx is done by now if it ended the wait, so the drain deadline is
+	// measured from here rather than inherited.
+	drainCtx, cancel := context.WithTimeout(context.WithoutCancel(ctx), cfg.drainTimeout)
+	defer cancel()
+	if err := d.Shutdown(drainCtx); err != nil {
+		errs = append(errs, fmt.Errorf("shutdown: %w", err))
+	}
+
+	if !served {
+		errs = append(errs, serveError(<-serveErr))
+	}
+
+	if cfg.tracerShutdown != nil {
+		cfg.tracerShutdown()
+	}
+
+	return errors.Join(errs...)
+}
+
+// serveError returns the error ListenAndServe stopped with, or nil if it
+// stopped because of Shutdown.
+func serveError(err error) error {
+	if err == nil || errors.Is(err, http.ErrServerClosed) {
+		return nil
+	}
+	return fmt.Errorf("serve: %w", err)
+}
@@ -0,0 +1,165 @@
+/*
+Copyright 2026 Chainguard, Inc.
+SPDX-License-Identifier: Apache-2.0
+*/
+
+package duplex
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"net/http"
+	"strings"
+	"sync/atomic"
+	"syscall"
+	"testing"
+	"time"
+
+	pb "chainguard.dev/go-grpc-kit/pkg/duplex/internal/proto/helloworld"
+)
+
+// startRun runs d in the background and waits until it is serving, returning
+// the address it serves on and the channel Run's result arrives on.
+func startRun(ctx context.Context, t *testing.T, opts []Option, runOpts ...RunOption) (string, <-chan error) {
+	t.Helper()
+
+	lis, err := net.Listen("tcp", "localhost:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	d, err := NewWithOptions(0, append([]Option{WithListener(lis)}, opts...)...)
+	if err != nil {
+		t.Fatalf("NewWithOptions: %v", err)
+	}
+	pb.RegisterGreeterServer(d.Server, &server{})
+
+	runErr := make(chan error, 1)
+	go func() { runErr <- Run(ctx, d, runOpts...) }()
+
+	addr := lis.Addr().String()
+	for deadline := time.Now().Add(5 * time.Second); ; {
+		if resp, err := http.Get(fmt.Sprintf("http://%s%s", addr, LivenessPath)); err == nil {
+			resp.Body.Close()
+			break
+		}
+		if time.Now().After(deadline) {
+			t.Fatal("server did not start serving")
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	return addr, runErr
+}
+
+func TestRun_ContextCancel(t *testing.T) {
+	ctx, cancel := context.WithCancel(t.Context())
+
+	var flushed atomic.Bool
+	_, runErr := startRun(ctx, t, nil, WithTracerShutdown(func() { flushed.Store(true) }))
+
+	cancel()
+
+	if err := <-runErr; err != nil {
+		t.Fatalf("Run: %v", err)
+	}
+	if !flushed.Load() {
+		t.Error("expected the tracer to be flushed")
+	}
+}
+
+func TestRun_Signal(t *testing.T) {
+	_, runErr := startRun(t.Context(), t, nil, WithSignals(syscall.SIGUSR1))
+
+	if err := syscall.Kill(syscall.Getpid(), syscall.SIGUSR1); err != nil {
+		t.Fatalf("signal: %v", err)
+	}
+
+	select {
+	case err := <-runErr:
+		if err != nil {
+			t.Fatalf("Run: %v", err)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("Run did not return after the shutdown signal")
+	}
+}
+
+// TestRun_PreStopDelay verifies that during the pre-stop delay the server keeps
+// serving but reports itself not ready.
+func TestRun_PreStopDelay(t *testing.T) {
+	ctx, cancel := context.WithCancel(t.Context())
+
+	addr, runErr := startRun(ctx, t, nil, WithPreStopDelay(time.Second))
+
+	cancel()
+
+	var status int
+	for deadline := time.Now().Add(time.Second); time.Now().Before(deadline); time.Sleep(10 * time.Millisecond) {
+		resp, err := http.Get(fmt.Sprintf("http://%s%s", addr, ReadinessPath))
+		if err != nil {
+			t.Fatalf("GET %s during the pre-stop delay: %v", ReadinessPath, err)
+		}
+		resp.Body.Close()
+		if status = resp.StatusCode; status == http.StatusServiceUnavailable {
+			break
+		}
+	}
+	if status != http.StatusServiceUnavailable {
+		t.Errorf("readiness during the pre-stop delay = %d, want %d", status, http.StatusServiceUnavailable)
+	}
+
+	if err := <-runErr; err != nil {
+		t.Fatalf("Run: %v", err)
+	}
+}
+
+func TestRun_ServeFailure(t *testing.T) {
+	lis, err := net.Listen("tcp", "localhost:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	// Hold a port so the metrics server cannot bind it.
+	taken, err := net.Listen("tcp", "localhost:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer taken.Close()
+
+	d, err := NewWithOptions(0,
+		WithListener(lis),
+		WithHost("localhost"),
+		WithMetrics(taken.Addr().(*net.TCPAddr).Port, false),
+	)
+	if err != nil {
+		t.Fatalf("NewWithOptions: %v", err)
+	}
+
+	var flushed atomic.Bool
+	err = Run(t.Context(), d, WithTracerShutdown(func() { flushed.Store(true) }))
+	if err == nil || !strings.Contains(err.Error(), "metrics server") {
+		t.Fatalf("expected Run to return the metrics server error, got %v", err)
+	}
+	if !flushed.Load() {
+		t.Error("expected the tracer to be flushed")
+	}
+}
+
+func TestRun_InvalidOptions(t *testing.T) {
+	d, err := NewWithOptions(0)
+	if err != nil {
+		t.Fatalf("NewWithOptions: %v", err)
+	}
+	for name, opt := range map[string]RunOption{
+		"no signals":         WithSignals(),
+		"negative delay":     WithPreStopDelay(-time.Second),
+		"zero drain timeout": WithDrainTimeout(0),
+		"negative drain":     WithDrainTimeout(-time.Second),
+	} {
+		t.Run(name, func(t *testing.T) {
+			if err := Run(t.Context(), d, opt); err == nil {
+				t.Error("Run() = nil, want error")
+			}
+		})
+	}
+}
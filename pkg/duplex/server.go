@@ -10,39 +10,82 @@ import (
 	"fmt"
 	"net"
 	"net/http"
+	"slices"
+	"strconv"
 	"strings"
 	"sync"
 	"time"
 
 	"github.com/grpc-ecosystem/grpc-gateway/v2/runtime"
 	"google.golang.org/grpc"
+	"google.golang.org/grpc/health"
+	healthpb "google.golang.org/grpc/health/grpc_health_v1"
 
 	"chainguard.dev/go-grpc-kit/pkg/interceptors/clientid"
+	"chainguard.dev/go-grpc-kit/pkg/interceptors/deadline"
+	"chainguard.dev/go-grpc-kit/pkg/interceptors/loadshed"
+	"chainguard.dev/go-grpc-kit/pkg/interceptors/ratelimit"
+	"chainguard.dev/go-grpc-kit/pkg/interceptors/recovery"
 	"chainguard.dev/go-grpc-kit/pkg/metrics"
 	"chainguard.dev/go-grpc-kit/pkg/options"
 )
 
 // handler routes inbound requests to either the gRPC server or the gateway MUX
 // based on the request content type, served over cleartext HTTP/2 (h2c) so gRPC
-// works on a cleartext port. Unencrypted HTTP/2 is enabled on the http.Server
-// via its Protocols field (see httpServerInstance). Each request is counted
-// while it runs, so Shutdown can wait for in-flight requests to finish.
+// works on a cleartext port, or over TLS when configured WithTLS. Unencrypted
+// HTTP/2 is enabled on the http.Server via its Protocols field (see
+// httpServerInstance). Each request is counted while it runs, so Shutdown can
+// wait for in-flight requests to finish, and a request on a connection past its
+// maximum age (see WithKeepalive) closes it. Operational endpoints
+// (LivenessPath, ReadinessPath, and metrics served WithMetricsPath) are
+// answered here, ahead of the gateway MUX and without being counted, so a probe
+// or a long pprof profile never holds Shutdown open. A panic is recovered here
+// too (see recoverHTTP); panics in gRPC handlers, which run on their own
+// goroutine, are recovered by the interceptors New installs.
 // See also, https://grpc-ecosystem.github.io/grpc-gateway/
 // This is based on: https://github.com/philips/grpc-gateway-example/issues/22#issuecomment-490733965
 func (d *Duplex) handler() http.Handler {
-	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
-		d.inflight.add()
-		defer d.inflight.done()
+	return http.HandlerFunc(func(rw http.ResponseWriter, r *http.Request) {
+		isGRPC := r.ProtoMajor == 2 && strings.Contains(r.Header.Get("Content-Type"), "application/grpc")
 
-		if r.ProtoMajor == 2 && strings.Contains(r.Header.Get("Content-Type"), "application/grpc") {
+		if d.connAger != nil {
+			d.connAger.expire(rw, r)
+		}
+
+		if !isGRPC {
+			if h := d.adminHandler(r.URL.Path); h != nil {
+				h.ServeHTTP(rw, r)
+				return
+			}
+		}
+
+		id := d.inflight.add(newInflightRequest(r, isGRPC))
+		defer d.inflight.done(id)
+
+		w := &panicResponseWriter{ResponseWriter: rw}
+		defer d.recoverHTTP(w, r, isGRPC)
+
+		if isGRPC {
 			d.Server.ServeHTTP(w, r)
 			return
 		}
 
-		d.MUX.ServeHTTP(w, r)
+		d.gateway.ServeHTTP(w, r)
 	})
 }
 
+// adminHandler returns the handler for the operational endpoints served on the
+// main port, or nil if path is not one of them.
+func (d *Duplex) adminHandler(path string) http.Handler {
+	switch path {
+	case LivenessPath:
+		return http.HandlerFunc(d.serveLiveness)
+	case ReadinessPath:
+		return http.HandlerFunc(d.serveReadiness)
+	}
+	return d.metricsHandler(path)
+}
+
 // allowedHeaders are HTTP headers that should be forwarded as gRPC metadata
 // by the grpc-gateway when converting REST requests to gRPC calls.
 var allowedHeaders = map[string]bool{
@@ -59,6 +102,20 @@ func incomingHeaderMatcher(key string) (string, bool) {
 	return runtime.DefaultHeaderMatcher(key)
 }
 
+// outgoingHeaderMatcher returns cgrequestid to REST callers under its own
+// name, so they can correlate a failure with server logs, and the retry-after
+// of a throttled call as the standard Retry-After header, and otherwise
+// applies the gateway's default Grpc-Metadata- prefix.
+func outgoingHeaderMatcher(key string) (string, bool) {
+	switch strings.ToLower(key) {
+	case clientid.CGRequestID:
+		return clientid.CGRequestID, true
+	case ratelimit.RetryAfter:
+		return "Retry-After", true
+	}
+	return runtime.MetadataHeaderPrefix + key, true
+}
+
 // Duplex is a wrapper for the gRPC server, gRPC HTTP Gateway MUX and options.
 type Duplex struct {
 	Server      *grpc.Server
@@ -68,47 +125,149 @@ type Duplex struct {
 	Port        int
 	DialOptions []grpc.DialOption
 
+	// Health is the standard gRPC health service, registered on Server by New
+	// and backing the HTTP ReadinessPath. Services may set their own status on
+	// it; Shutdown marks every service NOT_SERVING.
+	Health *health.Server
+
 	httpServerOnce sync.Once
 	httpServer     *http.Server
 
+	// gateway serves the requests routed to MUX, wrapped in any HTTPMiddleware
+	// configured.
+	gateway http.Handler
+
+	// cfg holds the settings NewWithOptions was given.
+	cfg config
+
+	// metrics is the metrics server on a second port, configured WithMetrics
+	// or by RegisterListenAndServeMetrics.
+	metrics metricsServer
+
+	// metricsOnMainPort serves metrics under the prefix configured
+	// WithMetricsPath, or is nil.
+	metricsOnMainPort http.Handler
+
 	// inflight counts requests currently being served, so Shutdown can wait for
 	// them to finish.
 	inflight inflightTracker
+
+	// streams tracks the gRPC streams being served, so Shutdown can cut them
+	// off.
+	streams *streamTracker
+
+	// watches tracks the health Watch streams being served, so Shutdown can
+	// end them once they report NOT_SERVING.
+	watches *watchTracker
+
+	// connAger ends connections past the maximum age configured WithKeepalive,
+	// or is nil.
+	connAger *connAger
+
+	// pings enforces the policy configured WithKeepaliveEnforcement on
+	// cleartext connections, or is nil.
+	pings *pingPolicy
 }
 
+// HTTPMiddleware wraps the handling of requests served by the gateway MUX, for
+// example with logging.HTTPMiddleware. It does not see gRPC requests, nor
+// requests to LivenessPath and ReadinessPath.
+type HTTPMiddleware func(http.Handler) http.Handler
+
 type RegisterHandlerFromEndpointFn func(ctx context.Context, mux *runtime.ServeMux, endpoint string, opts []grpc.DialOption) error
 
 // New creates a Duplex gRPC server / gRPC HTTP Gateway. New takes in options
 // for `grpc.NewServer`, typed `grpc.ServerOption`, and `runtime.NewServeMux`,
-// typed `runtime.ServeMuxOption`. Unknown opts will cause a panic.
+// typed `runtime.ServeMuxOption`, as well as `grpc.DialOption` for the loopback
+// connection, `HTTPMiddleware` for the gateway, and any typed Option.
+// Unknown opts, or an Option that reports an error, will cause a panic; use
+// NewWithOptions to get an error instead.
 func New(port int, opts ...interface{}) *Duplex {
-	// Split out the options into their types.
-	var (
-		gOpts []grpc.ServerOption
-		dOpts []grpc.DialOption
-		mOpts []runtime.ServeMuxOption
-	)
+	typed := make([]Option, 0, len(opts))
+	for _, o := range opts {
+		opt, err := legacyOption(o)
+		if err != nil {
+			panic(err)
+		}
+		typed = append(typed, opt)
+	}
+
+	d, err := NewWithOptions(port, typed...)
+	if err != nil {
+		panic(err)
+	}
+	return d
+}
+
+// NewWithOptions creates a Duplex gRPC server / gRPC HTTP Gateway serving on
+// port, configured by opts. Middleware is applied in the order given, the first
+// outermost. Panic recovery interceptors are installed ahead of any
+// interceptors passed in, so a panicking handler returns codes.Internal rather
+// than crashing the process. The standard gRPC health service is registered on
+// the returned server, reporting SERVING.
+func NewWithOptions(port int, opts ...Option) (*Duplex, error) {
+	if err := validatePort(port); err != nil {
+		return nil, err
+	}
+
+	var cfg config
 	for _, o := range opts {
-		switch opt := o.(type) {
-		case grpc.ServerOption:
-			gOpts = append(gOpts, opt)
-		case runtime.ServeMuxOption:
-			mOpts = append(mOpts, opt)
-		case grpc.DialOption:
-			dOpts = append(dOpts, opt)
-		default:
-			panic(fmt.Errorf("unknown type: %T", o))
+		if err := o(&cfg); err != nil {
+			return nil, err
+		}
+	}
+
+	// Loop back to the address of a provided listener, taking its port when
+	// none was given.
+	host := cfg.host
+	if cfg.listener != nil {
+		if addr, ok := cfg.listener.Addr().(*net.TCPAddr); ok {
+			host = addr.IP.String()
+			if port == 0 {
+				port = addr.Port
+			}
 		}
 	}
 
+	// Recover from panics outermost, so a panic in any interceptor is caught,
+	// then track streams and health watches so Shutdown can end them, shed
+	// load before any other work is done, and refuse calls already out of
+	// time.
+	streams, watches := &streamTracker{}, &watchTracker{}
+	gOpts := []grpc.ServerOption{
+		grpc.ChainUnaryInterceptor(recovery.UnaryServerInterceptor()),
+		grpc.ChainStreamInterceptor(recovery.StreamServerInterceptor(), streams.interceptor(), watches.interceptor()),
+	}
+	if cfg.loadShed != nil {
+		gOpts = append(gOpts,
+			grpc.ChainUnaryInterceptor(loadshed.UnaryServerInterceptor(cfg.loadShed)),
+			grpc.ChainStreamInterceptor(loadshed.StreamServerInterceptor(cfg.loadShed)),
+		)
+	}
+	if cfg.maxTimeout != nil {
+		gOpts = append(gOpts,
+			grpc.ChainUnaryInterceptor(deadline.UnaryServerInterceptor(*cfg.maxTimeout)),
+			grpc.ChainStreamInterceptor(deadline.StreamServerInterceptor()),
+		)
+	}
+	gOpts = append(gOpts, cfg.serverOpts...)
+
 	// Include the clientid interceptor on the loopback connection so that
 	// REST-originated requests carry cgclientid metadata. We use
 	// LoopbackDialOptions (not GRPCDialOptions) to avoid double-counting
 	// client metrics and creating noisy self-referential OTEL traces.
-	dOpts = append(options.LoopbackDialOptions(), dOpts...)
+	dOpts := options.LoopbackDialOptions()
+	if cfg.tls != nil {
+		dOpts = append(dOpts, loopbackTLSDialOption(cfg.tls))
+	}
+	dOpts = append(dOpts, cfg.dialOpts...)
 
-	// Always forward cgclientid from HTTP headers to gRPC metadata.
-	mOpts = append(mOpts, runtime.WithIncomingHeaderMatcher(incomingHeaderMatcher))
+	// Always forward cgclientid from HTTP headers to gRPC metadata, and return
+	// cgrequestid from gRPC response headers to HTTP callers.
+	mOpts := append(slices.Clone(cfg.muxOpts),
+		runtime.WithIncomingHeaderMatcher(incomingHeaderMatcher),
+		runtime.WithOutgoingHeaderMatcher(outgoingHeaderMatcher),
+	)
 
 	// Create the Duplex Server.
 	d := &Duplex{
@@ -116,11 +275,48 @@ func New(port int, opts ...interface{}) *Duplex {
 		MUX:    runtime.NewServeMux(mOpts...),
 		// The REST gateway translates the json to grpc and then dispatches to
 		// the appropriate method on this address, so we loopback to ourselves.
-		Loopback:    fmt.Sprintf("localhost:%d", port),
+		Loopback:    loopbackAddress(host, port),
+		Host:        cfg.host,
 		Port:        port,
 		DialOptions: dOpts,
+		Health:      health.NewServer(),
+		cfg:         cfg,
+		streams:     streams,
+		watches:     watches,
 	}
-	return d
+	healthpb.RegisterHealthServer(d.Server, d.Health)
+
+	if cfg.keepalive != nil {
+		d.connAger = newConnAger(*cfg.keepalive)
+	}
+	if cfg.enforcement != nil {
+		d.pings = newPingPolicy(*cfg.enforcement)
+	}
+
+	d.metrics.handler = d.observabilityHandler
+	d.metrics.configure(cfg.metricsPort, nil, cfg.metricsPprof)
+	if cfg.metricsPath != "" {
+		d.metricsOnMainPort = http.StripPrefix(cfg.metricsPath, d.observabilityHandler(cfg.metricsPathPprof))
+	}
+
+	d.gateway = d.MUX
+	if cfg.maxTimeout != nil {
+		d.gateway = deadline.HTTPMiddleware(d.gateway)
+	}
+	for i := len(cfg.middleware) - 1; i >= 0; i-- {
+		d.gateway = cfg.middleware[i](d.gateway)
+	}
+	return d, nil
+}
+
+// loopbackAddress returns the address the gateway dials to reach a server
+// listening on host and port: localhost when it listens on every interface, and
+// host itself when it listens on a specific one.
+func loopbackAddress(host string, port int) string {
+	if ip := net.ParseIP(host); host == "" || ip != nil && ip.IsUnspecified() {
+		host = "localhost"
+	}
+	return net.JoinHostPort(host, strconv.Itoa(port))
 }
 
 // RegisterHandler is a helper registration handler to call the passed in
@@ -138,19 +334,49 @@ func (d *Duplex) RegisterHandler(ctx context.Context, fn RegisterHandlerFromEndp
 	return fn(ctx, d.MUX, d.Loopback, d.DialOptions)
 }
 
-// ListenAndServe starts both the gRPC server and HTTP Gateway MUX.
-// Note: This call is blocking. It returns http.ErrServerClosed after Shutdown.
-func (d *Duplex) ListenAndServe(_ context.Context) error {
+// ListenAndServe starts both the gRPC server and HTTP Gateway MUX, and the
+// metrics server if one is configured. If a listener was provided WithListener,
+// it serves on that instead of listening on the host and port.
+// Note: This call is blocking. It returns http.ErrServerClosed after Shutdown,
+// or the error that stopped the main or metrics server.
+func (d *Duplex) ListenAndServe(ctx context.Context) error {
+	if d.cfg.listener != nil {
+		return d.Serve(ctx, d.cfg.listener)
+	}
+
 	server := d.httpServerInstance()
 	server.Addr = fmt.Sprintf("%s:%d", d.Host, d.Port)
 
-	return server.ListenAndServe()
+	if d.cfg.tls != nil {
+		// The certificates come from server.TLSConfig.
+		return d.serve(func() error { return server.ListenAndServeTLS("", "") })
+	}
+	if d.pings != nil {
+		// The ping policy is enforced on the listener's connections.
+		lis, err := net.Listen("tcp", server.Addr)
+		if err != nil {
+			return err
+		}
+		return d.Serve(ctx, lis)
+	}
+	return d.serve(server.ListenAndServe)
 }
 
-// Serve starts both the gRPC server and HTTP Gateway MUX on the given listener.
-// Note: This call is blocking. It returns http.ErrServerClosed after Shutdown.
+// Serve starts both the gRPC server and HTTP Gateway MUX on the given listener,
+// and the metrics server if one is configured.
+// Note: This call is blocking. It returns http.ErrServerClosed after Shutdown,
+// or the error that stopped the main or metrics server.
 func (d *Duplex) Serve(_ context.Context, listener net.Listener) error {
-	return d.httpServerInstance().Serve(listener)
+	server := d.httpServerInstance()
+
+	if d.cfg.tls != nil {
+		// The certificates come from server.TLSConfig.
+		return d.serve(func() error { return server.ServeTLS(listener, "", "") })
+	}
+	if d.pings != nil {
+		listener = d.pings.listener(listener)
+	}
+	return d.serve(func() error { return server.Serve(listener) })
 }
 
 // httpServerInstance returns the underlying http.Server, constructing it on
@@ -159,27 +385,62 @@ func (d *Duplex) httpServerInstance() *http.Server {
 	d.httpServerOnce.Do(func() {
 		// Enable cleartext HTTP/2 (h2c) alongside HTTP/1 so gRPC works on the
 		// cleartext port. This replaces the deprecated x/net/http2/h2c handler.
+		// Over TLS, HTTP/2 is negotiated via ALPN instead.
 		protocols := new(http.Protocols)
 		protocols.SetHTTP1(true)
-		protocols.SetUnencryptedHTTP2(true)
+		if d.cfg.tls != nil {
+			protocols.SetHTTP2(true)
+		} else {
+			protocols.SetUnencryptedHTTP2(true)
+		}
 		d.httpServer = &http.Server{
-			Handler:           d.handler(),
-			ReadHeaderTimeout: 10 * time.Second,
-			Protocols:         protocols,
+			Handler:   d.handler(),
+			Protocols: protocols,
+		}
+		if d.cfg.tls != nil {
+			d.httpServer.TLSConfig = serverTLSConfig(d.cfg.tls)
+		}
+		d.cfg.httpServer.apply(d.httpServer)
+		if d.cfg.keepalive != nil {
+			applyKeepalive(*d.cfg.keepalive, d.httpServer)
+		}
+		if d.connAger != nil {
+			d.httpServer.ConnContext = d.connAger.connContext
+		}
+		if d.connAger != nil || d.pings != nil {
+			d.httpServer.ConnState = d.connState
 		}
 	})
 
 	return d.httpServer
 }
 
-// Shutdown gracefully stops the duplex. It stops accepting new connections and
-// waits for in-flight requests to finish, bounded by ctx; if ctx is done before
-// they drain it stops waiting and returns ctx.Err(). After Shutdown returns, the
+// connState tells the connAger and the ping policy, whichever are configured,
+// that c changed state.
+func (d *Duplex) connState(c net.Conn, state http.ConnState) {
+	if pc, ok := c.(*policedConn); ok {
+		pc.setState(state)
+	}
+	if d.connAger != nil {
+		d.connAger.connState(c, state)
+	}
+}
+
+// Shutdown gracefully stops the duplex. It first marks every service in the
+// health service NOT_SERVING, so health checks and ReadinessPath report the
+// server draining, and ends health Watch streams with codes.Unavailable once
+// they have sent their clients NOT_SERVING. It then stops accepting new
+// connections, sends every HTTP/2 connection a GOAWAY so its client takes new
+// requests elsewhere, and waits for in-flight requests to finish, bounded by
+// ctx; if ctx is done before they drain it stops waiting and returns
+// ctx.Err(). After Shutdown returns, the
 // blocking ListenAndServe or Serve call returns http.ErrServerClosed.
 //
 // The wait is bounded only by ctx, mirroring http.Server.Shutdown: pass a
 // context with a deadline to cap it, or a long-lived request will hold shutdown
-// open indefinitely.
+// open indefinitely. Configured WithStreamGracePeriod, gRPC streams still open
+// after the grace period are cut off with codes.Unavailable. Streams cut off,
+// then or when ctx ends, are counted in duplex_shutdown_streams_cut_off_total.
 //
 // gRPC is served over cleartext HTTP/2 via a grpc.Server.ServeHTTP handler, and
 // grpc.Server.GracefulStop panics on a ServeHTTP-backed transport, so it cannot
@@ -192,14 +453,32 @@ func (d *Duplex) httpServerInstance() *http.Server {
 func (d *Duplex) Shutdown(ctx context.Context) error {
 	server := d.httpServerInstance()
 
-	// Stop accepting new connections and drain the HTTP server's connections.
-	// Run it in the background while the in-flight counter is drained below,
-	// which is what reports the outcome for requests of both kinds. Its error is
-	// dropped deliberately: the wait below reports the drain outcome, and the
+	// Report NOT_SERVING before draining, so load balancers stop routing to us
+	// while in-flight requests finish. Later status updates are ignored. Health
+	// Watch streams end once they have sent NOT_SERVING, rather than holding
+	// the drain open.
+	d.Health.Shutdown()
+	d.watches.drain()
+
+	// Stop accepting new connections and drain the HTTP server's connections,
+	// which starts by sending each HTTP/2 connection a GOAWAY. Run it in the
+	// background while the in-flight counter is drained below, which is what
+	// reports the outcome for requests of both kinds. Its error is dropped
+	// deliberately: the wait below reports the drain outcome, and the
 	// conditional Close below is the backstop.
 	go func() { _ = server.Shutdown(ctx) }()
 
+	if grace := d.cfg.streamGracePeriod; grace > 0 {
+		timer := time.AfterFunc(grace, func() { d.cutOffStreams(ctx, cutOffGracePeriod) })
+		defer timer.Stop()
+	}
+
+	start := time.Now()
 	err := d.inflight.wait(ctx)
+	observeDrain(time.Since(start), err)
+	if err != nil {
+		d.cutOffStreams(ctx, cutOffDrainTimeout)
+	}
 
 	d.Server.Stop()
 
@@ -212,20 +491,32 @@ func (d *Duplex) Shutdown(ctx context.Context) error {
 		_ = server.Close()
 	}
 
+	// Stop the metrics server last, so the drain can be observed. If the drain
+	// ran out of time, there is none left to shut it down gracefully.
+	if merr := d.metrics.shutdown(ctx, err != nil); err == nil {
+		err = merr
+	}
+
 	return err
 }
 
-// RegisterListenAndServe initializes Prometheus metrics and starts a HTTP
-// /metrics endpoint for exporting Prometheus metrics in the background.
-// Call this *after* all services have been registered.
+// RegisterListenAndServeMetrics initializes Prometheus metrics and starts a
+// HTTP /metrics endpoint for exporting Prometheus metrics in the background.
+// Call this *after* all services have been registered. The metrics server is
+// managed like one configured WithMetrics: a failure is returned by
+// ListenAndServe or Serve, and Shutdown stops it.
 func (d *Duplex) RegisterListenAndServeMetrics(port int, enablePprof bool) {
-	metrics.RegisterListenAndServe(d.Server, fmt.Sprintf("%s:%d", d.Host, port), enablePprof)
+	metrics.Initialize(d.Server)
+	d.metrics.configure(port, nil, enablePprof)
+	d.metrics.start(d.Host)
 }
 
-// RegisterAndServe initializes Prometheus metrics and starts a HTTP
+// RegisterAndServeMetrics initializes Prometheus metrics and starts a HTTP
 // /metrics endpoint for exporting Prometheus metrics in the background.
 // Call this *after* all services have been registered.
 // Used ONLY for testing
 func (d *Duplex) RegisterAndServeMetrics(listener net.Listener, enablePprof bool) {
-	metrics.RegisterAndServe(d.Server, listener, enablePprof)
+	metrics.Initialize(d.Server)
+	d.metrics.configure(0, listener, enablePprof)
+	d.metrics.start(d.Host)
 }
@@ -8,6 +8,11 @@ package duplex
 import (
 	"bytes"
 	"context"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
 	"encoding/json"
 	"errors"
 	"fmt"
@@ -15,16 +20,32 @@ import (
 	"log"
 	"net"
 	"net/http"
+	"net/http/httptest"
 	"strings"
 	"testing"
 	"time"
 
 	pb "chainguard.dev/go-grpc-kit/pkg/duplex/internal/proto/helloworld"
+	"chainguard.dev/go-grpc-kit/pkg/interceptors/auth"
 	"chainguard.dev/go-grpc-kit/pkg/interceptors/clientid"
+	"chainguard.dev/go-grpc-kit/pkg/interceptors/deadline"
+	"chainguard.dev/go-grpc-kit/pkg/interceptors/loadshed"
+	"chainguard.dev/go-grpc-kit/pkg/interceptors/ratelimit"
 	"chainguard.dev/go-grpc-kit/pkg/metrics"
+	"github.com/google/go-cmp/cmp"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	dto "github.com/prometheus/client_model/go"
+	"golang.org/x/net/http2"
 	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/connectivity"
 	"google.golang.org/grpc/credentials/insecure"
+	healthpb "google.golang.org/grpc/health/grpc_health_v1"
+	"google.golang.org/grpc/keepalive"
 	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/status"
+	"google.golang.org/protobuf/types/known/emptypb"
 )
 
 func TestMetrics(t *testing.T) {
@@ -247,6 +268,135 @@ func TestHTTPLoopbackWithoutExplicitDialOptions(t *testing.T) {
 	}
 }
 
+// TestRequestIDHeader verifies that a REST caller through the gateway gets the
+// cgrequestid it sent echoed back as a plain response header.
+func TestRequestIDHeader(t *testing.T) {
+	ctx := t.Context()
+
+	lis, err := net.Listen("tcp", "localhost:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	ip, err := net.ResolveTCPAddr(lis.Addr().Network(), lis.Addr().String())
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	d := New(ip.Port, grpc.ChainUnaryInterceptor(clientid.UnaryServerInterceptor()))
+	pb.RegisterGreeterServer(d.Server, &server{})
+	if err := d.RegisterHandler(ctx, pb.RegisterGreeterHandlerFromEndpoint); err != nil {
+		t.Fatalf("RegisterHandler: %v", err)
+	}
+
+	go func() { _ = d.Serve(ctx, lis) }()
+	t.Cleanup(func() { _ = d.Shutdown(context.Background()) })
+
+	body, _ := json.Marshal(&pb.HelloRequest{Name: "request-id"})
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost,
+		fmt.Sprintf("http://%s/v1/example/echo", lis.Addr().String()), bytes.NewBuffer(body))
+	if err != nil {
+		t.Fatal(err)
+	}
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set(clientid.CGRequestID, "rest-req-id")
+
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		t.Fatalf("HTTP POST: %v", err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		t.Fatalf("expected 200, got %d", resp.StatusCode)
+	}
+	if got := resp.Header.Get(clientid.CGRequestID); got != "rest-req-id" {
+		t.Errorf("response header %s = %q, want %q", clientid.CGRequestID, got, "rest-req-id")
+	}
+}
+
+// TestAuthThroughGateway verifies that the bearer token of a REST call reaches
+// the auth interceptors through the gateway.
+func TestAuthThroughGateway(t *testing.T) {
+	ctx := t.Context()
+
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	pub, err := key.PublicKey.Bytes()
+	if err != nil {
+		t.Fatal(err)
+	}
+	enc := base64.RawURLEncoding.EncodeToString
+	jwks, _ := json.Marshal(map[string]any{"keys": []map[string]string{{
+		"kty": "EC", "crv": "P-256", "x": enc(pub[1:33]), "y": enc(pub[33:]),
+	}}})
+	v, err := auth.NewVerifier(auth.FetcherFunc(func(context.Context) ([]byte, error) { return jwks, nil }),
+		"https://issuer.example.com", "https://api.example.com")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	header, _ := json.Marshal(map[string]string{"alg": "ES256"})
+	claims, _ := json.Marshal(map[string]any{
+		"iss": "https://issuer.example.com",
+		"aud": "https://api.example.com",
+		"exp": time.Now().Add(time.Hour).Unix(),
+	})
+	signed := enc(header) + "." + enc(claims)
+	digest := sha256.Sum256([]byte(signed))
+	r, sig, err := ecdsa.Sign(rand.Reader, key, digest[:])
+	if err != nil {
+		t.Fatal(err)
+	}
+	token := signed + "." + enc(append(r.FillBytes(make([]byte, 32)), sig.FillBytes(make([]byte, 32))...))
+
+	lis, err := net.Listen("tcp", "localhost:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	d, err := NewWithOptions(0, WithListener(lis),
+		WithServerOptions(grpc.ChainUnaryInterceptor(auth.UnaryServerInterceptor(v))))
+	if err != nil {
+		t.Fatalf("NewWithOptions() = %v", err)
+	}
+	pb.RegisterGreeterServer(d.Server, &server{})
+	if err := d.RegisterHandler(ctx, pb.RegisterGreeterHandlerFromEndpoint); err != nil {
+		t.Fatalf("RegisterHandler: %v", err)
+	}
+	go func() { _ = d.ListenAndServe(ctx) }()
+	t.Cleanup(func() { _ = d.Shutdown(context.Background()) })
+
+	for _, tc := range []struct {
+		authorization string
+		want          int
+	}{
+		{"Bearer " + token, http.StatusOK},
+		{"", http.StatusUnauthorized},
+		{"Bearer " + signed + ".AAAA", http.StatusUnauthorized},
+	} {
+		body, _ := json.Marshal(&pb.HelloRequest{Name: "auth"})
+		req, err := http.NewRequestWithContext(ctx, http.MethodPost,
+			fmt.Sprintf("http://%s/v1/example/echo", lis.Addr().String()), bytes.NewBuffer(body))
+		if err != nil {
+			t.Fatal(err)
+		}
+		req.Header.Set("Content-Type", "application/json")
+		if tc.authorization != "" {
+			req.Header.Set("Authorization", tc.authorization)
+		}
+
+		resp, err := http.DefaultClient.Do(req)
+		if err != nil {
+			t.Fatalf("HTTP POST: %v", err)
+		}
+		resp.Body.Close()
+		if resp.StatusCode != tc.want {
+			t.Errorf("Authorization %q: status = %d, want %d", tc.authorization, resp.StatusCode, tc.want)
+		}
+	}
+}
+
 // TestShutdown verifies that Shutdown drains the duplex and unblocks the
 // serving goroutine: a request succeeds before shutdown, Shutdown returns
 // cleanly, and Serve then returns http.ErrServerClosed.
@@ -405,38 +555,1245 @@ func TestShutdownTimeoutStopsInflight(t *testing.T) {
 	}
 }
 
-// blockingServer holds each SayHello in the handler until release is closed,
-// signaling started once a request has arrived. It lets a test drive the
-// duplex into a state where a request is genuinely in flight during shutdown.
-type blockingServer struct {
-	pb.UnimplementedGreeterServer
+// TestHealth verifies that New registers the gRPC health service and that the
+// HTTP liveness and readiness endpoints are served on the same port.
+func TestHealth(t *testing.T) {
+	ctx := t.Context()
 
-	started chan struct{}
-	release chan struct{}
+	lis, err := net.Listen("tcp", "localhost:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	d := New(0)
+	pb.RegisterGreeterServer(d.Server, &server{})
+
+	go func() { _ = d.Serve(ctx, lis) }()
+	t.Cleanup(func() { _ = d.Shutdown(context.Background()) })
+
+	conn, err := grpc.NewClient(lis.Addr().String(), grpc.WithTransportCredentials(insecure.NewCredentials()))
+	if err != nil {
+		t.Fatalf("failed to dial: %v", err)
+	}
+	defer conn.Close()
+
+	resp, err := healthpb.NewHealthClient(conn).Check(ctx, &healthpb.HealthCheckRequest{})
+	if err != nil {
+		t.Fatalf("health check failed: %v", err)
+	}
+	if got, want := resp.GetStatus(), healthpb.HealthCheckResponse_SERVING; got != want {
+		t.Errorf("health status = %v, want %v", got, want)
+	}
+
+	for _, path := range []string{LivenessPath, ReadinessPath} {
+		httpResp, err := http.Get(fmt.Sprintf("http://%s%s", lis.Addr().String(), path))
+		if err != nil {
+			t.Fatalf("GET %s: %v", path, err)
+		}
+		httpResp.Body.Close()
+		if httpResp.StatusCode != http.StatusOK {
+			t.Errorf("GET %s = %d, want %d", path, httpResp.StatusCode, http.StatusOK)
+		}
+	}
 }
 
-func (s *blockingServer) SayHello(_ context.Context, in *pb.HelloRequest) (*pb.HelloReply, error) {
-	s.started <- struct{}{}
-	<-s.release
-	return &pb.HelloReply{Message: "Hello " + in.GetName()}, nil
+// TestHealthShutdown verifies that Shutdown flips readiness to NOT_SERVING
+// while liveness keeps reporting the process up.
+func TestHealthShutdown(t *testing.T) {
+	d := New(0)
+	d.Health.SetServingStatus("helloworld.Greeter", healthpb.HealthCheckResponse_SERVING)
+
+	if err := d.Shutdown(t.Context()); err != nil {
+		t.Fatalf("Shutdown: %v", err)
+	}
+
+	cases := []struct {
+		path string
+		want int
+	}{
+		{LivenessPath, http.StatusOK},
+		{ReadinessPath, http.StatusServiceUnavailable},
+		{ReadinessPath + "?service=helloworld.Greeter", http.StatusServiceUnavailable},
+		{ReadinessPath + "?service=unknown", http.StatusNotFound},
+	}
+	for _, tc := range cases {
+		rec := httptest.NewRecorder()
+		d.handler().ServeHTTP(rec, httptest.NewRequest(http.MethodGet, tc.path, nil))
+		if rec.Code != tc.want {
+			t.Errorf("GET %s = %d, want %d", tc.path, rec.Code, tc.want)
+		}
+	}
 }
 
-// server is used to implement helloworld.GreeterServer.
-type server struct {
+// TestHTTPMiddleware verifies that middleware passed to New wraps gateway
+// requests, outermost first, but not the health endpoints.
+func TestHTTPMiddleware(t *testing.T) {
+	var seen []string
+	mw := func(name string) func(http.Handler) http.Handler {
+		return func(next http.Handler) http.Handler {
+			return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				seen = append(seen, name+" "+r.URL.Path)
+				next.ServeHTTP(w, r)
+			})
+		}
+	}
+
+	d := New(0, HTTPMiddleware(mw("outer")), mw("inner"))
+
+	for _, path := range []string{"/v1/example/echo", LivenessPath, ReadinessPath} {
+		d.handler().ServeHTTP(httptest.NewRecorder(), httptest.NewRequest(http.MethodGet, path, nil))
+	}
+
+	want := []string{"outer /v1/example/echo", "inner /v1/example/echo"}
+	if diff := cmp.Diff(want, seen); diff != "" {
+		t.Errorf("middleware calls -want,+got: %s", diff)
+	}
+}
+
+// TestRecovery verifies that a panic in a gRPC handler or in gateway middleware
+// is returned to the caller as an internal error, and the server keeps serving.
+func TestRecovery(t *testing.T) {
+	ctx := t.Context()
+
+	lis, err := net.Listen("tcp", "localhost:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	ip, err := net.ResolveTCPAddr(lis.Addr().Network(), lis.Addr().String())
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	panicky := HTTPMiddleware(func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			if r.URL.Path == "/panic" {
+				panic("middleware boom")
+			}
+			next.ServeHTTP(w, r)
+		})
+	})
+
+	d := New(ip.Port, panicky)
+	pb.RegisterGreeterServer(d.Server, &panickingServer{})
+	if err := d.RegisterHandler(ctx, pb.RegisterGreeterHandlerFromEndpoint); err != nil {
+		t.Fatalf("RegisterHandler: %v", err)
+	}
+
+	go func() { _ = d.Serve(ctx, lis) }()
+	t.Cleanup(func() { _ = d.Shutdown(context.Background()) })
+
+	conn, err := grpc.NewClient(lis.Addr().String(), grpc.WithTransportCredentials(insecure.NewCredentials()))
+	if err != nil {
+		t.Fatalf("failed to dial: %v", err)
+	}
+	defer conn.Close()
+
+	_, err = pb.NewGreeterClient(conn).SayHello(ctx, &pb.HelloRequest{Name: "world"})
+	if got := status.Code(err); got != codes.Internal {
+		t.Errorf("grpc status = %v, want %v", got, codes.Internal)
+	}
+
+	// The gateway maps the handler's codes.Internal to a 500.
+	body, _ := json.Marshal(&pb.HelloRequest{Name: "world"})
+	resp, err := http.Post(fmt.Sprintf("http://%s/v1/example/echo", lis.Addr().String()), "application/json", bytes.NewBuffer(body))
+	if err != nil {
+		t.Fatalf("HTTP POST: %v", err)
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusInternalServerError {
+		t.Errorf("gateway status = %d, want %d", resp.StatusCode, http.StatusInternalServerError)
+	}
+
+	// A panic in middleware is recovered at the HTTP layer.
+	resp, err = http.Get(fmt.Sprintf("http://%s/panic", lis.Addr().String()))
+	if err != nil {
+		t.Fatalf("HTTP GET: %v", err)
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusInternalServerError {
+		t.Errorf("middleware panic status = %d, want %d", resp.StatusCode, http.StatusInternalServerError)
+	}
+
+	// The server is still up.
+	resp, err = http.Get(fmt.Sprintf("http://%s%s", lis.Addr().String(), LivenessPath))
+	if err != nil {
+		t.Fatalf("HTTP GET: %v", err)
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		t.Errorf("liveness status = %d, want %d", resp.StatusCode, http.StatusOK)
+	}
+}
+
+// panickingServer panics in every SayHello.
+type panickingServer struct {
 	pb.UnimplementedGreeterServer
+}
 
-	// lastClientID captures the cgclientid from the most recent request.
-	lastClientID string
+func (*panickingServer) SayHello(context.Context, *pb.HelloRequest) (*pb.HelloReply, error) {
+	panic("handler boom")
 }
 
-// SayHello implements helloworld.GreeterServer
-func (s *server) SayHello(ctx context.Context, in *pb.HelloRequest) (*pb.HelloReply, error) {
-	md, _ := metadata.FromIncomingContext(ctx)
-	log.Printf("Received: %v (%v)", in.GetName(), md)
-	if vals := md.Get(clientid.CGClientID); len(vals) > 0 {
-		s.lastClientID = vals[0]
-	} else {
-		s.lastClientID = ""
+func TestNewWithOptions_Errors(t *testing.T) {
+	cases := []struct {
+		name string
+		port int
+		opts []Option
+	}{
+		{"negative port", -1, nil},
+		{"port out of range", 70000, nil},
+		{"nil listener", 0, []Option{WithListener(nil)}},
+		{"nil middleware", 0, []Option{WithHTTPMiddleware(nil)}},
+		{"negative timeout", 0, []Option{WithHTTPServerConfig(HTTPServerConfig{ReadTimeout: -time.Second})}},
+		{"negative max header bytes", 0, []Option{WithHTTPServerConfig(HTTPServerConfig{MaxHeaderBytes: -1})}},
+		{"unset metrics port", 0, []Option{WithMetrics(0, false)}},
+		{"nil load shedder", 0, []Option{WithLoadShedding(nil)}},
+		{"zero stream grace period", 0, []Option{WithStreamGracePeriod(0)}},
+		{"negative keepalive", 0, []Option{WithKeepalive(keepalive.ServerParameters{MaxConnectionAge: -time.Second})}},
+		{"negative keepalive enforcement", 0, []Option{WithKeepaliveEnforcement(keepalive.EnforcementPolicy{MinTime: -time.Second})}},
+		{"negative max timeout", 0, []Option{WithDeadlines(-time.Second)}},
+	}
+
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			if _, err := NewWithOptions(tc.port, tc.opts...); err == nil {
+				t.Error("expected an error")
+			}
+		})
+	}
+}
+
+func TestNew_UnknownOptionPanics(t *testing.T) {
+	defer func() {
+		if r := recover(); r == nil {
+			t.Error("expected panic for an unknown option type")
+		}
+	}()
+	New(0, "not an option")
+}
+
+func TestNew_AcceptsTypedOptions(t *testing.T) {
+	d := New(8080, WithHost("127.0.0.1"), WithHTTPServerConfig(HTTPServerConfig{
+		ReadTimeout:    time.Minute,
+		MaxHeaderBytes: 4096,
+	}))
+
+	if d.Host != "127.0.0.1" {
+		t.Errorf("Host = %q, want %q", d.Host, "127.0.0.1")
+	}
+
+	server := d.httpServerInstance()
+	if server.ReadTimeout != time.Minute {
+		t.Errorf("ReadTimeout = %v, want %v", server.ReadTimeout, time.Minute)
+	}
+	if server.ReadHeaderTimeout != defaultReadHeaderTimeout {
+		t.Errorf("ReadHeaderTimeout = %v, want the default %v", server.ReadHeaderTimeout, defaultReadHeaderTimeout)
+	}
+	if server.MaxHeaderBytes != 4096 {
+		t.Errorf("MaxHeaderBytes = %d, want %d", server.MaxHeaderBytes, 4096)
+	}
+}
+
+// TestWithListener verifies that ListenAndServe serves on a provided listener,
+// and that the gateway loopback dials the listener's port.
+func TestWithListener(t *testing.T) {
+	ctx := t.Context()
+
+	lis, err := net.Listen("tcp", "localhost:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	d, err := NewWithOptions(0, WithListener(lis))
+	if err != nil {
+		t.Fatalf("NewWithOptions: %v", err)
+	}
+	if want := lis.Addr().String(); d.Loopback != want {
+		t.Errorf("Loopback = %q, want %q", d.Loopback, want)
+	}
+
+	pb.RegisterGreeterServer(d.Server, &server{})
+	if err := d.RegisterHandler(ctx, pb.RegisterGreeterHandlerFromEndpoint); err != nil {
+		t.Fatalf("RegisterHandler: %v", err)
+	}
+
+	go func() { _ = d.ListenAndServe(ctx) }()
+	t.Cleanup(func() { _ = d.Shutdown(context.Background()) })
+
+	body, _ := json.Marshal(&pb.HelloRequest{Name: "listener"})
+	resp, err := http.Post(fmt.Sprintf("http://%s/v1/example/echo", lis.Addr().String()), "application/json", bytes.NewBuffer(body))
+	if err != nil {
+		t.Fatalf("HTTP POST: %v", err)
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		t.Errorf("expected 200, got %d", resp.StatusCode)
+	}
+}
+
+// TestWithHost verifies that the gateway loopback dials the interface
+// ListenAndServe listens on.
+func TestWithHost(t *testing.T) {
+	ctx := t.Context()
+
+	// Find a free port on an interface other than localhost.
+	const host = "127.0.0.2"
+	lis, err := net.Listen("tcp", host+":0")
+	if err != nil {
+		t.Skipf("cannot listen on %s: %v", host, err)
+	}
+	port := lis.Addr().(*net.TCPAddr).Port
+	lis.Close()
+
+	d, err := NewWithOptions(port, WithHost(host))
+	if err != nil {
+		t.Fatalf("NewWithOptions: %v", err)
+	}
+	if want := fmt.Sprintf("%s:%d", host, port); d.Loopback != want {
+		t.Errorf("Loopback = %q, want %q", d.Loopback, want)
+	}
+
+	pb.RegisterGreeterServer(d.Server, &server{})
+	if err := d.RegisterHandler(ctx, pb.RegisterGreeterHandlerFromEndpoint); err != nil {
+		t.Fatalf("RegisterHandler: %v", err)
+	}
+	go func() { _ = d.ListenAndServe(ctx) }()
+	t.Cleanup(func() { _ = d.Shutdown(context.Background()) })
+
+	// The server starts listening, and the loopback reconnects to it, in the
+	// background.
+	body, _ := json.Marshal(&pb.HelloRequest{Name: "host"})
+	var code int
+	for range 100 {
+		resp, err := http.Post(fmt.Sprintf("http://%s:%d/v1/example/echo", host, port), "application/json", bytes.NewBuffer(body))
+		if err == nil {
+			resp.Body.Close()
+			if code = resp.StatusCode; code == http.StatusOK {
+				break
+			}
+		}
+		time.Sleep(50 * time.Millisecond)
+	}
+	if code != http.StatusOK {
+		t.Errorf("expected 200, got %d", code)
+	}
+}
+
+func TestLoopbackAddress(t *testing.T) {
+	for _, tc := range []struct {
+		host string
+		want string
+	}{
+		{"", "localhost:8080"},
+		{"0.0.0.0", "localhost:8080"},
+		{"::", "localhost:8080"},
+		{"localhost", "localhost:8080"},
+		{"10.0.0.5", "10.0.0.5:8080"},
+		{"fd00::5", "[fd00::5]:8080"},
+	} {
+		if got := loopbackAddress(tc.host, 8080); got != tc.want {
+			t.Errorf("loopbackAddress(%q) = %q, want %q", tc.host, got, tc.want)
+		}
+	}
+}
+
+// TestMetricsShutdown verifies that Shutdown stops the metrics server along
+// with the main one.
+func TestMetricsShutdown(t *testing.T) {
+	ctx := t.Context()
+
+	lis, err := net.Listen("tcp", "localhost:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	mlis, err := net.Listen("tcp", "localhost:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	d := New(0)
+	pb.RegisterGreeterServer(d.Server, &server{})
+	d.RegisterAndServeMetrics(mlis, false)
+
+	serveErr := make(chan error, 1)
+	go func() { serveErr <- d.Serve(ctx, lis) }()
+
+	metricsURL := fmt.Sprintf("http://%s/metrics", mlis.Addr().String())
+	resp, err := http.Get(metricsURL)
+	if err != nil {
+		t.Fatalf("GET /metrics: %v", err)
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		t.Fatalf("GET /metrics = %d, want %d", resp.StatusCode, http.StatusOK)
+	}
+
+	if err := d.Shutdown(ctx); err != nil {
+		t.Fatalf("Shutdown: %v", err)
+	}
+	if err := <-serveErr; !errors.Is(err, http.ErrServerClosed) {
+		t.Fatalf("Serve returned unexpected error: %v", err)
+	}
+
+	client := &http.Client{Transport: &http.Transport{DisableKeepAlives: true}}
+	if resp, err := client.Get(metricsURL); err == nil {
+		resp.Body.Close()
+		t.Error("expected the metrics server to be stopped after Shutdown")
+	}
+}
+
+// TestMetricsFailureStopsServe verifies that a metrics server that cannot
+// start is reported by Serve rather than exiting the process.
+func TestMetricsFailureStopsServe(t *testing.T) {
+	lis, err := net.Listen("tcp", "localhost:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	// Hold a port so the metrics server cannot bind it.
+	taken, err := net.Listen("tcp", "localhost:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer taken.Close()
+
+	d, err := NewWithOptions(0,
+		WithHost("localhost"),
+		WithMetrics(taken.Addr().(*net.TCPAddr).Port, false),
+	)
+	if err != nil {
+		t.Fatalf("NewWithOptions: %v", err)
+	}
+
+	err = d.Serve(t.Context(), lis)
+	if err == nil || errors.Is(err, http.ErrServerClosed) {
+		t.Fatalf("expected Serve to fail with the metrics error, got %v", err)
+	}
+	if !strings.Contains(err.Error(), "metrics server") {
+		t.Errorf("expected a metrics server error, got %v", err)
+	}
+}
+
+// TestWithMetricsPath verifies that metrics can be served on the main port
+// under a path prefix.
+func TestWithMetricsPath(t *testing.T) {
+	d, err := NewWithOptions(0, WithMetricsPath("/_admin/", false))
+	if err != nil {
+		t.Fatalf("NewWithOptions: %v", err)
+	}
+
+	cases := []struct {
+		path string
+		want int
+	}{
+		{"/_admin/metrics", http.StatusOK},
+		{"/_admin/debug/pprof/", http.StatusNotFound},
+		{"/_administrator/metrics", http.StatusNotFound},
+	}
+	for _, tc := range cases {
+		rec := httptest.NewRecorder()
+		d.handler().ServeHTTP(rec, httptest.NewRequest(http.MethodGet, tc.path, nil))
+		if rec.Code != tc.want {
+			t.Errorf("GET %s = %d, want %d", tc.path, rec.Code, tc.want)
+		}
+	}
+
+	if _, err := NewWithOptions(0, WithMetricsPath("/", false)); err == nil {
+		t.Error("expected an error for a root metrics path prefix")
+	}
+}
+
+// blockingServer holds each SayHello in the handler until release is closed,
+// signaling started once a request has arrived. It lets a test drive the
+// duplex into a state where a request is genuinely in flight during shutdown.
+type blockingServer struct {
+	pb.UnimplementedGreeterServer
+
+	started chan struct{}
+	release chan struct{}
+}
+
+func (s *blockingServer) SayHello(_ context.Context, in *pb.HelloRequest) (*pb.HelloReply, error) {
+	s.started <- struct{}{}
+	<-s.release
+	return &pb.HelloReply{Message: "Hello " + in.GetName()}, nil
+}
+
+// server is used to implement helloworld.GreeterServer.
+type server struct {
+	pb.UnimplementedGreeterServer
+
+	// lastClientID captures the cgclientid from the most recent request.
+	lastClientID string
+}
+
+// SayHello implements helloworld.GreeterServer
+func (s *server) SayHello(ctx context.Context, in *pb.HelloRequest) (*pb.HelloReply, error) {
+	md, _ := metadata.FromIncomingContext(ctx)
+	log.Printf("Received: %v (%v)", in.GetName(), md)
+	if vals := md.Get(clientid.CGClientID); len(vals) > 0 {
+		s.lastClientID = vals[0]
+	} else {
+		s.lastClientID = ""
+	}
+	return &pb.HelloReply{Message: "Hello " + in.GetName()}, nil
+}
+
+// TestRateLimitThroughGateway verifies that a REST caller refused by the rate
+// limit gets a 429 with a Retry-After header.
+func TestRateLimitThroughGateway(t *testing.T) {
+	ctx := t.Context()
+
+	l, err := ratelimit.New(ratelimit.WithRate(1, 1))
+	if err != nil {
+		t.Fatal(err)
+	}
+	lis, err := net.Listen("tcp", "localhost:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	d, err := NewWithOptions(0, WithListener(lis),
+		WithServerOptions(grpc.ChainUnaryInterceptor(ratelimit.UnaryServerInterceptor(l))))
+	if err != nil {
+		t.Fatalf("NewWithOptions() = %v", err)
+	}
+	pb.RegisterGreeterServer(d.Server, &server{})
+	if err := d.RegisterHandler(ctx, pb.RegisterGreeterHandlerFromEndpoint); err != nil {
+		t.Fatalf("RegisterHandler: %v", err)
+	}
+	go func() { _ = d.ListenAndServe(ctx) }()
+	t.Cleanup(func() { _ = d.Shutdown(context.Background()) })
+
+	for _, tc := range []struct {
+		want           int
+		wantRetryAfter string
+	}{
+		{http.StatusOK, ""},
+		{http.StatusTooManyRequests, "1"},
+	} {
+		body, _ := json.Marshal(&pb.HelloRequest{Name: "throttled"})
+		req, err := http.NewRequestWithContext(ctx, http.MethodPost,
+			fmt.Sprintf("http://%s/v1/example/echo", lis.Addr().String()), bytes.NewBuffer(body))
+		if err != nil {
+			t.Fatal(err)
+		}
+		req.Header.Set("Content-Type", "application/json")
+		req.Header.Set(clientid.CGClientID, "greedy")
+
+		resp, err := http.DefaultClient.Do(req)
+		if err != nil {
+			t.Fatalf("HTTP POST: %v", err)
+		}
+		resp.Body.Close()
+		if resp.StatusCode != tc.want {
+			t.Errorf("status = %d, want %d", resp.StatusCode, tc.want)
+		}
+		if got := resp.Header.Get("Retry-After"); got != tc.wantRetryAfter {
+			t.Errorf("Retry-After = %q, want %q", got, tc.wantRetryAfter)
+		}
+	}
+}
+
+// TestLoadShedding verifies that while the shedder's limit is full, gRPC and
+// REST calls are refused as unavailable, and health checks are still served.
+func TestLoadShedding(t *testing.T) {
+	ctx := t.Context()
+
+	l, err := loadshed.New(loadshed.WithLimits(1, 1, 1), loadshed.WithRegisterer(prometheus.NewRegistry()))
+	if err != nil {
+		t.Fatal(err)
+	}
+	lis, err := net.Listen("tcp", "localhost:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	d, err := NewWithOptions(0, WithListener(lis), WithLoadShedding(l))
+	if err != nil {
+		t.Fatalf("NewWithOptions() = %v", err)
+	}
+	srv := &blockingServer{started: make(chan struct{}, 1), release: make(chan struct{})}
+	pb.RegisterGreeterServer(d.Server, srv)
+	if err := d.RegisterHandler(ctx, pb.RegisterGreeterHandlerFromEndpoint); err != nil {
+		t.Fatalf("RegisterHandler: %v", err)
+	}
+	go func() { _ = d.ListenAndServe(ctx) }()
+	t.Cleanup(func() { _ = d.Shutdown(context.Background()) })
+
+	conn, err := grpc.NewClient(lis.Addr().String(), grpc.WithTransportCredentials(insecure.NewCredentials()))
+	if err != nil {
+		t.Fatalf("failed to dial: %v", err)
+	}
+	defer conn.Close()
+	client := pb.NewGreeterClient(conn)
+
+	callErr := make(chan error, 1)
+	go func() {
+		_, err := client.SayHello(ctx, &pb.HelloRequest{Name: "first"})
+		callErr <- err
+	}()
+	<-srv.started // the limit is now full
+
+	if _, err := client.SayHello(ctx, &pb.HelloRequest{Name: "second"}); status.Code(err) != codes.Unavailable {
+		t.Errorf("SayHello() = %v, want %v", err, codes.Unavailable)
+	}
+
+	body, _ := json.Marshal(&pb.HelloRequest{Name: "rest"})
+	resp, err := http.Post(fmt.Sprintf("http://%s/v1/example/echo", lis.Addr().String()), "application/json", bytes.NewBuffer(body))
+	if err != nil {
+		t.Fatalf("HTTP POST: %v", err)
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusServiceUnavailable {
+		t.Errorf("REST status = %d, want %d", resp.StatusCode, http.StatusServiceUnavailable)
+	}
+
+	hc, err := healthpb.NewHealthClient(conn).Check(ctx, &healthpb.HealthCheckRequest{})
+	if err != nil || hc.GetStatus() != healthpb.HealthCheckResponse_SERVING {
+		t.Errorf("health Check() = %v, %v; want SERVING", hc, err)
+	}
+
+	close(srv.release)
+	if err := <-callErr; err != nil {
+		t.Fatalf("first call = %v", err)
+	}
+}
+
+// TestDeadlines verifies that gRPC calls without a deadline are capped, and
+// that a REST caller's timeout header bounds the call the gateway makes.
+func TestDeadlines(t *testing.T) {
+	ctx := t.Context()
+
+	lis, err := net.Listen("tcp", "localhost:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	d, err := NewWithOptions(0, WithListener(lis), WithDeadlines(time.Minute))
+	if err != nil {
+		t.Fatalf("NewWithOptions() = %v", err)
+	}
+	srv := &deadlineServer{left: make(chan time.Duration, 1)}
+	pb.RegisterGreeterServer(d.Server, srv)
+	if err := d.RegisterHandler(ctx, pb.RegisterGreeterHandlerFromEndpoint); err != nil {
+		t.Fatalf("RegisterHandler: %v", err)
+	}
+	go func() { _ = d.ListenAndServe(ctx) }()
+	t.Cleanup(func() { _ = d.Shutdown(context.Background()) })
+
+	conn, err := grpc.NewClient(lis.Addr().String(), grpc.WithTransportCredentials(insecure.NewCredentials()))
+	if err != nil {
+		t.Fatalf("failed to dial: %v", err)
+	}
+	defer conn.Close()
+
+	if _, err := pb.NewGreeterClient(conn).SayHello(ctx, &pb.HelloRequest{Name: "grpc"}); err != nil {
+		t.Fatalf("SayHello() = %v", err)
+	}
+	if left := <-srv.left; left <= 30*time.Second || left > time.Minute {
+		t.Errorf("gRPC call without a deadline had %v left, want the one minute cap", left)
+	}
+
+	for _, tc := range []struct {
+		timeout    string
+		wantStatus int
+		wantMax    time.Duration
+	}{
+		{"5s", http.StatusOK, 5 * time.Second},
+		{"0.5", http.StatusOK, 500 * time.Millisecond},
+		{"soon", http.StatusBadRequest, 0},
+	} {
+		body, _ := json.Marshal(&pb.HelloRequest{Name: "rest"})
+		req, err := http.NewRequestWithContext(ctx, http.MethodPost,
+			fmt.Sprintf("http://%s/v1/example/echo", lis.Addr().String()), bytes.NewBuffer(body))
+		if err != nil {
+			t.Fatal(err)
+		}
+		req.Header.Set("Content-Type", "application/json")
+		req.Header.Set(deadline.TimeoutHeader, tc.timeout)
+
+		resp, err := http.DefaultClient.Do(req)
+		if err != nil {
+			t.Fatalf("HTTP POST: %v", err)
+		}
+		resp.Body.Close()
+		if resp.StatusCode != tc.wantStatus {
+			t.Errorf("%s: status = %d, want %d", tc.timeout, resp.StatusCode, tc.wantStatus)
+			continue
+		}
+		if tc.wantStatus != http.StatusOK {
+			continue
+		}
+		if left := <-srv.left; left <= 0 || left > tc.wantMax {
+			t.Errorf("%s: call had %v left, want at most %v", tc.timeout, left, tc.wantMax)
+		}
+	}
+}
+
+// deadlineServer reports the time each SayHello has left on left.
+type deadlineServer struct {
+	pb.UnimplementedGreeterServer
+
+	left chan time.Duration
+}
+
+func (s *deadlineServer) SayHello(ctx context.Context, in *pb.HelloRequest) (*pb.HelloReply, error) {
+	dl, _ := ctx.Deadline()
+	s.left <- time.Until(dl)
+	return &pb.HelloReply{Message: "Hello " + in.GetName()}, nil
+}
+
+// TestInflightRequests verifies that a request in flight is counted by
+// protocol and listed by the debug endpoint, and that Shutdown records how
+// long it waited for it.
+func TestInflightRequests(t *testing.T) {
+	ctx := t.Context()
+
+	lis, err := net.Listen("tcp", "localhost:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	d, err := NewWithOptions(0, WithListener(lis), WithMetricsPath("/_admin", true))
+	if err != nil {
+		t.Fatalf("NewWithOptions() = %v", err)
+	}
+	srv := &blockingServer{started: make(chan struct{}, 1), release: make(chan struct{})}
+	pb.RegisterGreeterServer(d.Server, srv)
+	go func() { _ = d.ListenAndServe(ctx) }()
+
+	conn, err := grpc.NewClient(lis.Addr().String(), grpc.WithTransportCredentials(insecure.NewCredentials()))
+	if err != nil {
+		t.Fatalf("failed to dial: %v", err)
+	}
+	defer conn.Close()
+
+	grpcInflight := inflightRequests.WithLabelValues(protocolGRPC)
+	before := testutil.ToFloat64(grpcInflight)
+
+	callErr := make(chan error, 1)
+	go func() {
+		callCtx := metadata.AppendToOutgoingContext(ctx, clientid.CGClientID, "debugger", clientid.CGRequestID, "req-1")
+		_, err := pb.NewGreeterClient(conn).SayHello(callCtx, &pb.HelloRequest{Name: "world"})
+		callErr <- err
+	}()
+	<-srv.started
+
+	if got := testutil.ToFloat64(grpcInflight) - before; got != 1 {
+		t.Errorf("in-flight gRPC requests = %v, want 1", got)
+	}
+
+	rec := httptest.NewRecorder()
+	d.handler().ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/_admin"+RequestsPath, nil))
+	if rec.Code != http.StatusOK {
+		t.Fatalf("GET %s = %d, want %d", RequestsPath, rec.Code, http.StatusOK)
+	}
+	var listing struct {
+		Count    int               `json:"count"`
+		Requests []inflightRequest `json:"requests"`
+	}
+	if err := json.Unmarshal(rec.Body.Bytes(), &listing); err != nil {
+		t.Fatalf("decoding %s: %v", RequestsPath, err)
+	}
+	if listing.Count != 1 || len(listing.Requests) != 1 {
+		t.Fatalf("listing = %+v, want one request", listing)
+	}
+	got := listing.Requests[0]
+	got.Start = time.Time{}
+	want := inflightRequest{Protocol: protocolGRPC, Method: "/helloworld.Greeter/SayHello", ClientID: "debugger", RequestID: "req-1"}
+	if diff := cmp.Diff(want, got); diff != "" {
+		t.Errorf("listed request (-want +got):\n%s", diff)
+	}
+
+	drained := drainDuration.WithLabelValues("drained").(prometheus.Histogram)
+	drainsBefore := sampleCount(t, drained)
+
+	shutdownErr := make(chan error, 1)
+	go func() { shutdownErr <- d.Shutdown(ctx) }()
+	close(srv.release)
+	if err := <-callErr; err != nil {
+		t.Fatalf("in-flight request = %v", err)
+	}
+	if err := <-shutdownErr; err != nil {
+		t.Fatalf("Shutdown() = %v", err)
+	}
+	if got := sampleCount(t, drained) - drainsBefore; got != 1 {
+		t.Errorf("drains recorded = %d, want 1", got)
+	}
+	if got := testutil.ToFloat64(grpcInflight) - before; got != 0 {
+		t.Errorf("in-flight gRPC requests after shutdown = %v, want 0", got)
+	}
+}
+
+func sampleCount(t *testing.T, h prometheus.Histogram) uint64 {
+	t.Helper()
+	m := &dto.Metric{}
+	if err := h.Write(m); err != nil {
+		t.Fatal(err)
+	}
+	return m.GetHistogram().GetSampleCount()
+}
+
+// TestShutdownStreams verifies that Shutdown sends clients a GOAWAY as soon as
+// it starts draining, and cuts off a stream that would hold the drain open,
+// after the grace period or when its context ends.
+// holdMethod is a server stream registered by registerHold, which holds its
+// stream open until its context ends.
+const holdMethod = "/test.Hold/Hold"
+
+func registerHold(d *Duplex) {
+	d.Server.RegisterService(&grpc.ServiceDesc{
+		ServiceName: "test.Hold",
+		HandlerType: (*any)(nil),
+		Streams: []grpc.StreamDesc{{
+			StreamName:    "Hold",
+			ServerStreams: true,
+			Handler: func(_ any, ss grpc.ServerStream) error {
+				if err := ss.SendHeader(metadata.MD{}); err != nil {
+					return err
+				}
+				<-ss.Context().Done()
+				return ss.Context().Err()
+			},
+		}},
+	}, struct{}{})
+}
+
+// hold opens a holdMethod stream on conn, returning once it is being served.
+func hold(t *testing.T, ctx context.Context, conn *grpc.ClientConn) grpc.ClientStream {
+	t.Helper()
+	cs, err := conn.NewStream(ctx, &grpc.StreamDesc{ServerStreams: true}, holdMethod)
+	if err != nil {
+		t.Fatalf("NewStream() = %v", err)
+	}
+	if err := cs.SendMsg(&emptypb.Empty{}); err != nil {
+		t.Fatalf("SendMsg() = %v", err)
+	}
+	if err := cs.CloseSend(); err != nil {
+		t.Fatalf("CloseSend() = %v", err)
+	}
+	if _, err := cs.Header(); err != nil {
+		t.Fatalf("Header() = %v", err)
+	}
+	return cs
+}
+
+func TestShutdownStreams(t *testing.T) {
+	tests := []struct {
+		name       string
+		opts       []Option
+		wantReason string
+		wantErr    error
+	}{{
+		name:       "grace period",
+		opts:       []Option{WithStreamGracePeriod(100 * time.Millisecond)},
+		wantReason: cutOffGracePeriod,
+	}, {
+		name:       "drain timeout",
+		wantReason: cutOffDrainTimeout,
+		wantErr:    context.DeadlineExceeded,
+	}}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			ctx := t.Context()
+
+			lis, err := net.Listen("tcp", "localhost:0")
+			if err != nil {
+				t.Fatal(err)
+			}
+			d, err := NewWithOptions(0, append([]Option{WithListener(lis)}, tt.opts...)...)
+			if err != nil {
+				t.Fatalf("NewWithOptions() = %v", err)
+			}
+			registerHold(d)
+			go func() { _ = d.ListenAndServe(ctx) }()
+
+			conn, err := grpc.NewClient(lis.Addr().String(), grpc.WithTransportCredentials(insecure.NewCredentials()))
+			if err != nil {
+				t.Fatalf("failed to dial: %v", err)
+			}
+			defer conn.Close()
+
+			stream := hold(t, ctx, conn)
+
+			cutOff := streamsCutOff.WithLabelValues(tt.wantReason)
+			before := testutil.ToFloat64(cutOff)
+
+			shutdownCtx, cancel := context.WithTimeout(ctx, time.Second)
+			defer cancel()
+			shutdownErr := make(chan error, 1)
+			go func() { shutdownErr <- d.Shutdown(shutdownCtx) }()
+
+			// The GOAWAY moves the client off the connection while the stream
+			// is still open.
+			waitCtx, cancelWait := context.WithTimeout(ctx, 500*time.Millisecond)
+			defer cancelWait()
+			for conn.GetState() == connectivity.Ready && conn.WaitForStateChange(waitCtx, connectivity.Ready) {
+			}
+			if state := conn.GetState(); state == connectivity.Ready {
+				t.Errorf("connection state = %v after Shutdown began, want it to have left READY", state)
+			}
+
+			err = stream.RecvMsg(&emptypb.Empty{})
+			if tt.wantErr == nil && status.Code(err) != codes.Unavailable {
+				t.Errorf("RecvMsg() = %v, want %v", err, codes.Unavailable)
+			}
+
+			if err := <-shutdownErr; !errors.Is(err, tt.wantErr) {
+				t.Errorf("Shutdown() = %v, want %v", err, tt.wantErr)
+			}
+			if got := testutil.ToFloat64(cutOff) - before; got != 1 {
+				t.Errorf("streams cut off = %v, want 1", got)
+			}
+		})
+	}
+}
+
+// TestShutdownHealthWatch verifies that an open health Watch is told its
+// service is NOT_SERVING and ended, rather than stalling Shutdown.
+func TestShutdownHealthWatch(t *testing.T) {
+	tests := []struct {
+		name    string
+		service string
+		want    []healthpb.HealthCheckResponse_ServingStatus
+	}{{
+		name: "server",
+		want: []healthpb.HealthCheckResponse_ServingStatus{
+			healthpb.HealthCheckResponse_SERVING,
+			healthpb.HealthCheckResponse_NOT_SERVING,
+		},
+	}, {
+		name:    "unknown service",
+		service: "unknown.Service",
+		want:    []healthpb.HealthCheckResponse_ServingStatus{healthpb.HealthCheckResponse_SERVICE_UNKNOWN},
+	}}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			ctx := t.Context()
+
+			lis, err := net.Listen("tcp", "localhost:0")
+			if err != nil {
+				t.Fatal(err)
+			}
+			d, err := NewWithOptions(0, WithListener(lis))
+			if err != nil {
+				t.Fatalf("NewWithOptions() = %v", err)
+			}
+			go func() { _ = d.ListenAndServe(ctx) }()
+
+			conn, err := grpc.NewClient(lis.Addr().String(), grpc.WithTransportCredentials(insecure.NewCredentials()))
+			if err != nil {
+				t.Fatalf("failed to dial: %v", err)
+			}
+			defer conn.Close()
+
+			watch, err := healthpb.NewHealthClient(conn).Watch(ctx, &healthpb.HealthCheckRequest{Service: tt.service})
+			if err != nil {
+				t.Fatalf("Watch() = %v", err)
+			}
+			resp, err := watch.Recv()
+			if err != nil {
+				t.Fatalf("Recv() = %v", err)
+			}
+			got := []healthpb.HealthCheckResponse_ServingStatus{resp.GetStatus()}
+
+			shutdownCtx, cancel := context.WithTimeout(ctx, 5*time.Second)
+			defer cancel()
+			shutdownErr := make(chan error, 1)
+			go func() { shutdownErr <- d.Shutdown(shutdownCtx) }()
+
+			for {
+				resp, err := watch.Recv()
+				if err != nil {
+					if status.Code(err) != codes.Unavailable {
+						t.Errorf("Recv() = %v, want %v", err, codes.Unavailable)
+					}
+					break
+				}
+				got = append(got, resp.GetStatus())
+			}
+			if diff := cmp.Diff(tt.want, got); diff != "" {
+				t.Errorf("statuses (-want, +got):\n%s", diff)
+			}
+			if err := <-shutdownErr; err != nil {
+				t.Errorf("Shutdown() = %v", err)
+			}
+		})
+	}
+}
+
+func TestKeepalive(t *testing.T) {
+	d, err := NewWithOptions(0,
+		WithHTTPServerConfig(HTTPServerConfig{IdleTimeout: time.Hour}),
+		WithKeepalive(keepalive.ServerParameters{MaxConnectionIdle: time.Minute, Time: time.Millisecond}),
+	)
+	if err != nil {
+		t.Fatalf("NewWithOptions() = %v", err)
+	}
+	server := d.httpServerInstance()
+	if got, want := server.IdleTimeout, time.Minute; got != want {
+		t.Errorf("IdleTimeout = %v, want %v", got, want)
+	}
+	if got, want := server.HTTP2.SendPingTimeout, time.Second; got != want {
+		t.Errorf("SendPingTimeout = %v, want %v", got, want)
+	}
+	if got, want := server.HTTP2.PingTimeout, 20*time.Second; got != want {
+		t.Errorf("PingTimeout = %v, want %v", got, want)
+	}
+}
+
+func TestKeepaliveMaxConnectionAge(t *testing.T) {
+	tests := []struct {
+		name string
+		kp   keepalive.ServerParameters
+		// watch holds a stream open on the connection past its maximum age, and
+		// check makes another call on it then.
+		watch, check bool
+		wantHow      string
+	}{{
+		name:    "idle",
+		kp:      keepalive.ServerParameters{MaxConnectionAge: 100 * time.Millisecond},
+		wantHow: agedOutIdle,
+	}, {
+		name:    "goaway",
+		kp:      keepalive.ServerParameters{MaxConnectionAge: 100 * time.Millisecond},
+		watch:   true,
+		check:   true,
+		wantHow: agedOutGoAway,
+	}, {
+		name:    "grace period",
+		kp:      keepalive.ServerParameters{MaxConnectionAge: 50 * time.Millisecond, MaxConnectionAgeGrace: 100 * time.Millisecond},
+		watch:   true,
+		wantHow: agedOutClosed,
+	}}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			ctx := t.Context()
+
+			lis, err := net.Listen("tcp", "localhost:0")
+			if err != nil {
+				t.Fatal(err)
+			}
+			d, err := NewWithOptions(0, WithListener(lis), WithKeepalive(tt.kp))
+			if err != nil {
+				t.Fatalf("NewWithOptions() = %v", err)
+			}
+			go func() { _ = d.ListenAndServe(ctx) }()
+			t.Cleanup(func() { _ = d.Shutdown(context.Background()) })
+
+			conn, err := grpc.NewClient(lis.Addr().String(), grpc.WithTransportCredentials(insecure.NewCredentials()))
+			if err != nil {
+				t.Fatalf("failed to dial: %v", err)
+			}
+			defer conn.Close()
+			client := healthpb.NewHealthClient(conn)
+
+			agedOut := connectionsAgedOut.WithLabelValues(tt.wantHow)
+			before := testutil.ToFloat64(agedOut)
+
+			if _, err := client.Check(ctx, &healthpb.HealthCheckRequest{}); err != nil {
+				t.Fatalf("Check() = %v", err)
+			}
+
+			var watch grpc.ServerStreamingClient[healthpb.HealthCheckResponse]
+			if tt.watch {
+				watchCtx, cancel := context.WithCancel(ctx)
+				defer cancel()
+				watch, err = client.Watch(watchCtx, &healthpb.HealthCheckRequest{})
+				if err != nil {
+					t.Fatalf("Watch() = %v", err)
+				}
+				if _, err := watch.Recv(); err != nil {
+					t.Fatalf("Recv() = %v", err)
+				}
+			}
+
+			switch {
+			case tt.check:
+				time.Sleep(tt.kp.MaxConnectionAge * 3 / 2)
+				if _, err := client.Check(ctx, &healthpb.HealthCheckRequest{}); err != nil {
+					t.Fatalf("Check() past the maximum age = %v, want it to finish", err)
+				}
+			case tt.watch:
+				if _, err := watch.Recv(); status.Code(err) != codes.Unavailable {
+					t.Errorf("Recv() = %v, want %v", err, codes.Unavailable)
+				}
+			}
+
+			// The client is moved off the connection, without making another
+			// call on it.
+			waitCtx, cancel := context.WithTimeout(ctx, time.Second)
+			defer cancel()
+			for conn.GetState() == connectivity.Ready && conn.WaitForStateChange(waitCtx, connectivity.Ready) {
+			}
+			if state := conn.GetState(); state == connectivity.Ready {
+				t.Errorf("connection state = %v past the maximum age, want it to have left READY", state)
+			}
+
+			if got := testutil.ToFloat64(agedOut) - before; got != 1 {
+				t.Errorf("connections aged out = %v, want 1", got)
+			}
+
+			// The client reconnects.
+			if _, err := client.Check(ctx, &healthpb.HealthCheckRequest{}); err != nil {
+				t.Errorf("Check() after reconnecting = %v", err)
+			}
+		})
+	}
+}
+
+func TestKeepaliveEnforcement(t *testing.T) {
+	tests := []struct {
+		name   string
+		policy *keepalive.EnforcementPolicy
+		gap    time.Duration
+		// wantGoAway is set when the client is told it pinged too often.
+		wantGoAway bool
+	}{{
+		name:   "no policy",
+		policy: nil,
+	}, {
+		name:       "too many pings",
+		policy:     &keepalive.EnforcementPolicy{MinTime: time.Hour, PermitWithoutStream: true},
+		wantGoAway: true,
+	}, {
+		name:   "pings permitted",
+		policy: &keepalive.EnforcementPolicy{MinTime: 20 * time.Millisecond, PermitWithoutStream: true},
+		gap:    50 * time.Millisecond,
+	}, {
+		name:       "pings without streams",
+		policy:     &keepalive.EnforcementPolicy{MinTime: time.Millisecond},
+		gap:        10 * time.Millisecond,
+		wantGoAway: true,
+	}}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			ctx := t.Context()
+
+			lis, err := net.Listen("tcp", "localhost:0")
+			if err != nil {
+				t.Fatal(err)
+			}
+			opts := []Option{WithListener(lis)}
+			if tt.policy != nil {
+				opts = append(opts, WithKeepaliveEnforcement(*tt.policy))
+			}
+			d, err := NewWithOptions(0, opts...)
+			if err != nil {
+				t.Fatalf("NewWithOptions() = %v", err)
+			}
+			go func() { _ = d.ListenAndServe(ctx) }()
+			t.Cleanup(func() { _ = d.Shutdown(context.Background()) })
+
+			// gRPC and HTTP/1 are served as ever.
+			conn, err := grpc.NewClient(lis.Addr().String(), grpc.WithTransportCredentials(insecure.NewCredentials()))
+			if err != nil {
+				t.Fatalf("failed to dial: %v", err)
+			}
+			defer conn.Close()
+			if _, err := healthpb.NewHealthClient(conn).Check(ctx, &healthpb.HealthCheckRequest{}); err != nil {
+				t.Fatalf("Check() = %v", err)
+			}
+			resp, err := http.Get("http://" + lis.Addr().String() + LivenessPath)
+			if err != nil {
+				t.Fatalf("GET %s = %v", LivenessPath, err)
+			}
+			resp.Body.Close()
+
+			before := testutil.ToFloat64(connectionsTooManyPings)
+			acks, goAway := ping(t, lis.Addr().String(), 4, tt.gap)
+			if gotGoAway := goAway != nil; gotGoAway != tt.wantGoAway {
+				t.Fatalf("GOAWAY = %v, want one %v", goAway, tt.wantGoAway)
+			}
+			if tt.wantGoAway {
+				if goAway.ErrCode != http2.ErrCodeEnhanceYourCalm || string(goAway.DebugData()) != "too_many_pings" {
+					t.Errorf("GOAWAY = %v %q, want %v too_many_pings", goAway.ErrCode, goAway.DebugData(), http2.ErrCodeEnhanceYourCalm)
+				}
+				if got := testutil.ToFloat64(connectionsTooManyPings) - before; got != 1 {
+					t.Errorf("connections closed for too many pings = %v, want 1", got)
+				}
+			} else if acks != 4 {
+				t.Errorf("ping acks = %d, want 4", acks)
+			}
+		})
+	}
+}
+
+// ping opens an HTTP/2 connection to addr, sends n pings gap apart, and returns
+// how many were acknowledged and the GOAWAY the server sent, if any.
+func ping(t *testing.T, addr string, n int, gap time.Duration) (int, *http2.GoAwayFrame) {
+	t.Helper()
+
+	conn, err := net.Dial("tcp", addr)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+	if _, err := io.WriteString(conn, http2.ClientPreface); err != nil {
+		t.Fatal(err)
+	}
+	fr := http2.NewFramer(conn, conn)
+	if err := fr.WriteSettings(); err != nil {
+		t.Fatal(err)
+	}
+	for i := range n {
+		time.Sleep(gap)
+		if err := fr.WritePing(false, [8]byte{byte(i)}); err != nil {
+			break
+		}
+	}
+
+	_ = conn.SetReadDeadline(time.Now().Add(500 * time.Millisecond))
+	acks := 0
+	for {
+		f, err := fr.ReadFrame()
+		if err != nil {
+			return acks, nil
+		}
+		switch f := f.(type) {
+		case *http2.PingFrame:
+			if f.IsAck() {
+				acks++
+			}
+		case *http2.GoAwayFrame:
+			return acks, f
+		}
+	}
+}
+
+func TestFrameScanner(t *testing.T) {
+	var stream bytes.Buffer
+	stream.WriteString(http2.ClientPreface)
+	fr := http2.NewFramer(&stream, nil)
+	_ = fr.WriteSettings()
+	_ = fr.WritePing(false, [8]byte{})
+	_ = fr.WriteData(3, true, []byte("hello"))
+	want := []http2.FrameType{http2.FrameSettings, http2.FramePing, http2.FrameData}
+
+	// Any split of the bytes is followed the same.
+	for _, size := range []int{1, 2, 7, 9, 1024} {
+		var (
+			s        frameScanner
+			got      []http2.FrameType
+			prefaced bool
+		)
+		for b := stream.Bytes(); len(b) > 0; {
+			n := min(size, len(b))
+			prefaced = s.scan(b[:n], func(h http2.FrameHeader) { got = append(got, h.Type) }) || prefaced
+			b = b[n:]
+		}
+		if !prefaced || !s.atBoundary() {
+			t.Errorf("size %d: prefaced = %v, at boundary = %v, want both", size, prefaced, s.atBoundary())
+		}
+		if diff := cmp.Diff(want, got); diff != "" {
+			t.Errorf("size %d: frames -want,+got: %s", size, diff)
+		}
+	}
+
+	// HTTP/1 is not followed.
+	var s frameScanner
+	s.scan([]byte("GET / HTTP/1.1\r\n\r\n"), func(h http2.FrameHeader) { t.Errorf("frame %v in HTTP/1", h) })
+	if !s.off {
+		t.Error("HTTP/1 followed as HTTP/2")
 	}
-	return &pb.HelloReply{Message: "Hello " + in.GetName()}, nil
 }
@@ -0,0 +1,121 @@
+/*
+Copyright 2026 Chainguard, Inc.
+SPDX-License-Identifier: Apache-2.0
+*/
+
+package duplex
+
+import (
+	"context"
+	"errors"
+	"sync"
+
+	middleware "github.com/grpc-ecosystem/go-grpc-middleware/v2"
+	"github.com/prometheus/client_golang/prometheus"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+
+	"github.com/chainguard-dev/clog"
+)
+
+// Reasons a stream was cut off by Shutdown, as labelled in
+// duplex_shutdown_streams_cut_off_total.
+const (
+	cutOffGracePeriod  = "grace_period"
+	cutOffDrainTimeout = "drain_timeout"
+)
+
+var streamsCutOff = prometheus.NewCounterVec(prometheus.CounterOpts{
+	Name: "duplex_shutdown_streams_cut_off_total",
+	Help: "Number of gRPC streams Shutdown ended before they finished, by reason (grace_period or drain_timeout).",
+}, []string{"reason"})
+
+func init() {
+	prometheus.MustRegister(streamsCutOff)
+}
+
+// errShuttingDown is the cause a stream's context is cancelled with when
+// Shutdown cuts it off.
+var errShuttingDown = errors.New("server is shutting down")
+
+// streamTracker tracks the gRPC streams being served, so that Shutdown can end
+// those that would otherwise hold the drain open, such as streams a client
+// keeps open for updates. Its zero value is ready to use.
+type streamTracker struct {
+	mu      sync.Mutex
+	nextID  uint64
+	cancels map[uint64]context.CancelCauseFunc
+
+	// closed is set once the streams have been cut off, after which new
+	// streams are ended as soon as they start.
+	closed bool
+}
+
+// interceptor returns the stream server interceptor that serves each stream
+// with a context the tracker can cancel. A stream cut off by the tracker ends
+// with codes.Unavailable, so its client retries against another server,
+// whatever its handler returns.
+func (t *streamTracker) interceptor() grpc.StreamServerInterceptor {
+	return func(srv any, ss grpc.ServerStream, _ *grpc.StreamServerInfo, handler grpc.StreamHandler) error {
+		ctx, cancel := context.WithCancelCause(ss.Context())
+		defer cancel(nil)
+		id := t.add(cancel)
+		defer t.remove(id)
+
+		wrapped := middleware.WrapServerStream(ss)
+		wrapped.WrappedContext = ctx
+		err := handler(srv, wrapped)
+		if errors.Is(context.Cause(ctx), errShuttingDown) {
+			return status.Error(codes.Unavailable, errShuttingDown.Error())
+		}
+		return err
+	}
+}
+
+func (t *streamTracker) add(cancel context.CancelCauseFunc) uint64 {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	if t.closed {
+		cancel(errShuttingDown)
+		return 0
+	}
+	if t.cancels == nil {
+		t.cancels = map[uint64]context.CancelCauseFunc{}
+	}
+	t.nextID++
+	t.cancels[t.nextID] = cancel
+	return t.nextID
+}
+
+func (t *streamTracker) remove(id uint64) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	delete(t.cancels, id)
+}
+
+// cutOff cancels the contexts of the streams being served, and of any started
+// later, returning how many it cancelled that were not already.
+func (t *streamTracker) cutOff() int {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	t.closed = true
+	n := len(t.cancels)
+	for id, cancel := range t.cancels {
+		cancel(errShuttingDown)
+		delete(t.cancels, id)
+	}
+	return n
+}
+
+// cutOffStreams cuts off the streams being served, counting and logging them
+// under reason.
+func (d *Duplex) cutOffStreams(ctx context.Context, reason string) {
+	if n := d.streams.cutOff(); n > 0 {
+		streamsCutOff.WithLabelValues(reason).Add(float64(n))
+		clog.FromContext(ctx).Warn("cut off streams during shutdown", "streams", n, "reason", reason)
+	}
+}
@@ -0,0 +1,160 @@
+/*
+Copyright 2026 Chainguard, Inc.
+SPDX-License-Identifier: Apache-2.0
+*/
+
+// Package auth provides gRPC server interceptors that authenticate callers by
+// the bearer JWT in their authorization metadata, verified against a JWKS, and
+// make the token's claims available to handlers. Requests served through a
+// Duplex's gateway carry the HTTP Authorization header through to the
+// interceptors, so both are covered.
+package auth
+
+import (
+	"context"
+	"errors"
+	"strings"
+
+	middleware "github.com/grpc-ecosystem/go-grpc-middleware/v2"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/status"
+
+	"chainguard.dev/go-grpc-kit/internal/methodmatch"
+	"github.com/chainguard-dev/clog"
+)
+
+type claimsKey struct{}
+
+// ClaimsFromContext returns the claims of the caller's verified token, placed
+// on the context by the interceptors. It reports false for methods exempt from
+// authentication.
+func ClaimsFromContext(ctx context.Context) (*Claims, bool) {
+	c, ok := ctx.Value(claimsKey{}).(*Claims)
+	return c, ok
+}
+
+// ContextWithClaims returns a copy of ctx carrying c, as the interceptors place
+// it, for testing handlers and interceptors that read ClaimsFromContext.
+func ContextWithClaims(ctx context.Context, c *Claims) context.Context {
+	return context.WithValue(ctx, claimsKey{}, c)
+}
+
+// Option configures the interceptors.
+type Option func(*config)
+
+type config struct {
+	exempt []string
+	scopes []methodScopes
+}
+
+type methodScopes struct {
+	pattern string
+	scopes  []string
+}
+
+// WithExemptMethods serves methods matching any of patterns without
+// authentication, such as "/grpc.health.v1.Health/*" for health checks. A
+// pattern is either a full method name, such as "/pkg.Service/Method", or a
+// prefix ending in "*", such as "/pkg.Service/*".
+func WithExemptMethods(patterns ...string) Option {
+	return func(c *config) {
+		c.exempt = append(c.exempt, patterns...)
+	}
+}
+
+// WithRequiredScopes requires the tokens of callers of methods matching
+// pattern, as for WithExemptMethods, to grant all of scopes. A caller without
+// them is refused with codes.PermissionDenied. The requirements of every
+// matching pattern apply.
+func WithRequiredScopes(pattern string, scopes ...string) Option {
+	return func(c *config) {
+		c.scopes = append(c.scopes, methodScopes{pattern: pattern, scopes: scopes})
+	}
+}
+
+// authenticate verifies the caller of method and checks its scopes, returning
+// the context to serve it with.
+func (c *config) authenticate(ctx context.Context, v *Verifier, method string) (context.Context, error) {
+	if methodmatch.Any(c.exempt, method) {
+		return ctx, nil
+	}
+
+	raw, ok := bearerToken(ctx)
+	if !ok {
+		return nil, status.Error(codes.Unauthenticated, "missing bearer token")
+	}
+	claims, err := v.Verify(ctx, raw)
+	if err != nil {
+		if errors.Is(err, ErrKeysUnavailable) {
+			clog.FromContext(ctx).Error("cannot verify bearer token", "method", method, "error", err)
+			return nil, status.Error(codes.Unavailable, "cannot verify bearer token")
+		}
+		// The reason is logged rather than returned, so as not to help forgers.
+		clog.FromContext(ctx).Info("rejected bearer token", "method", method, "error", err)
+		return nil, status.Error(codes.Unauthenticated, "invalid bearer token")
+	}
+
+	for _, ms := range c.scopes {
+		if !methodmatch.Match(ms.pattern, method) {
+			continue
+		}
+		for _, s := range ms.scopes {
+			if !claims.HasScope(s) {
+				return nil, status.Errorf(codes.PermissionDenied, "missing required scope %q", s)
+			}
+		}
+	}
+	return ContextWithClaims(ctx, claims), nil
+}
+
+// bearerToken returns the token in the incoming authorization metadata.
+func bearerToken(ctx context.Context) (string, bool) {
+	vals := metadata.ValueFromIncomingContext(ctx, "authorization")
+	if len(vals) == 0 {
+		return "", false
+	}
+	scheme, tok, ok := strings.Cut(vals[0], " ")
+	if !ok || !strings.EqualFold(scheme, "Bearer") || tok == "" {
+		return "", false
+	}
+	return strings.TrimSpace(tok), true
+}
+
+// UnaryServerInterceptor authenticates each call with v, refusing one without
+// a valid bearer token with codes.Unauthenticated, and places the token's
+// claims on the handler's context.
+func UnaryServerInterceptor(v *Verifier, opts ...Option) grpc.UnaryServerInterceptor {
+	cfg := newConfig(opts)
+	return func(ctx context.Context, req any, info *grpc.UnaryServerInfo, handler grpc.UnaryHandler) (any, error) {
+		ctx, err := cfg.authenticate(ctx, v, info.FullMethod)
+		if err != nil {
+			return nil, err
+		}
+		return handler(ctx, req)
+	}
+}
+
+// StreamServerInterceptor is the streaming counterpart of
+// UnaryServerInterceptor.
+func StreamServerInterceptor(v *Verifier, opts ...Option) grpc.StreamServerInterceptor {
+	cfg := newConfig(opts)
+	return func(srv any, ss grpc.ServerStream, info *grpc.StreamServerInfo, handler grpc.StreamHandler) error {
+		ctx, err := cfg.authenticate(ss.Context(), v, info.FullMethod)
+		if err != nil {
+			return err
+		}
+		wrapped := middleware.WrapServerStream(ss)
+		wrapped.WrappedContext = ctx
+		return handler(srv, wrapped)
+	}
+}
+
+func newConfig(opts []Option) *config {
+	cfg := &config{}
+	for _, o := range opts {
+		o(cfg)
+	}
+	return cfg
+}
@@ -0,0 +1,144 @@
+/*
+Copyright 2026 Chainguard, Inc.
+SPDX-License-Identifier: Apache-2.0
+*/
+
+package auth
+
+import (
+	"context"
+	"errors"
+	"testing"
+
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/status"
+)
+
+func TestInterceptors(t *testing.T) {
+	key := newECKey(t)
+	v := newTestVerifier(t, staticFetcher(jwksFor(t, testKey{kid: "ec", signer: key})))
+	down := newTestVerifier(t, FetcherFunc(func(context.Context) ([]byte, error) {
+		return nil, errors.New("JWKS is down")
+	}))
+
+	valid := "Bearer " + signToken(t, key, "ES256", "ec", validClaims("sub", "user-1", "scope", "read"))
+	opts := []Option{
+		WithExemptMethods("/grpc.health.v1.Health/*"),
+		WithRequiredScopes("/pkg.Service/Read", "read"),
+		WithRequiredScopes("/pkg.Service/Write", "read", "write"),
+	}
+
+	tests := []struct {
+		name          string
+		verifier      *Verifier
+		method        string
+		authorization string
+		want          codes.Code
+		wantSubject   string
+	}{{
+		name:          "valid token",
+		method:        "/pkg.Service/Get",
+		authorization: valid,
+		want:          codes.OK,
+		wantSubject:   "user-1",
+	}, {
+		name:          "lower-case scheme",
+		method:        "/pkg.Service/Get",
+		authorization: "bearer " + valid[len("Bearer "):],
+		want:          codes.OK,
+		wantSubject:   "user-1",
+	}, {
+		name:   "exempt method without a token",
+		method: "/grpc.health.v1.Health/Check",
+		want:   codes.OK,
+	}, {
+		name:   "missing token",
+		method: "/pkg.Service/Get",
+		want:   codes.Unauthenticated,
+	}, {
+		name:          "not a bearer token",
+		method:        "/pkg.Service/Get",
+		authorization: "Basic dXNlcjpwYXNz",
+		want:          codes.Unauthenticated,
+	}, {
+		name:          "invalid token",
+		method:        "/pkg.Service/Get",
+		authorization: "Bearer " + signToken(t, newECKey(t), "ES256", "ec", validClaims()),
+		want:          codes.Unauthenticated,
+	}, {
+		name:          "required scope granted",
+		method:        "/pkg.Service/Read",
+		authorization: valid,
+		want:          codes.OK,
+		wantSubject:   "user-1",
+	}, {
+		name:          "required scope missing",
+		method:        "/pkg.Service/Write",
+		authorization: valid,
+		want:          codes.PermissionDenied,
+	}, {
+		name:          "keys unavailable",
+		verifier:      down,
+		method:        "/pkg.Service/Get",
+		authorization: valid,
+		want:          codes.Unavailable,
+	}}
+
+	for _, tt := range tests {
+		verifier := v
+		if tt.verifier != nil {
+			verifier = tt.verifier
+		}
+
+		ctx := context.Background()
+		if tt.authorization != "" {
+			ctx = metadata.NewIncomingContext(ctx, metadata.Pairs("authorization", tt.authorization))
+		}
+		// subject reports the subject of the claims the handler saw.
+		subject := func(ctx context.Context) string {
+			if c, ok := ClaimsFromContext(ctx); ok {
+				return c.Subject
+			}
+			return ""
+		}
+
+		t.Run(tt.name+"/unary", func(t *testing.T) {
+			var got string
+			_, err := UnaryServerInterceptor(verifier, opts...)(ctx, nil, &grpc.UnaryServerInfo{FullMethod: tt.method},
+				func(ctx context.Context, _ any) (any, error) {
+					got = subject(ctx)
+					return nil, nil
+				})
+			if code := status.Code(err); code != tt.want {
+				t.Errorf("status code = %v, want %v (%v)", code, tt.want, err)
+			}
+			if got != tt.wantSubject {
+				t.Errorf("subject = %q, want %q", got, tt.wantSubject)
+			}
+		})
+
+		t.Run(tt.name+"/stream", func(t *testing.T) {
+			var got string
+			err := StreamServerInterceptor(verifier, opts...)(nil, &fakeServerStream{ctx: ctx}, &grpc.StreamServerInfo{FullMethod: tt.method},
+				func(_ any, ss grpc.ServerStream) error {
+					got = subject(ss.Context())
+					return nil
+				})
+			if code := status.Code(err); code != tt.want {
+				t.Errorf("status code = %v, want %v (%v)", code, tt.want, err)
+			}
+			if got != tt.wantSubject {
+				t.Errorf("subject = %q, want %q", got, tt.wantSubject)
+			}
+		})
+	}
+}
+
+type fakeServerStream struct {
+	grpc.ServerStream
+	ctx context.Context
+}
+
+func (s *fakeServerStream) Context() context.Context { return s.ctx }
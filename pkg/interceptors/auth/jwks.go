@@ -0,0 +1,117 @@
+/*
+Copyright 2026 Chainguard, Inc.
+SPDX-License-Identifier: Apache-2.0
+*/
+
+package auth
+
+import (
+	"context"
+	"crypto/rsa"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+
+	"github.com/go-jose/go-jose/v4"
+)
+
+// maxJWKSSize bounds the size of a JWKS document read from a URL.
+const maxJWKSSize = 1 << 20
+
+// Fetcher fetches a JSON Web Key Set (RFC 7517) document. Implement it to
+// supply keys from elsewhere, or to fake them in tests.
+type Fetcher interface {
+	Fetch(ctx context.Context) ([]byte, error)
+}
+
+// FetcherFunc adapts a function to a Fetcher.
+type FetcherFunc func(ctx context.Context) ([]byte, error)
+
+// Fetch calls f.
+func (f FetcherFunc) Fetch(ctx context.Context) ([]byte, error) {
+	return f(ctx)
+}
+
+// FileFetcher returns a Fetcher that reads the JWKS in the file at path.
+func FileFetcher(path string) Fetcher {
+	return FetcherFunc(func(context.Context) ([]byte, error) {
+		return os.ReadFile(path) //nolint:gosec // G304: the path is the caller's own configuration
+	})
+}
+
+// URLFetcher returns a Fetcher that GETs the JWKS at url with client, or with
+// http.DefaultClient when client is nil.
+func URLFetcher(url string, client *http.Client) Fetcher {
+	if client == nil {
+		client = http.DefaultClient
+	}
+	return FetcherFunc(func(ctx context.Context) ([]byte, error) {
+		req, err := http.NewRequestWithContext(ctx, http.MethodGet, url, nil)
+		if err != nil {
+			return nil, err
+		}
+		resp, err := client.Do(req)
+		if err != nil {
+			return nil, err
+		}
+		defer resp.Body.Close()
+
+		if resp.StatusCode != http.StatusOK {
+			return nil, fmt.Errorf("fetching %s: %s", url, resp.Status)
+		}
+		return io.ReadAll(io.LimitReader(resp.Body, maxJWKSSize))
+	})
+}
+
+// matches reports whether k may have signed a token with header h.
+func matches(k jose.JSONWebKey, h jose.Header) bool {
+	return (h.KeyID == "" || h.KeyID == k.KeyID) && (k.Algorithm == "" || k.Algorithm == h.Algorithm)
+}
+
+// parseJWKS returns the signature verification keys in the JWKS document b.
+// Keys of unsupported types, and keys for encryption, are skipped.
+func parseJWKS(b []byte) ([]jose.JSONWebKey, error) {
+	var doc struct {
+		Keys []json.RawMessage `json:"keys"`
+	}
+	if err := json.Unmarshal(b, &doc); err != nil {
+		return nil, fmt.Errorf("decoding JWKS: %w", err)
+	}
+
+	keys := make([]jose.JSONWebKey, 0, len(doc.Keys))
+	for _, raw := range doc.Keys {
+		var k jose.JSONWebKey
+		if err := k.UnmarshalJSON(raw); err != nil {
+			var id struct {
+				Kty string `json:"kty"`
+				Kid string `json:"kid"`
+			}
+			if json.Unmarshal(raw, &id) == nil && !supportedKeyTypes[id.Kty] {
+				continue
+			}
+			return nil, fmt.Errorf("key %q: %w", id.Kid, err)
+		}
+		if k.Use != "" && k.Use != "sig" {
+			continue
+		}
+		// Public returns an invalid key for symmetric keys, which are skipped.
+		if k = k.Public(); !k.Valid() {
+			continue
+		}
+		if pub, ok := k.Key.(*rsa.PublicKey); ok && pub.N.BitLen() < 2048 {
+			return nil, fmt.Errorf("key %q: RSA key of %d bits is too small", k.KeyID, pub.N.BitLen())
+		}
+		keys = append(keys, k)
+	}
+	if len(keys) == 0 {
+		return nil, errors.New("JWKS has no signature verification keys")
+	}
+	return keys, nil
+}
+
+// supportedKeyTypes are the key types whose keys must parse: a key of another
+// type is skipped.
+var supportedKeyTypes = map[string]bool{"RSA": true, "EC": true, "OKP": true}
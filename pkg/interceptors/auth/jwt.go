@@ -0,0 +1,70 @@
+/*
+Copyright 2026 Chainguard, Inc.
+SPDX-License-Identifier: Apache-2.0
+*/
+
+package auth
+
+import (
+	"slices"
+	"strings"
+	"time"
+
+	"github.com/go-jose/go-jose/v4"
+	"github.com/go-jose/go-jose/v4/jwt"
+)
+
+// Claims are the claims of a verified token.
+type Claims struct {
+	Issuer    string
+	Subject   string
+	Audience  []string
+	ExpiresAt time.Time
+	NotBefore time.Time
+	IssuedAt  time.Time
+
+	// Scopes are the token's scopes, from its space-separated "scope" claim or
+	// its "scp" list.
+	Scopes []string
+
+	// Raw holds every claim in the token, including those above.
+	Raw map[string]any
+}
+
+// HasScope reports whether the token was granted scope.
+func (c *Claims) HasScope(scope string) bool {
+	return slices.Contains(c.Scopes, scope)
+}
+
+// algorithms are the JWS algorithms a token may be signed with.
+var algorithms = []jose.SignatureAlgorithm{
+	jose.RS256, jose.RS384, jose.RS512,
+	jose.PS256, jose.PS384, jose.PS512,
+	jose.ES256, jose.ES384, jose.ES512,
+	jose.EdDSA,
+}
+
+// verifiedClaims returns the claims of t, if key signed it.
+func verifiedClaims(t *jwt.JSONWebToken, key jose.JSONWebKey) (*Claims, error) {
+	var (
+		registered jwt.Claims
+		scopes     struct {
+			Scope string   `json:"scope"`
+			Scp   []string `json:"scp"`
+		}
+		raw map[string]any
+	)
+	if err := t.Claims(key.Key, &registered, &scopes, &raw); err != nil {
+		return nil, err
+	}
+	return &Claims{
+		Issuer:    registered.Issuer,
+		Subject:   registered.Subject,
+		Audience:  registered.Audience,
+		ExpiresAt: registered.Expiry.Time(),
+		NotBefore: registered.NotBefore.Time(),
+		IssuedAt:  registered.IssuedAt.Time(),
+		Scopes:    slices.Concat(strings.Fields(scopes.Scope), scopes.Scp),
+		Raw:       raw,
+	}, nil
+}
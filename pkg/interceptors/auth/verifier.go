@@ -0,0 +1,228 @@
+/*
+Copyright 2026 Chainguard, Inc.
+SPDX-License-Identifier: Apache-2.0
+*/
+
+package auth
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"slices"
+	"sync"
+	"time"
+
+	"github.com/go-jose/go-jose/v4"
+	"github.com/go-jose/go-jose/v4/jwt"
+	"golang.org/x/sync/singleflight"
+
+	"github.com/chainguard-dev/clog"
+)
+
+const (
+	// defaultRefreshInterval is how long fetched keys are used before they are
+	// fetched again.
+	defaultRefreshInterval = 15 * time.Minute
+
+	// minRefreshInterval is the least time between fetches, whether prompted
+	// by stale keys, a token signed with an unknown key, or retrying a failed
+	// fetch, so that bad tokens cannot make the Verifier hammer the JWKS.
+	minRefreshInterval = 30 * time.Second
+
+	// defaultClockSkew is the leeway allowed when checking a token's validity
+	// period against the local clock.
+	defaultClockSkew = time.Minute
+
+	// fetchTimeout bounds a fetch of the keys, which is not cancelled with the
+	// call that prompted it, as other calls may be waiting on it too.
+	fetchTimeout = 30 * time.Second
+)
+
+// ErrKeysUnavailable is returned, wrapped, by Verify when no keys could be
+// fetched to verify a token with.
+var ErrKeysUnavailable = errors.New("verification keys unavailable")
+
+// Verifier verifies JWTs signed with the keys of a JWKS. It fetches the keys
+// when first needed, and again periodically, or when a token names a key it
+// does not have, so that keys can be rotated. Calls that need keys while they
+// are being fetched wait for that fetch rather than starting another. If a
+// fetch fails, it keeps using the keys it has, even once they are stale, and
+// fetches are always at least 30s apart, so bad tokens or a JWKS that is down
+// cannot make it hammer the JWKS.
+type Verifier struct {
+	fetcher  Fetcher
+	issuer   string
+	audience string
+
+	refreshInterval time.Duration
+	clockSkew       time.Duration
+	now             func() time.Time
+
+	// group shares a fetch among the calls that need it.
+	group singleflight.Group
+
+	mu          sync.Mutex
+	keys        []jose.JSONWebKey
+	fetchedAt   time.Time
+	attemptedAt time.Time
+	fetchErr    error
+}
+
+// VerifierOption configures a Verifier.
+type VerifierOption func(*Verifier)
+
+// WithRefreshInterval sets how long fetched keys are used before they are
+// fetched again. The default is 15 minutes.
+func WithRefreshInterval(interval time.Duration) VerifierOption {
+	return func(v *Verifier) {
+		v.refreshInterval = interval
+	}
+}
+
+// WithClockSkew sets the leeway allowed when checking a token's expiry and
+// not-before time. The default is one minute.
+func WithClockSkew(skew time.Duration) VerifierOption {
+	return func(v *Verifier) {
+		v.clockSkew = skew
+	}
+}
+
+// NewVerifier returns a Verifier that accepts tokens signed by a key from
+// fetcher, issued by issuer, for audience.
+func NewVerifier(fetcher Fetcher, issuer, audience string, opts ...VerifierOption) (*Verifier, error) {
+	if fetcher == nil {
+		return nil, errors.New("nil Fetcher")
+	}
+	if issuer == "" || audience == "" {
+		return nil, errors.New("issuer and audience must be set")
+	}
+
+	v := &Verifier{
+		fetcher:         fetcher,
+		issuer:          issuer,
+		audience:        audience,
+		refreshInterval: defaultRefreshInterval,
+		clockSkew:       defaultClockSkew,
+		now:             time.Now,
+	}
+	for _, o := range opts {
+		o(v)
+	}
+	if v.refreshInterval <= 0 || v.clockSkew < 0 {
+		return nil, fmt.Errorf("invalid refresh interval %s or clock skew %s", v.refreshInterval, v.clockSkew)
+	}
+	return v, nil
+}
+
+// Verify verifies the signature of the compact JWS raw, and that it was issued
+// by the Verifier's issuer for its audience and is within its validity period,
+// and returns its claims. A token must carry an expiry.
+func (v *Verifier) Verify(ctx context.Context, raw string) (*Claims, error) {
+	t, err := jwt.ParseSigned(raw, algorithms)
+	if err != nil {
+		return nil, fmt.Errorf("parsing token: %w", err)
+	}
+	// A compact JWS has exactly one signature.
+	h := t.Headers[0]
+
+	keys, err := v.keysFor(ctx, h)
+	if err != nil {
+		return nil, err
+	}
+	var c *Claims
+	for _, k := range keys {
+		if !matches(k, h) {
+			continue
+		}
+		if c, err = verifiedClaims(t, k); err == nil {
+			break
+		}
+	}
+	if c == nil {
+		return nil, errors.New("invalid signature")
+	}
+
+	now := v.now()
+	switch {
+	case c.Issuer != v.issuer:
+		return nil, fmt.Errorf("issuer %q is not %q", c.Issuer, v.issuer)
+	case !slices.Contains(c.Audience, v.audience):
+		return nil, fmt.Errorf("audience %q does not include %q", c.Audience, v.audience)
+	case c.ExpiresAt.IsZero():
+		return nil, errors.New("token has no expiry")
+	case now.After(c.ExpiresAt.Add(v.clockSkew)):
+		return nil, fmt.Errorf("token expired at %s", c.ExpiresAt)
+	case !c.NotBefore.IsZero() && now.Add(v.clockSkew).Before(c.NotBefore):
+		return nil, fmt.Errorf("token not valid before %s", c.NotBefore)
+	}
+	return c, nil
+}
+
+// keysFor returns the keys to verify a token with header h with, fetching
+// them first if they are stale or none matches h, unless the last fetch was
+// too recent.
+func (v *Verifier) keysFor(ctx context.Context, h jose.Header) ([]jose.JSONWebKey, error) {
+	v.mu.Lock()
+	keys, fetchedAt, attemptedAt, fetchErr := v.keys, v.fetchedAt, v.attemptedAt, v.fetchErr
+	v.mu.Unlock()
+
+	now := v.now()
+	stale := keys == nil || now.Sub(fetchedAt) >= v.refreshInterval ||
+		!slices.ContainsFunc(keys, func(k jose.JSONWebKey) bool { return matches(k, h) })
+	if !stale || now.Sub(attemptedAt) < minRefreshInterval {
+		if keys == nil {
+			return nil, fmt.Errorf("%w: %w", ErrKeysUnavailable, fetchErr)
+		}
+		return keys, nil
+	}
+
+	// The fetch outlives ctx, for the other calls that may wait on it, but
+	// keeps its values, such as its logger.
+	ch := v.group.DoChan("", func() (any, error) {
+		return v.refresh(context.WithoutCancel(ctx))
+	})
+	var res singleflight.Result
+	select {
+	case res = <-ch:
+	case <-ctx.Done():
+		return nil, fmt.Errorf("waiting for verification keys: %w", ctx.Err())
+	}
+
+	keys, _ = res.Val.([]jose.JSONWebKey)
+	if res.Err != nil {
+		if keys == nil {
+			return nil, fmt.Errorf("%w: %w", ErrKeysUnavailable, res.Err)
+		}
+		clog.FromContext(ctx).Warn("failed to refresh JWKS, keeping the previous keys", "error", res.Err)
+	}
+	return keys, nil
+}
+
+// refresh fetches the keys, within fetchTimeout, and returns them, or the keys
+// it had with the error if the fetch fails. The attempt is recorded once it
+// ends, so calls that need keys meanwhile join it rather than being throttled.
+func (v *Verifier) refresh(ctx context.Context) ([]jose.JSONWebKey, error) {
+	now := v.now()
+	ctx, cancel := context.WithTimeout(ctx, fetchTimeout)
+	defer cancel()
+	keys, err := v.fetch(ctx)
+
+	v.mu.Lock()
+	defer v.mu.Unlock()
+	v.attemptedAt = now
+	if err != nil {
+		v.fetchErr = err
+		return v.keys, err
+	}
+	v.keys, v.fetchedAt, v.fetchErr = keys, now, nil
+	return keys, nil
+}
+
+func (v *Verifier) fetch(ctx context.Context) ([]jose.JSONWebKey, error) {
+	b, err := v.fetcher.Fetch(ctx)
+	if err != nil {
+		return nil, fmt.Errorf("fetching JWKS: %w", err)
+	}
+	return parseJWKS(b)
+}
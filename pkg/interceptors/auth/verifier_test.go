@@ -0,0 +1,579 @@
+/*
+Copyright 2026 Chainguard, Inc.
+SPDX-License-Identifier: Apache-2.0
+*/
+
+package auth
+
+import (
+	"context"
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/ed25519"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"maps"
+	"math/big"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/google/go-cmp/cmp"
+	"github.com/google/go-cmp/cmp/cmpopts"
+)
+
+const (
+	testIssuer   = "https://issuer.example.com"
+	testAudience = "https://api.example.com"
+)
+
+func TestVerify(t *testing.T) {
+	ecKey := newECKey(t)
+	rsaKey, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, edKey, err := ed25519.GenerateKey(rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	otherKey := newECKey(t)
+
+	jwks := jwksFor(t,
+		testKey{kid: "ec", signer: ecKey},
+		testKey{kid: "rsa", signer: rsaKey},
+		testKey{kid: "ed", signer: edKey},
+	)
+	v := newTestVerifier(t, staticFetcher(jwks))
+
+	tests := []struct {
+		name    string
+		token   string
+		wantErr bool
+	}{{
+		name:  "ES256",
+		token: signToken(t, ecKey, "ES256", "ec", validClaims()),
+	}, {
+		name:  "RS256",
+		token: signToken(t, rsaKey, "RS256", "rsa", validClaims()),
+	}, {
+		name:  "PS256",
+		token: signToken(t, rsaKey, "PS256", "rsa", validClaims()),
+	}, {
+		name:  "EdDSA",
+		token: signToken(t, edKey, "EdDSA", "ed", validClaims()),
+	}, {
+		name:  "no kid",
+		token: signToken(t, ecKey, "ES256", "", validClaims()),
+	}, {
+		name:  "audience list",
+		token: signToken(t, ecKey, "ES256", "ec", validClaims("aud", []string{"other", testAudience})),
+	}, {
+		name:  "expired within the clock skew",
+		token: signToken(t, ecKey, "ES256", "ec", validClaims("exp", time.Now().Add(-30*time.Second).Unix())),
+	}, {
+		name:    "wrong key",
+		token:   signToken(t, otherKey, "ES256", "ec", validClaims()),
+		wantErr: true,
+	}, {
+		name:    "algorithm not matching the key",
+		token:   signToken(t, rsaKey, "RS256", "ec", validClaims()),
+		wantErr: true,
+	}, {
+		name:    "alg none",
+		token:   unsignedToken(t, validClaims()),
+		wantErr: true,
+	}, {
+		name:    "wrong issuer",
+		token:   signToken(t, ecKey, "ES256", "ec", validClaims("iss", "https://evil.example.com")),
+		wantErr: true,
+	}, {
+		name:    "wrong audience",
+		token:   signToken(t, ecKey, "ES256", "ec", validClaims("aud", "https://other.example.com")),
+		wantErr: true,
+	}, {
+		name:    "expired",
+		token:   signToken(t, ecKey, "ES256", "ec", validClaims("exp", time.Now().Add(-time.Hour).Unix())),
+		wantErr: true,
+	}, {
+		name:    "not yet valid",
+		token:   signToken(t, ecKey, "ES256", "ec", validClaims("nbf", time.Now().Add(time.Hour).Unix())),
+		wantErr: true,
+	}, {
+		name:    "no expiry",
+		token:   signToken(t, ecKey, "ES256", "ec", validClaims("exp", nil)),
+		wantErr: true,
+	}, {
+		name:    "malformed",
+		token:   "not.a.token",
+		wantErr: true,
+	}}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := v.Verify(t.Context(), tt.token)
+			if (err != nil) != tt.wantErr {
+				t.Errorf("Verify() = %v, wantErr %v", err, tt.wantErr)
+			}
+		})
+	}
+}
+
+func TestVerify_Claims(t *testing.T) {
+	key := newECKey(t)
+	v := newTestVerifier(t, staticFetcher(jwksFor(t, testKey{kid: "ec", signer: key})))
+
+	iat := time.Now().Truncate(time.Second)
+	exp := iat.Add(time.Hour)
+	got, err := v.Verify(t.Context(), signToken(t, key, "ES256", "ec", validClaims(
+		"sub", "user-1",
+		"iat", iat.Unix(),
+		"exp", exp.Unix(),
+		"scope", "read write",
+		"scp", []string{"admin"},
+	)))
+	if err != nil {
+		t.Fatalf("Verify() = %v", err)
+	}
+
+	want := &Claims{
+		Issuer:    testIssuer,
+		Subject:   "user-1",
+		Audience:  []string{testAudience},
+		ExpiresAt: exp,
+		IssuedAt:  iat,
+		Scopes:    []string{"read", "write", "admin"},
+	}
+	if diff := cmp.Diff(want, got, cmpopts.IgnoreFields(Claims{}, "Raw")); diff != "" {
+		t.Errorf("Verify() -want,+got: %s", diff)
+	}
+	if got.Raw["sub"] != "user-1" {
+		t.Errorf("Raw[sub] = %v, want user-1", got.Raw["sub"])
+	}
+	if !got.HasScope("write") || got.HasScope("delete") {
+		t.Errorf("HasScope() disagrees with scopes %v", got.Scopes)
+	}
+}
+
+func TestVerifier_KeyRotation(t *testing.T) {
+	oldKey, newKey := newECKey(t), newECKey(t)
+
+	var (
+		fetches atomic.Int32
+		jwks    atomic.Value
+	)
+	jwks.Store(jwksFor(t, testKey{kid: "old", signer: oldKey}))
+	v := newTestVerifier(t, FetcherFunc(func(context.Context) ([]byte, error) {
+		fetches.Add(1)
+		return jwks.Load().([]byte), nil
+	}))
+	now := time.Now()
+	v.now = func() time.Time { return now }
+
+	if _, err := v.Verify(t.Context(), signToken(t, oldKey, "ES256", "old", validClaims())); err != nil {
+		t.Fatalf("Verify(old) = %v", err)
+	}
+
+	// The issuer rotates to a new key.
+	jwks.Store(jwksFor(t, testKey{kid: "new", signer: newKey}))
+	rotated := signToken(t, newKey, "ES256", "new", validClaims())
+
+	// An unknown key prompts a fetch, but not straight after the last one.
+	if _, err := v.Verify(t.Context(), rotated); err == nil {
+		t.Error("Verify(new) straight after a fetch succeeded, want error")
+	}
+	now = now.Add(minRefreshInterval)
+	if _, err := v.Verify(t.Context(), rotated); err != nil {
+		t.Errorf("Verify(new) = %v", err)
+	}
+	if got := fetches.Load(); got != 2 {
+		t.Errorf("fetches = %d, want 2", got)
+	}
+}
+
+func TestVerifier_FetchFailure(t *testing.T) {
+	key := newECKey(t)
+	tok := signToken(t, key, "ES256", "ec", validClaims())
+
+	var (
+		failing atomic.Bool
+		fetches atomic.Int32
+	)
+	failing.Store(true)
+	v := newTestVerifier(t, FetcherFunc(func(context.Context) ([]byte, error) {
+		fetches.Add(1)
+		if failing.Load() {
+			return nil, errors.New("JWKS is down")
+		}
+		return jwksFor(t, testKey{kid: "ec", signer: key}), nil
+	}))
+	now := time.Now()
+	v.now = func() time.Time { return now }
+
+	// With no keys yet, tokens cannot be verified.
+	if _, err := v.Verify(t.Context(), tok); !errors.Is(err, ErrKeysUnavailable) {
+		t.Errorf("Verify() = %v, want %v", err, ErrKeysUnavailable)
+	}
+
+	// Nor can they until the fetch is retried, which is not straight away.
+	failing.Store(false)
+	if _, err := v.Verify(t.Context(), tok); !errors.Is(err, ErrKeysUnavailable) {
+		t.Errorf("Verify() straight after a failed fetch = %v, want %v", err, ErrKeysUnavailable)
+	}
+	if got := fetches.Load(); got != 1 {
+		t.Errorf("fetches = %d, want 1", got)
+	}
+	now = now.Add(minRefreshInterval)
+	if _, err := v.Verify(t.Context(), tok); err != nil {
+		t.Fatalf("Verify() = %v", err)
+	}
+
+	// Once the keys are stale, a failed refresh keeps using them.
+	failing.Store(true)
+	now = now.Add(defaultRefreshInterval)
+	if _, err := v.Verify(t.Context(), tok); err != nil {
+		t.Errorf("Verify() with a failed refresh = %v", err)
+	}
+
+	// The stale keys are not fetched again straight after the failed refresh,
+	// but are once it is 30s old.
+	if _, err := v.Verify(t.Context(), tok); err != nil {
+		t.Errorf("Verify() straight after a failed refresh = %v", err)
+	}
+	if got := fetches.Load(); got != 3 {
+		t.Errorf("fetches = %d, want 3", got)
+	}
+	now = now.Add(minRefreshInterval)
+	if _, err := v.Verify(t.Context(), tok); err != nil {
+		t.Errorf("Verify() = %v", err)
+	}
+	if got := fetches.Load(); got != 4 {
+		t.Errorf("fetches = %d, want 4", got)
+	}
+}
+
+func TestVerifier_SharedFetch(t *testing.T) {
+	key := newECKey(t)
+	tok := signToken(t, key, "ES256", "ec", validClaims())
+
+	var fetches atomic.Int32
+	release := make(chan struct{})
+	v := newTestVerifier(t, FetcherFunc(func(ctx context.Context) ([]byte, error) {
+		fetches.Add(1)
+		select {
+		case <-release:
+		case <-ctx.Done():
+			return nil, ctx.Err()
+		}
+		return jwksFor(t, testKey{kid: "ec", signer: key}), nil
+	}))
+
+	// A caller that gives up does not cancel the fetch it started.
+	ctx, cancel := context.WithCancel(t.Context())
+	abandoned := make(chan error, 1)
+	go func() {
+		_, err := v.Verify(ctx, tok)
+		abandoned <- err
+	}()
+	for fetches.Load() == 0 {
+		time.Sleep(time.Millisecond)
+	}
+	cancel()
+	if err := <-abandoned; !errors.Is(err, context.Canceled) {
+		t.Errorf("Verify() after giving up = %v, want %v", err, context.Canceled)
+	}
+
+	// Calls during the fetch wait for it, rather than fetching again.
+	errs := make(chan error, 10)
+	for range cap(errs) {
+		go func() {
+			_, err := v.Verify(t.Context(), tok)
+			errs <- err
+		}()
+	}
+	time.Sleep(10 * time.Millisecond)
+	close(release)
+	for range cap(errs) {
+		if err := <-errs; err != nil {
+			t.Errorf("Verify() = %v", err)
+		}
+	}
+	if got := fetches.Load(); got != 1 {
+		t.Errorf("fetches = %d, want 1", got)
+	}
+}
+
+func TestParseJWKS(t *testing.T) {
+	ec := jwksFor(t, testKey{kid: "ec", signer: newECKey(t)})
+	var doc struct {
+		Keys []map[string]any `json:"keys"`
+	}
+	if err := json.Unmarshal(ec, &doc); err != nil {
+		t.Fatal(err)
+	}
+	encryption := maps.Clone(doc.Keys[0])
+	encryption["use"] = "enc"
+	mixed, err := json.Marshal(map[string]any{"keys": []any{
+		map[string]any{"kty": "oct", "k": "c2VjcmV0"},
+		map[string]any{"kty": "XYZ"},
+		encryption,
+		doc.Keys[0],
+	}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	smallRSA, err := rsa.GenerateKey(rand.Reader, 1024) //nolint:gosec // G403: a key too small to be accepted
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name     string
+		jwks     string
+		wantKeys int
+		wantErr  bool
+	}{{
+		name:     "EC key",
+		jwks:     string(ec),
+		wantKeys: 1,
+	}, {
+		name:     "unsupported and encryption keys skipped",
+		jwks:     string(mixed),
+		wantKeys: 1,
+	}, {
+		name:    "malformed EC key",
+		jwks:    `{"keys": [{"kty": "EC", "crv": "P-256", "x": "AA", "y": "AA"}]}`,
+		wantErr: true,
+	}, {
+		name:    "small RSA key",
+		jwks:    string(jwksFor(t, testKey{kid: "rsa", signer: smallRSA})),
+		wantErr: true,
+	}, {
+		name:    "no keys",
+		jwks:    `{"keys": []}`,
+		wantErr: true,
+	}, {
+		name:    "not JSON",
+		jwks:    `keys`,
+		wantErr: true,
+	}}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			keys, err := parseJWKS([]byte(tt.jwks))
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("parseJWKS() = %v, wantErr %v", err, tt.wantErr)
+			}
+			if len(keys) != tt.wantKeys {
+				t.Errorf("parseJWKS() = %d keys, want %d", len(keys), tt.wantKeys)
+			}
+		})
+	}
+}
+
+func TestNewVerifier_Errors(t *testing.T) {
+	fetcher := staticFetcher(nil)
+
+	tests := []struct {
+		name     string
+		fetcher  Fetcher
+		issuer   string
+		audience string
+		opts     []VerifierOption
+	}{
+		{"nil fetcher", nil, testIssuer, testAudience, nil},
+		{"no issuer", fetcher, "", testAudience, nil},
+		{"no audience", fetcher, testIssuer, "", nil},
+		{"zero refresh interval", fetcher, testIssuer, testAudience, []VerifierOption{WithRefreshInterval(0)}},
+		{"negative clock skew", fetcher, testIssuer, testAudience, []VerifierOption{WithClockSkew(-time.Second)}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if _, err := NewVerifier(tt.fetcher, tt.issuer, tt.audience, tt.opts...); err == nil {
+				t.Error("NewVerifier() = nil, want error")
+			}
+		})
+	}
+}
+
+func TestFetchers(t *testing.T) {
+	jwks := jwksFor(t, testKey{kid: "ec", signer: newECKey(t)})
+
+	path := filepath.Join(t.TempDir(), "jwks.json")
+	if err := os.WriteFile(path, jwks, 0o600); err != nil {
+		t.Fatal(err)
+	}
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/jwks" {
+			http.NotFound(w, r)
+			return
+		}
+		_, _ = w.Write(jwks)
+	}))
+	t.Cleanup(srv.Close)
+
+	tests := []struct {
+		name    string
+		fetcher Fetcher
+		wantErr bool
+	}{
+		{"file", FileFetcher(path), false},
+		{"missing file", FileFetcher(path + ".missing"), true},
+		{"URL", URLFetcher(srv.URL+"/jwks", nil), false},
+		{"URL not found", URLFetcher(srv.URL+"/missing", srv.Client()), true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := tt.fetcher.Fetch(t.Context())
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("Fetch() = %v, wantErr %v", err, tt.wantErr)
+			}
+			if !tt.wantErr && string(got) != string(jwks) {
+				t.Errorf("Fetch() = %s, want %s", got, jwks)
+			}
+		})
+	}
+}
+
+func newTestVerifier(t *testing.T, fetcher Fetcher) *Verifier {
+	t.Helper()
+
+	v, err := NewVerifier(fetcher, testIssuer, testAudience)
+	if err != nil {
+		t.Fatalf("NewVerifier() = %v", err)
+	}
+	return v
+}
+
+func staticFetcher(jwks []byte) Fetcher {
+	return FetcherFunc(func(context.Context) ([]byte, error) { return jwks, nil })
+}
+
+func newECKey(t *testing.T) *ecdsa.PrivateKey {
+	t.Helper()
+
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return key
+}
+
+// validClaims returns claims that pass verification, with the given
+// name/value pairs set, or removed when the value is nil.
+func validClaims(kv ...any) map[string]any {
+	claims := map[string]any{
+		"iss": testIssuer,
+		"aud": testAudience,
+		"exp": time.Now().Add(time.Hour).Unix(),
+		"iat": time.Now().Unix(),
+	}
+	for i := 0; i < len(kv); i += 2 {
+		if kv[i+1] == nil {
+			delete(claims, kv[i].(string))
+		} else {
+			claims[kv[i].(string)] = kv[i+1]
+		}
+	}
+	return claims
+}
+
+type testKey struct {
+	kid    string
+	signer crypto.Signer
+}
+
+// jwksFor returns a JWKS document with the public halves of keys.
+func jwksFor(t *testing.T, keys ...testKey) []byte {
+	t.Helper()
+
+	enc := base64.RawURLEncoding.EncodeToString
+	var doc struct {
+		Keys []map[string]string `json:"keys"`
+	}
+	for _, k := range keys {
+		var jwk map[string]string
+		switch pub := k.signer.Public().(type) {
+		case *ecdsa.PublicKey:
+			b, err := pub.Bytes()
+			if err != nil {
+				t.Fatal(err)
+			}
+			size := (len(b) - 1) / 2
+			jwk = map[string]string{"kty": "EC", "crv": "P-256", "x": enc(b[1 : 1+size]), "y": enc(b[1+size:])}
+		case *rsa.PublicKey:
+			jwk = map[string]string{"kty": "RSA", "n": enc(pub.N.Bytes()), "e": enc(big.NewInt(int64(pub.E)).Bytes())}
+		case ed25519.PublicKey:
+			jwk = map[string]string{"kty": "OKP", "crv": "Ed25519", "x": enc(pub)}
+		default:
+			t.Fatalf("unsupported key type %T", pub)
+		}
+		jwk["kid"] = k.kid
+		doc.Keys = append(doc.Keys, jwk)
+	}
+
+	b, err := json.Marshal(doc)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return b
+}
+
+// signToken returns a compact JWS of claims, signed by key with alg.
+func signToken(t *testing.T, key crypto.Signer, alg, kid string, claims map[string]any) string {
+	t.Helper()
+
+	signed := encodeSegment(t, map[string]string{"alg": alg, "kid": kid, "typ": "JWT"}) + "." + encodeSegment(t, claims)
+	digest := sha256.Sum256([]byte(signed))
+
+	var (
+		sig []byte
+		err error
+	)
+	switch alg {
+	case "ES256":
+		var r, s *big.Int
+		if r, s, err = ecdsa.Sign(rand.Reader, key.(*ecdsa.PrivateKey), digest[:]); err == nil {
+			sig = append(r.FillBytes(make([]byte, 32)), s.FillBytes(make([]byte, 32))...)
+		}
+	case "RS256":
+		sig, err = rsa.SignPKCS1v15(rand.Reader, key.(*rsa.PrivateKey), crypto.SHA256, digest[:])
+	case "PS256":
+		sig, err = rsa.SignPSS(rand.Reader, key.(*rsa.PrivateKey), crypto.SHA256, digest[:], nil)
+	case "EdDSA":
+		sig = ed25519.Sign(key.(ed25519.PrivateKey), []byte(signed))
+	default:
+		t.Fatalf("unsupported algorithm %q", alg)
+	}
+	if err != nil {
+		t.Fatalf("signing: %v", err)
+	}
+	return signed + "." + base64.RawURLEncoding.EncodeToString(sig)
+}
+
+func unsignedToken(t *testing.T, claims map[string]any) string {
+	t.Helper()
+
+	return encodeSegment(t, map[string]string{"alg": "none"}) + "." + encodeSegment(t, claims) + "."
+}
+
+func encodeSegment(t *testing.T, v any) string {
+	t.Helper()
+
+	b, err := json.Marshal(v)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return base64.RawURLEncoding.EncodeToString(b)
+}
@@ -0,0 +1,210 @@
+/*
+Copyright 2026 Chainguard, Inc.
+SPDX-License-Identifier: Apache-2.0
+*/
+
+package authz
+
+import (
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"net"
+	"net/url"
+	"testing"
+
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/credentials"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/peer"
+	"google.golang.org/grpc/status"
+
+	"chainguard.dev/go-grpc-kit/pkg/interceptors/auth"
+	"chainguard.dev/go-grpc-kit/pkg/interceptors/clientid"
+)
+
+func TestInterceptors(t *testing.T) {
+	policy := &Policy{Rules: []Rule{{
+		Methods: []string{"/grpc.health.v1.Health/*"},
+		Public:  true,
+	}, {
+		Methods:  []string{"/pkg.Service/Get", "/pkg.Service/List"},
+		Subjects: []string{"user-*"},
+	}, {
+		Methods:   []string{"/pkg.Service/List"},
+		ClientIDs: []string{"dashboard"},
+	}, {
+		Methods: []string{"/pkg.Service/*"},
+		SANs:    []string{"spiffe://example.com/ns/admin/*", "10.0.0.1"},
+	}}}
+
+	spiffe, err := url.Parse("spiffe://example.com/ns/admin/sa/ops")
+	if err != nil {
+		t.Fatal(err)
+	}
+	admin := &x509.Certificate{URIs: []*url.URL{spiffe}}
+
+	tests := []struct {
+		name   string
+		ctx    context.Context
+		method string
+		want   codes.Code
+	}{{
+		name:   "public method",
+		ctx:    context.Background(),
+		method: "/grpc.health.v1.Health/Check",
+		want:   codes.OK,
+	}, {
+		name:   "allowed subject",
+		ctx:    withSubject("user-1"),
+		method: "/pkg.Service/Get",
+		want:   codes.OK,
+	}, {
+		name:   "other subject",
+		ctx:    withSubject("robot-1"),
+		method: "/pkg.Service/Get",
+		want:   codes.PermissionDenied,
+	}, {
+		name:   "allowed client ID",
+		ctx:    withClientID("dashboard"),
+		method: "/pkg.Service/List",
+		want:   codes.OK,
+	}, {
+		name:   "client ID not allowed this method",
+		ctx:    withClientID("dashboard"),
+		method: "/pkg.Service/Get",
+		want:   codes.PermissionDenied,
+	}, {
+		name:   "allowed URI SAN",
+		ctx:    withPeerCertificate(admin, true),
+		method: "/pkg.Service/Delete",
+		want:   codes.OK,
+	}, {
+		name:   "allowed IP SAN",
+		ctx:    withPeerCertificate(&x509.Certificate{IPAddresses: []net.IP{net.ParseIP("10.0.0.1")}}, true),
+		method: "/pkg.Service/Delete",
+		want:   codes.OK,
+	}, {
+		name:   "unverified certificate",
+		ctx:    withPeerCertificate(admin, false),
+		method: "/pkg.Service/Delete",
+		want:   codes.PermissionDenied,
+	}, {
+		name:   "anonymous",
+		ctx:    context.Background(),
+		method: "/pkg.Service/Get",
+		want:   codes.PermissionDenied,
+	}, {
+		name:   "method without rules",
+		ctx:    withSubject("user-1"),
+		method: "/other.Service/Get",
+		want:   codes.PermissionDenied,
+	}}
+
+	enforcing, err := NewAuthorizer(policy)
+	if err != nil {
+		t.Fatalf("NewAuthorizer() = %v", err)
+	}
+	dryRun, err := NewAuthorizer(policy, WithDryRun())
+	if err != nil {
+		t.Fatalf("NewAuthorizer() = %v", err)
+	}
+
+	for _, tt := range tests {
+		wantDenials := 0.0
+		if tt.want != codes.OK {
+			wantDenials = 1
+		}
+
+		t.Run(tt.name+"/unary", func(t *testing.T) {
+			denials := denialsTotal.WithLabelValues(tt.method, "false")
+			before := testutil.ToFloat64(denials)
+
+			called := false
+			_, err := UnaryServerInterceptor(enforcing)(tt.ctx, nil, &grpc.UnaryServerInfo{FullMethod: tt.method},
+				func(context.Context, any) (any, error) {
+					called = true
+					return nil, nil
+				})
+			if code := status.Code(err); code != tt.want {
+				t.Errorf("status code = %v, want %v (%v)", code, tt.want, err)
+			}
+			if called != (tt.want == codes.OK) {
+				t.Errorf("handler called = %t, want %t", called, tt.want == codes.OK)
+			}
+			if got := testutil.ToFloat64(denials) - before; got != wantDenials {
+				t.Errorf("denials = %v, want %v", got, wantDenials)
+			}
+		})
+
+		t.Run(tt.name+"/stream", func(t *testing.T) {
+			err := StreamServerInterceptor(enforcing)(nil, &fakeServerStream{ctx: tt.ctx}, &grpc.StreamServerInfo{FullMethod: tt.method},
+				func(any, grpc.ServerStream) error { return nil })
+			if code := status.Code(err); code != tt.want {
+				t.Errorf("status code = %v, want %v (%v)", code, tt.want, err)
+			}
+		})
+
+		t.Run(tt.name+"/dry run", func(t *testing.T) {
+			denials := denialsTotal.WithLabelValues(tt.method, "true")
+			before := testutil.ToFloat64(denials)
+
+			called := false
+			_, err := UnaryServerInterceptor(dryRun)(tt.ctx, nil, &grpc.UnaryServerInfo{FullMethod: tt.method},
+				func(context.Context, any) (any, error) {
+					called = true
+					return nil, nil
+				})
+			if err != nil || !called {
+				t.Errorf("dry run: err = %v, handler called = %t; want the call served", err, called)
+			}
+			if got := testutil.ToFloat64(denials) - before; got != wantDenials {
+				t.Errorf("denials = %v, want %v", got, wantDenials)
+			}
+		})
+	}
+}
+
+func TestNewAuthorizer_CopiesPolicy(t *testing.T) {
+	policy := &Policy{Rules: []Rule{{
+		Methods:  []string{"/pkg.Service/Get"},
+		Subjects: []string{"user-1"},
+	}}}
+	a, err := NewAuthorizer(policy)
+	if err != nil {
+		t.Fatalf("NewAuthorizer() = %v", err)
+	}
+	policy.Rules[0].Subjects[0] = "user-2"
+
+	if err := a.Authorize(withSubject("user-1"), "/pkg.Service/Get"); err != nil {
+		t.Errorf("Authorize() = %v, want the original policy enforced", err)
+	}
+	if _, err := NewAuthorizer(&Policy{}); err == nil {
+		t.Error("NewAuthorizer(empty) = nil, want error")
+	}
+}
+
+func withSubject(sub string) context.Context {
+	return auth.ContextWithClaims(context.Background(), &auth.Claims{Subject: sub})
+}
+
+func withClientID(id string) context.Context {
+	return metadata.NewIncomingContext(context.Background(), metadata.Pairs(clientid.CGClientID, id))
+}
+
+func withPeerCertificate(cert *x509.Certificate, verified bool) context.Context {
+	state := tls.ConnectionState{PeerCertificates: []*x509.Certificate{cert}}
+	if verified {
+		state.VerifiedChains = [][]*x509.Certificate{{cert}}
+	}
+	return peer.NewContext(context.Background(), &peer.Peer{AuthInfo: credentials.TLSInfo{State: state}})
+}
+
+type fakeServerStream struct {
+	grpc.ServerStream
+	ctx context.Context
+}
+
+func (s *fakeServerStream) Context() context.Context { return s.ctx }
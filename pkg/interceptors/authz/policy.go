@@ -0,0 +1,147 @@
+/*
+Copyright 2026 Chainguard, Inc.
+SPDX-License-Identifier: Apache-2.0
+*/
+
+package authz
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"strings"
+
+	"sigs.k8s.io/yaml"
+
+	"chainguard.dev/go-grpc-kit/internal/methodmatch"
+)
+
+// Policy maps gRPC methods to the principals allowed to call them. A caller
+// may call a method when any rule matching the method allows it; a method
+// matched by no rule may not be called by anyone.
+//
+// A Policy is written in YAML or JSON, as in:
+//
+//	rules:
+//	- methods: ["/grpc.health.v1.Health/*"]
+//	  public: true
+//	- methods: ["/pkg.Service/Get", "/pkg.Service/List"]
+//	  subjects: ["user-*"]
+//	  clientIDs: ["dashboard"]
+//	- methods: ["/pkg.Service/*"]
+//	  sans: ["spiffe://example.com/ns/admin/*"]
+type Policy struct {
+	Rules []Rule `json:"rules"`
+}
+
+// Rule allows the principals it lists to call the methods it lists.
+//
+// Methods, and each principal, are either exact values or prefixes ending in
+// "*": "/pkg.Service/*" matches every method of pkg.Service, and "*" alone
+// matches any non-empty principal.
+type Rule struct {
+	// Methods are the full method names the rule applies to, such as
+	// "/pkg.Service/Method".
+	Methods []string `json:"methods"`
+
+	// Public allows any caller, authenticated or not.
+	Public bool `json:"public,omitempty"`
+
+	// Subjects allows callers whose verified JWT has one of these subjects
+	// (the sub claim), as placed on the context by the auth interceptors.
+	Subjects []string `json:"subjects,omitempty"`
+
+	// ClientIDs allows callers that send one of these cgclientid values. The
+	// cgclientid is asserted by the caller rather than proven, so allow by it
+	// only where that is enough.
+	ClientIDs []string `json:"clientIDs,omitempty"`
+
+	// SANs allows callers whose verified mTLS client certificate has one of
+	// these subject alternative names: a DNS name, URI, email address or IP
+	// address.
+	SANs []string `json:"sans,omitempty"`
+}
+
+// ParsePolicy parses the YAML or JSON policy in b and validates it. Unknown
+// fields are rejected, so that a misspelt one cannot silently change what is
+// allowed.
+func ParsePolicy(b []byte) (*Policy, error) {
+	p := &Policy{}
+	if err := yaml.UnmarshalStrict(b, p); err != nil {
+		return nil, fmt.Errorf("parsing policy: %w", err)
+	}
+	if err := p.Validate(); err != nil {
+		return nil, err
+	}
+	return p, nil
+}
+
+// LoadPolicy reads and parses the policy in the file at path, as ParsePolicy.
+func LoadPolicy(path string) (*Policy, error) {
+	b, err := os.ReadFile(path) //nolint:gosec // G304: the path is the caller's own configuration
+	if err != nil {
+		return nil, err
+	}
+	p, err := ParsePolicy(b)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", path, err)
+	}
+	return p, nil
+}
+
+// Validate reports whether every rule names at least one method, each a full
+// method name or prefix, and allows someone.
+func (p *Policy) Validate() error {
+	if len(p.Rules) == 0 {
+		return errors.New("policy has no rules")
+	}
+	for i, r := range p.Rules {
+		if len(r.Methods) == 0 {
+			return fmt.Errorf("rule %d: no methods", i)
+		}
+		for _, m := range r.Methods {
+			if !strings.HasPrefix(m, "/") && m != "*" {
+				return fmt.Errorf("rule %d: method %q is not of the form /pkg.Service/Method", i, m)
+			}
+		}
+		if !r.Public && len(r.Subjects) == 0 && len(r.ClientIDs) == 0 && len(r.SANs) == 0 {
+			return fmt.Errorf("rule %d: allows no principals", i)
+		}
+		if r.Public && (len(r.Subjects) > 0 || len(r.ClientIDs) > 0 || len(r.SANs) > 0) {
+			return fmt.Errorf("rule %d: a public rule cannot also list principals", i)
+		}
+	}
+	return nil
+}
+
+// principal identifies a caller, by whichever of these it presented.
+type principal struct {
+	subject  string
+	clientID string
+	sans     []string
+}
+
+// allows reports whether r allows p to call method.
+func (r *Rule) allows(method string, p principal) bool {
+	if !matchAny(r.Methods, method) {
+		return false
+	}
+	if r.Public {
+		return true
+	}
+	if matchAny(r.Subjects, p.subject) || matchAny(r.ClientIDs, p.clientID) {
+		return true
+	}
+	for _, san := range p.sans {
+		if matchAny(r.SANs, san) {
+			return true
+		}
+	}
+	return false
+}
+
+// matchAny reports whether value, when non-empty, matches any of patterns: a
+// pattern is either an exact value or a prefix ending in "*".
+func matchAny(patterns []string, value string) bool {
+	return value != "" && methodmatch.Any(patterns, value)
+}
@@ -0,0 +1,113 @@
+/*
+Copyright 2026 Chainguard, Inc.
+SPDX-License-Identifier: Apache-2.0
+*/
+
+package authz
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/google/go-cmp/cmp"
+)
+
+func TestParsePolicy(t *testing.T) {
+	want := &Policy{Rules: []Rule{{
+		Methods: []string{"/grpc.health.v1.Health/*"},
+		Public:  true,
+	}, {
+		Methods:   []string{"/pkg.Service/Get"},
+		Subjects:  []string{"user-*"},
+		ClientIDs: []string{"dashboard"},
+		SANs:      []string{"spiffe://example.com/admin"},
+	}}}
+
+	tests := []struct {
+		name string
+		in   string
+	}{{
+		name: "yaml",
+		in: `
+rules:
+- methods: ["/grpc.health.v1.Health/*"]
+  public: true
+- methods:
+  - /pkg.Service/Get
+  subjects: ["user-*"]
+  clientIDs: [dashboard]
+  sans: ["spiffe://example.com/admin"]
+`,
+	}, {
+		name: "json",
+		in: `{"rules": [
+  {"methods": ["/grpc.health.v1.Health/*"], "public": true},
+  {"methods": ["/pkg.Service/Get"], "subjects": ["user-*"], "clientIDs": ["dashboard"], "sans": ["spiffe://example.com/admin"]}
+]}`,
+	}}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := ParsePolicy([]byte(tt.in))
+			if err != nil {
+				t.Fatalf("ParsePolicy() = %v", err)
+			}
+			if diff := cmp.Diff(want, got); diff != "" {
+				t.Errorf("ParsePolicy() (-want +got):\n%s", diff)
+			}
+		})
+	}
+
+	t.Run("file", func(t *testing.T) {
+		path := filepath.Join(t.TempDir(), "policy.yaml")
+		if err := os.WriteFile(path, []byte(tests[0].in), 0o600); err != nil {
+			t.Fatal(err)
+		}
+		got, err := LoadPolicy(path)
+		if err != nil {
+			t.Fatalf("LoadPolicy() = %v", err)
+		}
+		if diff := cmp.Diff(want, got); diff != "" {
+			t.Errorf("LoadPolicy() (-want +got):\n%s", diff)
+		}
+	})
+}
+
+func TestParsePolicy_Errors(t *testing.T) {
+	tests := []struct {
+		name string
+		in   string
+	}{{
+		name: "malformed",
+		in:   "rules: [",
+	}, {
+		name: "unknown field",
+		in:   `{"rules": [{"methods": ["/pkg.Service/Get"], "subject": ["user-1"]}]}`,
+	}, {
+		name: "no rules",
+		in:   "rules: []",
+	}, {
+		name: "no methods",
+		in:   `{"rules": [{"subjects": ["user-1"]}]}`,
+	}, {
+		name: "bad method",
+		in:   `{"rules": [{"methods": ["pkg.Service/Get"], "subjects": ["user-1"]}]}`,
+	}, {
+		name: "no principals",
+		in:   `{"rules": [{"methods": ["/pkg.Service/Get"]}]}`,
+	}, {
+		name: "public with principals",
+		in:   `{"rules": [{"methods": ["/pkg.Service/Get"], "public": true, "subjects": ["user-1"]}]}`,
+	}}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if _, err := ParsePolicy([]byte(tt.in)); err == nil {
+				t.Error("ParsePolicy() = nil, want error")
+			}
+		})
+	}
+
+	if _, err := LoadPolicy(filepath.Join(t.TempDir(), "missing.yaml")); err == nil {
+		t.Error("LoadPolicy(missing) = nil, want error")
+	}
+}
@@ -0,0 +1,364 @@
+/*
+Copyright 2026 Chainguard, Inc.
+SPDX-License-Identifier: Apache-2.0
+*/
+
+package circuitbreaker
+
+import (
+	"errors"
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"google.golang.org/grpc/codes"
+)
+
+const (
+	defaultFailureRatio = 0.5
+	defaultMinRequests  = 20
+	defaultWindow       = 10 * time.Second
+	defaultOpenDuration = 5 * time.Second
+	defaultProbes       = 3
+	defaultWaitLimit    = 10 * time.Second
+
+	// windowBuckets is the number of buckets the rolling window is divided
+	// into, so that calls age out of it a bucket at a time.
+	windowBuckets = 10
+)
+
+// defaultFailureCodes are the codes that say a downstream is unhealthy, rather
+// than that a call was wrong.
+var defaultFailureCodes = []codes.Code{codes.Unavailable, codes.DeadlineExceeded, codes.Internal, codes.Unknown}
+
+// State is the state of a circuit.
+type State int
+
+const (
+	// Closed circuits let calls through, counting their failures.
+	Closed State = iota
+
+	// HalfOpen circuits let a few probe calls through, to find out whether
+	// the downstream has recovered.
+	HalfOpen
+
+	// Open circuits fail calls at once.
+	Open
+)
+
+func (s State) String() string {
+	switch s {
+	case Closed:
+		return "closed"
+	case HalfOpen:
+		return "half-open"
+	case Open:
+		return "open"
+	}
+	return fmt.Sprintf("State(%d)", int(s))
+}
+
+// Breaker keeps a circuit per target and method. A circuit opens when, over a
+// rolling window, enough calls have been made and the share that failed
+// reaches a threshold. While open, calls fail at once. After a while it
+// half-opens, letting a few probe calls through: if they all succeed it
+// closes, and if any fails it opens again.
+type Breaker struct {
+	now func() time.Time
+
+	failureRatio float64
+	minRequests  int
+	window       time.Duration
+	openDuration time.Duration
+	probes       int
+	failureCodes map[codes.Code]bool
+	waitLimit    time.Duration
+
+	registerer prometheus.Registerer
+	state      *prometheus.GaugeVec
+	rejected   *prometheus.CounterVec
+
+	mu       sync.Mutex
+	circuits map[circuitKey]*circuit
+}
+
+type circuitKey struct {
+	target, method string
+}
+
+// circuit is the state of calls to one method of one target.
+type circuit struct {
+	state    State
+	buckets  [windowBuckets]bucket
+	openedAt time.Time
+
+	// probing is the number of probes in flight while half-open, and
+	// succeeded the number that have succeeded.
+	probing   int
+	succeeded int
+}
+
+// bucket counts the calls that ended in a slice of the rolling window.
+type bucket struct {
+	start    time.Time
+	requests int
+	failures int
+}
+
+// Option configures a Breaker.
+type Option func(*Breaker)
+
+// WithThreshold opens a circuit once at least minRequests calls have ended in
+// the window and at least ratio of them failed. The defaults are 0.5 and 20.
+func WithThreshold(ratio float64, minRequests int) Option {
+	return func(b *Breaker) {
+		b.failureRatio, b.minRequests = ratio, minRequests
+	}
+}
+
+// WithWindow sets the rolling window calls are counted over. The default is
+// 10 seconds.
+func WithWindow(window time.Duration) Option {
+	return func(b *Breaker) {
+		b.window = window
+	}
+}
+
+// WithOpenDuration sets how long a circuit stays open before it half-opens.
+// The default is 5 seconds.
+func WithOpenDuration(d time.Duration) Option {
+	return func(b *Breaker) {
+		b.openDuration = d
+	}
+}
+
+// WithProbes sets how many probe calls a half-open circuit lets through at
+// once, all of which must succeed for it to close. The default is 3.
+func WithProbes(n int) Option {
+	return func(b *Breaker) {
+		b.probes = n
+	}
+}
+
+// WithFailureCodes sets the status codes that count as failures. The default
+// is codes.Unavailable, codes.DeadlineExceeded, codes.Internal and
+// codes.Unknown; other codes say the downstream answered. A call the caller
+// cancelled counts as neither a failure nor a success.
+func WithFailureCodes(cs ...codes.Code) Option {
+	return func(b *Breaker) {
+		b.failureCodes = map[codes.Code]bool{}
+		for _, c := range cs {
+			b.failureCodes[c] = true
+		}
+	}
+}
+
+// WithWaitLimit sets how long a call may wait for a connection to its target
+// before it counts as a failure. A call made WaitForReady to a target that
+// cannot be reached waits until its deadline, or forever without one, so
+// without this such calls would never end to open the circuit. A call still
+// in progress after the limit while its connection is not ready is counted
+// failed then, and how it ends is not counted. A stream that has neither
+// received a message nor ended after the limit counts for nothing. The default
+// is 10 seconds.
+func WithWaitLimit(d time.Duration) Option {
+	return func(b *Breaker) {
+		b.waitLimit = d
+	}
+}
+
+// WithRegisterer sets where the Breaker registers its metrics. The default is
+// prometheus.DefaultRegisterer, and nil registers them nowhere. Breakers
+// sharing a registerer share its metrics, as one breaker per client does by
+// default, so their circuits for the same target and method report together.
+func WithRegisterer(reg prometheus.Registerer) Option {
+	return func(b *Breaker) {
+		b.registerer = reg
+	}
+}
+
+// New returns a Breaker configured by opts. If another Breaker's metrics are
+// already registered with its registerer, it reports to those. It returns an
+// error if its metrics cannot be registered otherwise.
+func New(opts ...Option) (*Breaker, error) {
+	b := &Breaker{
+		now:          time.Now,
+		failureRatio: defaultFailureRatio,
+		minRequests:  defaultMinRequests,
+		window:       defaultWindow,
+		openDuration: defaultOpenDuration,
+		probes:       defaultProbes,
+		waitLimit:    defaultWaitLimit,
+		registerer:   prometheus.DefaultRegisterer,
+		circuits:     map[circuitKey]*circuit{},
+	}
+	WithFailureCodes(defaultFailureCodes...)(b)
+	for _, o := range opts {
+		o(b)
+	}
+	switch {
+	case b.failureRatio <= 0 || b.failureRatio > 1:
+		return nil, fmt.Errorf("failure ratio %v must be in (0, 1]", b.failureRatio)
+	case b.minRequests < 1:
+		return nil, fmt.Errorf("minimum requests %d must be positive", b.minRequests)
+	case b.window < windowBuckets*time.Millisecond:
+		return nil, fmt.Errorf("window %v must be at least %v", b.window, windowBuckets*time.Millisecond)
+	case b.openDuration <= 0:
+		return nil, fmt.Errorf("open duration %v must be positive", b.openDuration)
+	case b.probes < 1:
+		return nil, fmt.Errorf("probes %d must be positive", b.probes)
+	case b.waitLimit <= 0:
+		return nil, fmt.Errorf("wait limit %v must be positive", b.waitLimit)
+	case len(b.failureCodes) == 0:
+		return nil, errors.New("no failure codes")
+	case b.failureCodes[codes.OK]:
+		return nil, errors.New("OK cannot be a failure code")
+	}
+
+	b.state = prometheus.NewGaugeVec(prometheus.GaugeOpts{
+		Name: "grpc_client_circuit_breaker_state",
+		Help: "State of the circuit breaker for calls to a method of a target: 0 closed, 1 half-open, 2 open.",
+	}, []string{"target", "method"})
+	b.rejected = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Name: "grpc_client_circuit_breaker_rejected_total",
+		Help: "Number of calls failed without being made because their circuit was open, by target and method.",
+	}, []string{"target", "method"})
+	if b.registerer != nil {
+		var err error
+		if b.state, err = register(b.registerer, b.state); err != nil {
+			return nil, fmt.Errorf("registering circuit breaker metrics: %w", err)
+		}
+		if b.rejected, err = register(b.registerer, b.rejected); err != nil {
+			return nil, fmt.Errorf("registering circuit breaker metrics: %w", err)
+		}
+	}
+	return b, nil
+}
+
+// register registers collector with reg, returning it, or the collector of the
+// same kind already registered in its place.
+func register[C prometheus.Collector](reg prometheus.Registerer, collector C) (C, error) {
+	err := reg.Register(collector)
+	if err == nil {
+		return collector, nil
+	}
+	var are prometheus.AlreadyRegisteredError
+	if errors.As(err, &are) {
+		if existing, ok := are.ExistingCollector.(C); ok {
+			return existing, nil
+		}
+	}
+	return collector, err
+}
+
+// State returns the state of the circuit for method of target.
+func (b *Breaker) State(target, method string) State {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if c, ok := b.circuits[circuitKey{target, method}]; ok {
+		return c.state
+	}
+	return Closed
+}
+
+// acquire lets a call to method of target through, returning the function to
+// call with its status code when it ends, or reports that the circuit is open.
+func (b *Breaker) acquire(target, method string) (done func(codes.Code), ok bool) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	key := circuitKey{target, method}
+	c, found := b.circuits[key]
+	if !found {
+		c = &circuit{}
+		b.circuits[key] = c
+		b.state.WithLabelValues(target, method).Set(float64(Closed))
+	}
+
+	now := b.now()
+	if c.state == Open {
+		if now.Sub(c.openedAt) < b.openDuration {
+			return nil, false
+		}
+		b.transition(key, c, HalfOpen, now)
+	}
+
+	probe := c.state == HalfOpen
+	if probe {
+		if c.probing >= b.probes {
+			return nil, false
+		}
+		c.probing++
+	}
+
+	var once sync.Once
+	return func(code codes.Code) {
+		once.Do(func() { b.done(key, c, probe, code) })
+	}, true
+}
+
+// done records the end, with code, of a call through c, a probe if probe is
+// set.
+func (b *Breaker) done(key circuitKey, c *circuit, probe bool, code codes.Code) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	now := b.now()
+	failed := b.failureCodes[code]
+
+	if probe {
+		c.probing--
+		// A probe that ends after another has reopened the circuit is moot.
+		if c.state != HalfOpen || code == codes.Canceled {
+			return
+		}
+		if failed {
+			b.transition(key, c, Open, now)
+			return
+		}
+		if c.succeeded++; c.succeeded >= b.probes {
+			b.transition(key, c, Closed, now)
+		}
+		return
+	}
+
+	if c.state != Closed || code == codes.Canceled {
+		return
+	}
+	width := b.window / windowBuckets
+	start := now.Truncate(width)
+	bk := &c.buckets[(start.UnixNano()/int64(width))%windowBuckets]
+	if !bk.start.Equal(start) {
+		*bk = bucket{start: start}
+	}
+	bk.requests++
+	if failed {
+		bk.failures++
+	}
+
+	var requests, failures int
+	for _, bk := range c.buckets {
+		if now.Sub(bk.start) < b.window {
+			requests += bk.requests
+			failures += bk.failures
+		}
+	}
+	if requests >= b.minRequests && float64(failures) >= b.failureRatio*float64(requests) {
+		b.transition(key, c, Open, now)
+	}
+}
+
+// transition moves c to state at now.
+func (b *Breaker) transition(key circuitKey, c *circuit, state State, now time.Time) {
+	c.state = state
+	switch state {
+	case Open:
+		c.openedAt = now
+	case HalfOpen:
+		c.succeeded = 0
+	case Closed:
+		c.buckets = [windowBuckets]bucket{}
+	}
+	b.state.WithLabelValues(key.target, key.method).Set(float64(state))
+}
@@ -0,0 +1,225 @@
+/*
+Copyright 2026 Chainguard, Inc.
+SPDX-License-Identifier: Apache-2.0
+*/
+
+package circuitbreaker
+
+import (
+	"testing"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"google.golang.org/grpc/codes"
+)
+
+// newTestBreaker returns a Breaker configured by opts whose clock is *now.
+func newTestBreaker(t *testing.T, now *time.Time, opts ...Option) *Breaker {
+	t.Helper()
+	b, err := New(append([]Option{WithRegisterer(prometheus.NewRegistry())}, opts...)...)
+	if err != nil {
+		t.Fatalf("New() = %v", err)
+	}
+	b.now = func() time.Time { return *now }
+	return b
+}
+
+// call makes a call through b that ends with code, reporting whether it was
+// let through.
+func call(b *Breaker, code codes.Code) bool {
+	done, ok := b.acquire("target", "/pkg.Service/Get")
+	if ok {
+		done(code)
+	}
+	return ok
+}
+
+func TestBreaker_Opens(t *testing.T) {
+	now := time.Unix(1_700_000_000, 0)
+	b := newTestBreaker(t, &now, WithThreshold(0.5, 4))
+
+	// Errors that say the downstream answered are not failures.
+	for range 4 {
+		call(b, codes.NotFound)
+	}
+	for range 3 {
+		call(b, codes.Unavailable)
+	}
+	if got := b.State("target", "/pkg.Service/Get"); got != Closed {
+		t.Fatalf("state at 3 of 7 failed = %v, want %v", got, Closed)
+	}
+
+	call(b, codes.DeadlineExceeded)
+	if got := b.State("target", "/pkg.Service/Get"); got != Open {
+		t.Fatalf("state at 4 of 8 failed = %v, want %v", got, Open)
+	}
+	if call(b, codes.OK) {
+		t.Error("call through an open circuit let through")
+	}
+
+	// Other methods have circuits of their own, and too few calls to one to
+	// judge it, however many fail.
+	for range 3 {
+		done, ok := b.acquire("target", "/pkg.Service/List")
+		if !ok {
+			t.Fatal("call to another method refused")
+		}
+		done(codes.Unavailable)
+	}
+	if got := b.State("target", "/pkg.Service/List"); got != Closed {
+		t.Errorf("state after 3 failures = %v, want %v", got, Closed)
+	}
+}
+
+func TestBreaker_Window(t *testing.T) {
+	now := time.Unix(1_700_000_000, 0)
+	b := newTestBreaker(t, &now, WithThreshold(0.5, 4), WithWindow(10*time.Second))
+
+	for range 3 {
+		call(b, codes.Unavailable)
+	}
+	// The failures age out of the window.
+	now = now.Add(11 * time.Second)
+	call(b, codes.Unavailable)
+	call(b, codes.OK)
+	call(b, codes.OK)
+	call(b, codes.OK)
+	if got := b.State("target", "/pkg.Service/Get"); got != Closed {
+		t.Errorf("state = %v, want %v", got, Closed)
+	}
+}
+
+func TestBreaker_HalfOpen(t *testing.T) {
+	open := func(t *testing.T, now *time.Time) *Breaker {
+		b := newTestBreaker(t, now, WithThreshold(1, 1), WithOpenDuration(5*time.Second), WithProbes(2))
+		call(b, codes.Unavailable)
+		*now = now.Add(5 * time.Second)
+		return b
+	}
+
+	t.Run("probes succeed", func(t *testing.T) {
+		now := time.Unix(1_700_000_000, 0)
+		b := open(t, &now)
+
+		first, ok1 := b.acquire("target", "/pkg.Service/Get")
+		second, ok2 := b.acquire("target", "/pkg.Service/Get")
+		if !ok1 || !ok2 {
+			t.Fatal("probe refused")
+		}
+		if got := b.State("target", "/pkg.Service/Get"); got != HalfOpen {
+			t.Errorf("state = %v, want %v", got, HalfOpen)
+		}
+		if call(b, codes.OK) {
+			t.Error("call beyond the probes let through")
+		}
+		first(codes.OK)
+		second(codes.OK)
+		if got := b.State("target", "/pkg.Service/Get"); got != Closed {
+			t.Errorf("state = %v, want %v", got, Closed)
+		}
+	})
+
+	t.Run("probe fails", func(t *testing.T) {
+		now := time.Unix(1_700_000_000, 0)
+		b := open(t, &now)
+
+		first, _ := b.acquire("target", "/pkg.Service/Get")
+		second, _ := b.acquire("target", "/pkg.Service/Get")
+		first(codes.Unavailable)
+		second(codes.OK)
+		if got := b.State("target", "/pkg.Service/Get"); got != Open {
+			t.Errorf("state = %v, want %v", got, Open)
+		}
+		if call(b, codes.OK) {
+			t.Error("call through a reopened circuit let through")
+		}
+	})
+
+	t.Run("cancelled probe", func(t *testing.T) {
+		now := time.Unix(1_700_000_000, 0)
+		b := open(t, &now)
+
+		first, _ := b.acquire("target", "/pkg.Service/Get")
+		first(codes.Canceled)
+		first(codes.Unavailable)
+		if got := b.State("target", "/pkg.Service/Get"); got != HalfOpen {
+			t.Errorf("state = %v, want %v", got, HalfOpen)
+		}
+		if !call(b, codes.OK) || !call(b, codes.OK) {
+			t.Error("probe refused after a cancelled probe ended")
+		}
+		if got := b.State("target", "/pkg.Service/Get"); got != Closed {
+			t.Errorf("state = %v, want %v", got, Closed)
+		}
+	})
+}
+
+func TestNew_Errors(t *testing.T) {
+	tests := []struct {
+		name string
+		opts []Option
+	}{{
+		name: "zero ratio",
+		opts: []Option{WithThreshold(0, 10)},
+	}, {
+		name: "ratio above 1",
+		opts: []Option{WithThreshold(1.5, 10)},
+	}, {
+		name: "zero minimum requests",
+		opts: []Option{WithThreshold(0.5, 0)},
+	}, {
+		name: "tiny window",
+		opts: []Option{WithWindow(time.Millisecond)},
+	}, {
+		name: "zero open duration",
+		opts: []Option{WithOpenDuration(0)},
+	}, {
+		name: "zero probes",
+		opts: []Option{WithProbes(0)},
+	}, {
+		name: "zero wait limit",
+		opts: []Option{WithWaitLimit(0)},
+	}, {
+		name: "no failure codes",
+		opts: []Option{WithFailureCodes()},
+	}, {
+		name: "OK failure code",
+		opts: []Option{WithFailureCodes(codes.OK)},
+	}}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if _, err := New(tt.opts...); err == nil {
+				t.Error("New() = nil, want error")
+			}
+		})
+	}
+}
+
+func TestNew_Registerer(t *testing.T) {
+	reg := prometheus.NewRegistry()
+	first, err := New(WithRegisterer(reg))
+	if err != nil {
+		t.Fatalf("New() = %v", err)
+	}
+	// Another Breaker on the same registerer reports to the same metrics.
+	second, err := New(WithRegisterer(reg))
+	if err != nil {
+		t.Fatalf("New() with a registerer already used = %v", err)
+	}
+	if first.state != second.state || first.rejected != second.rejected {
+		t.Error("Breakers sharing a registerer have metrics of their own")
+	}
+	if _, err := New(WithRegisterer(nil)); err != nil {
+		t.Errorf("New() without a registerer = %v", err)
+	}
+
+	// A collector of another kind under the same name is an error.
+	clash := prometheus.NewRegistry()
+	clash.MustRegister(prometheus.NewCounterVec(prometheus.CounterOpts{
+		Name: "grpc_client_circuit_breaker_state",
+		Help: "State of the circuit breaker for calls to a method of a target: 0 closed, 1 half-open, 2 open.",
+	}, []string{"target", "method"}))
+	if _, err := New(WithRegisterer(clash)); err == nil {
+		t.Error("New() with a clashing metric registered = nil, want error")
+	}
+}
@@ -0,0 +1,130 @@
+/*
+Copyright 2026 Chainguard, Inc.
+SPDX-License-Identifier: Apache-2.0
+*/
+
+// Package circuitbreaker provides gRPC client interceptors that fail calls
+// fast while a downstream is down. Calls to each method of each target pass
+// through a circuit that opens when too many of them fail, after which calls
+// fail at once with codes.Unavailable, rather than wait, as calls made
+// WaitForReady do, for a downstream that is not coming back soon. Such calls
+// count as failures once they have waited WithWaitLimit for a connection.
+// After a while the circuit half-opens to probe whether the downstream has
+// recovered. Each Breaker exports the state of its circuits in the
+// grpc_client_circuit_breaker_state metric, and counts calls failed while open
+// in grpc_client_circuit_breaker_rejected_total.
+//
+// Set an options.Config's CircuitBreaker to install the interceptors in its
+// dial options, ahead of retries, so a call failed by an open circuit is not
+// retried.
+package circuitbreaker
+
+import (
+	"context"
+	"errors"
+	"io"
+	"time"
+
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/connectivity"
+	"google.golang.org/grpc/status"
+
+	"github.com/chainguard-dev/clog"
+)
+
+// admit lets a call to method on cc through b, returning the function to call
+// with its error when it ends, or the status to fail it with. Until then, or
+// until the call stops waiting for a connection, wait must be called.
+func (b *Breaker) admit(ctx context.Context, cc *grpc.ClientConn, method string) (done func(error), wait func(), err error) {
+	target := ""
+	if cc != nil {
+		target = cc.Target()
+	}
+	ended, ok := b.acquire(target, method)
+	if !ok {
+		b.rejected.WithLabelValues(target, method).Inc()
+		clog.FromContext(ctx).Debug("circuit open", "target", target, "method", method)
+		return nil, nil, status.Errorf(codes.Unavailable, "circuit breaker open for %s on %s", method, target)
+	}
+
+	// A call waiting for a connection that is not ready fails once it has
+	// waited too long, rather than when it ends, which it may never do.
+	waited := func() {}
+	if cc != nil {
+		timer := time.AfterFunc(b.waitLimit, func() {
+			if cc.GetState() != connectivity.Ready {
+				ended(codes.Unavailable)
+			}
+		})
+		waited = func() { timer.Stop() }
+	}
+	// A call abandoned by its caller counts for nothing, and frees its probe.
+	stop := context.AfterFunc(ctx, func() { ended(codes.Canceled) })
+
+	return func(err error) {
+		waited()
+		stop()
+		ended(status.Code(err))
+	}, waited, nil
+}
+
+// UnaryClientInterceptor fails calls fast while b's circuit for them is open.
+func UnaryClientInterceptor(b *Breaker) grpc.UnaryClientInterceptor {
+	return func(ctx context.Context, method string, req, reply any, cc *grpc.ClientConn, invoker grpc.UnaryInvoker, opts ...grpc.CallOption) error {
+		done, _, err := b.admit(ctx, cc, method)
+		if err != nil {
+			return err
+		}
+		err = invoker(ctx, method, req, reply, cc, opts...)
+		done(err)
+		return err
+	}
+}
+
+// StreamClientInterceptor fails streams fast while b's circuit for them is
+// open. A stream counts as a success once it receives a message or ends
+// cleanly, and as a failure if receiving ends with a failure code first. A
+// stream with neither outcome after the wait limit, such as one never received
+// from, counts for nothing, so it does not hold a half-open circuit's probe.
+func StreamClientInterceptor(b *Breaker) grpc.StreamClientInterceptor {
+	return func(ctx context.Context, desc *grpc.StreamDesc, cc *grpc.ClientConn, method string, streamer grpc.Streamer, opts ...grpc.CallOption) (grpc.ClientStream, error) {
+		done, wait, err := b.admit(ctx, cc, method)
+		if err != nil {
+			return nil, err
+		}
+		cs, err := streamer(ctx, desc, cc, method, opts...)
+		if err != nil {
+			done(err)
+			return nil, err
+		}
+		// The stream has a connection, and its outcome comes from the server,
+		// if the caller receives it in time.
+		wait()
+		unanswered := time.AfterFunc(b.waitLimit, func() {
+			done(status.Error(codes.Canceled, "stream not received from"))
+		})
+		return &clientStream{ClientStream: cs, done: func(err error) {
+			unanswered.Stop()
+			done(err)
+		}}, nil
+	}
+}
+
+// clientStream reports the outcome of its first receive to its circuit: gRPC
+// returns a stream before the server has answered, so a failure only shows
+// there.
+type clientStream struct {
+	grpc.ClientStream
+	done func(error)
+}
+
+func (s *clientStream) RecvMsg(m any) error {
+	err := s.ClientStream.RecvMsg(m)
+	if errors.Is(err, io.EOF) {
+		s.done(nil)
+	} else {
+		s.done(err)
+	}
+	return err
+}
@@ -0,0 +1,196 @@
+/*
+Copyright 2026 Chainguard, Inc.
+SPDX-License-Identifier: Apache-2.0
+*/
+
+package circuitbreaker
+
+import (
+	"context"
+	"net"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/credentials/insecure"
+	"google.golang.org/grpc/status"
+	"google.golang.org/protobuf/types/known/emptypb"
+)
+
+func TestInterceptors(t *testing.T) {
+	now := time.Unix(1_700_000_000, 0)
+	b := newTestBreaker(t, &now, WithThreshold(1, 2))
+	ctx := t.Context()
+	const method = "/pkg.Service/Get"
+
+	state := b.state.WithLabelValues("", method)
+	rejected := b.rejected.WithLabelValues("", method)
+
+	calls := 0
+	unary := func() error {
+		return UnaryClientInterceptor(b)(ctx, method, nil, nil, nil,
+			func(context.Context, string, any, any, *grpc.ClientConn, ...grpc.CallOption) error {
+				calls++
+				return status.Error(codes.Unavailable, "down")
+			})
+	}
+	stream := func() error {
+		_, err := StreamClientInterceptor(b)(ctx, &grpc.StreamDesc{}, nil, method,
+			func(context.Context, *grpc.StreamDesc, *grpc.ClientConn, string, ...grpc.CallOption) (grpc.ClientStream, error) {
+				calls++
+				return nil, status.Error(codes.Unavailable, "down")
+			})
+		return err
+	}
+
+	_ = unary()
+	_ = stream()
+	if got := testutil.ToFloat64(state); got != float64(Open) {
+		t.Errorf("state gauge = %v, want %v", got, float64(Open))
+	}
+
+	for _, fn := range []func() error{unary, stream} {
+		if err := fn(); status.Code(err) != codes.Unavailable {
+			t.Errorf("call through an open circuit = %v, want %v", err, codes.Unavailable)
+		}
+	}
+	if calls != 2 {
+		t.Errorf("calls made = %d, want 2", calls)
+	}
+	if got := testutil.ToFloat64(rejected); got != 2 {
+		t.Errorf("rejected = %v, want 2", got)
+	}
+}
+
+// failingStream is a stream the server fails with code.
+type failingStream struct {
+	grpc.ClientStream
+	code codes.Code
+}
+
+func (s *failingStream) RecvMsg(any) error { return status.Error(s.code, "failed") }
+
+func TestStreamClientInterceptor_FailsOnReceive(t *testing.T) {
+	now := time.Unix(1_700_000_000, 0)
+	b := newTestBreaker(t, &now, WithThreshold(1, 2))
+	const method = "/pkg.Service/Watch"
+
+	// gRPC returns streams before the server answers, so their failures show
+	// only when receiving.
+	for range 2 {
+		cs, err := StreamClientInterceptor(b)(t.Context(), &grpc.StreamDesc{ServerStreams: true}, nil, method,
+			func(context.Context, *grpc.StreamDesc, *grpc.ClientConn, string, ...grpc.CallOption) (grpc.ClientStream, error) {
+				return &failingStream{code: codes.Unavailable}, nil
+			})
+		if err != nil {
+			t.Fatalf("interceptor() = %v", err)
+		}
+		if err := cs.RecvMsg(nil); status.Code(err) != codes.Unavailable {
+			t.Fatalf("RecvMsg() = %v, want %v", err, codes.Unavailable)
+		}
+	}
+	if got := b.State("", method); got != Open {
+		t.Errorf("state = %v, want %v", got, Open)
+	}
+}
+
+// blockingStream is a stream whose server never answers.
+type blockingStream struct {
+	grpc.ClientStream
+}
+
+func TestStreamClientInterceptor_NeverReceived(t *testing.T) {
+	now := time.Unix(1_700_000_000, 0)
+	b := newTestBreaker(t, &now, WithThreshold(1, 2), WithProbes(1), WithWaitLimit(50*time.Millisecond))
+	const method = "/pkg.Service/Watch"
+	stream := func() (grpc.ClientStream, error) {
+		return StreamClientInterceptor(b)(t.Context(), &grpc.StreamDesc{ServerStreams: true}, nil, method,
+			func(context.Context, *grpc.StreamDesc, *grpc.ClientConn, string, ...grpc.CallOption) (grpc.ClientStream, error) {
+				return &blockingStream{}, nil
+			})
+	}
+
+	call(b, codes.Unavailable)
+	for range 2 {
+		if done, ok := b.acquire("", method); ok {
+			done(codes.Unavailable)
+		}
+	}
+	now = now.Add(defaultOpenDuration)
+
+	// The probe stream is opened, with a context that does not end, and never
+	// received from.
+	if _, err := stream(); err != nil {
+		t.Fatalf("probe stream = %v", err)
+	}
+	if _, err := stream(); status.Code(err) != codes.Unavailable {
+		t.Fatalf("stream while probing = %v, want %v", err, codes.Unavailable)
+	}
+
+	// After the wait limit, its probe is freed.
+	deadline := time.Now().Add(5 * time.Second)
+	for {
+		_, err := stream()
+		if err == nil {
+			break
+		}
+		if time.Now().After(deadline) {
+			t.Fatalf("stream after the wait limit = %v, want the probe freed", err)
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	if got := b.State("", method); got != HalfOpen {
+		t.Errorf("state = %v, want %v", got, HalfOpen)
+	}
+}
+
+// TestUnaryClientInterceptor_WaitForReady verifies that calls made WaitForReady
+// to a target that cannot be reached open the circuit, though they never end.
+func TestUnaryClientInterceptor_WaitForReady(t *testing.T) {
+	b, err := New(WithRegisterer(prometheus.NewRegistry()), WithThreshold(1, 3), WithWaitLimit(50*time.Millisecond))
+	if err != nil {
+		t.Fatalf("New() = %v", err)
+	}
+
+	// Nothing listens on the port once the listener is closed.
+	lis, err := net.Listen("tcp", "localhost:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	lis.Close()
+	conn, err := grpc.NewClient(lis.Addr().String(),
+		grpc.WithTransportCredentials(insecure.NewCredentials()),
+		grpc.WithChainUnaryInterceptor(UnaryClientInterceptor(b)),
+	)
+	if err != nil {
+		t.Fatalf("NewClient() = %v", err)
+	}
+	defer conn.Close()
+
+	// The calls have no deadline, and wait until the test ends.
+	ctx, cancel := context.WithCancel(t.Context())
+	var wg sync.WaitGroup
+	for range 3 {
+		wg.Go(func() {
+			_ = conn.Invoke(ctx, "/pkg.Service/Get", &emptypb.Empty{}, &emptypb.Empty{}, grpc.WaitForReady(true))
+		})
+	}
+	defer wg.Wait()
+	defer cancel()
+
+	deadline := time.Now().Add(5 * time.Second)
+	for b.State(conn.Target(), "/pkg.Service/Get") != Open {
+		if time.Now().After(deadline) {
+			t.Fatal("circuit did not open")
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	err = conn.Invoke(ctx, "/pkg.Service/Get", &emptypb.Empty{}, &emptypb.Empty{}, grpc.WaitForReady(true))
+	if status.Code(err) != codes.Unavailable {
+		t.Errorf("call through an open circuit = %v, want %v", err, codes.Unavailable)
+	}
+}
@@ -18,6 +18,10 @@ import (
 const CGClientID = "cgclientid"
 const CGRequestID = "cgrequestid"
 
+// CGParentRequestID carries the request ID of the call that caused an outgoing
+// RPC, when the client interceptors are configured WithParentRequestID.
+const CGParentRequestID = "cgparentrequestid"
+
 var cachedClientID = sync.OnceValue(func() string {
 	// Prefer K_SERVICE (Cloud Run service name) for descriptive labels.
 	if svc := os.Getenv("K_SERVICE"); svc != "" {
@@ -34,26 +38,74 @@ var cachedClientID = sync.OnceValue(func() string {
 	return e
 })
 
-func appendClientID(ctx context.Context) context.Context {
+// Option configures the client interceptors.
+type Option func(*clientConfig)
+
+type clientConfig struct {
+	parentRequestID bool
+}
+
+// WithParentRequestID makes every outgoing call carry a fresh cgrequestid of
+// its own, with the request ID of the call being served sent alongside it as
+// cgparentrequestid. This records each hop separately, so the chain of calls
+// can be rebuilt from logs, at the cost of a single ID shared by every hop.
+func WithParentRequestID() Option {
+	return func(c *clientConfig) {
+		c.parentRequestID = true
+	}
+}
+
+func newClientConfig(opts []Option) clientConfig {
+	var cfg clientConfig
+	for _, o := range opts {
+		o(&cfg)
+	}
+	return cfg
+}
+
+func appendClientID(ctx context.Context, cfg clientConfig) context.Context {
 	// Always set this service's identity on outgoing calls so the
 	// downstream server knows its immediate caller.
-	return metadata.AppendToOutgoingContext(ctx,
-		CGClientID, cachedClientID(),
-		CGRequestID, uuid.New().String(),
-	)
+	kv := []string{CGClientID, cachedClientID()}
+
+	// A request ID already on the outgoing metadata, such as one forwarded
+	// from an HTTP header by the gateway, is left alone.
+	if md, _ := metadata.FromOutgoingContext(ctx); len(md.Get(CGRequestID)) == 0 {
+		// Reuse the request ID of the call being served, so a request keeps
+		// one ID as it fans out across services, and mint one only at the edge.
+		inbound := RequestIDFromContext(ctx)
+		switch {
+		case inbound == "":
+			kv = append(kv, CGRequestID, uuid.New().String())
+		case cfg.parentRequestID:
+			kv = append(kv, CGRequestID, uuid.New().String(), CGParentRequestID, inbound)
+		default:
+			kv = append(kv, CGRequestID, inbound)
+		}
+	}
+
+	return metadata.AppendToOutgoingContext(ctx, kv...)
 }
 
-func UnaryClientInterceptor() grpc.UnaryClientInterceptor {
+// UnaryClientInterceptor returns a gRPC unary client interceptor that adds
+// cgclientid and cgrequestid to outgoing metadata. The request ID of the call
+// being served, if any, is propagated; otherwise a new one is generated.
+func UnaryClientInterceptor(opts ...Option) grpc.UnaryClientInterceptor {
+	cfg := newClientConfig(opts)
 	return func(ctx context.Context, method string, req, reply any, cc *grpc.ClientConn, invoker grpc.UnaryInvoker, opts ...grpc.CallOption) error {
-		nc := appendClientID(ctx)
+		nc := appendClientID(ctx, cfg)
 		// Make the call
 		return invoker(nc, method, req, reply, cc, opts...)
 	}
 }
 
-func StreamClientInterceptor() grpc.StreamClientInterceptor {
+// StreamClientInterceptor returns a gRPC stream client interceptor that adds
+// cgclientid and cgrequestid to outgoing metadata. The request ID of the call
+// being served, if any, is propagated; otherwise a new one is generated.
+func StreamClientInterceptor(opts ...Option) grpc.StreamClientInterceptor {
+	cfg := newClientConfig(opts)
 	return func(ctx context.Context, desc *grpc.StreamDesc, cc *grpc.ClientConn, method string, streamer grpc.Streamer, opts ...grpc.CallOption) (grpc.ClientStream, error) {
-		nc := appendClientID(ctx)
+		nc := appendClientID(ctx, cfg)
 		// Make the call
 		return streamer(nc, desc, cc, method, opts...)
 	}
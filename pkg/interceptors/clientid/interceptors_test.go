@@ -14,7 +14,7 @@ import (
 
 func TestAppendClientID_AlwaysSetsOutgoing(t *testing.T) {
 	ctx := context.Background()
-	got := appendClientID(ctx)
+	got := appendClientID(ctx, clientConfig{})
 
 	md, ok := metadata.FromOutgoingContext(got)
 	if !ok {
@@ -35,7 +35,7 @@ func TestAppendClientID_OverridesIncoming(t *testing.T) {
 	incoming := metadata.Pairs(CGClientID, "previous-caller", CGRequestID, "prev-req-id")
 	ctx := metadata.NewIncomingContext(context.Background(), incoming)
 
-	got := appendClientID(ctx)
+	got := appendClientID(ctx, clientConfig{})
 
 	md, ok := metadata.FromOutgoingContext(got)
 	if !ok {
@@ -53,8 +53,8 @@ func TestAppendClientID_OverridesIncoming(t *testing.T) {
 
 func TestAppendClientID_UniqueRequestIDs(t *testing.T) {
 	ctx := context.Background()
-	ctx1 := appendClientID(ctx)
-	ctx2 := appendClientID(ctx)
+	ctx1 := appendClientID(ctx, clientConfig{})
+	ctx2 := appendClientID(ctx, clientConfig{})
 
 	md1, _ := metadata.FromOutgoingContext(ctx1)
 	md2, _ := metadata.FromOutgoingContext(ctx2)
@@ -81,3 +81,58 @@ func TestCachedClientID_KServiceEnv(t *testing.T) {
 		}
 	})
 }
+
+func TestAppendClientID_PropagatesIncomingRequestID(t *testing.T) {
+	incoming := metadata.Pairs(CGClientID, "previous-caller", CGRequestID, "prev-req-id")
+	ctx := metadata.NewIncomingContext(context.Background(), incoming)
+
+	md, _ := metadata.FromOutgoingContext(appendClientID(ctx, clientConfig{}))
+	if got := md.Get(CGRequestID); len(got) != 1 || got[0] != "prev-req-id" {
+		t.Errorf("outgoing %s = %v, want [prev-req-id]", CGRequestID, got)
+	}
+	if got := md.Get(CGParentRequestID); len(got) != 0 {
+		t.Errorf("outgoing %s = %v, want none", CGParentRequestID, got)
+	}
+}
+
+func TestAppendClientID_PropagatesServerContextRequestID(t *testing.T) {
+	// The server interceptor assigns a request ID when the caller sent none;
+	// that ID, not a fresh one, should be propagated downstream.
+	var served context.Context
+	_, _ = UnaryServerInterceptor()(context.Background(), nil, nil, func(ctx context.Context, _ any) (any, error) {
+		served = ctx
+		return nil, nil
+	})
+
+	md, _ := metadata.FromOutgoingContext(appendClientID(served, clientConfig{}))
+	if got, want := md.Get(CGRequestID), RequestIDFromContext(served); len(got) != 1 || got[0] != want {
+		t.Errorf("outgoing %s = %v, want [%s]", CGRequestID, got, want)
+	}
+}
+
+func TestAppendClientID_ParentRequestID(t *testing.T) {
+	incoming := metadata.Pairs(CGRequestID, "prev-req-id")
+	ctx := metadata.NewIncomingContext(context.Background(), incoming)
+
+	cfg := newClientConfig([]Option{WithParentRequestID()})
+	md, _ := metadata.FromOutgoingContext(appendClientID(ctx, cfg))
+
+	rid := md.Get(CGRequestID)
+	if len(rid) != 1 || rid[0] == "prev-req-id" {
+		t.Errorf("outgoing %s = %v, want a fresh ID", CGRequestID, rid)
+	}
+	if got := md.Get(CGParentRequestID); len(got) != 1 || got[0] != "prev-req-id" {
+		t.Errorf("outgoing %s = %v, want [prev-req-id]", CGParentRequestID, got)
+	}
+}
+
+func TestAppendClientID_KeepsOutgoingRequestID(t *testing.T) {
+	// The gateway forwards an HTTP cgrequestid header as outgoing metadata;
+	// the loopback interceptor must not add a second one.
+	ctx := metadata.NewOutgoingContext(context.Background(), metadata.Pairs(CGRequestID, "rest-req-id"))
+
+	md, _ := metadata.FromOutgoingContext(appendClientID(ctx, clientConfig{}))
+	if got := md.Get(CGRequestID); len(got) != 1 || got[0] != "rest-req-id" {
+		t.Errorf("outgoing %s = %v, want [rest-req-id]", CGRequestID, got)
+	}
+}
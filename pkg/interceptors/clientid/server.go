@@ -0,0 +1,115 @@
+/*
+Copyright 2026 Chainguard, Inc.
+SPDX-License-Identifier: Apache-2.0
+*/
+
+package clientid
+
+import (
+	"context"
+
+	"github.com/google/uuid"
+	middleware "github.com/grpc-ecosystem/go-grpc-middleware/v2"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/metadata"
+
+	"github.com/chainguard-dev/clog"
+)
+
+type identityKey struct{}
+
+// identity is the caller identity parsed from incoming metadata by the server
+// interceptors.
+type identity struct {
+	clientID  string
+	requestID string
+}
+
+// FromContext returns the cgclientid of the caller that issued the request
+// being served. It reads the value parsed by the server interceptors, falling
+// back to the incoming metadata when they are not installed, and returns the
+// empty string when the caller sent none.
+func FromContext(ctx context.Context) string {
+	if id, ok := ctx.Value(identityKey{}).(identity); ok {
+		return id.clientID
+	}
+	return firstIncoming(ctx, CGClientID)
+}
+
+// RequestIDFromContext returns the cgrequestid of the request being served.
+// When the server interceptors are installed this is never empty: a request
+// that arrived without one is assigned a fresh ID. Without them it falls back
+// to the incoming metadata.
+func RequestIDFromContext(ctx context.Context) string {
+	if id, ok := ctx.Value(identityKey{}).(identity); ok {
+		return id.requestID
+	}
+	return firstIncoming(ctx, CGRequestID)
+}
+
+func firstIncoming(ctx context.Context, key string) string {
+	if vals := metadata.ValueFromIncomingContext(ctx, key); len(vals) > 0 {
+		return vals[0]
+	}
+	return ""
+}
+
+// withIdentity parses the caller identity from the incoming metadata, assigns
+// a request ID when the caller did not send one, and records both on the
+// context and its clog logger, along with any cgparentrequestid. A context that
+// already carries an identity is returned as is, with fresh set to false, so
+// chaining the interceptors more than once is harmless.
+func withIdentity(ctx context.Context) (_ context.Context, _ identity, fresh bool) {
+	if id, ok := ctx.Value(identityKey{}).(identity); ok {
+		return ctx, id, false
+	}
+
+	id := identity{
+		clientID:  firstIncoming(ctx, CGClientID),
+		requestID: firstIncoming(ctx, CGRequestID),
+	}
+	if id.requestID == "" {
+		id.requestID = uuid.New().String()
+	}
+
+	ctx = context.WithValue(ctx, identityKey{}, id)
+	logger := clog.FromContext(ctx).With(CGClientID, id.clientID, CGRequestID, id.requestID)
+	if parent := firstIncoming(ctx, CGParentRequestID); parent != "" {
+		logger = logger.With(CGParentRequestID, parent)
+	}
+	return clog.WithLogger(ctx, logger), id, true
+}
+
+// UnaryServerInterceptor returns a gRPC unary server interceptor that extracts
+// the caller's cgclientid and cgrequestid into the context (see FromContext and
+// RequestIDFromContext), attaches them to the clog logger, and echoes the
+// cgrequestid back to the caller as a response header.
+func UnaryServerInterceptor() grpc.UnaryServerInterceptor {
+	return func(ctx context.Context, req any, _ *grpc.UnaryServerInfo, handler grpc.UnaryHandler) (any, error) {
+		nc, id, fresh := withIdentity(ctx)
+		if fresh {
+			// Best effort: this only fails if headers were already sent.
+			_ = grpc.SetHeader(nc, metadata.Pairs(CGRequestID, id.requestID))
+		}
+		return handler(nc, req)
+	}
+}
+
+// StreamServerInterceptor returns a gRPC stream server interceptor that
+// extracts the caller's cgclientid and cgrequestid into the stream context
+// (see FromContext and RequestIDFromContext), attaches them to the clog logger,
+// and echoes the cgrequestid back to the caller as a response header.
+func StreamServerInterceptor() grpc.StreamServerInterceptor {
+	return func(srv any, ss grpc.ServerStream, _ *grpc.StreamServerInfo, handler grpc.StreamHandler) error {
+		nc, id, fresh := withIdentity(ss.Context())
+		if !fresh {
+			return handler(srv, ss)
+		}
+		// Best effort: this only fails if headers were already sent.
+		_ = ss.SetHeader(metadata.Pairs(CGRequestID, id.requestID))
+
+		wrapped := middleware.WrapServerStream(ss)
+		wrapped.WrappedContext = nc
+		return handler(srv, wrapped)
+	}
+}
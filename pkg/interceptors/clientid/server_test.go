@@ -0,0 +1,104 @@
+/*
+Copyright 2026 Chainguard, Inc.
+SPDX-License-Identifier: Apache-2.0
+*/
+
+package clientid
+
+import (
+	"context"
+	"testing"
+
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/metadata"
+)
+
+func TestUnaryServerInterceptor_ParsesIdentity(t *testing.T) {
+	ctx := metadata.NewIncomingContext(context.Background(),
+		metadata.Pairs(CGClientID, "caller", CGRequestID, "req-123"))
+
+	var gotClient, gotRequest string
+	_, err := UnaryServerInterceptor()(ctx, nil, &grpc.UnaryServerInfo{}, func(ctx context.Context, _ any) (any, error) {
+		gotClient, gotRequest = FromContext(ctx), RequestIDFromContext(ctx)
+		return nil, nil
+	})
+	if err != nil {
+		t.Fatalf("interceptor: %v", err)
+	}
+	if gotClient != "caller" {
+		t.Errorf("FromContext() = %q, want %q", gotClient, "caller")
+	}
+	if gotRequest != "req-123" {
+		t.Errorf("RequestIDFromContext() = %q, want %q", gotRequest, "req-123")
+	}
+}
+
+func TestUnaryServerInterceptor_GeneratesRequestID(t *testing.T) {
+	ctx := metadata.NewIncomingContext(context.Background(), metadata.MD{})
+
+	var gotClient, gotRequest string
+	_, err := UnaryServerInterceptor()(ctx, nil, &grpc.UnaryServerInfo{}, func(ctx context.Context, _ any) (any, error) {
+		gotClient, gotRequest = FromContext(ctx), RequestIDFromContext(ctx)
+		return nil, nil
+	})
+	if err != nil {
+		t.Fatalf("interceptor: %v", err)
+	}
+	if gotClient != "" {
+		t.Errorf("FromContext() = %q, want empty", gotClient)
+	}
+	if gotRequest == "" {
+		t.Error("expected a request ID to be generated when none was sent")
+	}
+}
+
+func TestStreamServerInterceptor_ParsesIdentity(t *testing.T) {
+	ctx := metadata.NewIncomingContext(context.Background(),
+		metadata.Pairs(CGClientID, "caller", CGRequestID, "req-456"))
+	ss := &fakeServerStream{ctx: ctx}
+
+	var gotClient, gotRequest string
+	err := StreamServerInterceptor()(nil, ss, &grpc.StreamServerInfo{}, func(_ any, stream grpc.ServerStream) error {
+		gotClient, gotRequest = FromContext(stream.Context()), RequestIDFromContext(stream.Context())
+		return nil
+	})
+	if err != nil {
+		t.Fatalf("interceptor: %v", err)
+	}
+	if gotClient != "caller" || gotRequest != "req-456" {
+		t.Errorf("got (%q, %q), want (%q, %q)", gotClient, gotRequest, "caller", "req-456")
+	}
+	if got := ss.header.Get(CGRequestID); len(got) != 1 || got[0] != "req-456" {
+		t.Errorf("response header %s = %v, want [req-456]", CGRequestID, got)
+	}
+}
+
+func TestFromContext_FallsBackToMetadata(t *testing.T) {
+	ctx := metadata.NewIncomingContext(context.Background(),
+		metadata.Pairs(CGClientID, "caller", CGRequestID, "req-789"))
+
+	if got := FromContext(ctx); got != "caller" {
+		t.Errorf("FromContext() = %q, want %q", got, "caller")
+	}
+	if got := RequestIDFromContext(ctx); got != "req-789" {
+		t.Errorf("RequestIDFromContext() = %q, want %q", got, "req-789")
+	}
+	if got := FromContext(context.Background()); got != "" {
+		t.Errorf("FromContext() without metadata = %q, want empty", got)
+	}
+}
+
+// fakeServerStream is a grpc.ServerStream that records the headers set on it.
+type fakeServerStream struct {
+	grpc.ServerStream
+
+	ctx    context.Context
+	header metadata.MD
+}
+
+func (s *fakeServerStream) Context() context.Context { return s.ctx }
+
+func (s *fakeServerStream) SetHeader(md metadata.MD) error {
+	s.header = metadata.Join(s.header, md)
+	return nil
+}
@@ -0,0 +1,58 @@
+/*
+Copyright 2026 Chainguard, Inc.
+SPDX-License-Identifier: Apache-2.0
+*/
+
+package deadline
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"strconv"
+	"time"
+)
+
+// TimeoutHeader is the HTTP request header giving the time a REST caller has
+// left for the call, as a number of seconds, such as "2.5", or a Go duration,
+// such as "2500ms".
+const TimeoutHeader = "X-Request-Timeout"
+
+// ParseTimeout parses the value of a TimeoutHeader.
+func ParseTimeout(v string) (time.Duration, error) {
+	d, err := time.ParseDuration(v)
+	if err != nil {
+		secs, ferr := strconv.ParseFloat(v, 64)
+		if ferr != nil {
+			return 0, fmt.Errorf("timeout %q is neither a number of seconds nor a duration", v)
+		}
+		d = time.Duration(secs * float64(time.Second))
+	}
+	if d <= 0 {
+		return 0, errors.New("timeout must be positive")
+	}
+	return d, nil
+}
+
+// HTTPMiddleware gives each request with a TimeoutHeader a context with that
+// deadline. Behind it, the grpc-gateway sends the time left as the call's
+// grpc-timeout, so a REST caller's budget bounds the gRPC call it makes. A
+// request whose header does not parse is refused with 400 Bad Request.
+func HTTPMiddleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		v := r.Header.Get(TimeoutHeader)
+		if v == "" {
+			next.ServeHTTP(w, r)
+			return
+		}
+		d, err := ParseTimeout(v)
+		if err != nil {
+			http.Error(w, fmt.Sprintf("%s: %v", TimeoutHeader, err), http.StatusBadRequest)
+			return
+		}
+		ctx, cancel := context.WithTimeout(r.Context(), d)
+		defer cancel()
+		next.ServeHTTP(w, r.WithContext(ctx))
+	})
+}
@@ -0,0 +1,76 @@
+/*
+Copyright 2026 Chainguard, Inc.
+SPDX-License-Identifier: Apache-2.0
+*/
+
+// Package deadline provides gRPC server interceptors that refuse calls whose
+// deadline passed before they arrived, counting them in the
+// grpc_server_deadline_expired_total metric, and cap the deadline of the rest,
+// so that no unary call runs unbounded. Its HTTP middleware gives REST calls
+// the deadline their TimeoutHeader asks for, which the grpc-gateway propagates
+// to the gRPC server as grpc-timeout.
+package deadline
+
+import (
+	"context"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+
+	"github.com/chainguard-dev/clog"
+)
+
+var expiredTotal = prometheus.NewCounterVec(prometheus.CounterOpts{
+	Name: "grpc_server_deadline_expired_total",
+	Help: "Number of calls refused because their deadline had passed when they arrived, by method.",
+}, []string{"method"})
+
+func init() {
+	prometheus.MustRegister(expiredTotal)
+}
+
+// expired returns the status to refuse a call to method with when ctx's
+// deadline has already passed, or nil.
+func expired(ctx context.Context, method string) error {
+	deadline, ok := ctx.Deadline()
+	if !ok || time.Until(deadline) > 0 {
+		return nil
+	}
+	expiredTotal.WithLabelValues(method).Inc()
+	clog.FromContext(ctx).Debug("deadline expired on arrival", "method", method)
+	return status.Errorf(codes.DeadlineExceeded, "deadline of %s expired before it was handled", method)
+}
+
+// UnaryServerInterceptor refuses calls whose deadline has passed with
+// codes.DeadlineExceeded, without calling the handler, and bounds the others to
+// maxTimeout: a call with no deadline, or a later one, gets maxTimeout from
+// when it arrives. A maxTimeout of 0 leaves deadlines uncapped.
+func UnaryServerInterceptor(maxTimeout time.Duration) grpc.UnaryServerInterceptor {
+	return func(ctx context.Context, req any, info *grpc.UnaryServerInfo, handler grpc.UnaryHandler) (any, error) {
+		if err := expired(ctx, info.FullMethod); err != nil {
+			return nil, err
+		}
+		if maxTimeout > 0 {
+			var cancel context.CancelFunc
+			ctx, cancel = context.WithTimeout(ctx, maxTimeout)
+			defer cancel()
+		}
+		return handler(ctx, req)
+	}
+}
+
+// StreamServerInterceptor refuses streams whose deadline has passed with
+// codes.DeadlineExceeded, without calling the handler. Streams are not capped,
+// as many, such as watches, are meant to stay open; see
+// duplex.WithStreamGracePeriod for ending them on shutdown.
+func StreamServerInterceptor() grpc.StreamServerInterceptor {
+	return func(srv any, ss grpc.ServerStream, info *grpc.StreamServerInfo, handler grpc.StreamHandler) error {
+		if err := expired(ss.Context(), info.FullMethod); err != nil {
+			return err
+		}
+		return handler(srv, ss)
+	}
+}
@@ -0,0 +1,176 @@
+/*
+Copyright 2026 Chainguard, Inc.
+SPDX-License-Identifier: Apache-2.0
+*/
+
+package deadline
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+func TestUnaryServerInterceptor(t *testing.T) {
+	const method = "/pkg.Service/Get"
+
+	tests := []struct {
+		name        string
+		maxTimeout  time.Duration
+		timeout     time.Duration // 0 for no deadline
+		wantCode    codes.Code
+		wantHandled bool
+		wantLeft    time.Duration // most time the handler may have left, 0 for no deadline
+	}{
+		{"no deadline capped", time.Second, 0, codes.OK, true, time.Second},
+		{"later deadline capped", time.Second, time.Hour, codes.OK, true, time.Second},
+		{"earlier deadline kept", time.Hour, time.Second, codes.OK, true, time.Second},
+		{"no cap", 0, 0, codes.OK, true, 0},
+		{"expired", time.Second, -time.Second, codes.DeadlineExceeded, false, 0},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			ctx := t.Context()
+			if tt.timeout != 0 {
+				var cancel context.CancelFunc
+				ctx, cancel = context.WithTimeout(ctx, tt.timeout)
+				defer cancel()
+			}
+			expired := expiredTotal.WithLabelValues(method)
+			before := testutil.ToFloat64(expired)
+
+			handled := false
+			_, err := UnaryServerInterceptor(tt.maxTimeout)(ctx, nil, &grpc.UnaryServerInfo{FullMethod: method},
+				func(ctx context.Context, _ any) (any, error) {
+					handled = true
+					dl, ok := ctx.Deadline()
+					switch {
+					case tt.wantLeft == 0 && ok:
+						t.Errorf("handler deadline = %v, want none", dl)
+					case tt.wantLeft != 0 && (!ok || time.Until(dl) > tt.wantLeft):
+						t.Errorf("handler has %v left, want at most %v", time.Until(dl), tt.wantLeft)
+					}
+					return nil, nil
+				})
+			if status.Code(err) != tt.wantCode {
+				t.Errorf("status code = %v, want %v", status.Code(err), tt.wantCode)
+			}
+			if handled != tt.wantHandled {
+				t.Errorf("handled = %v, want %v", handled, tt.wantHandled)
+			}
+
+			wantExpired := 0.0
+			if tt.wantCode == codes.DeadlineExceeded {
+				wantExpired = 1
+			}
+			if got := testutil.ToFloat64(expired) - before; got != wantExpired {
+				t.Errorf("expired = %v, want %v", got, wantExpired)
+			}
+		})
+	}
+}
+
+type fakeServerStream struct {
+	grpc.ServerStream
+	ctx context.Context
+}
+
+func (s *fakeServerStream) Context() context.Context { return s.ctx }
+
+func TestStreamServerInterceptor(t *testing.T) {
+	expiredCtx, cancel := context.WithDeadline(t.Context(), time.Now().Add(-time.Second))
+	defer cancel()
+
+	for _, tc := range []struct {
+		name     string
+		ctx      context.Context
+		wantCode codes.Code
+	}{
+		{"no deadline", t.Context(), codes.OK},
+		{"expired", expiredCtx, codes.DeadlineExceeded},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			handled := false
+			err := StreamServerInterceptor()(nil, &fakeServerStream{ctx: tc.ctx}, &grpc.StreamServerInfo{FullMethod: "/pkg.Service/Watch"},
+				func(any, grpc.ServerStream) error {
+					handled = true
+					return nil
+				})
+			if status.Code(err) != tc.wantCode {
+				t.Errorf("status code = %v, want %v", status.Code(err), tc.wantCode)
+			}
+			if handled != (tc.wantCode == codes.OK) {
+				t.Errorf("handled = %v", handled)
+			}
+		})
+	}
+}
+
+func TestParseTimeout(t *testing.T) {
+	tests := []struct {
+		value   string
+		want    time.Duration
+		wantErr bool
+	}{
+		{"2.5", 2500 * time.Millisecond, false},
+		{"30", 30 * time.Second, false},
+		{"250ms", 250 * time.Millisecond, false},
+		{"0", 0, true},
+		{"-1s", 0, true},
+		{"soon", 0, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.value, func(t *testing.T) {
+			got, err := ParseTimeout(tt.value)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("ParseTimeout() = %v, wantErr %v", err, tt.wantErr)
+			}
+			if got != tt.want {
+				t.Errorf("ParseTimeout() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestHTTPMiddleware(t *testing.T) {
+	tests := []struct {
+		name       string
+		header     string
+		wantStatus int
+		wantLeft   time.Duration // most time the handler may have left, 0 for no deadline
+	}{
+		{"no header", "", http.StatusOK, 0},
+		{"timeout", "2s", http.StatusOK, 2 * time.Second},
+		{"invalid", "soon", http.StatusBadRequest, 0},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			h := HTTPMiddleware(http.HandlerFunc(func(_ http.ResponseWriter, r *http.Request) {
+				dl, ok := r.Context().Deadline()
+				switch {
+				case tt.wantLeft == 0 && ok:
+					t.Errorf("request deadline = %v, want none", dl)
+				case tt.wantLeft != 0 && (!ok || time.Until(dl) > tt.wantLeft):
+					t.Errorf("request has %v left, want at most %v", time.Until(dl), tt.wantLeft)
+				}
+			}))
+
+			req := httptest.NewRequest(http.MethodGet, "/v1/example", nil)
+			if tt.header != "" {
+				req.Header.Set(TimeoutHeader, tt.header)
+			}
+			rec := httptest.NewRecorder()
+			h.ServeHTTP(rec, req)
+			if rec.Code != tt.wantStatus {
+				t.Errorf("status = %d, want %d", rec.Code, tt.wantStatus)
+			}
+		})
+	}
+}
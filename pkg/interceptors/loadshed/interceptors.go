@@ -0,0 +1,67 @@
+/*
+Copyright 2026 Chainguard, Inc.
+SPDX-License-Identifier: Apache-2.0
+*/
+
+// Package loadshed provides gRPC server interceptors that shed load: they
+// refuse new calls with codes.Unavailable while the number in flight is at a
+// concurrency limit that adapts to observed latency, so that an overloaded
+// server answers some calls promptly rather than all of them slowly. Methods
+// are given priority classes, so that health checks and critical methods are
+// never shed, and low-priority ones are shed first. The current limit is
+// exported in the grpc_server_load_shed_limit metric, and shed calls are
+// counted in grpc_server_load_shed_total, each Limiter's on its own
+// registerer.
+//
+// Install the interceptors with duplex.WithLoadShedding, or chain them early,
+// so that shed calls cost as little as possible.
+package loadshed
+
+import (
+	"context"
+
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+
+	"github.com/chainguard-dev/clog"
+)
+
+// admit admits a call to method, or returns the status to shed it with.
+func (l *Limiter) admit(ctx context.Context, method string) (func(sample bool), error) {
+	p := l.priority(method)
+	release, ok := l.acquire(p)
+	if ok {
+		return release, nil
+	}
+	l.metrics.shed.WithLabelValues(method, p.String()).Inc()
+	clog.FromContext(ctx).Debug("shed call", "method", method, "priority", p)
+	return nil, status.Error(codes.Unavailable, "server overloaded, try again later")
+}
+
+// UnaryServerInterceptor sheds calls with l, and adapts its limit to the
+// latency of those it admits.
+func UnaryServerInterceptor(l *Limiter) grpc.UnaryServerInterceptor {
+	return func(ctx context.Context, req any, info *grpc.UnaryServerInfo, handler grpc.UnaryHandler) (any, error) {
+		release, err := l.admit(ctx, info.FullMethod)
+		if err != nil {
+			return nil, err
+		}
+		defer release(true)
+		return handler(ctx, req)
+	}
+}
+
+// StreamServerInterceptor sheds streams with l when they start. A stream's
+// duration says nothing about load, so streams neither count as in flight
+// once admitted nor adapt the limit.
+func StreamServerInterceptor(l *Limiter) grpc.StreamServerInterceptor {
+	return func(srv any, ss grpc.ServerStream, info *grpc.StreamServerInfo, handler grpc.StreamHandler) error {
+		release, err := l.admit(ss.Context(), info.FullMethod)
+		if err != nil {
+			return err
+		}
+		release(false)
+		return handler(srv, ss)
+	}
+}
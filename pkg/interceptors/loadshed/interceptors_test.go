@@ -0,0 +1,68 @@
+/*
+Copyright 2026 Chainguard, Inc.
+SPDX-License-Identifier: Apache-2.0
+*/
+
+package loadshed
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+func TestInterceptors(t *testing.T) {
+	now := time.Unix(1_700_000_000, 0)
+	l := newTestLimiter(t, &now, WithLimits(1, 1, 1))
+	ctx := context.Background()
+	shed := l.metrics.shed.WithLabelValues("/pkg.Service/Get", "normal")
+
+	unary := func(method string, handler grpc.UnaryHandler) error {
+		_, err := UnaryServerInterceptor(l)(ctx, nil, &grpc.UnaryServerInfo{FullMethod: method}, handler)
+		return err
+	}
+	stream := func(method string) error {
+		return StreamServerInterceptor(l)(nil, &fakeServerStream{ctx: ctx}, &grpc.StreamServerInfo{FullMethod: method},
+			func(any, grpc.ServerStream) error { return nil })
+	}
+
+	// While one call is in flight, the next is shed, except health checks.
+	err := unary("/pkg.Service/Get", func(context.Context, any) (any, error) {
+		if code := status.Code(unary("/pkg.Service/Get", nil)); code != codes.Unavailable {
+			t.Errorf("unary status code = %v, want %v", code, codes.Unavailable)
+		}
+		if code := status.Code(stream("/pkg.Service/Watch")); code != codes.Unavailable {
+			t.Errorf("stream status code = %v, want %v", code, codes.Unavailable)
+		}
+		if err := unary("/grpc.health.v1.Health/Check", func(context.Context, any) (any, error) { return nil, nil }); err != nil {
+			t.Errorf("health check = %v, want admitted", err)
+		}
+		return nil, nil
+	})
+	if err != nil {
+		t.Fatalf("first call = %v", err)
+	}
+	if got := testutil.ToFloat64(shed); got != 1 {
+		t.Errorf("shed = %v, want 1", got)
+	}
+
+	// An admitted stream does not hold its slot.
+	if err := StreamServerInterceptor(l)(nil, &fakeServerStream{ctx: ctx}, &grpc.StreamServerInfo{FullMethod: "/pkg.Service/Watch"},
+		func(any, grpc.ServerStream) error {
+			return unary("/pkg.Service/Get", func(context.Context, any) (any, error) { return nil, nil })
+		}); err != nil {
+		t.Errorf("unary call inside a stream = %v, want admitted", err)
+	}
+}
+
+type fakeServerStream struct {
+	grpc.ServerStream
+	ctx context.Context
+}
+
+func (s *fakeServerStream) Context() context.Context { return s.ctx }
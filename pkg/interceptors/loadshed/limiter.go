@@ -0,0 +1,246 @@
+/*
+Copyright 2026 Chainguard, Inc.
+SPDX-License-Identifier: Apache-2.0
+*/
+
+package loadshed
+
+import (
+	"errors"
+	"fmt"
+	"math"
+	"sync"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+
+	"chainguard.dev/go-grpc-kit/internal/methodmatch"
+)
+
+const (
+	defaultMinLimit     = 10
+	defaultInitialLimit = 100
+	defaultMaxLimit     = 1000
+
+	// shortWindow and longWindow are the number of samples the short- and
+	// long-term latency averages are taken over.
+	shortWindow = 10
+	longWindow  = 600
+
+	// tolerance is how far short-term latency may exceed long-term latency
+	// before the limit shrinks.
+	tolerance = 1.5
+
+	// smoothing is the weight of each new limit estimate.
+	smoothing = 0.2
+
+	// lowPriorityShare is the share of the limit low-priority calls may fill,
+	// keeping the rest for normal ones.
+	lowPriorityShare = 0.75
+)
+
+// Priority is the class of a method, which decides when its calls are shed.
+type Priority int
+
+const (
+	// PriorityNormal calls are shed once in-flight calls reach the limit.
+	PriorityNormal Priority = iota
+
+	// PriorityLow calls are shed first, once in-flight calls reach three
+	// quarters of the limit.
+	PriorityLow
+
+	// PriorityCritical calls are never shed, though they count as in flight.
+	PriorityCritical
+)
+
+func (p Priority) String() string {
+	switch p {
+	case PriorityNormal:
+		return "normal"
+	case PriorityLow:
+		return "low"
+	case PriorityCritical:
+		return "critical"
+	}
+	return fmt.Sprintf("Priority(%d)", int(p))
+}
+
+// Limiter admits calls while the number in flight is below a concurrency limit
+// that adapts to their latency. Each completed call's latency is compared with
+// the long-term average: while it stays within tolerance the limit grows, and
+// as latency rises, a sign that the server is queueing work, the limit shrinks
+// in proportion.
+type Limiter struct {
+	now func() time.Time
+
+	registerer prometheus.Registerer
+	metrics    metrics
+
+	minLimit float64
+	maxLimit float64
+
+	// priorities are checked in order, the first match deciding.
+	priorities []methodPriority
+
+	mu       sync.Mutex
+	limit    float64
+	inflight int
+	shortRTT float64
+	longRTT  float64
+}
+
+type methodPriority struct {
+	pattern  string
+	priority Priority
+}
+
+// Option configures a Limiter.
+type Option func(*Limiter)
+
+// WithLimits sets the least, initial and greatest concurrency limit. The
+// defaults are 10, 100 and 1000.
+func WithLimits(minLimit, initial, maxLimit int) Option {
+	return func(l *Limiter) {
+		l.minLimit, l.limit, l.maxLimit = float64(minLimit), float64(initial), float64(maxLimit)
+	}
+}
+
+// WithPriority gives methods matching pattern priority p. A pattern is either a
+// full method name, such as "/pkg.Service/Method", or a prefix ending in "*",
+// such as "/pkg.Service/*". The first matching pattern decides, and methods
+// matching none are PriorityNormal, except that the health service,
+// "/grpc.health.v1.Health/*", is PriorityCritical unless a pattern given here
+// matches it.
+func WithPriority(pattern string, p Priority) Option {
+	return func(l *Limiter) {
+		l.priorities = append(l.priorities, methodPriority{pattern: pattern, priority: p})
+	}
+}
+
+// WithRegisterer sets where the Limiter registers its metrics. The default is
+// prometheus.DefaultRegisterer, and nil registers them nowhere. The metrics
+// report one Limiter's limit and calls in flight, so each Limiter needs a
+// registerer of its own.
+func WithRegisterer(reg prometheus.Registerer) Option {
+	return func(l *Limiter) {
+		l.registerer = reg
+	}
+}
+
+// New returns a Limiter configured by opts. It returns an error if its metrics
+// cannot be registered, such as when another Limiter's are already registered
+// with its registerer.
+func New(opts ...Option) (*Limiter, error) {
+	l := &Limiter{
+		now:        time.Now,
+		registerer: prometheus.DefaultRegisterer,
+		metrics:    newMetrics(),
+		minLimit:   defaultMinLimit,
+		limit:      defaultInitialLimit,
+		maxLimit:   defaultMaxLimit,
+	}
+	for _, o := range opts {
+		o(l)
+	}
+	if l.minLimit < 1 || l.limit < l.minLimit || l.maxLimit < l.limit {
+		return nil, fmt.Errorf("invalid limits: want 1 <= min (%v) <= initial (%v) <= max (%v)", l.minLimit, l.limit, l.maxLimit)
+	}
+	for _, mp := range l.priorities {
+		if mp.priority < PriorityNormal || mp.priority > PriorityCritical {
+			return nil, fmt.Errorf("invalid priority %v for %q", mp.priority, mp.pattern)
+		}
+		if mp.pattern == "" {
+			return nil, errors.New("empty method pattern")
+		}
+	}
+	l.priorities = append(l.priorities, methodPriority{pattern: "/grpc.health.v1.Health/*", priority: PriorityCritical})
+	if l.registerer != nil {
+		if err := l.metrics.register(l.registerer); err != nil {
+			return nil, err
+		}
+	}
+	l.metrics.limit.Set(l.limit)
+	return l, nil
+}
+
+// Limit returns the current concurrency limit.
+func (l *Limiter) Limit() int {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	return int(l.limit)
+}
+
+// priority returns the priority of method.
+func (l *Limiter) priority(method string) Priority {
+	for _, mp := range l.priorities {
+		if methodmatch.Match(mp.pattern, method) {
+			return mp.priority
+		}
+	}
+	return PriorityNormal
+}
+
+// acquire admits a call of priority p, returning the function to call with
+// whether to sample its latency when it ends, or reports that it is shed.
+func (l *Limiter) acquire(p Priority) (release func(sample bool), ok bool) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	switch p {
+	case PriorityNormal:
+		if float64(l.inflight) >= math.Floor(l.limit) {
+			return nil, false
+		}
+	case PriorityLow:
+		if float64(l.inflight) >= math.Floor(l.limit*lowPriorityShare) {
+			return nil, false
+		}
+	}
+
+	l.inflight++
+	l.metrics.inflight.Set(float64(l.inflight))
+	start, inflight := l.now(), l.inflight
+	var once sync.Once
+	return func(sample bool) {
+		once.Do(func() { l.release(start, inflight, sample) })
+	}, true
+}
+
+// release ends a call started at start with inflight calls in flight, and
+// when sample is set, adapts the limit to its latency.
+func (l *Limiter) release(start time.Time, inflight int, sample bool) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	l.inflight--
+	l.metrics.inflight.Set(float64(l.inflight))
+
+	rtt := l.now().Sub(start).Seconds()
+	if !sample || rtt <= 0 {
+		return
+	}
+
+	if l.longRTT == 0 {
+		l.shortRTT, l.longRTT = rtt, rtt
+	} else {
+		l.shortRTT += (rtt - l.shortRTT) / shortWindow
+		l.longRTT += (rtt - l.longRTT) / longWindow
+	}
+	// Once latency has fallen well below the long-term average, let the
+	// average catch up quickly, rather than ratchet the limit up off a stale
+	// baseline.
+	if l.longRTT/l.shortRTT > 2 {
+		l.longRTT *= 0.95
+	}
+
+	// Calls that never came close to the limit say nothing about it.
+	if float64(inflight) < l.limit/2 {
+		return
+	}
+
+	gradient := max(0.5, min(1, tolerance*l.longRTT/l.shortRTT))
+	estimate := l.limit*gradient + math.Sqrt(l.limit)
+	l.limit = max(l.minLimit, min(l.maxLimit, l.limit*(1-smoothing)+estimate*smoothing))
+	l.metrics.limit.Set(l.limit)
+}
@@ -0,0 +1,220 @@
+/*
+Copyright 2026 Chainguard, Inc.
+SPDX-License-Identifier: Apache-2.0
+*/
+
+package loadshed
+
+import (
+	"testing"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+)
+
+// newTestLimiter returns a Limiter configured by opts whose clock is *now.
+func newTestLimiter(t *testing.T, now *time.Time, opts ...Option) *Limiter {
+	t.Helper()
+	l, err := New(append([]Option{WithRegisterer(prometheus.NewRegistry())}, opts...)...)
+	if err != nil {
+		t.Fatalf("New() = %v", err)
+	}
+	l.now = func() time.Time { return *now }
+	return l
+}
+
+func TestLimiter_Priorities(t *testing.T) {
+	now := time.Unix(1_700_000_000, 0)
+	l := newTestLimiter(t, &now, WithLimits(4, 4, 4),
+		WithPriority("/pkg.Service/Batch*", PriorityLow),
+		WithPriority("/pkg.Service/Critical", PriorityCritical),
+	)
+
+	tests := []struct {
+		method string
+		want   Priority
+	}{
+		{"/pkg.Service/Get", PriorityNormal},
+		{"/pkg.Service/BatchImport", PriorityLow},
+		{"/pkg.Service/Critical", PriorityCritical},
+		{"/grpc.health.v1.Health/Check", PriorityCritical},
+	}
+	for _, tt := range tests {
+		if got := l.priority(tt.method); got != tt.want {
+			t.Errorf("priority(%q) = %v, want %v", tt.method, got, tt.want)
+		}
+	}
+
+	// Low-priority calls fill three quarters of the limit.
+	for i := range 3 {
+		if _, ok := l.acquire(PriorityLow); !ok {
+			t.Fatalf("low-priority call %d shed, want admitted", i)
+		}
+	}
+	if _, ok := l.acquire(PriorityLow); ok {
+		t.Error("low-priority call over three quarters of the limit admitted, want shed")
+	}
+
+	// Normal calls fill the limit.
+	if _, ok := l.acquire(PriorityNormal); !ok {
+		t.Fatal("normal call under the limit shed, want admitted")
+	}
+	if _, ok := l.acquire(PriorityNormal); ok {
+		t.Error("normal call over the limit admitted, want shed")
+	}
+
+	// Critical calls are never shed.
+	for i := range 3 {
+		if _, ok := l.acquire(PriorityCritical); !ok {
+			t.Errorf("critical call %d shed, want admitted", i)
+		}
+	}
+}
+
+func TestLimiter_HealthPriorityOverride(t *testing.T) {
+	now := time.Unix(1_700_000_000, 0)
+	l := newTestLimiter(t, &now, WithPriority("/grpc.health.v1.Health/Watch", PriorityLow))
+
+	if got := l.priority("/grpc.health.v1.Health/Watch"); got != PriorityLow {
+		t.Errorf("priority(Watch) = %v, want %v", got, PriorityLow)
+	}
+	if got := l.priority("/grpc.health.v1.Health/Check"); got != PriorityCritical {
+		t.Errorf("priority(Check) = %v, want %v", got, PriorityCritical)
+	}
+}
+
+func TestLimiter_Adapts(t *testing.T) {
+	// run completes rounds of calls, limit calls at a time, each taking the
+	// latency latency returns for the round, and returns the resulting limit.
+	run := func(l *Limiter, now *time.Time, rounds int, latency func(round int) time.Duration) int {
+		for round := range rounds {
+			n := l.Limit()
+			releases := make([]func(bool), 0, n)
+			for range n {
+				release, ok := l.acquire(PriorityNormal)
+				if !ok {
+					t.Fatalf("call under the limit of %d shed", n)
+				}
+				releases = append(releases, release)
+			}
+			*now = now.Add(latency(round))
+			for _, release := range releases {
+				release(true)
+			}
+		}
+		return l.Limit()
+	}
+
+	t.Run("steady latency grows the limit", func(t *testing.T) {
+		now := time.Unix(1_700_000_000, 0)
+		l := newTestLimiter(t, &now, WithLimits(10, 20, 200))
+		got := run(l, &now, 20, func(int) time.Duration { return 10 * time.Millisecond })
+		if got <= 20 {
+			t.Errorf("limit = %d, want grown above 20", got)
+		}
+	})
+
+	t.Run("rising latency shrinks the limit", func(t *testing.T) {
+		now := time.Unix(1_700_000_000, 0)
+		l := newTestLimiter(t, &now, WithLimits(10, 100, 200))
+		got := run(l, &now, 20, func(round int) time.Duration {
+			return time.Duration(round+1) * 50 * time.Millisecond
+		})
+		if got >= 100 {
+			t.Errorf("limit = %d, want shrunk below 100", got)
+		}
+		if got < 10 {
+			t.Errorf("limit = %d, want at least the minimum 10", got)
+		}
+	})
+
+	t.Run("a lightly loaded server keeps its limit", func(t *testing.T) {
+		now := time.Unix(1_700_000_000, 0)
+		l := newTestLimiter(t, &now, WithLimits(10, 100, 200))
+		for range 50 {
+			release, _ := l.acquire(PriorityNormal)
+			now = now.Add(time.Second)
+			release(true)
+		}
+		if got := l.Limit(); got != 100 {
+			t.Errorf("limit = %d, want 100", got)
+		}
+	})
+}
+
+func TestLimiter_ReleaseOnce(t *testing.T) {
+	now := time.Unix(1_700_000_000, 0)
+	l := newTestLimiter(t, &now, WithLimits(1, 1, 1))
+
+	release, ok := l.acquire(PriorityNormal)
+	if !ok {
+		t.Fatal("first call shed")
+	}
+	release(false)
+	release(false)
+	if _, ok := l.acquire(PriorityNormal); !ok {
+		t.Fatal("call after release shed, want admitted")
+	}
+	if _, ok := l.acquire(PriorityNormal); ok {
+		t.Error("call over the limit admitted, want shed; a double release freed two slots")
+	}
+}
+
+func TestNew_Errors(t *testing.T) {
+	tests := []struct {
+		name string
+		opts []Option
+	}{{
+		name: "zero minimum",
+		opts: []Option{WithLimits(0, 10, 100)},
+	}, {
+		name: "initial below minimum",
+		opts: []Option{WithLimits(10, 5, 100)},
+	}, {
+		name: "maximum below initial",
+		opts: []Option{WithLimits(10, 50, 20)},
+	}, {
+		name: "invalid priority",
+		opts: []Option{WithPriority("/pkg.Service/*", Priority(7))},
+	}, {
+		name: "empty pattern",
+		opts: []Option{WithPriority("", PriorityLow)},
+	}}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if _, err := New(append([]Option{WithRegisterer(nil)}, tt.opts...)...); err == nil {
+				t.Error("New() = nil, want error")
+			}
+		})
+	}
+}
+
+func TestNew_Registerer(t *testing.T) {
+	reg := prometheus.NewRegistry()
+	a, err := New(WithRegisterer(reg), WithLimits(1, 5, 10))
+	if err != nil {
+		t.Fatalf("New() = %v", err)
+	}
+
+	// Another Limiter's gauges would overwrite a's, so it cannot share reg.
+	if _, err := New(WithRegisterer(reg)); err == nil {
+		t.Error("New() on a registerer in use = nil, want error")
+	}
+
+	b, err := New(WithRegisterer(prometheus.NewRegistry()), WithLimits(1, 7, 10))
+	if err != nil {
+		t.Fatalf("New() = %v", err)
+	}
+	for _, tt := range []struct {
+		l    *Limiter
+		want float64
+	}{{a, 5}, {b, 7}} {
+		if got := testutil.ToFloat64(tt.l.metrics.limit); got != tt.want {
+			t.Errorf("limit gauge = %v, want %v", got, tt.want)
+		}
+	}
+	if n, err := testutil.GatherAndCount(reg, "grpc_server_load_shed_limit"); err != nil || n != 1 {
+		t.Errorf("GatherAndCount() = %d, %v; want 1", n, err)
+	}
+}
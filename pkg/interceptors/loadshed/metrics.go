@@ -0,0 +1,52 @@
+/*
+Copyright 2026 Chainguard, Inc.
+SPDX-License-Identifier: Apache-2.0
+*/
+
+package loadshed
+
+import (
+	"fmt"
+
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+// metrics are a Limiter's own metrics, as its gauges report its own limit and
+// calls in flight.
+type metrics struct {
+	limit    prometheus.Gauge
+	inflight prometheus.Gauge
+	shed     *prometheus.CounterVec
+}
+
+func newMetrics() metrics {
+	return metrics{
+		limit: prometheus.NewGauge(prometheus.GaugeOpts{
+			Name: "grpc_server_load_shed_limit",
+			Help: "Current adaptive limit on the number of calls in flight, above which calls are shed.",
+		}),
+		inflight: prometheus.NewGauge(prometheus.GaugeOpts{
+			Name: "grpc_server_load_shed_inflight",
+			Help: "Number of calls in flight counted against the adaptive limit.",
+		}),
+		shed: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Name: "grpc_server_load_shed_total",
+			Help: "Number of calls shed for exceeding the adaptive limit, by method and priority (normal or low).",
+		}, []string{"method", "priority"}),
+	}
+}
+
+// register registers m with reg, or none of them if any cannot be.
+func (m metrics) register(reg prometheus.Registerer) error {
+	var registered []prometheus.Collector
+	for _, c := range []prometheus.Collector{m.limit, m.inflight, m.shed} {
+		if err := reg.Register(c); err != nil {
+			for _, r := range registered {
+				reg.Unregister(r)
+			}
+			return fmt.Errorf("registering load shedding metrics: %w", err)
+		}
+		registered = append(registered, c)
+	}
+	return nil
+}
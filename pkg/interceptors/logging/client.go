@@ -0,0 +1,161 @@
+/*
+Copyright 2026 Chainguard, Inc.
+SPDX-License-Identifier: Apache-2.0
+*/
+
+package logging
+
+import (
+	"context"
+	"errors"
+	"io"
+	"sync"
+	"time"
+
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/status"
+
+	"chainguard.dev/go-grpc-kit/pkg/interceptors/clientid"
+)
+
+// clientAttrs returns the attributes common to every client log line. When the
+// call is not made while serving another request, the logger carries no
+// cgrequestid, so the one sent on the outgoing metadata is added. Install the
+// logging interceptors after the clientid ones so that value is present.
+func clientAttrs(ctx context.Context, cc *grpc.ClientConn, method string) []any {
+	attrs := []any{
+		"grpc.component", "client",
+		"grpc.method", method,
+		"grpc.target", cc.Target(),
+	}
+	if clientid.RequestIDFromContext(ctx) == "" {
+		if md, ok := metadata.FromOutgoingContext(ctx); ok {
+			if rid := md.Get(clientid.CGRequestID); len(rid) > 0 {
+				attrs = append(attrs, clientid.CGRequestID, rid[0])
+			}
+		}
+	}
+	return attrs
+}
+
+// UnaryClientInterceptor returns a gRPC unary client interceptor that logs one
+// line per call.
+func UnaryClientInterceptor(opts ...Option) grpc.UnaryClientInterceptor {
+	cfg := newConfig(opts)
+
+	return func(ctx context.Context, method string, req, reply any, cc *grpc.ClientConn, invoker grpc.UnaryInvoker, opts ...grpc.CallOption) error {
+		start := time.Now()
+		err := invoker(ctx, method, req, reply, cc, opts...)
+
+		if err == nil && !cfg.sampled() {
+			return err
+		}
+
+		code := status.Code(err)
+		attrs := append(clientAttrs(ctx, cc, method),
+			"grpc.code", code.String(),
+			"grpc.request.bytes", size(req),
+		)
+		if err != nil {
+			attrs = append(attrs, "error", err)
+		} else {
+			attrs = append(attrs, "grpc.response.bytes", size(reply))
+			attrs = append(attrs, cfg.payloadAttr("grpc.response", method, reply)...)
+		}
+		attrs = append(attrs, cfg.payloadAttr("grpc.request", method, req)...)
+
+		emit(ctx, cfg.level(method, err != nil, codeLevel(code)), "finished client call", start, attrs...)
+		return err
+	}
+}
+
+// StreamClientInterceptor returns a gRPC stream client interceptor that logs one
+// line per stream, once receiving from it reports the end of the stream or an
+// error. A stream whose responses are never read to the end is not logged.
+func StreamClientInterceptor(opts ...Option) grpc.StreamClientInterceptor {
+	cfg := newConfig(opts)
+
+	return func(ctx context.Context, desc *grpc.StreamDesc, cc *grpc.ClientConn, method string, streamer grpc.Streamer, opts ...grpc.CallOption) (grpc.ClientStream, error) {
+		start := time.Now()
+		cs, err := streamer(ctx, desc, cc, method, opts...)
+		ls := &loggingClientStream{ClientStream: cs, serverStreams: desc.ServerStreams, finish: func(sent, recv messageCount, err error) {
+			if err == nil && !cfg.sampled() {
+				return
+			}
+
+			code := status.Code(err)
+			attrs := append(clientAttrs(ctx, cc, method),
+				"grpc.code", code.String(),
+				"grpc.recv.messages", recv.messages,
+				"grpc.recv.bytes", recv.bytes,
+				"grpc.sent.messages", sent.messages,
+				"grpc.sent.bytes", sent.bytes,
+			)
+			if err != nil {
+				attrs = append(attrs, "error", err)
+			}
+
+			emit(ctx, cfg.level(method, err != nil, codeLevel(code)), "finished client call", start, attrs...)
+		}}
+		if err != nil {
+			ls.end(err)
+			return nil, err
+		}
+		return ls, nil
+	}
+}
+
+// loggingClientStream is a grpc.ClientStream that counts the messages sent and
+// received over it and calls finish once when the stream ends. Sending and
+// receiving may happen on different goroutines, so the counts are guarded.
+type loggingClientStream struct {
+	grpc.ClientStream
+
+	// serverStreams is false when the server sends a single response, which
+	// then ends the stream without a trailing io.EOF from RecvMsg.
+	serverStreams bool
+	finish        func(sent, recv messageCount, err error)
+	once          sync.Once
+
+	mu         sync.Mutex
+	sent, recv messageCount
+}
+
+func (s *loggingClientStream) end(err error) {
+	s.once.Do(func() {
+		s.mu.Lock()
+		sent, recv := s.sent, s.recv
+		s.mu.Unlock()
+
+		s.finish(sent, recv, err)
+	})
+}
+
+func (s *loggingClientStream) SendMsg(m any) error {
+	err := s.ClientStream.SendMsg(m)
+	if err == nil {
+		s.mu.Lock()
+		s.sent.add(m)
+		s.mu.Unlock()
+	}
+	return err
+}
+
+func (s *loggingClientStream) RecvMsg(m any) error {
+	err := s.ClientStream.RecvMsg(m)
+	switch {
+	case err == nil:
+		s.mu.Lock()
+		s.recv.add(m)
+		s.mu.Unlock()
+		if !s.serverStreams {
+			s.end(nil)
+		}
+	case errors.Is(err, io.EOF):
+		s.end(nil)
+	default:
+		s.end(err)
+	}
+	return err
+}
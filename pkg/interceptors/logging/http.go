@@ -0,0 +1,106 @@
+/*
+Copyright 2026 Chainguard, Inc.
+SPDX-License-Identifier: Apache-2.0
+*/
+
+package logging
+
+import (
+	"log/slog"
+	"net/http"
+	"time"
+
+	"chainguard.dev/go-grpc-kit/pkg/interceptors/clientid"
+)
+
+// HTTPMiddleware returns an HTTP middleware that logs one line per request,
+// for the REST gateway path that gRPC interceptors do not see in full. The
+// cgrequestid is taken from the request, or else from the response header the
+// clientid server interceptors echo back through the gateway. Responses with a
+// status of 400 or above count as failed calls.
+func HTTPMiddleware(opts ...Option) func(http.Handler) http.Handler {
+	cfg := newConfig(opts)
+
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			start := time.Now()
+			rec := &statusRecorder{ResponseWriter: w, status: http.StatusOK}
+			next.ServeHTTP(rec, r)
+
+			failed := rec.status >= http.StatusBadRequest
+			if !failed && !cfg.sampled() {
+				return
+			}
+
+			requestID := r.Header.Get(clientid.CGRequestID)
+			if requestID == "" {
+				requestID = rec.Header().Get(clientid.CGRequestID)
+			}
+
+			attrs := []any{
+				"http.method", r.Method,
+				"http.path", r.URL.Path,
+				"http.status", rec.status,
+				"http.response.bytes", rec.bytes,
+				"peer.address", r.RemoteAddr,
+				clientid.CGClientID, r.Header.Get(clientid.CGClientID),
+				clientid.CGRequestID, requestID,
+			}
+			if r.ContentLength >= 0 {
+				attrs = append(attrs, "http.request.bytes", r.ContentLength)
+			}
+
+			emit(r.Context(), cfg.level(r.URL.Path, failed, statusLevel(rec.status)), "finished http request", start, attrs...)
+		})
+	}
+}
+
+// statusLevel maps an HTTP status to the level a request ending with it is
+// logged at.
+func statusLevel(status int) slog.Level {
+	switch {
+	case status >= http.StatusInternalServerError:
+		return slog.LevelError
+	case status >= http.StatusBadRequest:
+		return slog.LevelWarn
+	default:
+		return slog.LevelInfo
+	}
+}
+
+// statusRecorder is an http.ResponseWriter that records the status and number
+// of body bytes written through it.
+type statusRecorder struct {
+	http.ResponseWriter
+
+	status      int
+	bytes       int
+	wroteHeader bool
+}
+
+func (w *statusRecorder) WriteHeader(status int) {
+	if !w.wroteHeader {
+		w.status = status
+		w.wroteHeader = true
+	}
+	w.ResponseWriter.WriteHeader(status)
+}
+
+func (w *statusRecorder) Write(b []byte) (int, error) {
+	w.wroteHeader = true
+	n, err := w.ResponseWriter.Write(b)
+	w.bytes += n
+	return n, err
+}
+
+// Flush supports streaming responses from the gateway.
+func (w *statusRecorder) Flush() {
+	if f, ok := w.ResponseWriter.(http.Flusher); ok {
+		f.Flush()
+	}
+}
+
+// Unwrap lets http.ResponseController reach the underlying writer.
+func (w *statusRecorder) Unwrap() http.ResponseWriter {
+	return w.ResponseWriter
+}
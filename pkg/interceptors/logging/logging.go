@@ -0,0 +1,165 @@
+/*
+Copyright 2026 Chainguard, Inc.
+SPDX-License-Identifier: Apache-2.0
+*/
+
+// Package logging provides gRPC interceptors and an HTTP middleware that emit
+// one structured clog line per call, carrying the method, outcome, duration,
+// peer, caller identity (cgclientid, cgrequestid), trace context and message
+// sizes.
+package logging
+
+import (
+	"context"
+	"log/slog"
+	"math/rand/v2"
+	"time"
+
+	"go.opentelemetry.io/otel/trace"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/peer"
+	"google.golang.org/protobuf/proto"
+
+	"chainguard.dev/go-grpc-kit/internal/methodmatch"
+	"github.com/chainguard-dev/clog"
+)
+
+// Redactor returns the form of a request or response message to log for the
+// given full method name, or nil to leave the payload out of the log line. It
+// is where sensitive fields are stripped before a payload is logged.
+type Redactor func(method string, msg any) any
+
+// Option configures the logging interceptors and middleware.
+type Option func(*config)
+
+type config struct {
+	sampleRate   float64
+	methodLevels []methodLevel
+	redactor     Redactor
+}
+
+type methodLevel struct {
+	pattern string
+	level   slog.Level
+}
+
+func newConfig(opts []Option) *config {
+	cfg := &config{sampleRate: 1}
+	for _, o := range opts {
+		o(cfg)
+	}
+	return cfg
+}
+
+// WithSampleRate logs only the given fraction, between 0 and 1, of calls that
+// succeed. Failed calls are always logged. The default logs every call.
+func WithSampleRate(rate float64) Option {
+	return func(c *config) {
+		c.sampleRate = rate
+	}
+}
+
+// WithMethodLevel sets the level successful calls to matching methods are
+// logged at, in place of Info. The pattern is either a full method name, such
+// as "/pkg.Service/Method", or a prefix ending in "*", such as "/pkg.Service/*".
+// For the HTTP middleware the pattern is matched against the request path.
+// Failed calls keep the level of their status code. When several patterns
+// match, the first one given wins.
+func WithMethodLevel(pattern string, level slog.Level) Option {
+	return func(c *config) {
+		c.methodLevels = append(c.methodLevels, methodLevel{pattern: pattern, level: level})
+	}
+}
+
+// WithPayloads includes unary request and response payloads in the log line,
+// passed through the given Redactor first. Payloads are not logged by default,
+// nor for streaming calls.
+func WithPayloads(r Redactor) Option {
+	return func(c *config) {
+		c.redactor = r
+	}
+}
+
+// level returns the level to log a call to method at, given whether it failed
+// and the level its failure maps to.
+func (c *config) level(method string, failed bool, failure slog.Level) slog.Level {
+	if failed {
+		return failure
+	}
+	for _, ml := range c.methodLevels {
+		if methodmatch.Match(ml.pattern, method) {
+			return ml.level
+		}
+	}
+	return slog.LevelInfo
+}
+
+// sampled reports whether a successful call should be logged.
+func (c *config) sampled() bool {
+	if c.sampleRate >= 1 {
+		return true
+	}
+	// Sampling decisions need no cryptographic randomness.
+	return rand.Float64() < c.sampleRate //nolint:gosec
+}
+
+// codeLevel maps a gRPC status code to the level a call ending with it is
+// logged at: codes that indicate a server-side fault are errors, the rest are
+// warnings.
+func codeLevel(code codes.Code) slog.Level {
+	switch code {
+	case codes.OK:
+		return slog.LevelInfo
+	case codes.Unknown, codes.DeadlineExceeded, codes.Unimplemented,
+		codes.Internal, codes.Unavailable, codes.DataLoss:
+		return slog.LevelError
+	default:
+		return slog.LevelWarn
+	}
+}
+
+// emit writes the log line for a finished call, if level is enabled on the
+// context's logger.
+func emit(ctx context.Context, level slog.Level, msg string, start time.Time, attrs ...any) {
+	logger := clog.FromContext(ctx)
+	if !logger.Enabled(ctx, level) {
+		return
+	}
+
+	attrs = append(attrs, "duration", time.Since(start))
+	if p, ok := peer.FromContext(ctx); ok && p.Addr != nil {
+		attrs = append(attrs, "peer.address", p.Addr.String())
+	}
+	attrs = append(attrs, traceAttrs(ctx)...)
+
+	logger.Log(ctx, level, msg, attrs...)
+}
+
+// traceAttrs returns the trace and span IDs on ctx, if it carries a valid span.
+func traceAttrs(ctx context.Context) []any {
+	sc := trace.SpanContextFromContext(ctx)
+	if !sc.IsValid() {
+		return nil
+	}
+	return []any{"trace_id", sc.TraceID().String(), "span_id", sc.SpanID().String()}
+}
+
+// size returns the encoded size of msg, or zero if it is not a proto message.
+func size(msg any) int {
+	if m, ok := msg.(proto.Message); ok {
+		return proto.Size(m)
+	}
+	return 0
+}
+
+// payloadAttr returns the attributes for a logged payload, or nil when payload
+// logging is disabled or the Redactor drops it.
+func (c *config) payloadAttr(key, method string, msg any) []any {
+	if c.redactor == nil || msg == nil {
+		return nil
+	}
+	if v := c.redactor(method, msg); v != nil {
+		return []any{key, v}
+	}
+	return nil
+}
@@ -0,0 +1,219 @@
+/*
+Copyright 2026 Chainguard, Inc.
+SPDX-License-Identifier: Apache-2.0
+*/
+
+package logging
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"log/slog"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/credentials/insecure"
+	healthpb "google.golang.org/grpc/health/grpc_health_v1"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/status"
+
+	"chainguard.dev/go-grpc-kit/pkg/interceptors/clientid"
+	"github.com/chainguard-dev/clog"
+)
+
+const checkMethod = "/grpc.health.v1.Health/Check"
+
+// withLogger returns a context whose clog logger writes JSON lines to the
+// returned buffer.
+func withLogger(ctx context.Context) (context.Context, *bytes.Buffer) {
+	var buf bytes.Buffer
+	logger := clog.New(slog.NewJSONHandler(&buf, nil))
+	return clog.WithLogger(ctx, logger), &buf
+}
+
+// lines decodes the JSON log lines written to buf.
+func lines(t *testing.T, buf *bytes.Buffer) []map[string]any {
+	t.Helper()
+	var out []map[string]any
+	for _, l := range strings.Split(strings.TrimSpace(buf.String()), "\n") {
+		if l == "" {
+			continue
+		}
+		m := map[string]any{}
+		if err := json.Unmarshal([]byte(l), &m); err != nil {
+			t.Fatalf("decode log line %q: %v", l, err)
+		}
+		out = append(out, m)
+	}
+	return out
+}
+
+func callUnary(ctx context.Context, err error, opts ...Option) error {
+	_, got := UnaryServerInterceptor(opts...)(ctx, &healthpb.HealthCheckRequest{Service: "svc"},
+		&grpc.UnaryServerInfo{FullMethod: checkMethod},
+		func(context.Context, any) (any, error) {
+			if err != nil {
+				return nil, err
+			}
+			return &healthpb.HealthCheckResponse{Status: healthpb.HealthCheckResponse_SERVING}, nil
+		})
+	return got
+}
+
+func TestUnaryServerInterceptor_LogsCall(t *testing.T) {
+	ctx, buf := withLogger(metadata.NewIncomingContext(context.Background(),
+		metadata.Pairs(clientid.CGClientID, "caller", clientid.CGRequestID, "req-1")))
+
+	if err := callUnary(ctx, nil); err != nil {
+		t.Fatalf("interceptor: %v", err)
+	}
+
+	got := lines(t, buf)
+	if len(got) != 1 {
+		t.Fatalf("got %d log lines, want 1: %s", len(got), buf)
+	}
+	line := got[0]
+	for key, want := range map[string]any{
+		"level":               "INFO",
+		"grpc.method":         checkMethod,
+		"grpc.code":           "OK",
+		clientid.CGClientID:   "caller",
+		clientid.CGRequestID:  "req-1",
+		"grpc.response.bytes": float64(2),
+	} {
+		if line[key] != want {
+			t.Errorf("%s = %v, want %v", key, line[key], want)
+		}
+	}
+	if _, ok := line["duration"]; !ok {
+		t.Error("expected duration in log line")
+	}
+}
+
+func TestUnaryServerInterceptor_Sampling(t *testing.T) {
+	ctx, buf := withLogger(context.Background())
+
+	_ = callUnary(ctx, nil, WithSampleRate(0))
+	if buf.Len() != 0 {
+		t.Errorf("expected successful call to be sampled out, got %s", buf)
+	}
+
+	_ = callUnary(ctx, status.Error(codes.Internal, "boom"), WithSampleRate(0))
+	got := lines(t, buf)
+	if len(got) != 1 {
+		t.Fatalf("expected failed call to be logged, got %d lines", len(got))
+	}
+	if got[0]["level"] != "ERROR" || got[0]["grpc.code"] != "Internal" {
+		t.Errorf("got level %v code %v, want ERROR Internal", got[0]["level"], got[0]["grpc.code"])
+	}
+}
+
+func TestUnaryServerInterceptor_MethodLevel(t *testing.T) {
+	ctx, buf := withLogger(context.Background())
+
+	// The logger is at Info, so a success lowered to Debug is dropped...
+	_ = callUnary(ctx, nil, WithMethodLevel("/grpc.health.v1.Health/*", slog.LevelDebug))
+	if buf.Len() != 0 {
+		t.Errorf("expected debug-level call to be dropped, got %s", buf)
+	}
+
+	// ...while a failure keeps the level of its code.
+	_ = callUnary(ctx, status.Error(codes.NotFound, "nope"), WithMethodLevel("/grpc.health.v1.Health/*", slog.LevelDebug))
+	if got := lines(t, buf); len(got) != 1 || got[0]["level"] != "WARN" {
+		t.Errorf("expected one WARN line for the failure, got %s", buf)
+	}
+}
+
+func TestUnaryServerInterceptor_Payloads(t *testing.T) {
+	ctx, buf := withLogger(context.Background())
+
+	redact := func(_ string, msg any) any {
+		if req, ok := msg.(*healthpb.HealthCheckRequest); ok {
+			return map[string]string{"service": "REDACTED:" + req.GetService()}
+		}
+		return nil
+	}
+	_ = callUnary(ctx, nil, WithPayloads(redact))
+
+	got := lines(t, buf)
+	if len(got) != 1 {
+		t.Fatalf("got %d log lines, want 1", len(got))
+	}
+	req, ok := got[0]["grpc.request"].(map[string]any)
+	if !ok || req["service"] != "REDACTED:svc" {
+		t.Errorf("grpc.request = %v, want redacted payload", got[0]["grpc.request"])
+	}
+	if _, ok := got[0]["grpc.response"]; ok {
+		t.Error("expected the response dropped by the redactor to be omitted")
+	}
+}
+
+func TestUnaryClientInterceptor_LogsCall(t *testing.T) {
+	ctx, buf := withLogger(metadata.AppendToOutgoingContext(context.Background(), clientid.CGRequestID, "edge-req"))
+
+	cc, err := grpc.NewClient("passthrough:///example", grpc.WithTransportCredentials(insecure.NewCredentials()))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer cc.Close()
+
+	err = UnaryClientInterceptor()(ctx, checkMethod, &healthpb.HealthCheckRequest{}, &healthpb.HealthCheckResponse{}, cc,
+		func(context.Context, string, any, any, *grpc.ClientConn, ...grpc.CallOption) error {
+			return status.Error(codes.Unavailable, "down")
+		})
+	if status.Code(err) != codes.Unavailable {
+		t.Fatalf("interceptor returned %v, want Unavailable", err)
+	}
+
+	got := lines(t, buf)
+	if len(got) != 1 {
+		t.Fatalf("got %d log lines, want 1", len(got))
+	}
+	for key, want := range map[string]any{
+		"level":              "ERROR",
+		"grpc.component":     "client",
+		"grpc.code":          "Unavailable",
+		"grpc.target":        "passthrough:///example",
+		clientid.CGRequestID: "edge-req",
+	} {
+		if got[0][key] != want {
+			t.Errorf("%s = %v, want %v", key, got[0][key], want)
+		}
+	}
+}
+
+func TestHTTPMiddleware(t *testing.T) {
+	ctx, buf := withLogger(context.Background())
+
+	h := HTTPMiddleware()(http.HandlerFunc(func(w http.ResponseWriter, _ *http.Request) {
+		w.Header().Set(clientid.CGRequestID, "echoed-req")
+		w.WriteHeader(http.StatusNotFound)
+		_, _ = w.Write([]byte("missing"))
+	}))
+
+	req := httptest.NewRequestWithContext(ctx, http.MethodGet, "/v1/example/echo", nil)
+	req.Header.Set(clientid.CGClientID, "rest-caller")
+	h.ServeHTTP(httptest.NewRecorder(), req)
+
+	got := lines(t, buf)
+	if len(got) != 1 {
+		t.Fatalf("got %d log lines, want 1", len(got))
+	}
+	for key, want := range map[string]any{
+		"level":               "WARN",
+		"http.path":           "/v1/example/echo",
+		"http.status":         float64(http.StatusNotFound),
+		"http.response.bytes": float64(len("missing")),
+		clientid.CGClientID:   "rest-caller",
+		clientid.CGRequestID:  "echoed-req",
+	} {
+		if got[0][key] != want {
+			t.Errorf("%s = %v, want %v", key, got[0][key], want)
+		}
+	}
+}
@@ -0,0 +1,126 @@
+/*
+Copyright 2026 Chainguard, Inc.
+SPDX-License-Identifier: Apache-2.0
+*/
+
+package logging
+
+import (
+	"context"
+	"time"
+
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/status"
+
+	"chainguard.dev/go-grpc-kit/pkg/interceptors/clientid"
+)
+
+// UnaryServerInterceptor returns a gRPC unary server interceptor that logs one
+// line per call. It extracts the caller identity as clientid.UnaryServerInterceptor
+// does, so the line and the handler's logger both carry cgclientid and
+// cgrequestid whether or not that interceptor is also installed.
+func UnaryServerInterceptor(opts ...Option) grpc.UnaryServerInterceptor {
+	cfg := newConfig(opts)
+	identify := clientid.UnaryServerInterceptor()
+
+	return func(ctx context.Context, req any, info *grpc.UnaryServerInfo, handler grpc.UnaryHandler) (any, error) {
+		return identify(ctx, req, info, func(ctx context.Context, req any) (any, error) {
+			start := time.Now()
+			resp, err := handler(ctx, req)
+
+			code := status.Code(err)
+			if err == nil && !cfg.sampled() {
+				return resp, err
+			}
+
+			attrs := []any{
+				"grpc.component", "server",
+				"grpc.method", info.FullMethod,
+				"grpc.code", code.String(),
+				"grpc.request.bytes", size(req),
+				"grpc.response.bytes", size(resp),
+			}
+			if err != nil {
+				attrs = append(attrs, "error", err)
+			}
+			attrs = append(attrs, cfg.payloadAttr("grpc.request", info.FullMethod, req)...)
+			attrs = append(attrs, cfg.payloadAttr("grpc.response", info.FullMethod, resp)...)
+
+			emit(ctx, cfg.level(info.FullMethod, err != nil, codeLevel(code)), "finished call", start, attrs...)
+			return resp, err
+		})
+	}
+}
+
+// StreamServerInterceptor returns a gRPC stream server interceptor that logs
+// one line per stream when it ends, with the number and total size of the
+// messages sent and received. Like UnaryServerInterceptor, it extracts the
+// caller identity into the stream context.
+func StreamServerInterceptor(opts ...Option) grpc.StreamServerInterceptor {
+	cfg := newConfig(opts)
+	identify := clientid.StreamServerInterceptor()
+
+	return func(srv any, ss grpc.ServerStream, info *grpc.StreamServerInfo, handler grpc.StreamHandler) error {
+		return identify(srv, ss, info, func(srv any, ss grpc.ServerStream) error {
+			start := time.Now()
+			counted := &countingServerStream{ServerStream: ss}
+			err := handler(srv, counted)
+
+			code := status.Code(err)
+			if err == nil && !cfg.sampled() {
+				return err
+			}
+
+			attrs := []any{
+				"grpc.component", "server",
+				"grpc.method", info.FullMethod,
+				"grpc.code", code.String(),
+				"grpc.recv.messages", counted.recv.messages,
+				"grpc.recv.bytes", counted.recv.bytes,
+				"grpc.sent.messages", counted.sent.messages,
+				"grpc.sent.bytes", counted.sent.bytes,
+			}
+			if err != nil {
+				attrs = append(attrs, "error", err)
+			}
+
+			emit(ss.Context(), cfg.level(info.FullMethod, err != nil, codeLevel(code)), "finished call", start, attrs...)
+			return err
+		})
+	}
+}
+
+// messageCount tallies the messages passed over a stream in one direction.
+type messageCount struct {
+	messages int
+	bytes    int
+}
+
+func (c *messageCount) add(msg any) {
+	c.messages++
+	c.bytes += size(msg)
+}
+
+// countingServerStream is a grpc.ServerStream that counts the messages sent and
+// received over it.
+type countingServerStream struct {
+	grpc.ServerStream
+
+	sent, recv messageCount
+}
+
+func (s *countingServerStream) SendMsg(m any) error {
+	err := s.ServerStream.SendMsg(m)
+	if err == nil {
+		s.sent.add(m)
+	}
+	return err
+}
+
+func (s *countingServerStream) RecvMsg(m any) error {
+	err := s.ServerStream.RecvMsg(m)
+	if err == nil {
+		s.recv.add(m)
+	}
+	return err
+}
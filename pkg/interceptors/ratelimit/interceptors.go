@@ -0,0 +1,95 @@
+/*
+Copyright 2026 Chainguard, Inc.
+SPDX-License-Identifier: Apache-2.0
+*/
+
+// Package ratelimit provides gRPC server interceptors that cap the rate of
+// calls and the number of calls in flight per key: by default the caller's
+// cgclientid, so that one misbehaving caller cannot saturate a server. A call
+// over a limit is refused with codes.ResourceExhausted and a retry-after
+// response header giving the seconds to wait, which a Duplex's gateway returns
+// to REST callers as an HTTP 429 with a Retry-After header. Refusals are
+// counted in the grpc_server_throttled_total metric.
+package ratelimit
+
+import (
+	"context"
+	"math"
+	"strconv"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/status"
+
+	"chainguard.dev/go-grpc-kit/pkg/interceptors/clientid"
+	"github.com/chainguard-dev/clog"
+)
+
+// RetryAfter is the response header metadata key carrying the whole number of
+// seconds a refused caller should wait before retrying.
+const RetryAfter = "retry-after"
+
+var throttledTotal = prometheus.NewCounterVec(prometheus.CounterOpts{
+	Name: "grpc_server_throttled_total",
+	Help: "Number of calls refused for exceeding a rate or concurrency limit, by cgclientid and reason (rate or concurrency).",
+}, []string{"client", "reason"})
+
+func init() {
+	prometheus.MustRegister(throttledTotal)
+}
+
+// admit admits the call to method described by ctx under l, returning the
+// function to call when it ends, or the status to refuse it with.
+func (l *Limiter) admit(ctx context.Context, method string, setHeader func(metadata.MD) error) (func(), error) {
+	release, retryAfter, r, ok := l.acquire(l.key(ctx, method))
+	if ok {
+		return release, nil
+	}
+
+	client := clientid.FromContext(ctx)
+	throttledTotal.WithLabelValues(client, string(r)).Inc()
+	clog.FromContext(ctx).Info("throttled call", "method", method, "reason", r, "retryAfter", retryAfter)
+
+	secs := retryAfterSeconds(retryAfter)
+	// Best effort: this only fails if headers were already sent.
+	_ = setHeader(metadata.Pairs(RetryAfter, secs))
+	return nil, status.Errorf(codes.ResourceExhausted, "too many requests (%s limit), retry after %ss", r, secs)
+}
+
+// retryAfterSeconds rounds d up to whole seconds, of which there is at least
+// one.
+func retryAfterSeconds(d time.Duration) string {
+	return strconv.Itoa(max(1, int(math.Ceil(d.Seconds()))))
+}
+
+// UnaryServerInterceptor limits calls with l, refusing those over a limit with
+// codes.ResourceExhausted.
+func UnaryServerInterceptor(l *Limiter) grpc.UnaryServerInterceptor {
+	return func(ctx context.Context, req any, info *grpc.UnaryServerInfo, handler grpc.UnaryHandler) (any, error) {
+		release, err := l.admit(ctx, info.FullMethod, func(md metadata.MD) error {
+			return grpc.SetHeader(ctx, md)
+		})
+		if err != nil {
+			return nil, err
+		}
+		defer release()
+		return handler(ctx, req)
+	}
+}
+
+// StreamServerInterceptor is the streaming counterpart of
+// UnaryServerInterceptor. A stream counts against the rate once, when it
+// starts, and against the concurrency until it ends.
+func StreamServerInterceptor(l *Limiter) grpc.StreamServerInterceptor {
+	return func(srv any, ss grpc.ServerStream, info *grpc.StreamServerInfo, handler grpc.StreamHandler) error {
+		release, err := l.admit(ss.Context(), info.FullMethod, ss.SetHeader)
+		if err != nil {
+			return err
+		}
+		defer release()
+		return handler(srv, ss)
+	}
+}
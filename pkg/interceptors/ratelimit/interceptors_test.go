@@ -0,0 +1,123 @@
+/*
+Copyright 2026 Chainguard, Inc.
+SPDX-License-Identifier: Apache-2.0
+*/
+
+package ratelimit
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/status"
+
+	"chainguard.dev/go-grpc-kit/pkg/interceptors/clientid"
+)
+
+func TestInterceptors(t *testing.T) {
+	ctx := metadata.NewIncomingContext(context.Background(), metadata.Pairs(clientid.CGClientID, "greedy"))
+	throttled := throttledTotal.WithLabelValues("greedy", "rate")
+
+	t.Run("unary", func(t *testing.T) {
+		now := time.Unix(1_700_000_000, 0)
+		l := newTestLimiter(t, &now, WithRate(0.4, 1))
+		before := testutil.ToFloat64(throttled)
+
+		call := func() (metadata.MD, error) {
+			stream := &fakeTransportStream{}
+			_, err := UnaryServerInterceptor(l)(grpc.NewContextWithServerTransportStream(ctx, stream), nil,
+				&grpc.UnaryServerInfo{FullMethod: "/pkg.Service/Get"},
+				func(context.Context, any) (any, error) { return nil, nil })
+			return stream.header, err
+		}
+		if _, err := call(); err != nil {
+			t.Fatalf("first call = %v", err)
+		}
+		header, err := call()
+		if code := status.Code(err); code != codes.ResourceExhausted {
+			t.Fatalf("status code = %v, want %v", code, codes.ResourceExhausted)
+		}
+		// 2.5s to the next token, rounded up.
+		if got := header.Get(RetryAfter); len(got) != 1 || got[0] != "3" {
+			t.Errorf("%s = %q, want [3]", RetryAfter, got)
+		}
+		if got := testutil.ToFloat64(throttled) - before; got != 1 {
+			t.Errorf("throttled = %v, want 1", got)
+		}
+	})
+
+	t.Run("stream", func(t *testing.T) {
+		now := time.Unix(1_700_000_000, 0)
+		l := newTestLimiter(t, &now, WithMaxConcurrent(1))
+
+		// A stream holds its slot until it ends.
+		inside := make(chan struct{})
+		end := make(chan struct{})
+		done := make(chan error)
+		go func() {
+			done <- StreamServerInterceptor(l)(nil, &fakeServerStream{ctx: ctx}, &grpc.StreamServerInfo{FullMethod: "/pkg.Service/Watch"},
+				func(any, grpc.ServerStream) error {
+					close(inside)
+					<-end
+					return nil
+				})
+		}()
+		<-inside
+
+		second := &fakeServerStream{ctx: ctx}
+		err := StreamServerInterceptor(l)(nil, second, &grpc.StreamServerInfo{FullMethod: "/pkg.Service/Watch"},
+			func(any, grpc.ServerStream) error { return nil })
+		if code := status.Code(err); code != codes.ResourceExhausted {
+			t.Errorf("status code = %v, want %v", code, codes.ResourceExhausted)
+		}
+		if got := second.header.Get(RetryAfter); len(got) != 1 || got[0] != "1" {
+			t.Errorf("%s = %q, want [1]", RetryAfter, got)
+		}
+
+		close(end)
+		if err := <-done; err != nil {
+			t.Fatalf("first stream = %v", err)
+		}
+		err = StreamServerInterceptor(l)(nil, &fakeServerStream{ctx: ctx}, &grpc.StreamServerInfo{FullMethod: "/pkg.Service/Watch"},
+			func(any, grpc.ServerStream) error { return nil })
+		if err != nil {
+			t.Errorf("stream after the first ended = %v, want admitted", err)
+		}
+	})
+}
+
+// fakeServerStream is a grpc.ServerStream that records the header set on it.
+type fakeServerStream struct {
+	grpc.ServerStream
+	ctx    context.Context
+	header metadata.MD
+}
+
+func (s *fakeServerStream) Context() context.Context { return s.ctx }
+
+func (s *fakeServerStream) SetHeader(md metadata.MD) error {
+	s.header = metadata.Join(s.header, md)
+	return nil
+}
+
+// fakeTransportStream is the grpc.ServerTransportStream of a unary call, that
+// records the header set on it.
+type fakeTransportStream struct {
+	header metadata.MD
+}
+
+func (s *fakeTransportStream) Method() string { return "" }
+
+func (s *fakeTransportStream) SetHeader(md metadata.MD) error {
+	s.header = metadata.Join(s.header, md)
+	return nil
+}
+
+func (s *fakeTransportStream) SendHeader(md metadata.MD) error { return s.SetHeader(md) }
+
+func (s *fakeTransportStream) SetTrailer(metadata.MD) error { return nil }
@@ -0,0 +1,176 @@
+/*
+Copyright 2026 Chainguard, Inc.
+SPDX-License-Identifier: Apache-2.0
+*/
+
+package ratelimit
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sync"
+	"time"
+
+	"golang.org/x/time/rate"
+
+	"chainguard.dev/go-grpc-kit/pkg/interceptors/clientid"
+)
+
+const (
+	// sweepInterval is how often idle rate buckets are forgotten, so that a
+	// stream of distinct keys cannot grow the Limiter without bound.
+	sweepInterval = time.Minute
+
+	// concurrencyRetryAfter is the retry hint given to a caller refused for
+	// having too many requests in flight, which, unlike a rate, has no
+	// predictable time to wait.
+	concurrencyRetryAfter = time.Second
+)
+
+// KeyFunc returns the key a call to method is limited under. Calls with the
+// same key share a limit.
+type KeyFunc func(ctx context.Context, method string) string
+
+// ByClientID limits each caller by its cgclientid. Callers that send none
+// share a limit.
+func ByClientID(ctx context.Context, _ string) string {
+	return clientid.FromContext(ctx)
+}
+
+// ByMethod limits each method, across all callers.
+func ByMethod(_ context.Context, method string) string {
+	return method
+}
+
+// Limiter caps the rate of calls, the number of calls in flight, or both, per
+// key.
+type Limiter struct {
+	key KeyFunc
+	now func() time.Time
+
+	limit rate.Limit
+	burst int
+
+	maxConcurrent int
+
+	mu        sync.Mutex
+	buckets   map[string]*rate.Limiter
+	inflight  map[string]int
+	lastSweep time.Time
+}
+
+// Option configures a Limiter.
+type Option func(*Limiter)
+
+// WithRate limits each key to perSecond calls per second on average, in
+// bursts of up to burst calls, as a token bucket.
+func WithRate(perSecond float64, burst int) Option {
+	return func(l *Limiter) {
+		l.limit, l.burst = rate.Limit(perSecond), burst
+	}
+}
+
+// WithMaxConcurrent limits each key to n calls in flight at once. A stream
+// counts as in flight until it ends.
+func WithMaxConcurrent(n int) Option {
+	return func(l *Limiter) {
+		l.maxConcurrent = n
+	}
+}
+
+// WithKey sets how calls are keyed. The default is ByClientID.
+func WithKey(key KeyFunc) Option {
+	return func(l *Limiter) {
+		l.key = key
+	}
+}
+
+// New returns a Limiter configured by opts, which must set a rate, a maximum
+// concurrency, or both.
+func New(opts ...Option) (*Limiter, error) {
+	l := &Limiter{
+		key:      ByClientID,
+		now:      time.Now,
+		buckets:  map[string]*rate.Limiter{},
+		inflight: map[string]int{},
+	}
+	for _, o := range opts {
+		o(l)
+	}
+	switch {
+	case l.key == nil:
+		return nil, errors.New("nil KeyFunc")
+	case l.limit == 0 && l.maxConcurrent == 0:
+		return nil, errors.New("neither a rate nor a maximum concurrency is set")
+	case l.limit < 0 || (l.limit > 0 && l.burst < 1):
+		return nil, fmt.Errorf("invalid rate %v with burst %d", float64(l.limit), l.burst)
+	case l.maxConcurrent < 0:
+		return nil, fmt.Errorf("invalid maximum concurrency %d", l.maxConcurrent)
+	}
+	l.lastSweep = l.now()
+	return l, nil
+}
+
+// reason is why a call was refused, as recorded in the throttled metric.
+type reason string
+
+const (
+	reasonRate        reason = "rate"
+	reasonConcurrency reason = "concurrency"
+)
+
+// acquire admits a call under key, returning the function to call when it
+// ends, or refuses it, returning why and how long the caller should wait
+// before retrying.
+func (l *Limiter) acquire(key string) (release func(), retryAfter time.Duration, r reason, ok bool) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	now := l.now()
+	if l.maxConcurrent > 0 && l.inflight[key] >= l.maxConcurrent {
+		return nil, concurrencyRetryAfter, reasonConcurrency, false
+	}
+	if l.limit > 0 {
+		res := l.bucket(key, now).ReserveN(now, 1)
+		if delay := res.DelayFrom(now); delay > 0 {
+			res.CancelAt(now)
+			return nil, delay, reasonRate, false
+		}
+	}
+
+	if l.maxConcurrent == 0 {
+		return func() {}, 0, "", true
+	}
+	l.inflight[key]++
+	var once sync.Once
+	return func() {
+		once.Do(func() {
+			l.mu.Lock()
+			defer l.mu.Unlock()
+			if l.inflight[key]--; l.inflight[key] <= 0 {
+				delete(l.inflight, key)
+			}
+		})
+	}, 0, "", true
+}
+
+// bucket returns the token bucket for key, first forgetting the buckets that
+// have refilled, which are no different from new ones, if it is time to.
+func (l *Limiter) bucket(key string, now time.Time) *rate.Limiter {
+	if now.Sub(l.lastSweep) >= sweepInterval {
+		for k, b := range l.buckets {
+			if b.TokensAt(now) >= float64(l.burst) {
+				delete(l.buckets, k)
+			}
+		}
+		l.lastSweep = now
+	}
+
+	b, ok := l.buckets[key]
+	if !ok {
+		b = rate.NewLimiter(l.limit, l.burst)
+		l.buckets[key] = b
+	}
+	return b
+}
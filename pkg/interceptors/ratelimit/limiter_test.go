@@ -0,0 +1,141 @@
+/*
+Copyright 2026 Chainguard, Inc.
+SPDX-License-Identifier: Apache-2.0
+*/
+
+package ratelimit
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"google.golang.org/grpc/metadata"
+
+	"chainguard.dev/go-grpc-kit/pkg/interceptors/clientid"
+)
+
+// newTestLimiter returns a Limiter configured by opts whose clock is *now.
+func newTestLimiter(t *testing.T, now *time.Time, opts ...Option) *Limiter {
+	t.Helper()
+	l, err := New(opts...)
+	if err != nil {
+		t.Fatalf("New() = %v", err)
+	}
+	l.now = func() time.Time { return *now }
+	l.lastSweep = *now
+	return l
+}
+
+func TestLimiter_Rate(t *testing.T) {
+	now := time.Unix(1_700_000_000, 0)
+	l := newTestLimiter(t, &now, WithRate(2, 3))
+
+	// The burst is admitted at once, and the next call must wait for a token.
+	for i := range 3 {
+		if _, _, _, ok := l.acquire("a"); !ok {
+			t.Fatalf("call %d refused, want the burst admitted", i)
+		}
+	}
+	_, retryAfter, r, ok := l.acquire("a")
+	if ok || r != reasonRate {
+		t.Fatalf("acquire() = %t, %q; want refused for rate", ok, r)
+	}
+	if retryAfter != 500*time.Millisecond {
+		t.Errorf("retryAfter = %v, want 500ms", retryAfter)
+	}
+
+	// Another key has its own bucket.
+	if _, _, _, ok := l.acquire("b"); !ok {
+		t.Error("other key refused, want admitted")
+	}
+
+	// A refused call does not consume a token.
+	now = now.Add(500 * time.Millisecond)
+	if _, _, _, ok := l.acquire("a"); !ok {
+		t.Error("call after refilling refused, want admitted")
+	}
+	if _, _, _, ok := l.acquire("a"); ok {
+		t.Error("second call after refilling one token admitted, want refused")
+	}
+}
+
+func TestLimiter_Concurrency(t *testing.T) {
+	now := time.Unix(1_700_000_000, 0)
+	l := newTestLimiter(t, &now, WithMaxConcurrent(2))
+
+	first, _, _, ok := l.acquire("a")
+	if !ok {
+		t.Fatal("first call refused")
+	}
+	if _, _, _, ok := l.acquire("a"); !ok {
+		t.Fatal("second call refused")
+	}
+	_, retryAfter, r, ok := l.acquire("a")
+	if ok || r != reasonConcurrency || retryAfter != concurrencyRetryAfter {
+		t.Fatalf("acquire() = %t, %q, %v; want refused for concurrency", ok, r, retryAfter)
+	}
+
+	// Releasing twice frees only one slot.
+	first()
+	first()
+	if _, _, _, ok := l.acquire("a"); !ok {
+		t.Error("call after release refused, want admitted")
+	}
+	if _, _, _, ok := l.acquire("a"); ok {
+		t.Error("call over the limit admitted, want refused")
+	}
+}
+
+func TestLimiter_Sweep(t *testing.T) {
+	now := time.Unix(1_700_000_000, 0)
+	l := newTestLimiter(t, &now, WithRate(1, 1))
+
+	l.acquire("idle")
+	now = now.Add(sweepInterval)
+	l.acquire("busy")
+	if _, ok := l.buckets["idle"]; ok {
+		t.Error("refilled bucket kept after the sweep interval, want forgotten")
+	}
+	if _, ok := l.buckets["busy"]; !ok {
+		t.Error("used bucket forgotten, want kept")
+	}
+}
+
+func TestKeyFuncs(t *testing.T) {
+	ctx := metadata.NewIncomingContext(context.Background(), metadata.Pairs(clientid.CGClientID, "caller"))
+	if got := ByClientID(ctx, "/pkg.Service/Get"); got != "caller" {
+		t.Errorf("ByClientID() = %q, want %q", got, "caller")
+	}
+	if got := ByMethod(ctx, "/pkg.Service/Get"); got != "/pkg.Service/Get" {
+		t.Errorf("ByMethod() = %q, want %q", got, "/pkg.Service/Get")
+	}
+}
+
+func TestNew_Errors(t *testing.T) {
+	tests := []struct {
+		name string
+		opts []Option
+	}{{
+		name: "no limits",
+	}, {
+		name: "zero burst",
+		opts: []Option{WithRate(1, 0)},
+	}, {
+		name: "negative rate",
+		opts: []Option{WithRate(-1, 1)},
+	}, {
+		name: "negative concurrency",
+		opts: []Option{WithMaxConcurrent(-1)},
+	}, {
+		name: "nil key",
+		opts: []Option{WithMaxConcurrent(1), WithKey(nil)},
+	}}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if _, err := New(tt.opts...); err == nil {
+				t.Error("New() = nil, want error")
+			}
+		})
+	}
+}
@@ -0,0 +1,66 @@
+/*
+Copyright 2026 Chainguard, Inc.
+SPDX-License-Identifier: Apache-2.0
+*/
+
+// Package recovery provides gRPC server interceptors that turn a panic in a
+// handler into a codes.Internal status, logging the stack and counting it in
+// the grpc_server_panics_total metric, rather than crashing the process.
+package recovery
+
+import (
+	"context"
+	"runtime/debug"
+
+	grpc_recovery "github.com/grpc-ecosystem/go-grpc-middleware/v2/interceptors/recovery"
+	"github.com/prometheus/client_golang/prometheus"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+
+	"github.com/chainguard-dev/clog"
+)
+
+var panicsTotal = prometheus.NewCounterVec(prometheus.CounterOpts{
+	Name: "grpc_server_panics_total",
+	Help: "Number of panics recovered while serving requests, by method.",
+}, []string{"method"})
+
+func init() {
+	prometheus.MustRegister(panicsTotal)
+}
+
+// HandlePanic records a panic p recovered while serving method: it logs the
+// panic and the current goroutine's stack with the context's clog logger,
+// counts it in grpc_server_panics_total, and returns the codes.Internal status
+// to report to the caller. The panic value is not exposed to the caller. Call
+// it from the deferred function that recovered, so the stack still shows where
+// the panic happened.
+func HandlePanic(ctx context.Context, method string, p any) error {
+	panicsTotal.WithLabelValues(method).Inc()
+	clog.FromContext(ctx).Error("recovered from panic",
+		"method", method,
+		"panic", p,
+		"stack", string(debug.Stack()),
+	)
+	return status.Error(codes.Internal, "internal error")
+}
+
+func handlerFunc(ctx context.Context, p any) error {
+	method, _ := grpc.Method(ctx)
+	return HandlePanic(ctx, method, p)
+}
+
+// UnaryServerInterceptor returns a gRPC unary server interceptor that recovers
+// from a panic in the handler, or in interceptors chained after it, and returns
+// codes.Internal in its place. See HandlePanic.
+func UnaryServerInterceptor() grpc.UnaryServerInterceptor {
+	return grpc_recovery.UnaryServerInterceptor(grpc_recovery.WithRecoveryHandlerContext(handlerFunc))
+}
+
+// StreamServerInterceptor returns a gRPC stream server interceptor that
+// recovers from a panic in the handler, or in interceptors chained after it,
+// and returns codes.Internal in its place. See HandlePanic.
+func StreamServerInterceptor() grpc.StreamServerInterceptor {
+	return grpc_recovery.StreamServerInterceptor(grpc_recovery.WithRecoveryHandlerContext(handlerFunc))
+}
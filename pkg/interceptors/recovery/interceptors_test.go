@@ -0,0 +1,86 @@
+/*
+Copyright 2026 Chainguard, Inc.
+SPDX-License-Identifier: Apache-2.0
+*/
+
+package recovery
+
+import (
+	"context"
+	"errors"
+	"testing"
+
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+const method = "/pkg.Service/Method"
+
+// fakeTransportStream lets grpc.Method report a method outside a real server.
+type fakeTransportStream struct {
+	grpc.ServerTransportStream
+}
+
+func (fakeTransportStream) Method() string { return method }
+
+func TestUnaryServerInterceptor_RecoversPanic(t *testing.T) {
+	before := testutil.ToFloat64(panicsTotal.WithLabelValues(method))
+
+	ctx := grpc.NewContextWithServerTransportStream(context.Background(), fakeTransportStream{})
+	_, err := UnaryServerInterceptor()(ctx, nil, &grpc.UnaryServerInfo{FullMethod: method},
+		func(context.Context, any) (any, error) {
+			panic("boom")
+		})
+
+	if got := status.Code(err); got != codes.Internal {
+		t.Errorf("status code = %v, want %v", got, codes.Internal)
+	}
+	if after := testutil.ToFloat64(panicsTotal.WithLabelValues(method)); after-before != 1 {
+		t.Errorf("expected panics counter +1, got %v", after-before)
+	}
+}
+
+func TestStreamServerInterceptor_RecoversPanic(t *testing.T) {
+	before := testutil.ToFloat64(panicsTotal.WithLabelValues(method))
+
+	ctx := grpc.NewContextWithServerTransportStream(context.Background(), fakeTransportStream{})
+	err := StreamServerInterceptor()(nil, &fakeServerStream{ctx: ctx}, &grpc.StreamServerInfo{FullMethod: method},
+		func(any, grpc.ServerStream) error {
+			panic("boom")
+		})
+
+	if got := status.Code(err); got != codes.Internal {
+		t.Errorf("status code = %v, want %v", got, codes.Internal)
+	}
+	if after := testutil.ToFloat64(panicsTotal.WithLabelValues(method)); after-before != 1 {
+		t.Errorf("expected panics counter +1, got %v", after-before)
+	}
+}
+
+func TestUnaryServerInterceptor_PassesThrough(t *testing.T) {
+	want := status.Error(codes.NotFound, "missing")
+	_, err := UnaryServerInterceptor()(context.Background(), nil, &grpc.UnaryServerInfo{FullMethod: method},
+		func(context.Context, any) (any, error) {
+			return nil, want
+		})
+	if !errors.Is(err, want) {
+		t.Errorf("got %v, want %v", err, want)
+	}
+}
+
+func TestHandlePanic_HidesPanicValue(t *testing.T) {
+	err := HandlePanic(context.Background(), method, "secret detail")
+	if msg := status.Convert(err).Message(); msg != "internal error" {
+		t.Errorf("status message = %q, want %q", msg, "internal error")
+	}
+}
+
+type fakeServerStream struct {
+	grpc.ServerStream
+
+	ctx context.Context
+}
+
+func (s *fakeServerStream) Context() context.Context { return s.ctx }
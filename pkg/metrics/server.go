@@ -22,7 +22,6 @@ import (
 	"go.opentelemetry.io/otel/sdk/resource"
 	"go.opentelemetry.io/otel/sdk/trace"
 	"google.golang.org/grpc"
-	"google.golang.org/grpc/metadata"
 
 	"chainguard.dev/go-grpc-kit/pkg/interceptors/clientid"
 	"github.com/chainguard-dev/clog"
@@ -90,14 +89,17 @@ func SetupTracer(ctx context.Context) func() {
 }
 
 func labelsFromContext(ctx context.Context) prometheus.Labels {
-	cid := "unknown"
-	if clientids := metadata.ValueFromIncomingContext(ctx, clientid.CGClientID); len(clientids) > 0 {
-		cid = clientids[0]
+	cid := clientid.FromContext(ctx)
+	if cid == "" {
+		cid = "unknown"
 	}
 	return prometheus.Labels{clientid.CGClientID: cid}
 }
 
-func getServer(enablePprof bool) *http.Server {
+// Handler returns an HTTP handler serving Prometheus metrics from the default
+// gatherer at /metrics and, when enablePprof is set, the pprof endpoints under
+// /debug/pprof/.
+func Handler(enablePprof bool) http.Handler {
 	mux := http.NewServeMux()
 	mux.Handle("/metrics", promhttp.HandlerFor(
 		prometheus.DefaultGatherer,
@@ -129,15 +131,26 @@ func getServer(enablePprof bool) *http.Server {
 		clog.Infof("registering handle for /debug/pprof")
 	}
 
+	return mux
+}
+
+// Initialize registers the server metrics for every method of the services
+// registered on server, so they are reported with zero values before the first
+// call. Call this *after* all services have been registered.
+func Initialize(server *grpc.Server) {
+	state().serverMetrics.InitializeMetrics(server)
+}
+
+func getServer(enablePprof bool) *http.Server {
 	return &http.Server{
-		Handler:           mux,
+		Handler:           Handler(enablePprof),
 		ReadHeaderTimeout: 10 * time.Second,
 	}
 }
 
 // Used ONLY for testing
 func RegisterAndServe(server *grpc.Server, listener net.Listener, enablePprof bool) {
-	state().serverMetrics.InitializeMetrics(server)
+	Initialize(server)
 
 	go func() {
 		s := getServer(enablePprof)
@@ -148,8 +161,11 @@ func RegisterAndServe(server *grpc.Server, listener net.Listener, enablePprof bo
 	}()
 }
 
+// RegisterListenAndServe initializes the server metrics and serves Handler on
+// listenAddr in the background, exiting the process if serving fails. A Duplex
+// manages its metrics server instead; see duplex.WithMetrics.
 func RegisterListenAndServe(server *grpc.Server, listenAddr string, enablePprof bool) {
-	state().serverMetrics.InitializeMetrics(server)
+	Initialize(server)
 
 	go func() {
 		s := getServer(enablePprof)
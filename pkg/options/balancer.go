@@ -0,0 +1,120 @@
+/*
+Copyright 2026 Chainguard, Inc.
+SPDX-License-Identifier: Apache-2.0
+*/
+
+package options
+
+import (
+	"encoding/json"
+	"fmt"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"google.golang.org/grpc/balancer"
+	"google.golang.org/grpc/balancer/leastrequest"
+	"google.golang.org/grpc/balancer/pickfirst"
+	"google.golang.org/grpc/balancer/roundrobin"
+	"google.golang.org/grpc/connectivity"
+	"google.golang.org/grpc/resolver"
+	"google.golang.org/grpc/serviceconfig"
+)
+
+// Load balancing policies, as named by the lb query parameter of the dns and
+// static schemes.
+const (
+	RoundRobin   = "round_robin"
+	LeastRequest = "least_request"
+	PickFirst    = "pick_first"
+)
+
+// balancers maps the load balancing policies to the gRPC balancers they wrap.
+var balancers = map[string]string{
+	RoundRobin:   roundrobin.Name,
+	LeastRequest: leastrequest.Name,
+	PickFirst:    pickfirst.Name,
+}
+
+// instrumentedPrefix prefixes the names the instrumented balancers are
+// registered under.
+const instrumentedPrefix = "instrumented_"
+
+// newSubchannelState returns the gauge of subchannel states, which each Config
+// registers with its Registerer.
+func newSubchannelState() *prometheus.GaugeVec {
+	return prometheus.NewGaugeVec(prometheus.GaugeOpts{
+		Name: "grpc_client_subchannel_state",
+		Help: "1 for the connectivity state (IDLE, CONNECTING, READY or TRANSIENT_FAILURE) of each backend a load-balanced client connects to, by target and address.",
+	}, []string{"target", "address", "state"})
+}
+
+// subchannelStateKey is the key of the address attribute holding the gauge
+// the subchannel of the address reports its state to. The dns and static
+// resolvers of a Config set it, so the process-wide balancers report to the
+// gauge of the Config that dialled.
+type subchannelStateKey struct{}
+
+func init() {
+	for policy := range balancers {
+		balancer.Register(instrumentedBuilder{policy: policy})
+	}
+}
+
+// loadBalancingConfig returns the default service config selecting policy.
+func loadBalancingConfig(policy string) (string, error) {
+	if _, ok := balancers[policy]; !ok {
+		return "", fmt.Errorf("unknown load balancing policy %q, want %s, %s or %s", policy, RoundRobin, LeastRequest, PickFirst)
+	}
+	return fmt.Sprintf(`{"loadBalancingConfig": [{%q: {}}]}`, instrumentedPrefix+policy), nil
+}
+
+// instrumentedBuilder builds a gRPC balancer that exports the state of its
+// subchannels in grpc_client_subchannel_state, when their addresses carry it.
+type instrumentedBuilder struct {
+	policy string
+}
+
+func (b instrumentedBuilder) Name() string { return instrumentedPrefix + b.policy }
+
+func (b instrumentedBuilder) child() balancer.Builder {
+	return balancer.Get(balancers[b.policy])
+}
+
+func (b instrumentedBuilder) Build(cc balancer.ClientConn, opts balancer.BuildOptions) balancer.Balancer {
+	return b.child().Build(&instrumentedClientConn{ClientConn: cc, target: opts.Target.String()}, opts)
+}
+
+// ParseConfig parses the config of the wrapped balancer.
+func (b instrumentedBuilder) ParseConfig(cfg json.RawMessage) (serviceconfig.LoadBalancingConfig, error) {
+	if p, ok := b.child().(balancer.ConfigParser); ok {
+		return p.ParseConfig(cfg)
+	}
+	return nil, nil
+}
+
+// instrumentedClientConn watches the subchannels a balancer creates.
+type instrumentedClientConn struct {
+	balancer.ClientConn
+	target string
+}
+
+func (cc *instrumentedClientConn) NewSubConn(addrs []resolver.Address, opts balancer.NewSubConnOptions) (balancer.SubConn, error) {
+	if listener := opts.StateListener; listener != nil && len(addrs) > 0 {
+		gauge, _ := addrs[0].Attributes.Value(subchannelStateKey{}).(*prometheus.GaugeVec)
+		if gauge == nil {
+			return cc.ClientConn.NewSubConn(addrs, opts)
+		}
+		addr := addrs[0].Addr
+		var last connectivity.State = -1
+		opts.StateListener = func(s balancer.SubConnState) {
+			if last >= 0 {
+				gauge.DeleteLabelValues(cc.target, addr, last.String())
+			}
+			last = s.ConnectivityState
+			if last != connectivity.Shutdown {
+				gauge.WithLabelValues(cc.target, addr, last.String()).Set(1)
+			}
+			listener(s)
+		}
+	}
+	return cc.ClientConn.NewSubConn(addrs, opts)
+}
@@ -0,0 +1,439 @@
+/*
+Copyright 2026 Chainguard, Inc.
+SPDX-License-Identifier: Apache-2.0
+*/
+
+package options
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+
+	grpc_prometheus "github.com/grpc-ecosystem/go-grpc-middleware/providers/prometheus"
+	"github.com/kelseyhightower/envconfig"
+	"github.com/prometheus/client_golang/prometheus"
+	"go.opentelemetry.io/contrib/instrumentation/google.golang.org/grpc/otelgrpc"
+	"google.golang.org/api/option"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/connectivity"
+	"google.golang.org/grpc/credentials/insecure"
+	"google.golang.org/grpc/keepalive"
+
+	"chainguard.dev/go-grpc-kit/pkg/interceptors/circuitbreaker"
+	"chainguard.dev/go-grpc-kit/pkg/interceptors/clientid"
+	"chainguard.dev/go-grpc-kit/pkg/trace"
+)
+
+const defaultMsgSize = 100 * 1024 * 1024 // 100MB
+
+// Config configures the connections dialled with it. Its zero value dials with
+// the package's defaults, registering metrics on the default Prometheus
+// registerer, and reads no environment variables; ConfigFromEnv returns one
+// that does. The package-level GRPCDialOptions, GRPCOptions, DialReady and
+// ClientOptions dial with DefaultConfig, so a program that needs clients
+// configured differently, or tests that must not share state, use a Config of
+// their own. A Config that dials https with a client certificate reloads it
+// until Close is called. A Config must not be copied after first use.
+type Config struct {
+	// Registerer is where the client metrics are registered, defaulting to
+	// prometheus.DefaultRegisterer: the call, retry, hedging, subchannel and
+	// token metrics of the connections dialled with the Config. Configs
+	// sharing a Registerer share their metrics, so they must agree on the
+	// histograms they disable. A CircuitBreaker registers its own.
+	Registerer prometheus.Registerer
+
+	// DisableHandlingTimeHistogram, DisableStreamReceiveTimeHistogram and
+	// DisableStreamSendTimeHistogram leave out the client metrics' histograms
+	// (ENABLE_CLIENT_HANDLING_TIME_HISTOGRAM,
+	// ENABLE_CLIENT_STREAM_RECEIVE_TIME_HISTOGRAM and
+	// ENABLE_CLIENT_STREAM_SEND_TIME_HISTOGRAM set false).
+	DisableHandlingTimeHistogram      bool
+	DisableStreamReceiveTimeHistogram bool
+	DisableStreamSendTimeHistogram    bool
+
+	// RecvMsgSize and SendMsgSize are the largest messages a call receives and
+	// sends. The default is 100MB each.
+	RecvMsgSize, SendMsgSize int
+
+	// Keepalive, when set, pings idle connections; see KeepaliveDialOption for
+	// what the server must accept.
+	Keepalive *keepalive.ClientParameters
+
+	// HTTPS configures the TLS connections of the https scheme.
+	HTTPS TLSConfig
+
+	// Timeout, Retry and Hedging give calls default deadlines, retries and
+	// hedges, and CircuitBreaker, when set, fails them fast while their
+	// downstream is down.
+	Timeout        TimeoutConfig
+	Retry          RetryConfig
+	Hedging        HedgingConfig
+	CircuitBreaker *circuitbreaker.Breaker
+
+	// certs are the client certificates of the https connections dialled
+	// with the Config, reloaded until it is closed.
+	certs certSources
+}
+
+// ConfigFromEnv returns a Config set from the environment variables, read now:
+// the ENABLE_CLIENT_*_HISTOGRAM, GRPC_CLIENT_MAX_RETRY,
+// GRPC_CLIENT_RETRY_SERVICE_CONFIG, GRPC_CLIENT_DEFAULT_TIMEOUT and
+// GRPC_CLIENT_TLS_* variables. Set its other fields before dialling with it.
+func ConfigFromEnv() (*Config, error) {
+	var env envStruct
+	if err := envconfig.Process("", &env); err != nil {
+		return nil, fmt.Errorf("processing environment variables: %w", err)
+	}
+	c := &Config{}
+	if err := c.withEnv(env); err != nil {
+		return nil, err
+	}
+	return c, nil
+}
+
+// withEnv takes c's unset fields from env.
+func (c *Config) withEnv(env envStruct) error {
+	c.DisableHandlingTimeHistogram = c.DisableHandlingTimeHistogram || !env.EnableClientHandlingTimeHistogram
+	c.DisableStreamReceiveTimeHistogram = c.DisableStreamReceiveTimeHistogram || !env.EnableClientStreamReceiveTimeHistogram
+	c.DisableStreamSendTimeHistogram = c.DisableStreamSendTimeHistogram || !env.EnableClientStreamSendTimeHistogram
+
+	var err error
+	if c.HTTPS, err = c.HTTPS.withEnv(env); err != nil {
+		return err
+	}
+	if c.Timeout, err = c.Timeout.withEnv(env); err != nil {
+		return err
+	}
+	if c.Retry, err = c.Retry.withEnv(env); err != nil {
+		return err
+	}
+	return nil
+}
+
+// Close stops reloading the client certificates of the https connections
+// dialled with c, which keep the certificates last loaded. Dialling with c
+// after starts reloading them again. DefaultConfig is never closed.
+func (c *Config) Close() {
+	c.certs.close()
+}
+
+func (c *Config) validate() error {
+	if c.RecvMsgSize < 0 || c.SendMsgSize < 0 {
+		return fmt.Errorf("negative message size: receive %d, send %d", c.RecvMsgSize, c.SendMsgSize)
+	}
+	if err := c.Timeout.validate(); err != nil {
+		return err
+	}
+	if err := c.Retry.validate(); err != nil {
+		return err
+	}
+	return c.Hedging.validate()
+}
+
+// clientMetrics are the metrics of the connections a Config dials.
+type clientMetrics struct {
+	grpc        *grpc_prometheus.ClientMetrics
+	retries     *prometheus.CounterVec
+	hedges      *prometheus.CounterVec
+	subchannels *prometheus.GaugeVec
+	tokens      *tokenMetrics
+}
+
+// metrics returns c's metrics, registered with its Registerer, or those
+// already registered there.
+func (c *Config) metrics() (*clientMetrics, error) {
+	reg := c.Registerer
+	if reg == nil {
+		reg = prometheus.DefaultRegisterer
+	}
+
+	hopt := grpc_prometheus.WithHistogramBuckets(
+		[]float64{0.1, 0.25, 0.5, 1, 2.5, 5, 10, 30, 60, 120, 300, 600, 1200, 2400, 3666},
+	)
+	cmOpts := []grpc_prometheus.ClientMetricsOption{}
+	if !c.DisableHandlingTimeHistogram {
+		cmOpts = append(cmOpts, grpc_prometheus.WithClientHandlingTimeHistogram(hopt))
+	}
+	if !c.DisableStreamReceiveTimeHistogram {
+		cmOpts = append(cmOpts, grpc_prometheus.WithClientStreamRecvHistogram(hopt))
+	}
+	if !c.DisableStreamSendTimeHistogram {
+		cmOpts = append(cmOpts, grpc_prometheus.WithClientStreamSendHistogram(hopt))
+	}
+
+	var (
+		m   clientMetrics
+		err error
+	)
+	if m.grpc, err = register(reg, grpc_prometheus.NewClientMetrics(cmOpts...)); err != nil {
+		return nil, fmt.Errorf("registering client metrics: %w", err)
+	}
+	if m.retries, err = register(reg, newRetriesTotal()); err != nil {
+		return nil, fmt.Errorf("registering retry metrics: %w", err)
+	}
+	if m.hedges, err = register(reg, newHedgesTotal()); err != nil {
+		return nil, fmt.Errorf("registering hedging metrics: %w", err)
+	}
+	if m.subchannels, err = register(reg, newSubchannelState()); err != nil {
+		return nil, fmt.Errorf("registering subchannel metrics: %w", err)
+	}
+	if m.tokens, err = registerTokenMetrics(reg); err != nil {
+		return nil, err
+	}
+	return &m, nil
+}
+
+// register registers collector with reg, returning the collector registered
+// there already in its place, if there is one.
+func register[C prometheus.Collector](reg prometheus.Registerer, collector C) (C, error) {
+	err := reg.Register(collector)
+	if err == nil {
+		return collector, nil
+	}
+	var are prometheus.AlreadyRegisteredError
+	if errors.As(err, &are) {
+		if existing, ok := are.ExistingCollector.(C); ok {
+			return existing, nil
+		}
+	}
+	return collector, err
+}
+
+// GRPCDialOptions returns the standard set of gRPC dial options for production
+// use, including OTEL tracing, Prometheus client metrics, client identity
+// propagation, default deadlines by Timeout, the CircuitBreaker if one is set,
+// hedging by the policies in Hedging, retries by the policies in Retry, and
+// keepalive pings if Keepalive is set. It returns an error if c is invalid.
+func (c *Config) GRPCDialOptions() ([]grpc.DialOption, error) {
+	if err := c.validate(); err != nil {
+		return nil, err
+	}
+	m, err := c.metrics()
+	if err != nil {
+		return nil, err
+	}
+	return c.dialOptions(m), nil
+}
+
+// dialOptions returns the dial options of GRPCDialOptions, counted in m.
+func (c *Config) dialOptions(m *clientMetrics) []grpc.DialOption {
+	// Set default deadlines first, so everything after, hedges and retries
+	// included, is bounded by them.
+	unary := []grpc.UnaryClientInterceptor{timeoutUnaryClientInterceptor(c.Timeout), clientid.UnaryClientInterceptor(), m.grpc.UnaryClientInterceptor()}
+	stream := []grpc.StreamClientInterceptor{timeoutStreamClientInterceptor(c.Timeout), clientid.StreamClientInterceptor(), m.grpc.StreamClientInterceptor()}
+	// Break circuits outside retries, so a call failed by an open circuit is
+	// not retried, and a call's retries count once towards opening it.
+	if c.CircuitBreaker != nil {
+		unary = append(unary, circuitbreaker.UnaryClientInterceptor(c.CircuitBreaker))
+		stream = append(stream, circuitbreaker.StreamClientInterceptor(c.CircuitBreaker))
+	}
+	// Hedge outside retries, so each attempt is retried on its own.
+	unary = append(unary, hedgingUnaryClientInterceptor(c.Hedging, m.hedges), retryUnaryClientInterceptor(c.Retry, m.retries))
+	stream = append(stream, retryStreamClientInterceptor(c.Retry, m.retries))
+
+	opts := []grpc.DialOption{
+		grpc.WithStatsHandler(otelgrpc.NewClientHandler()),
+		grpc.WithStatsHandler(trace.PreserveTraceParentHandler),
+		grpc.WithChainUnaryInterceptor(unary...),
+		grpc.WithChainStreamInterceptor(stream...),
+	}
+	if c.Keepalive != nil {
+		opts = append(opts, grpc.WithKeepaliveParams(*c.Keepalive))
+	}
+	return opts
+}
+
+// ClientOptions wraps GRPCDialOptions as google.golang.org/api/option.ClientOption
+// for use with Google API clients.
+func (c *Config) ClientOptions() ([]option.ClientOption, error) {
+	do, err := c.GRPCDialOptions()
+	if err != nil {
+		return nil, err
+	}
+	cos := make([]option.ClientOption, 0, len(do))
+	for _, o := range do {
+		cos = append(cos, option.WithGRPCDialOption(o))
+	}
+	return cos, nil
+}
+
+func (c *Config) callOptions() []grpc.CallOption {
+	recv, send := c.RecvMsgSize, c.SendMsgSize
+	if recv == 0 {
+		recv = defaultMsgSize
+	}
+	if send == 0 {
+		send = defaultMsgSize
+	}
+	return []grpc.CallOption{
+		grpc.WaitForReady(true),
+		grpc.MaxCallRecvMsgSize(recv),
+		grpc.MaxCallSendMsgSize(send),
+	}
+}
+
+// dialsTLS reports whether delegate is dialled over TLS: the https scheme
+// always is, and the dns and static schemes are with tls=true.
+func dialsTLS(delegate url.URL) (bool, error) {
+	q := delegate.Query()
+	if !q.Has("tls") {
+		return delegate.Scheme == "https", nil
+	}
+	if delegate.Scheme != "dns" && delegate.Scheme != "static" {
+		return false, fmt.Errorf("tls is only supported by the dns and static schemes, got %q", delegate.Scheme)
+	}
+	secure, err := strconv.ParseBool(q.Get("tls"))
+	if err != nil {
+		return false, fmt.Errorf("invalid tls %q: %w", q.Get("tls"), err)
+	}
+	return secure, nil
+}
+
+// GRPCOptions returns a target address and dial options appropriate for the
+// given URL scheme (http, https, unix, dns, static, bufnet, or registered test
+// listeners). The dns and static schemes balance calls across replicas; see
+// loadBalancingDialOptions.
+// The https scheme, and the dns and static schemes with tls=true, connect over
+// TLS with the settings in HTTPS, and take per-RPC credentials from the URL's
+// query: auth=idtoken for a Google ID token (for Cloud Run, with the audience
+// derived from an https URL unless given as audience=...), or
+// auth=bearer&token_file=/path for a bearer token read from a file. All but
+// the bufnet and test listener schemes use GRPCDialOptions. It returns an
+// error if the URL's scheme is unsupported, or the URL or c is invalid.
+func (c *Config) GRPCOptions(delegate url.URL) (string, []grpc.DialOption, error) {
+	secure, err := dialsTLS(delegate)
+	if err != nil {
+		return "", nil, err
+	}
+	if !secure && delegate.Query().Has("auth") {
+		return "", nil, fmt.Errorf("auth requires TLS, with the https scheme or tls=true, got %q", delegate.Scheme)
+	}
+
+	var (
+		target string
+		creds  = insecure.NewCredentials()
+	)
+	switch delegate.Scheme {
+	case "http":
+		port := "80"
+		// Explicit port from the user signifies we should override the scheme-based defaults.
+		if delegate.Port() != "" {
+			port = delegate.Port()
+		}
+		target = net.JoinHostPort(delegate.Hostname(), port)
+
+	case "https":
+		port := "443"
+		// Explicit port from the user signifies we should override the scheme-based defaults.
+		if delegate.Port() != "" {
+			port = delegate.Port()
+		}
+		target = net.JoinHostPort(delegate.Hostname(), port)
+
+	case "unix": // Local Unix domain socket, e.g. unix:///path/to/sock.
+		target = delegate.String()
+
+	case "dns", "static": // Load-balanced replicas, e.g. dns:///svc.ns:8080 or static:///10.0.0.1:8080,10.0.0.2:8080.
+		target = delegate.Scheme + ":///" + strings.TrimPrefix(delegate.Path, "/")
+
+	case "bufnet": // This is to support testing, it will not pass webhook validation.
+		return "bufnet", []grpc.DialOption{
+			grpc.WithTransportCredentials(insecure.NewCredentials()),
+			grpc.WithContextDialer(func(context.Context, string) (net.Conn, error) {
+				return ListenerForTest.Dial()
+			}),
+		}, nil
+
+	default:
+		listener, ok := getTestListener(delegate.Scheme)
+		if !ok {
+			return "", nil, fmt.Errorf("unsupported scheme %q", delegate.Scheme)
+		}
+		return delegate.Scheme, []grpc.DialOption{
+			grpc.WithTransportCredentials(insecure.NewCredentials()),
+			grpc.WithContextDialer(func(context.Context, string) (net.Conn, error) {
+				return listener.Dial()
+			}),
+		}, nil
+	}
+
+	if secure {
+		if creds, err = c.HTTPS.credentials(&c.certs); err != nil {
+			return "", nil, err
+		}
+	}
+	if err := c.validate(); err != nil {
+		return "", nil, err
+	}
+	m, err := c.metrics()
+	if err != nil {
+		return "", nil, err
+	}
+	opts := append(c.dialOptions(m),
+		grpc.WithTransportCredentials(creds),
+		grpc.WithDefaultCallOptions(
+			c.callOptions()...,
+		))
+	if delegate.Scheme == "dns" || delegate.Scheme == "static" {
+		lbOpts, err := loadBalancingDialOptions(delegate, m.subchannels)
+		if err != nil {
+			return "", nil, err
+		}
+		opts = append(opts, lbOpts...)
+	}
+	authOpts, err := authDialOptions(delegate, m.tokens)
+	if err != nil {
+		return "", nil, err
+	}
+	return target, append(opts, authOpts...), nil
+}
+
+// DialReady opens a gRPC client connection to the target described by delegate
+// and blocks until its transport reaches a ready state, within timeout. A
+// timeout of zero applies the default deadline. The dial options for the
+// target's scheme (from GRPCOptions) are applied first, then opts. A target
+// that cannot be reached fails here rather than hanging the first RPC; the
+// returned connection is closed on failure.
+func (c *Config) DialReady(ctx context.Context, delegate url.URL, timeout time.Duration, opts ...grpc.DialOption) (*grpc.ClientConn, error) {
+	target, dialOpts, err := c.GRPCOptions(delegate)
+	if err != nil {
+		return nil, err
+	}
+	return dialReady(ctx, target, timeout, append(dialOpts, opts...))
+}
+
+// dialReady dials target with opts, and waits for its transport to be ready
+// within timeout, or dialReadyTimeout if timeout is zero.
+func dialReady(ctx context.Context, target string, timeout time.Duration, opts []grpc.DialOption) (*grpc.ClientConn, error) {
+	if timeout <= 0 {
+		timeout = dialReadyTimeout
+	}
+
+	conn, err := grpc.NewClient(target, opts...)
+	if err != nil {
+		return nil, err
+	}
+
+	conn.Connect()
+
+	deadline, cancel := context.WithTimeout(ctx, timeout)
+	defer cancel()
+
+	for {
+		state := conn.GetState()
+		if state == connectivity.Ready {
+			return conn, nil
+		}
+
+		if !conn.WaitForStateChange(deadline, state) {
+			conn.Close()
+			return nil, fmt.Errorf("connect to %s: not ready within %s (last state %q): %w", target, timeout, state, deadline.Err())
+		}
+	}
+}
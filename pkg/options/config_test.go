@@ -0,0 +1,155 @@
+/*
+Copyright 2026 Chainguard, Inc.
+SPDX-License-Identifier: Apache-2.0
+*/
+
+package options
+
+import (
+	"net"
+	"net/url"
+	"testing"
+	"time"
+
+	"github.com/google/go-cmp/cmp"
+	"github.com/google/go-cmp/cmp/cmpopts"
+	"github.com/prometheus/client_golang/prometheus"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/health"
+	healthpb "google.golang.org/grpc/health/grpc_health_v1"
+	"google.golang.org/grpc/status"
+)
+
+func TestConfig_Independent(t *testing.T) {
+	lis, err := net.Listen("tcp", "localhost:0")
+	if err != nil {
+		t.Fatalf("failed to listen: %v", err)
+	}
+	srv := grpc.NewServer()
+	healthpb.RegisterHealthServer(srv, health.NewServer())
+	go func() { _ = srv.Serve(lis) }()
+	defer srv.Stop()
+	u := url.URL{Scheme: "http", Host: lis.Addr().String()}
+
+	// Two clients in one process, each configured and counted on its own.
+	tests := []struct {
+		name     string
+		config   *Config
+		wantCode codes.Code
+	}{
+		{"default sizes", &Config{Registerer: prometheus.NewRegistry()}, codes.OK},
+		{"tiny messages", &Config{Registerer: prometheus.NewRegistry(), RecvMsgSize: 1}, codes.ResourceExhausted},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			conn, err := tt.config.DialReady(t.Context(), u, time.Second)
+			if err != nil {
+				t.Fatalf("DialReady() = %v", err)
+			}
+			defer conn.Close()
+
+			_, err = healthpb.NewHealthClient(conn).Check(t.Context(), &healthpb.HealthCheckRequest{})
+			if status.Code(err) != tt.wantCode {
+				t.Errorf("Check() = %v, want %v", err, tt.wantCode)
+			}
+
+			if got := handledCodes(t, tt.config.Registerer.(*prometheus.Registry)); !cmp.Equal(got, []string{tt.wantCode.String()}) {
+				t.Errorf("handled codes = %v, want [%v]", got, tt.wantCode)
+			}
+		})
+	}
+}
+
+// handledCodes returns the codes of the calls counted in reg's
+// grpc_client_handled_total.
+func handledCodes(t *testing.T, reg *prometheus.Registry) []string {
+	t.Helper()
+	mfs, err := reg.Gather()
+	if err != nil {
+		t.Fatalf("Gather() = %v", err)
+	}
+	var got []string
+	for _, mf := range mfs {
+		if mf.GetName() != "grpc_client_handled_total" {
+			continue
+		}
+		for _, m := range mf.GetMetric() {
+			for _, l := range m.GetLabel() {
+				if l.GetName() == "grpc_code" {
+					got = append(got, l.GetValue())
+				}
+			}
+		}
+	}
+	return got
+}
+
+func TestConfig_SharedRegisterer(t *testing.T) {
+	reg := prometheus.NewRegistry()
+	for range 2 {
+		c := &Config{Registerer: reg, Retry: RetryConfig{Default: RetryPolicy{MaxAttempts: 2}}}
+		if _, err := c.GRPCDialOptions(); err != nil {
+			t.Fatalf("GRPCDialOptions() = %v", err)
+		}
+	}
+
+	// Configs sharing a registerer must agree on their histograms.
+	c := &Config{Registerer: reg, DisableHandlingTimeHistogram: true}
+	if _, err := c.GRPCDialOptions(); err == nil {
+		t.Error("GRPCDialOptions() with other histograms = nil, want error")
+	}
+}
+
+func TestConfig_Errors(t *testing.T) {
+	for _, c := range []*Config{
+		{RecvMsgSize: -1},
+		{Timeout: TimeoutConfig{Default: -time.Second}},
+		{Retry: RetryConfig{Methods: map[string]RetryPolicy{"pkg.Service/Get": {}}}},
+		{Hedging: HedgingConfig{Methods: map[string]HedgingPolicy{"/pkg.Service/Get": {}}}},
+	} {
+		c.Registerer = prometheus.NewRegistry()
+		if _, err := c.GRPCDialOptions(); err == nil {
+			t.Errorf("GRPCDialOptions() with %+v = nil, want error", c)
+		}
+		if _, _, err := c.GRPCOptions(url.URL{Scheme: "http", Host: "localhost"}); err == nil {
+			t.Errorf("GRPCOptions() with %+v = nil, want error", c)
+		}
+	}
+}
+
+func TestConfig_UnsupportedScheme(t *testing.T) {
+	c := &Config{Registerer: prometheus.NewRegistry()}
+	if _, _, err := c.GRPCOptions(url.URL{Scheme: "unknown", Host: "example.com"}); err == nil {
+		t.Error("GRPCOptions() = nil, want error")
+	}
+	if _, err := c.DialReady(t.Context(), url.URL{Scheme: "unknown", Host: "example.com"}, time.Second); err == nil {
+		t.Error("DialReady() = nil, want error")
+	}
+}
+
+func TestConfigFromEnv(t *testing.T) {
+	t.Setenv("ENABLE_CLIENT_STREAM_SEND_TIME_HISTOGRAM", "false")
+	t.Setenv("GRPC_CLIENT_MAX_RETRY", "3")
+	t.Setenv("GRPC_CLIENT_DEFAULT_TIMEOUT", "5s")
+	t.Setenv("GRPC_CLIENT_TLS_SERVER_NAME", "svc.example.com")
+
+	c, err := ConfigFromEnv()
+	if err != nil {
+		t.Fatalf("ConfigFromEnv() = %v", err)
+	}
+	want := &Config{
+		DisableStreamSendTimeHistogram: true,
+		HTTPS:                          TLSConfig{ServerName: "svc.example.com", MinVersion: tlsVersions["1.2"]},
+		Timeout:                        TimeoutConfig{Default: 5 * time.Second},
+		Retry:                          RetryConfig{Default: RetryPolicy{MaxAttempts: 3}, AllMethods: true},
+	}
+	if diff := cmp.Diff(want, c, cmpopts.IgnoreUnexported(Config{})); diff != "" {
+		t.Errorf("ConfigFromEnv() -want,+got: %s", diff)
+	}
+
+	t.Setenv("GRPC_CLIENT_DEFAULT_TIMEOUT", "soon")
+	if _, err := ConfigFromEnv(); err == nil {
+		t.Error("ConfigFromEnv() with an invalid timeout = nil, want error")
+	}
+}
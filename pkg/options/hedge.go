@@ -0,0 +1,258 @@
+/*
+Copyright 2026 Chainguard, Inc.
+SPDX-License-Identifier: Apache-2.0
+*/
+
+package options
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"slices"
+	"sync"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/peer"
+	"google.golang.org/grpc/status"
+	"google.golang.org/protobuf/proto"
+
+	"chainguard.dev/go-grpc-kit/internal/methodmatch"
+)
+
+const (
+	defaultHedgeBudgetRatio = 0.1
+	defaultHedgeBudgetBurst = 10
+
+	hedgeWon       = "won"
+	hedgeLost      = "lost"
+	hedgeThrottled = "throttled"
+)
+
+// newHedgesTotal returns the counter of hedges, which each Config registers
+// with its Registerer.
+func newHedgesTotal() *prometheus.CounterVec {
+	return prometheus.NewCounterVec(prometheus.CounterOpts{
+		Name: "grpc_client_hedges_total",
+		Help: "Hedged attempts by method and result: won or lost against the first attempt, or throttled by the hedging budget and not sent.",
+	}, []string{"method", "result"})
+}
+
+// HedgingPolicy says how a call is hedged.
+type HedgingPolicy struct {
+	// Delay is how long the first attempt may take before a second is sent,
+	// such as the method's 95th percentile latency. It must be positive.
+	Delay time.Duration
+
+	// NonFatalCodes are the status codes an attempt may fail with while the
+	// other is still in flight and the call waits for it. Any other outcome
+	// of either attempt ends the call. The default is codes.Unavailable.
+	NonFatalCodes []codes.Code
+}
+
+// HedgingConfig says which calls made with a Config's dial options are hedged. A
+// hedged call sends a second attempt when the first has not finished within
+// its policy's Delay, takes whichever finishes first, and cancels the other.
+// This cuts the tail latency a single slow replica causes, at the cost of
+// the hedges' load, so only unary methods that are safe to send twice (those
+// that are idempotent, typically reads) should be given a policy. Streams are
+// never hedged.
+type HedgingConfig struct {
+	// Methods maps method patterns to their policy, with the same patterns as
+	// RetryConfig.Methods. Methods without a policy are not hedged.
+	Methods map[string]HedgingPolicy
+
+	// BudgetRatio and BudgetBurst cap the hedges a connection sends. Each
+	// hedgeable call earns BudgetRatio of a token, up to BudgetBurst tokens,
+	// and each hedge spends one, so that while replicas are slow across the
+	// board hedges add at most BudgetRatio to the load. The defaults are 0.1
+	// and 10.
+	BudgetRatio float64
+	BudgetBurst float64
+}
+
+func (c HedgingConfig) validate() error {
+	if c.BudgetRatio < 0 || c.BudgetBurst < 0 {
+		return errors.New("negative hedging budget")
+	}
+	for pattern, p := range c.Methods {
+		if err := validatePattern(pattern); err != nil {
+			return fmt.Errorf("hedging policy %w", err)
+		}
+		if p.Delay <= 0 {
+			return fmt.Errorf("hedging policy for %s: delay %v must be positive", pattern, p.Delay)
+		}
+		if slices.Contains(p.NonFatalCodes, codes.OK) {
+			return fmt.Errorf("hedging policy for %s: OK is not a non-fatal code", pattern)
+		}
+	}
+	return nil
+}
+
+// nonFatal reports whether an attempt failing with err leaves the call waiting
+// for the other attempt.
+func (p HedgingPolicy) nonFatal(err error) bool {
+	if err == nil {
+		return false
+	}
+	if len(p.NonFatalCodes) == 0 {
+		return status.Code(err) == codes.Unavailable
+	}
+	return slices.Contains(p.NonFatalCodes, status.Code(err))
+}
+
+// hedgeBudget is a token bucket that hedgeable calls fill and hedges drain.
+type hedgeBudget struct {
+	ratio, burst float64
+
+	mu     sync.Mutex
+	tokens float64
+}
+
+func newHedgeBudget(c HedgingConfig) *hedgeBudget {
+	b := &hedgeBudget{ratio: c.BudgetRatio, burst: c.BudgetBurst}
+	if b.ratio == 0 {
+		b.ratio = defaultHedgeBudgetRatio
+	}
+	if b.burst == 0 {
+		b.burst = defaultHedgeBudgetBurst
+	}
+	b.tokens = b.burst
+	return b
+}
+
+// earn adds a hedgeable call's share of a token.
+func (b *hedgeBudget) earn() {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.tokens = min(b.burst, b.tokens+b.ratio)
+}
+
+// spend takes a token for a hedge, reporting whether there was one.
+func (b *hedgeBudget) spend() bool {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if b.tokens < 1 {
+		return false
+	}
+	b.tokens--
+	return true
+}
+
+// hedgeAttempt is one attempt at a hedged call, holding what it would return
+// to the caller.
+type hedgeAttempt struct {
+	hedge   bool
+	reply   proto.Message
+	header  metadata.MD
+	trailer metadata.MD
+	peer    peer.Peer
+	err     error
+}
+
+// callOptions returns opts with the options that return results to the
+// caller redirected into a, so that concurrent attempts do not race to write
+// them.
+func (a *hedgeAttempt) callOptions(opts []grpc.CallOption) []grpc.CallOption {
+	out := make([]grpc.CallOption, 0, len(opts))
+	for _, o := range opts {
+		switch o.(type) {
+		case grpc.HeaderCallOption:
+			o = grpc.Header(&a.header)
+		case grpc.TrailerCallOption:
+			o = grpc.Trailer(&a.trailer)
+		case grpc.PeerCallOption:
+			o = grpc.Peer(&a.peer)
+		}
+		out = append(out, o)
+	}
+	return out
+}
+
+// commit returns a's results to the caller, into reply and opts.
+func (a *hedgeAttempt) commit(reply proto.Message, opts []grpc.CallOption) error {
+	for _, o := range opts {
+		switch o := o.(type) {
+		case grpc.HeaderCallOption:
+			*o.HeaderAddr = a.header
+		case grpc.TrailerCallOption:
+			*o.TrailerAddr = a.trailer
+		case grpc.PeerCallOption:
+			*o.PeerAddr = a.peer
+		}
+	}
+	if a.err != nil {
+		return a.err
+	}
+	proto.Reset(reply)
+	proto.Merge(reply, a.reply)
+	return nil
+}
+
+// hedgingUnaryClientInterceptor hedges unary calls by the policy c gives them,
+// counting the hedges in hedges. Each interceptor has its own budget, so that
+// each connection a Config dials does.
+func hedgingUnaryClientInterceptor(c HedgingConfig, hedges *prometheus.CounterVec) grpc.UnaryClientInterceptor {
+	budget := newHedgeBudget(c)
+	return func(ctx context.Context, method string, req, reply any, cc *grpc.ClientConn, invoker grpc.UnaryInvoker, opts ...grpc.CallOption) error {
+		p, ok := methodmatch.Best(c.Methods, method)
+		if !ok {
+			return invoker(ctx, method, req, reply, cc, opts...)
+		}
+		// Each attempt needs a reply of its own to decode into.
+		msg, ok := reply.(proto.Message)
+		if !ok {
+			return invoker(ctx, method, req, reply, cc, opts...)
+		}
+		// Take the type now, as the winner may write to reply while the loser
+		// is still starting.
+		mt := msg.ProtoReflect().Type()
+		budget.earn()
+
+		// Returning cancels the attempt that lost.
+		ctx, cancel := context.WithCancel(ctx)
+		defer cancel()
+
+		results := make(chan *hedgeAttempt, 2)
+		send := func(hedge bool) {
+			a := &hedgeAttempt{hedge: hedge, reply: mt.New().Interface()}
+			a.err = invoker(ctx, method, req, a.reply, cc, a.callOptions(opts)...)
+			results <- a
+		}
+		go send(false)
+
+		timer := time.NewTimer(p.Delay)
+		defer timer.Stop()
+		inflight, hedged := 1, false
+		for {
+			select {
+			case <-timer.C:
+				if !budget.spend() {
+					hedges.WithLabelValues(method, hedgeThrottled).Inc()
+					continue
+				}
+				hedged = true
+				inflight++
+				go send(true)
+
+			case a := <-results:
+				inflight--
+				if p.nonFatal(a.err) && inflight > 0 {
+					continue
+				}
+				if hedged {
+					result := hedgeLost
+					if a.hedge {
+						result = hedgeWon
+					}
+					hedges.WithLabelValues(method, result).Inc()
+				}
+				return a.commit(msg, opts)
+			}
+		}
+	}
+}
@@ -0,0 +1,209 @@
+/*
+Copyright 2026 Chainguard, Inc.
+SPDX-License-Identifier: Apache-2.0
+*/
+
+package options
+
+import (
+	"context"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	healthpb "google.golang.org/grpc/health/grpc_health_v1"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/status"
+)
+
+// hedgeOutcome is how an attempt made by hedgedInvoker ends: after delay, with
+// code, replying with serving.
+type hedgeOutcome struct {
+	delay   time.Duration
+	code    codes.Code
+	serving healthpb.HealthCheckResponse_ServingStatus
+}
+
+// hedgedInvoker returns an invoker whose attempts end as outcomes say, in
+// order, counting them in attempts. An attempt that is cancelled ends early.
+func hedgedInvoker(attempts *atomic.Int64, outcomes ...hedgeOutcome) grpc.UnaryInvoker {
+	return func(ctx context.Context, _ string, _, reply any, _ *grpc.ClientConn, opts ...grpc.CallOption) error {
+		o := outcomes[attempts.Add(1)-1]
+		select {
+		case <-time.After(o.delay):
+		case <-ctx.Done():
+			return status.FromContextError(ctx.Err()).Err()
+		}
+		for _, opt := range opts {
+			if h, ok := opt.(grpc.HeaderCallOption); ok {
+				*h.HeaderAddr = metadata.Pairs("serving", o.serving.String())
+			}
+		}
+		if o.code != codes.OK {
+			return status.Error(o.code, "failed")
+		}
+		reply.(*healthpb.HealthCheckResponse).Status = o.serving
+		return nil
+	}
+}
+
+func TestHedgingUnaryClientInterceptor(t *testing.T) {
+	const (
+		method = "/grpc.health.v1.Health/Check"
+		delay  = 20 * time.Millisecond
+		slow   = time.Second
+	)
+	c := HedgingConfig{Methods: map[string]HedgingPolicy{"/grpc.health.v1.Health/*": {Delay: delay}}}
+
+	tests := []struct {
+		name         string
+		method       string
+		outcomes     []hedgeOutcome
+		wantAttempts int64
+		wantCode     codes.Code
+		wantServing  healthpb.HealthCheckResponse_ServingStatus
+		wantResult   string
+	}{{
+		name:         "fast first attempt",
+		method:       method,
+		outcomes:     []hedgeOutcome{{0, codes.OK, healthpb.HealthCheckResponse_SERVING}},
+		wantAttempts: 1,
+		wantServing:  healthpb.HealthCheckResponse_SERVING,
+	}, {
+		name:   "no policy",
+		method: "/pkg.Service/Create",
+		outcomes: []hedgeOutcome{
+			{2 * delay, codes.OK, healthpb.HealthCheckResponse_SERVING},
+		},
+		wantAttempts: 1,
+		wantServing:  healthpb.HealthCheckResponse_SERVING,
+	}, {
+		name:   "hedge wins",
+		method: method,
+		outcomes: []hedgeOutcome{
+			{slow, codes.OK, healthpb.HealthCheckResponse_SERVING},
+			{0, codes.OK, healthpb.HealthCheckResponse_NOT_SERVING},
+		},
+		wantAttempts: 2,
+		wantServing:  healthpb.HealthCheckResponse_NOT_SERVING,
+		wantResult:   hedgeWon,
+	}, {
+		name:   "hedge loses",
+		method: method,
+		outcomes: []hedgeOutcome{
+			{2 * delay, codes.OK, healthpb.HealthCheckResponse_SERVING},
+			{slow, codes.OK, healthpb.HealthCheckResponse_NOT_SERVING},
+		},
+		wantAttempts: 2,
+		wantServing:  healthpb.HealthCheckResponse_SERVING,
+		wantResult:   hedgeLost,
+	}, {
+		name:   "non-fatal failure waits for the hedge",
+		method: method,
+		outcomes: []hedgeOutcome{
+			{2 * delay, codes.Unavailable, 0},
+			{4 * delay, codes.OK, healthpb.HealthCheckResponse_NOT_SERVING},
+		},
+		wantAttempts: 2,
+		wantServing:  healthpb.HealthCheckResponse_NOT_SERVING,
+		wantResult:   hedgeWon,
+	}, {
+		name:   "fatal failure ends the call",
+		method: method,
+		outcomes: []hedgeOutcome{
+			{2 * delay, codes.NotFound, 0},
+			{slow, codes.OK, healthpb.HealthCheckResponse_NOT_SERVING},
+		},
+		wantAttempts: 2,
+		wantCode:     codes.NotFound,
+		wantResult:   hedgeLost,
+	}}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			hedges := newHedgesTotal()
+			var attempts atomic.Int64
+			var reply healthpb.HealthCheckResponse
+			var header metadata.MD
+			start := time.Now()
+			err := hedgingUnaryClientInterceptor(c, hedges)(t.Context(), tt.method, &healthpb.HealthCheckRequest{}, &reply, nil,
+				hedgedInvoker(&attempts, tt.outcomes...), grpc.Header(&header))
+			if status.Code(err) != tt.wantCode {
+				t.Fatalf("status code = %v, want %v", status.Code(err), tt.wantCode)
+			}
+			if elapsed := time.Since(start); elapsed >= slow {
+				t.Errorf("call took %v, waiting for the slow attempt", elapsed)
+			}
+			if got := attempts.Load(); got != tt.wantAttempts {
+				t.Errorf("attempts = %d, want %d", got, tt.wantAttempts)
+			}
+			if tt.wantCode == codes.OK {
+				if reply.Status != tt.wantServing {
+					t.Errorf("reply status = %v, want %v", reply.Status, tt.wantServing)
+				}
+				if got := header.Get("serving"); len(got) != 1 || got[0] != tt.wantServing.String() {
+					t.Errorf("header serving = %v, want %v", got, tt.wantServing)
+				}
+			}
+			if tt.wantResult != "" {
+				if got := testutil.ToFloat64(hedges.WithLabelValues(tt.method, tt.wantResult)); got != 1 {
+					t.Errorf("hedges %s = %v, want 1", tt.wantResult, got)
+				}
+			}
+		})
+	}
+}
+
+func TestHedgingUnaryClientInterceptor_Budget(t *testing.T) {
+	const method = "/pkg.Service/Get"
+	c := HedgingConfig{
+		Methods:     map[string]HedgingPolicy{method: {Delay: time.Millisecond}},
+		BudgetRatio: 0.01,
+		BudgetBurst: 1,
+	}
+	hedges := newHedgesTotal()
+	interceptor := hedgingUnaryClientInterceptor(c, hedges)
+
+	var attempts atomic.Int64
+	invoker := hedgedInvoker(&attempts,
+		hedgeOutcome{50 * time.Millisecond, codes.OK, 0},
+		hedgeOutcome{50 * time.Millisecond, codes.OK, 0},
+		hedgeOutcome{50 * time.Millisecond, codes.OK, 0},
+	)
+	for range 2 {
+		if err := interceptor(t.Context(), method, nil, &healthpb.HealthCheckResponse{}, nil, invoker); err != nil {
+			t.Fatalf("interceptor() = %v", err)
+		}
+	}
+	// The first call spends the only token, so the second is not hedged.
+	if got := attempts.Load(); got != 3 {
+		t.Errorf("attempts = %d, want 3", got)
+	}
+	if got := testutil.ToFloat64(hedges.WithLabelValues(method, hedgeThrottled)); got != 1 {
+		t.Errorf("throttled = %v, want 1", got)
+	}
+}
+
+func TestHedgingConfig_Validate(t *testing.T) {
+	tests := []struct {
+		name    string
+		c       HedgingConfig
+		wantErr bool
+	}{
+		{"empty", HedgingConfig{}, false},
+		{"valid", HedgingConfig{Methods: map[string]HedgingPolicy{"/pkg.Service/*": {Delay: time.Millisecond}}}, false},
+		{"bad pattern", HedgingConfig{Methods: map[string]HedgingPolicy{"pkg.Service/*": {Delay: time.Millisecond}}}, true},
+		{"no delay", HedgingConfig{Methods: map[string]HedgingPolicy{"/pkg.Service/Get": {}}}, true},
+		{"OK non-fatal", HedgingConfig{Methods: map[string]HedgingPolicy{"/pkg.Service/Get": {Delay: time.Millisecond, NonFatalCodes: []codes.Code{codes.OK}}}}, true},
+		{"negative budget", HedgingConfig{BudgetRatio: -1}, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if err := tt.c.validate(); (err != nil) != tt.wantErr {
+				t.Errorf("validate() = %v, wantErr %v", err, tt.wantErr)
+			}
+		})
+	}
+}
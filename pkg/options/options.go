@@ -8,7 +8,6 @@ package options
 import (
 	"context"
 	"crypto/rand"
-	"crypto/tls"
 	"fmt"
 	"math"
 	"math/big"
@@ -17,20 +16,13 @@ import (
 	"sync"
 	"time"
 
-	grpc_prometheus "github.com/grpc-ecosystem/go-grpc-middleware/providers/prometheus"
-	grpc_retry "github.com/grpc-ecosystem/go-grpc-middleware/v2/interceptors/retry"
-	"github.com/kelseyhightower/envconfig"
 	"github.com/prometheus/client_golang/prometheus"
-	"go.opentelemetry.io/contrib/instrumentation/google.golang.org/grpc/otelgrpc"
 	"google.golang.org/api/option"
 	"google.golang.org/grpc"
-	"google.golang.org/grpc/connectivity"
-	"google.golang.org/grpc/credentials"
 	"google.golang.org/grpc/credentials/insecure"
 	"google.golang.org/grpc/keepalive"
 
 	"chainguard.dev/go-grpc-kit/pkg/interceptors/clientid"
-	"chainguard.dev/go-grpc-kit/pkg/trace"
 	"github.com/chainguard-dev/clog"
 )
 
@@ -39,44 +31,17 @@ type envStruct struct {
 	EnableClientStreamReceiveTimeHistogram bool `envconfig:"ENABLE_CLIENT_STREAM_RECEIVE_TIME_HISTOGRAM" default:"true"`
 	EnableClientStreamSendTimeHistogram    bool `envconfig:"ENABLE_CLIENT_STREAM_SEND_TIME_HISTOGRAM" default:"true"`
 	GrpcClientMaxRetry                     uint `envconfig:"GRPC_CLIENT_MAX_RETRY" default:"0"`
-}
-
-type initStuff struct {
-	env           envStruct
-	clientMetrics *grpc_prometheus.ClientMetrics
-}
-
-var (
-	state = sync.OnceValue(func() initStuff {
-		init := initStuff{}
-
-		logger := clog.FromContext(context.Background())
-		if err := envconfig.Process("", &init.env); err != nil {
-			logger.Warn("Failed to process environment variables", "error", err)
-		}
-
-		hopt := grpc_prometheus.WithHistogramBuckets(
-			[]float64{0.1, 0.25, 0.5, 1, 2.5, 5, 10, 30, 60, 120, 300, 600, 1200, 2400, 3666},
-		)
-
-		cmOpts := []grpc_prometheus.ClientMetricsOption{}
 
-		if init.env.EnableClientHandlingTimeHistogram {
-			cmOpts = append(cmOpts, grpc_prometheus.WithClientHandlingTimeHistogram(hopt))
-		}
-		if init.env.EnableClientStreamReceiveTimeHistogram {
-			cmOpts = append(cmOpts, grpc_prometheus.WithClientStreamRecvHistogram(hopt))
-		}
-		if init.env.EnableClientStreamSendTimeHistogram {
-			cmOpts = append(cmOpts, grpc_prometheus.WithClientStreamSendHistogram(hopt))
-		}
+	GrpcClientRetryServiceConfig string `envconfig:"GRPC_CLIENT_RETRY_SERVICE_CONFIG"`
 
-		init.clientMetrics = grpc_prometheus.NewClientMetrics(cmOpts...)
-		prometheus.MustRegister(init.clientMetrics)
+	GrpcClientDefaultTimeout time.Duration `envconfig:"GRPC_CLIENT_DEFAULT_TIMEOUT"`
 
-		return init
-	})
-)
+	GrpcClientTLSRootCAs    []string `envconfig:"GRPC_CLIENT_TLS_ROOT_CAS"`
+	GrpcClientTLSCertFile   string   `envconfig:"GRPC_CLIENT_TLS_CERT_FILE"`
+	GrpcClientTLSKeyFile    string   `envconfig:"GRPC_CLIENT_TLS_KEY_FILE"`
+	GrpcClientTLSServerName string   `envconfig:"GRPC_CLIENT_TLS_SERVER_NAME"`
+	GrpcClientTLSMinVersion string   `envconfig:"GRPC_CLIENT_TLS_MIN_VERSION" default:"1.2"`
+}
 
 // ListenerForTest is to support bufnet in our testing.
 var ListenerForTest DialableListener
@@ -117,10 +82,11 @@ func getTestListener(scheme string) (DialableListener, bool) {
 }
 
 // These are defined as global variables, so that folks can expose them as flags
-// in their entrypoints, if they choose.
+// in their entrypoints, if they choose. They are the message sizes of the
+// package-level GRPCOptions and DialReady, unless DefaultConfig sets its own.
 var (
-	RecvMsgSize = 100 * 1024 * 1024 // 100MB
-	SendMsgSize = 100 * 1024 * 1024 // 100MB
+	RecvMsgSize = defaultMsgSize
+	SendMsgSize = defaultMsgSize
 )
 
 const (
@@ -142,9 +108,10 @@ const (
 // rather than holding the next RPC open until it times out. It pings even with
 // no active RPCs (PermitWithoutStream), so the server must be configured with a
 // matching keepalive.EnforcementPolicy (MinTime no greater than the ping
-// interval, and PermitWithoutStream true). A server using gRPC's defaults
+// interval, and PermitWithoutStream true). A grpc.Server using gRPC's defaults
 // rejects these pings with a GOAWAY, which is why this is offered as an opt-in
-// option rather than folded into GRPCDialOptions.
+// option rather than folded into GRPCDialOptions. A duplex.Duplex accepts them
+// without configuration.
 func KeepaliveDialOption() grpc.DialOption {
 	return grpc.WithKeepaliveParams(keepalive.ClientParameters{
 		Time:                keepaliveTime,
@@ -153,18 +120,6 @@ func KeepaliveDialOption() grpc.DialOption {
 	})
 }
 
-// ClientOptions wraps GRPCDialOptions as google.golang.org/api/option.ClientOption
-// for use with Google API clients.
-func ClientOptions() []option.ClientOption {
-	do := GRPCDialOptions()
-	cos := make([]option.ClientOption, 0, len(do))
-
-	for _, o := range do {
-		cos = append(cos, option.WithGRPCDialOption(o))
-	}
-	return cos
-}
-
 // LoopbackDialOptions returns a minimal set of dial options suitable for
 // grpc-gateway loopback connections. It includes the clientid interceptor
 // (so the server sees who originated the REST call) but omits client-side
@@ -178,121 +133,120 @@ func LoopbackDialOptions() []grpc.DialOption {
 	}
 }
 
-// GRPCDialOptions returns the standard set of gRPC dial options for production
-// use, including OTEL tracing, Prometheus client metrics, client identity
-// propagation, and retry support.
+// defaultConfig is the Config of DefaultConfig, read from the environment the
+// first time it is needed.
+var defaultConfig = sync.OnceValues(ConfigFromEnv)
+
+// DefaultConfig returns the Config the package-level GRPCDialOptions,
+// GRPCOptions, ClientOptions and DialReady dial with: the one ConfigFromEnv
+// returns, read the first time it is needed. Every call returns the same
+// Config, so an entrypoint may set its fields, before dialling, to configure
+// the package-level functions. It returns an error if the environment is
+// invalid, and so does every later call.
+func DefaultConfig() (*Config, error) {
+	return defaultConfig()
+}
+
+// fallbackConfig is the Config the package-level functions dial with when
+// DefaultConfig is invalid: the package's defaults.
+var fallbackConfig = &Config{}
+
+// GRPCDialOptions is a wrapper over DefaultConfig's GRPCDialOptions; see
+// Config.GRPCDialOptions. If DefaultConfig is invalid, it logs why and returns
+// those of the package's defaults. Call the Config method to get the error
+// instead.
 func GRPCDialOptions() []grpc.DialOption {
-	retryOpts := []grpc_retry.CallOption{
-		grpc_retry.WithBackoff(grpc_retry.BackoffExponential(100 * time.Millisecond)),
-		grpc_retry.WithMax(state().env.GrpcClientMaxRetry),
+	c, err := DefaultConfig()
+	if err == nil {
+		var opts []grpc.DialOption
+		if opts, err = c.GRPCDialOptions(); err == nil {
+			return opts
+		}
 	}
+	clog.FromContext(context.Background()).Error("Invalid gRPC client configuration, dialling with the defaults", "error", err)
 
-	return []grpc.DialOption{
-		grpc.WithStatsHandler(otelgrpc.NewClientHandler()),
-		grpc.WithStatsHandler(trace.PreserveTraceParentHandler),
-		grpc.WithChainUnaryInterceptor(clientid.UnaryClientInterceptor(), state().clientMetrics.UnaryClientInterceptor(), grpc_retry.UnaryClientInterceptor(retryOpts...)),
-		grpc.WithChainStreamInterceptor(clientid.StreamClientInterceptor(), state().clientMetrics.StreamClientInterceptor(), grpc_retry.StreamClientInterceptor(retryOpts...)),
+	opts, err := fallbackConfig.GRPCDialOptions()
+	if err != nil {
+		// The defaults are valid, so only registering their metrics can have
+		// failed: dial without exporting them.
+		opts, _ = (&Config{Registerer: prometheus.NewRegistry()}).GRPCDialOptions()
 	}
+	return opts
 }
 
-func grpcCallOptions() []grpc.CallOption {
-	return []grpc.CallOption{
-		grpc.WaitForReady(true),
-		grpc.MaxCallRecvMsgSize(RecvMsgSize),
-		grpc.MaxCallSendMsgSize(SendMsgSize),
+// ClientOptions is a wrapper over DefaultConfig's ClientOptions: it wraps
+// GRPCDialOptions as google.golang.org/api/option.ClientOption for use with
+// Google API clients. If DefaultConfig is invalid, it logs why and uses the
+// package's defaults.
+func ClientOptions() []option.ClientOption {
+	do := GRPCDialOptions()
+	cos := make([]option.ClientOption, 0, len(do))
+
+	for _, o := range do {
+		cos = append(cos, option.WithGRPCDialOption(o))
 	}
+	return cos
 }
 
-// GRPCOptions returns a target address and dial options appropriate for the
-// given URL scheme (http, https, unix, bufnet, or registered test listeners).
+// GRPCOptions is a wrapper over DefaultConfig's GRPCOptions: it returns a
+// target address and dial options appropriate for the given URL scheme; see
+// Config.GRPCOptions. RecvMsgSize and SendMsgSize bound its calls' messages,
+// unless DefaultConfig sets its own. If DefaultConfig is invalid, it logs why
+// and uses the package's defaults. It panics if the URL is invalid, which
+// DialReady reports as an error instead.
 func GRPCOptions(delegate url.URL) (string, []grpc.DialOption) {
-	switch delegate.Scheme {
-	case "http":
-		port := "80"
-		// Explicit port from the user signifies we should override the scheme-based defaults.
-		if delegate.Port() != "" {
-			port = delegate.Port()
-		}
-		return net.JoinHostPort(delegate.Hostname(), port), append(GRPCDialOptions(), []grpc.DialOption{
-			grpc.WithTransportCredentials(insecure.NewCredentials()),
-			grpc.WithDefaultCallOptions(
-				grpcCallOptions()...,
-			)}...)
-	case "https":
-		port := "443"
-		// Explicit port from the user signifies we should override the scheme-based defaults.
-		if delegate.Port() != "" {
-			port = delegate.Port()
-		}
-		return net.JoinHostPort(delegate.Hostname(), port), append(GRPCDialOptions(), []grpc.DialOption{
-			grpc.WithTransportCredentials(credentials.NewTLS(&tls.Config{
-				MinVersion: tls.VersionTLS12,
-			})),
-			grpc.WithDefaultCallOptions(
-				grpcCallOptions()...,
-			)}...)
-
-	case "unix": // Local Unix domain socket, e.g. unix:///path/to/sock.
-		return delegate.String(), append(GRPCDialOptions(), []grpc.DialOption{
-			grpc.WithTransportCredentials(insecure.NewCredentials()),
-			grpc.WithDefaultCallOptions(
-				grpcCallOptions()...,
-			)}...)
-
-	case "bufnet": // This is to support testing, it will not pass webhook validation.
-		return "bufnet", []grpc.DialOption{
-			grpc.WithTransportCredentials(insecure.NewCredentials()),
-			grpc.WithContextDialer(func(context.Context, string) (net.Conn, error) {
-				return ListenerForTest.Dial()
-			}),
+	c, err := DefaultConfig()
+	if err == nil {
+		var (
+			target string
+			opts   []grpc.DialOption
+		)
+		if target, opts, err = grpcOptions(c, delegate); err == nil {
+			return target, opts
 		}
+	}
+	clog.FromContext(context.Background()).Error("Invalid gRPC client configuration, dialling with the defaults", "error", err)
 
-	default:
-		listener, ok := getTestListener(delegate.Scheme)
-		if !ok {
-			panic("unreachable for valid delegates.")
-		}
-		return delegate.Scheme, []grpc.DialOption{
-			grpc.WithTransportCredentials(insecure.NewCredentials()),
-			grpc.WithContextDialer(func(context.Context, string) (net.Conn, error) {
-				return listener.Dial()
-			}),
-		}
+	target, opts, err := grpcOptions(fallbackConfig, delegate)
+	if err != nil {
+		panic(fmt.Sprintf("invalid dial target: %v", err))
 	}
+	return target, opts
 }
 
-// DialReady opens a gRPC client connection to the target described by delegate
-// and blocks until its transport reaches a ready state, within timeout. A
-// timeout of zero applies the default deadline. The dial options for the
-// target's scheme (from GRPCOptions) are applied first, then opts. A target
-// that cannot be reached fails here rather than hanging the first RPC; the
-// returned connection is closed on failure.
-func DialReady(ctx context.Context, delegate url.URL, timeout time.Duration, opts ...grpc.DialOption) (*grpc.ClientConn, error) {
-	if timeout <= 0 {
-		timeout = dialReadyTimeout
+// grpcOptions returns the GRPCOptions of c, with the messages of their calls
+// bounded by RecvMsgSize and SendMsgSize where c sets no bound of its own.
+func grpcOptions(c *Config, delegate url.URL) (string, []grpc.DialOption, error) {
+	target, opts, err := c.GRPCOptions(delegate)
+	if err != nil {
+		return "", nil, err
 	}
+	var sizes []grpc.CallOption
+	if c.RecvMsgSize == 0 {
+		sizes = append(sizes, grpc.MaxCallRecvMsgSize(RecvMsgSize))
+	}
+	if c.SendMsgSize == 0 {
+		sizes = append(sizes, grpc.MaxCallSendMsgSize(SendMsgSize))
+	}
+	if len(sizes) > 0 {
+		opts = append(opts, grpc.WithDefaultCallOptions(sizes...))
+	}
+	return target, opts, nil
+}
 
-	target, dialOpts := GRPCOptions(delegate)
-
-	conn, err := grpc.NewClient(target, append(dialOpts, opts...)...)
+// DialReady is a wrapper over DefaultConfig's DialReady: it opens a gRPC client
+// connection to the target described by delegate, and blocks until its
+// transport reaches a ready state, within timeout; see Config.DialReady.
+// RecvMsgSize and SendMsgSize bound its calls' messages, unless DefaultConfig
+// sets its own. It returns an error if DefaultConfig is invalid.
+func DialReady(ctx context.Context, delegate url.URL, timeout time.Duration, opts ...grpc.DialOption) (*grpc.ClientConn, error) {
+	c, err := DefaultConfig()
 	if err != nil {
 		return nil, err
 	}
-
-	conn.Connect()
-
-	deadline, cancel := context.WithTimeout(ctx, timeout)
-	defer cancel()
-
-	for {
-		state := conn.GetState()
-		if state == connectivity.Ready {
-			return conn, nil
-		}
-
-		if !conn.WaitForStateChange(deadline, state) {
-			conn.Close()
-			return nil, fmt.Errorf("connect to %s: not ready within %s (last state %q): %w", target, timeout, state, deadline.Err())
-		}
+	target, dialOpts, err := grpcOptions(c, delegate)
+	if err != nil {
+		return nil, err
 	}
+	return dialReady(ctx, target, timeout, append(dialOpts, opts...))
 }
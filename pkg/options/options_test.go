@@ -11,38 +11,70 @@ import (
 	"net/url"
 	"os"
 	"strconv"
+	"strings"
+	"sync/atomic"
 	"testing"
 	"time"
 
+	"chainguard.dev/go-grpc-kit/pkg/interceptors/circuitbreaker"
 	"github.com/google/go-cmp/cmp"
+	"github.com/prometheus/client_golang/prometheus"
 	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
 	"google.golang.org/grpc/connectivity"
+	"google.golang.org/grpc/health"
+	healthpb "google.golang.org/grpc/health/grpc_health_v1"
+	"google.golang.org/grpc/status"
 )
 
-func TestGetEnv(t *testing.T) {
+func TestDefaultConfig(t *testing.T) {
 	old := os.Getenv("GRPC_CLIENT_MAX_RETRY")
 	defer os.Setenv("GRPC_CLIENT_MAX_RETRY", old)
 
-	want := envStruct{
-		EnableClientHandlingTimeHistogram:      true,
-		EnableClientStreamReceiveTimeHistogram: true,
-		EnableClientStreamSendTimeHistogram:    true,
-		GrpcClientMaxRetry:                     42,
-	}
+	want := RetryPolicy{MaxAttempts: 42}
 	t.Run("can change env right before usage", func(t *testing.T) {
-		os.Setenv("GRPC_CLIENT_MAX_RETRY", strconv.Itoa(int(want.GrpcClientMaxRetry)))
-		if diff := cmp.Diff(want, state().env); diff != "" {
-			t.Errorf("getEnv() -want,+got: %s", diff)
+		os.Setenv("GRPC_CLIENT_MAX_RETRY", strconv.Itoa(int(want.MaxAttempts)))
+		c, err := DefaultConfig()
+		if err != nil {
+			t.Fatalf("DefaultConfig() = %v", err)
+		}
+		if diff := cmp.Diff(want, c.Retry.Default); diff != "" {
+			t.Errorf("DefaultConfig() retry -want,+got: %s", diff)
 		}
 	})
 	t.Run("but cannot change after usage", func(t *testing.T) {
-		os.Setenv("GRPC_CLIENT_MAX_RETRY", strconv.Itoa(int(want.GrpcClientMaxRetry+10)))
-		if diff := cmp.Diff(want, state().env); diff != "" {
-			t.Errorf("getEnv() -want,+got: %s", diff)
+		os.Setenv("GRPC_CLIENT_MAX_RETRY", strconv.Itoa(int(want.MaxAttempts+10)))
+		c, err := DefaultConfig()
+		if err != nil {
+			t.Fatalf("DefaultConfig() = %v", err)
+		}
+		if diff := cmp.Diff(want, c.Retry.Default); diff != "" {
+			t.Errorf("DefaultConfig() retry -want,+got: %s", diff)
 		}
 	})
 }
 
+func TestDefaultConfig_InvalidFallsBack(t *testing.T) {
+	c, err := DefaultConfig()
+	if err != nil {
+		t.Fatalf("DefaultConfig() = %v", err)
+	}
+	old := c.Timeout
+	c.Timeout = TimeoutConfig{Default: -time.Second}
+	t.Cleanup(func() { c.Timeout = old })
+
+	if _, err := c.GRPCDialOptions(); err == nil {
+		t.Error("GRPCDialOptions() = nil, want error")
+	}
+	// The package-level functions log the error and dial with the defaults.
+	if opts := GRPCDialOptions(); len(opts) == 0 {
+		t.Error("expected non-empty dial options")
+	}
+	if addr, opts := GRPCOptions(url.URL{Scheme: "http", Host: "example.com"}); addr != "example.com:80" || len(opts) == 0 {
+		t.Errorf("GRPCOptions() = %q and %d options, want example.com:80 and some", addr, len(opts))
+	}
+}
+
 func TestGRPCOptions_HTTP(t *testing.T) {
 	u, _ := url.Parse("http://example.com")
 	addr, opts := GRPCOptions(*u)
@@ -164,6 +196,44 @@ func TestGRPCDialOptions_ReturnsNonEmpty(t *testing.T) {
 	}
 }
 
+func TestGRPCDialOptions_CircuitBreaker(t *testing.T) {
+	b, err := circuitbreaker.New(circuitbreaker.WithRegisterer(prometheus.NewRegistry()), circuitbreaker.WithThreshold(1, 2))
+	if err != nil {
+		t.Fatalf("circuitbreaker.New() = %v", err)
+	}
+	c := &Config{Registerer: prometheus.NewRegistry(), CircuitBreaker: b}
+
+	lis, err := net.Listen("tcp", "localhost:0")
+	if err != nil {
+		t.Fatalf("failed to listen: %v", err)
+	}
+	var calls atomic.Int64
+	srv := grpc.NewServer(grpc.UnaryInterceptor(func(context.Context, any, *grpc.UnaryServerInfo, grpc.UnaryHandler) (any, error) {
+		calls.Add(1)
+		return nil, status.Error(codes.Unavailable, "down")
+	}))
+	healthpb.RegisterHealthServer(srv, health.NewServer())
+	go func() { _ = srv.Serve(lis) }()
+	defer srv.Stop()
+
+	conn, err := c.DialReady(t.Context(), url.URL{Scheme: "http", Host: lis.Addr().String()}, time.Second)
+	if err != nil {
+		t.Fatalf("DialReady: %v", err)
+	}
+	defer conn.Close()
+
+	client := healthpb.NewHealthClient(conn)
+	for range 3 {
+		_, err = client.Check(t.Context(), &healthpb.HealthCheckRequest{})
+	}
+	if status.Code(err) != codes.Unavailable || !strings.Contains(err.Error(), "circuit breaker open") {
+		t.Errorf("Check() = %v, want the circuit breaker open", err)
+	}
+	if got := calls.Load(); got != 2 {
+		t.Errorf("calls served = %d, want 2", got)
+	}
+}
+
 func TestLoopbackDialOptions_ReturnsNonEmpty(t *testing.T) {
 	opts := LoopbackDialOptions()
 	if len(opts) == 0 {
@@ -0,0 +1,264 @@
+/*
+Copyright 2026 Chainguard, Inc.
+SPDX-License-Identifier: Apache-2.0
+*/
+
+package options
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"net/url"
+	"os"
+	"sync"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"golang.org/x/oauth2"
+	"google.golang.org/api/idtoken"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/credentials/oauth"
+
+	"github.com/chainguard-dev/clog"
+)
+
+const (
+	// tokenEarlyExpiry is how long before a token expires it is refreshed, so
+	// a token is not sent that expires in flight.
+	tokenEarlyExpiry = time.Minute
+
+	// tokenFileTTL is how long a token read from a file is used before the file
+	// is read again, to pick up a rotated token.
+	tokenFileTTL = time.Minute
+)
+
+// The token sources, for the source label of the token metrics.
+const (
+	sourceIDToken     = "idtoken"
+	sourceTokenFile   = "token_file"
+	sourceTokenSource = "token_source"
+)
+
+// tokenMetrics count the tokens per-RPC credentials fetch.
+type tokenMetrics struct {
+	refreshes *prometheus.CounterVec
+	expiry    *prometheus.GaugeVec
+}
+
+// registerTokenMetrics returns the token metrics registered with reg, or those
+// already registered there. On error, it returns them unregistered.
+func registerTokenMetrics(reg prometheus.Registerer) (*tokenMetrics, error) {
+	var (
+		m   tokenMetrics
+		err error
+	)
+	if m.refreshes, err = register(reg, prometheus.NewCounterVec(prometheus.CounterOpts{
+		Name: "grpc_client_token_refreshes_total",
+		Help: "Number of times a per-RPC credential token was fetched rather than served from the cache, by source and result (success or failure).",
+	}, []string{"source", "result"})); err != nil {
+		return &m, fmt.Errorf("registering token metrics: %w", err)
+	}
+	if m.expiry, err = register(reg, prometheus.NewGaugeVec(prometheus.GaugeOpts{
+		Name: "grpc_client_token_expiry_timestamp_seconds",
+		Help: "Expiry of the most recently fetched per-RPC credential token, as a Unix timestamp, by source.",
+	}, []string{"source"})); err != nil {
+		return &m, fmt.Errorf("registering token metrics: %w", err)
+	}
+	return &m, nil
+}
+
+// defaultTokenMetrics are the metrics of the credentials made by the
+// package-level functions, registered on the default registerer the first
+// time one is made. Credentials selected by a Config's URL are counted on its
+// Registerer instead.
+var defaultTokenMetrics = sync.OnceValue(func() *tokenMetrics {
+	m, err := registerTokenMetrics(prometheus.DefaultRegisterer)
+	if err != nil {
+		clog.FromContext(context.Background()).Warn("Failed to register token metrics", "error", err)
+	}
+	return m
+})
+
+// TokenSourceCredentials returns a dial option that authenticates every RPC
+// with a bearer token from ts, in the authorization header. Tokens are cached
+// until a minute before they expire. The connection must use TLS.
+func TokenSourceCredentials(ts oauth2.TokenSource) grpc.DialOption {
+	return perRPCCredentials(sourceTokenSource, ts, defaultTokenMetrics())
+}
+
+// GoogleIDTokenCredentials returns a dial option that authenticates every RPC
+// with a Google ID token for audience, using the application default
+// credentials, as Cloud Run services that require authentication expect. For
+// Cloud Run, the audience is the service's URL, e.g.
+// https://my-service-abc123-uc.a.run.app.
+// Tokens are cached by the ID token source, until shortly before they expire.
+func GoogleIDTokenCredentials(ctx context.Context, audience string) (grpc.DialOption, error) {
+	return googleIDTokenCredentials(ctx, audience, defaultTokenMetrics())
+}
+
+func googleIDTokenCredentials(ctx context.Context, audience string, m *tokenMetrics) (grpc.DialOption, error) {
+	ts, err := idtoken.NewTokenSource(ctx, audience)
+	if err != nil {
+		return nil, fmt.Errorf("creating ID token source: %w", err)
+	}
+	// The source caches its tokens itself, so they are counted as it fetches
+	// them rather than cached again.
+	return grpc.WithPerRPCCredentials(oauth.TokenSource{
+		TokenSource: &meteredCachingTokenSource{source: sourceIDToken, ts: ts, metrics: m},
+	}), nil
+}
+
+// BearerTokenFileCredentials returns a dial option that authenticates every RPC
+// with the bearer token in the file at path, such as a projected service
+// account token. The file is read again every minute, to pick up a rotated
+// token. It returns an error if the file cannot be read now.
+func BearerTokenFileCredentials(path string) (grpc.DialOption, error) {
+	return bearerTokenFileCredentials(path, defaultTokenMetrics())
+}
+
+func bearerTokenFileCredentials(path string, m *tokenMetrics) (grpc.DialOption, error) {
+	ts := tokenFile(path)
+	if _, err := ts.Token(); err != nil {
+		return nil, err
+	}
+	return perRPCCredentials(sourceTokenFile, ts, m), nil
+}
+
+// perRPCCredentials returns a dial option attaching tokens from ts, cached and
+// counted under source in m.
+func perRPCCredentials(source string, ts oauth2.TokenSource, m *tokenMetrics) grpc.DialOption {
+	return grpc.WithPerRPCCredentials(oauth.TokenSource{
+		TokenSource: oauth2.ReuseTokenSourceWithExpiry(nil, meteredTokenSource{source: source, ts: ts, metrics: m}, tokenEarlyExpiry),
+	})
+}
+
+// meteredTokenSource counts the tokens fetched from ts, and records their
+// expiry.
+type meteredTokenSource struct {
+	source  string
+	ts      oauth2.TokenSource
+	metrics *tokenMetrics
+}
+
+func (m meteredTokenSource) Token() (*oauth2.Token, error) {
+	tok, err := m.ts.Token()
+	if err != nil {
+		m.metrics.failed(m.source)
+		return nil, err
+	}
+	m.metrics.fetched(m.source, tok)
+	return tok, nil
+}
+
+// meteredCachingTokenSource counts the tokens a caching ts fetches, telling
+// them from those served from its cache by the token changing, and records
+// their expiry. A failure is counted each time, as ts has no token to serve.
+type meteredCachingTokenSource struct {
+	source  string
+	ts      oauth2.TokenSource
+	metrics *tokenMetrics
+
+	mu   sync.Mutex
+	last string
+}
+
+func (m *meteredCachingTokenSource) Token() (*oauth2.Token, error) {
+	tok, err := m.ts.Token()
+	if err != nil {
+		m.metrics.failed(m.source)
+		return nil, err
+	}
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if tok.AccessToken != m.last {
+		m.last = tok.AccessToken
+		m.metrics.fetched(m.source, tok)
+	}
+	return tok, nil
+}
+
+// fetched counts tok fetched from source, and records its expiry.
+func (m *tokenMetrics) fetched(source string, tok *oauth2.Token) {
+	m.refreshes.WithLabelValues(source, "success").Inc()
+	if !tok.Expiry.IsZero() {
+		m.expiry.WithLabelValues(source).Set(float64(tok.Expiry.Unix()))
+	}
+}
+
+// failed counts a failure to fetch a token from source.
+func (m *tokenMetrics) failed(source string) {
+	m.refreshes.WithLabelValues(source, "failure").Inc()
+}
+
+// tokenFile is a token source that reads a bearer token from a file.
+type tokenFile string
+
+func (f tokenFile) Token() (*oauth2.Token, error) {
+	b, err := os.ReadFile(string(f)) //nolint:gosec // G304: the path is the caller's own configuration
+	if err != nil {
+		return nil, fmt.Errorf("reading bearer token: %w", err)
+	}
+	tok := string(bytes.TrimSpace(b))
+	if tok == "" {
+		return nil, fmt.Errorf("bearer token file %s is empty", string(f))
+	}
+	return &oauth2.Token{
+		AccessToken: tok,
+		TokenType:   "Bearer",
+		// Not when the token expires, but when to read the file again.
+		Expiry: time.Now().Add(tokenFileTTL + tokenEarlyExpiry),
+	}, nil
+}
+
+// authDialOptions returns the per-RPC credentials selected by the query of
+// delegate: auth=idtoken for a Google ID token, with the audience in the
+// audience parameter or else derived from delegate, or auth=bearer for a
+// bearer token read from the file in the token_file parameter. Their tokens
+// are counted in m.
+func authDialOptions(delegate url.URL, m *tokenMetrics) ([]grpc.DialOption, error) {
+	q := delegate.Query()
+	switch auth := q.Get("auth"); auth {
+	case "":
+		return nil, nil
+
+	case "idtoken":
+		audience := q.Get("audience")
+		if audience == "" {
+			if delegate.Scheme != "https" {
+				return nil, fmt.Errorf("auth=idtoken on a %s target requires an audience parameter", delegate.Scheme)
+			}
+			audience = idTokenAudience(delegate)
+		}
+		opt, err := googleIDTokenCredentials(context.Background(), audience, m)
+		if err != nil {
+			return nil, err
+		}
+		return []grpc.DialOption{opt}, nil
+
+	case "bearer":
+		path := q.Get("token_file")
+		if path == "" {
+			return nil, errors.New("auth=bearer requires a token_file parameter")
+		}
+		opt, err := bearerTokenFileCredentials(path, m)
+		if err != nil {
+			return nil, err
+		}
+		return []grpc.DialOption{opt}, nil
+
+	default:
+		return nil, fmt.Errorf("unknown auth %q: must be idtoken or bearer", auth)
+	}
+}
+
+// idTokenAudience returns the audience Cloud Run expects in ID tokens for
+// delegate: its scheme and host, without the default port.
+func idTokenAudience(delegate url.URL) string {
+	host := delegate.Host
+	if delegate.Port() == "443" {
+		host = delegate.Hostname()
+	}
+	return (&url.URL{Scheme: delegate.Scheme, Host: host}).String()
+}
@@ -0,0 +1,273 @@
+/*
+Copyright 2026 Chainguard, Inc.
+SPDX-License-Identifier: Apache-2.0
+*/
+
+package options
+
+import (
+	"context"
+	"fmt"
+	"net/url"
+	"os"
+	"path/filepath"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	"golang.org/x/oauth2"
+	"google.golang.org/grpc"
+	healthpb "google.golang.org/grpc/health/grpc_health_v1"
+	"google.golang.org/grpc/metadata"
+)
+
+func TestTokenSourceCredentials(t *testing.T) {
+	tests := []struct {
+		name        string
+		expiry      time.Duration
+		wantFetches int64
+	}{{
+		name:        "cached until expiry",
+		expiry:      time.Hour,
+		wantFetches: 1,
+	}, {
+		name:        "refreshed when about to expire",
+		expiry:      tokenEarlyExpiry / 2,
+		wantFetches: 3,
+	}}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			ts := &countingTokenSource{expiry: tt.expiry}
+			conn, seen := dialAuthenticated(t, prometheus.NewRegistry(), url.URL{}, TokenSourceCredentials(ts))
+
+			for range 3 {
+				if _, err := healthpb.NewHealthClient(conn).Check(t.Context(), &healthpb.HealthCheckRequest{}); err != nil {
+					t.Fatalf("Check() = %v", err)
+				}
+			}
+			if got := ts.fetches.Load(); got != tt.wantFetches {
+				t.Errorf("token fetches = %d, want %d", got, tt.wantFetches)
+			}
+			if got, want := seen(), "Bearer token"; got != want {
+				t.Errorf("authorization = %q, want %q", got, want)
+			}
+		})
+	}
+}
+
+func TestBearerTokenFileCredentials(t *testing.T) {
+	tokenPath := filepath.Join(t.TempDir(), "token")
+	if err := os.WriteFile(tokenPath, []byte("hunter2\n"), 0o600); err != nil {
+		t.Fatal(err)
+	}
+
+	// Selected by the URL, as a dial path would be configured, and counted on
+	// the Config's registerer.
+	reg := prometheus.NewRegistry()
+	q := url.Values{"auth": {"bearer"}, "token_file": {tokenPath}}
+	conn, seen := dialAuthenticated(t, reg, url.URL{RawQuery: q.Encode()})
+	for range 2 {
+		if _, err := healthpb.NewHealthClient(conn).Check(t.Context(), &healthpb.HealthCheckRequest{}); err != nil {
+			t.Fatalf("Check() = %v", err)
+		}
+	}
+	if got, want := seen(), "Bearer hunter2"; got != want {
+		t.Errorf("authorization = %q, want %q", got, want)
+	}
+
+	// Load-balanced targets send it over TLS too.
+	q.Set("tls", "true")
+	conn, seen = dialAuthenticated(t, prometheus.NewRegistry(), url.URL{Scheme: "static", RawQuery: q.Encode()})
+	if _, err := healthpb.NewHealthClient(conn).Check(t.Context(), &healthpb.HealthCheckRequest{}); err != nil {
+		t.Fatalf("Check() = %v", err)
+	}
+	if got, want := seen(), "Bearer hunter2"; got != want {
+		t.Errorf("authorization over static with tls = %q, want %q", got, want)
+	}
+	// The second RPC is served from the cache.
+	m, err := registerTokenMetrics(reg)
+	if err != nil {
+		t.Fatalf("registerTokenMetrics() = %v", err)
+	}
+	if got := testutil.ToFloat64(m.refreshes.WithLabelValues(sourceTokenFile, "success")); got != 1 {
+		t.Errorf("refreshes = %v, want 1", got)
+	}
+}
+
+func TestMeteredCachingTokenSource(t *testing.T) {
+	m, err := registerTokenMetrics(prometheus.NewRegistry())
+	if err != nil {
+		t.Fatalf("registerTokenMetrics() = %v", err)
+	}
+	// A source that caches its tokens itself, as idtoken's does.
+	var fetches atomic.Int64
+	ts := oauth2.ReuseTokenSource(nil, tokenSourceFunc(func() (*oauth2.Token, error) {
+		n := fetches.Add(1)
+		return &oauth2.Token{AccessToken: fmt.Sprint("token", n), Expiry: time.Now().Add(time.Duration(n) * time.Hour)}, nil
+	}))
+	metered := &meteredCachingTokenSource{source: sourceIDToken, ts: ts, metrics: m}
+
+	for range 3 {
+		if _, err := metered.Token(); err != nil {
+			t.Fatalf("Token() = %v", err)
+		}
+	}
+	if got := testutil.ToFloat64(m.refreshes.WithLabelValues(sourceIDToken, "success")); got != 1 {
+		t.Errorf("refreshes = %v, want 1", got)
+	}
+
+	// Once it fetches another, that is counted too.
+	metered.ts = tokenSourceFunc(func() (*oauth2.Token, error) {
+		return &oauth2.Token{AccessToken: "rotated", Expiry: time.Now().Add(time.Hour)}, nil
+	})
+	if _, err := metered.Token(); err != nil {
+		t.Fatalf("Token() = %v", err)
+	}
+	if got := testutil.ToFloat64(m.refreshes.WithLabelValues(sourceIDToken, "success")); got != 2 {
+		t.Errorf("refreshes = %v, want 2", got)
+	}
+}
+
+type tokenSourceFunc func() (*oauth2.Token, error)
+
+func (f tokenSourceFunc) Token() (*oauth2.Token, error) { return f() }
+
+func TestAuthDialOptions_Errors(t *testing.T) {
+	dir := t.TempDir()
+	empty := filepath.Join(dir, "empty")
+	if err := os.WriteFile(empty, nil, 0o600); err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name     string
+		delegate string
+	}{{
+		name:     "unknown auth",
+		delegate: "https://example.com?auth=basic",
+	}, {
+		name:     "bearer without token file",
+		delegate: "https://example.com?auth=bearer",
+	}, {
+		name:     "missing token file",
+		delegate: "https://example.com?auth=bearer&token_file=" + filepath.Join(dir, "missing"),
+	}, {
+		name:     "empty token file",
+		delegate: "https://example.com?auth=bearer&token_file=" + empty,
+	}, {
+		name:     "cleartext",
+		delegate: "http://example.com?auth=bearer&token_file=" + empty,
+	}, {
+		name:     "cleartext load balanced",
+		delegate: "dns:///svc.ns:8080?tls=false&auth=bearer&token_file=" + empty,
+	}, {
+		name:     "idtoken load balanced without audience",
+		delegate: "static:///10.0.0.1:8080?tls=true&auth=idtoken",
+	}}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			u, err := url.Parse(tt.delegate)
+			if err != nil {
+				t.Fatalf("parse %q: %v", tt.delegate, err)
+			}
+			c := &Config{Registerer: prometheus.NewRegistry()}
+			if _, _, err := c.GRPCOptions(*u); err == nil {
+				t.Error("GRPCOptions() = nil, want error")
+			}
+		})
+	}
+}
+
+func TestIDTokenAudience(t *testing.T) {
+	tests := []struct {
+		delegate string
+		want     string
+	}{
+		{"https://svc-abc123-uc.a.run.app", "https://svc-abc123-uc.a.run.app"},
+		{"https://svc-abc123-uc.a.run.app:443?auth=idtoken", "https://svc-abc123-uc.a.run.app"},
+		{"https://svc.example.com:8443/ignored?auth=idtoken", "https://svc.example.com:8443"},
+	}
+
+	for _, tt := range tests {
+		u, err := url.Parse(tt.delegate)
+		if err != nil {
+			t.Fatalf("parse %q: %v", tt.delegate, err)
+		}
+		if got := idTokenAudience(*u); got != tt.want {
+			t.Errorf("idTokenAudience(%q) = %q, want %q", tt.delegate, got, tt.want)
+		}
+	}
+}
+
+// dialAuthenticated dials a TLS health server, at an https URL with the query
+// of delegate, or a static one when delegate's scheme is static, with opts and
+// a Config registering its metrics with reg, and returns the connection and a
+// function reporting the last authorization header the server received.
+func dialAuthenticated(t *testing.T, reg prometheus.Registerer, delegate url.URL, opts ...grpc.DialOption) (*grpc.ClientConn, func() string) {
+	t.Helper()
+
+	var (
+		mu            sync.Mutex
+		authorization string
+	)
+	record := func(ctx context.Context, req any, _ *grpc.UnaryServerInfo, handler grpc.UnaryHandler) (any, error) {
+		md, _ := metadata.FromIncomingContext(ctx)
+		mu.Lock()
+		authorization = firstOf(md.Get("authorization"))
+		mu.Unlock()
+		return handler(ctx, req)
+	}
+
+	certFile, keyFile := writeTestCertificate(t, t.TempDir())
+	addr := serveMTLS(t, certFile, keyFile, grpc.UnaryInterceptor(record))
+	if delegate.Scheme == "static" {
+		delegate.Path = "/" + addr
+	} else {
+		delegate.Scheme, delegate.Host = "https", addr
+	}
+	c := &Config{Registerer: reg, HTTPS: TLSConfig{
+		RootCAFiles: []string{certFile},
+		CertFile:    certFile,
+		KeyFile:     keyFile,
+		ServerName:  "localhost",
+	}}
+
+	conn, err := c.DialReady(t.Context(), delegate, 5*time.Second, opts...)
+	if err != nil {
+		t.Fatalf("DialReady: %v", err)
+	}
+	t.Cleanup(func() { conn.Close() })
+
+	return conn, func() string {
+		mu.Lock()
+		defer mu.Unlock()
+		return authorization
+	}
+}
+
+func firstOf(values []string) string {
+	if len(values) == 0 {
+		return ""
+	}
+	return values[0]
+}
+
+// countingTokenSource returns tokens valid for expiry, counting them.
+type countingTokenSource struct {
+	expiry  time.Duration
+	fetches atomic.Int64
+}
+
+func (ts *countingTokenSource) Token() (*oauth2.Token, error) {
+	ts.fetches.Add(1)
+	return &oauth2.Token{
+		AccessToken: "token",
+		TokenType:   "Bearer",
+		Expiry:      time.Now().Add(ts.expiry),
+	}, nil
+}
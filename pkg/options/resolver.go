@@ -0,0 +1,250 @@
+/*
+Copyright 2026 Chainguard, Inc.
+SPDX-License-Identifier: Apache-2.0
+*/
+
+package options
+
+import (
+	"cmp"
+	"context"
+	"fmt"
+	"net"
+	"net/url"
+	"slices"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/attributes"
+	"google.golang.org/grpc/resolver"
+
+	"github.com/chainguard-dev/clog"
+)
+
+const (
+	// defaultRefreshInterval is how often the dns scheme re-resolves its host
+	// when the URL does not say.
+	defaultRefreshInterval = 30 * time.Second
+
+	// minResolveGap is the least time between resolutions, so a burst of
+	// ResolveNow calls from failing subchannels does not flood DNS, and the
+	// least refresh interval.
+	minResolveGap = time.Second
+)
+
+// dnsBuilder builds resolvers for targets such as "dns:///svc.ns:8080" that
+// resolve the host every interval, and whenever gRPC asks, so that replicas
+// added behind it are picked up. gRPC's own dns resolver only re-resolves when
+// a connection fails. It is passed to each dial WithResolvers, leaving gRPC's
+// registered dns resolver in place for other clients.
+type dnsBuilder struct {
+	interval    time.Duration
+	lookup      func(ctx context.Context, host string) ([]string, error)
+	subchannels *prometheus.GaugeVec
+}
+
+func (dnsBuilder) Scheme() string { return "dns" }
+
+func (b dnsBuilder) Build(target resolver.Target, cc resolver.ClientConn, _ resolver.BuildOptions) (resolver.Resolver, error) {
+	host, port, err := net.SplitHostPort(target.Endpoint())
+	if err != nil {
+		return nil, fmt.Errorf("dns target %q must be host:port: %w", target.Endpoint(), err)
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	r := &dnsResolver{
+		host:        host,
+		port:        port,
+		cc:          cc,
+		interval:    b.interval,
+		lookup:      b.lookup,
+		subchannels: b.subchannels,
+		resolveNow:  make(chan struct{}, 1),
+		cancel:      cancel,
+	}
+	r.wg.Add(1)
+	go r.watch(ctx)
+	return r, nil
+}
+
+type dnsResolver struct {
+	host, port  string
+	cc          resolver.ClientConn
+	interval    time.Duration
+	lookup      func(ctx context.Context, host string) ([]string, error)
+	subchannels *prometheus.GaugeVec
+
+	resolveNow chan struct{}
+	cancel     context.CancelFunc
+	wg         sync.WaitGroup
+}
+
+func (r *dnsResolver) ResolveNow(resolver.ResolveNowOptions) {
+	select {
+	case r.resolveNow <- struct{}{}:
+	default:
+	}
+}
+
+func (r *dnsResolver) Close() {
+	r.cancel()
+	r.wg.Wait()
+}
+
+// watch resolves the host every interval, or sooner when asked, until ctx is
+// done.
+func (r *dnsResolver) watch(ctx context.Context) {
+	defer r.wg.Done()
+	for {
+		last := time.Now()
+		r.resolve(ctx)
+
+		timer := time.NewTimer(r.interval)
+		select {
+		case <-ctx.Done():
+			timer.Stop()
+			return
+		case <-timer.C:
+		case <-r.resolveNow:
+			timer.Stop()
+			select {
+			case <-ctx.Done():
+				return
+			case <-time.After(time.Until(last.Add(minResolveGap))):
+			}
+		}
+	}
+}
+
+// resolve looks up the host and passes its addresses to gRPC, or reports why
+// it could not.
+func (r *dnsResolver) resolve(ctx context.Context) {
+	hosts, err := r.lookup(ctx, r.host)
+	if err != nil {
+		if ctx.Err() == nil {
+			r.cc.ReportError(fmt.Errorf("resolving %s: %w", r.host, err))
+		}
+		return
+	}
+	addrs := make([]string, 0, len(hosts))
+	for _, h := range hosts {
+		addrs = append(addrs, net.JoinHostPort(h, r.port))
+	}
+	if err := r.cc.UpdateState(resolvedState(addrs, r.subchannels)); err != nil {
+		clog.FromContext(ctx).Debug("resolved addresses rejected", "host", r.host, "error", err)
+	}
+}
+
+// staticBuilder builds resolvers for targets listing their addresses, such as
+// "static:///10.0.0.1:8080,10.0.0.2:8080".
+type staticBuilder struct {
+	subchannels *prometheus.GaugeVec
+}
+
+func (staticBuilder) Scheme() string { return "static" }
+
+func (b staticBuilder) Build(target resolver.Target, cc resolver.ClientConn, _ resolver.BuildOptions) (resolver.Resolver, error) {
+	addrs, err := staticAddresses(target.Endpoint())
+	if err != nil {
+		return nil, err
+	}
+	// A static target names no single host for TLS to verify, so each address
+	// is verified against its own.
+	state := resolvedState(addrs, b.subchannels)
+	for i, e := range state.Endpoints {
+		host, _, _ := net.SplitHostPort(e.Addresses[0].Addr)
+		state.Addresses[i].ServerName = host
+		e.Addresses[0].ServerName = host
+	}
+	if err := cc.UpdateState(state); err != nil {
+		return nil, err
+	}
+	return staticResolver{}, nil
+}
+
+// staticAddresses splits a comma-separated list of host:port addresses.
+func staticAddresses(list string) ([]string, error) {
+	var addrs []string
+	for a := range strings.SplitSeq(list, ",") {
+		a = strings.TrimSpace(a)
+		if a == "" {
+			continue
+		}
+		if _, _, err := net.SplitHostPort(a); err != nil {
+			return nil, fmt.Errorf("static address %q must be host:port: %w", a, err)
+		}
+		addrs = append(addrs, a)
+	}
+	if len(addrs) == 0 {
+		return nil, fmt.Errorf("static target %q lists no addresses", list)
+	}
+	return addrs, nil
+}
+
+type staticResolver struct{}
+
+func (staticResolver) ResolveNow(resolver.ResolveNowOptions) {}
+func (staticResolver) Close()                                {}
+
+// resolvedState returns the resolver state of addrs, one endpoint each, in a
+// stable order, with their subchannels' states reported to subchannels, if
+// set.
+func resolvedState(addrs []string, subchannels *prometheus.GaugeVec) resolver.State {
+	addrs = slices.Clone(addrs)
+	slices.Sort(addrs)
+	var attrs *attributes.Attributes
+	if subchannels != nil {
+		attrs = attributes.New(subchannelStateKey{}, subchannels)
+	}
+	state := resolver.State{Endpoints: make([]resolver.Endpoint, 0, len(addrs))}
+	for _, a := range addrs {
+		addr := resolver.Address{Addr: a, Attributes: attrs}
+		state.Addresses = append(state.Addresses, addr)
+		state.Endpoints = append(state.Endpoints, resolver.Endpoint{Addresses: []resolver.Address{addr}})
+	}
+	return state
+}
+
+// loadBalancingDialOptions returns the dial options that balance calls across
+// the replicas a dns or static URL names, such as
+// dns:///svc.ns:8080?lb=least_request&refresh=10s. The lb query parameter
+// selects the policy, RoundRobin (the default), LeastRequest or PickFirst, as
+// the default service config, which a grpc.WithDefaultServiceConfig dialled
+// after it overrides. The refresh parameter sets how often a dns host is
+// re-resolved, 30s by default. The states of the replicas' subchannels are
+// reported to subchannels.
+func loadBalancingDialOptions(delegate url.URL, subchannels *prometheus.GaugeVec) ([]grpc.DialOption, error) {
+	if delegate.Host != "" {
+		return nil, fmt.Errorf("%s target must have an empty authority, as in %s:///host:port, got %q", delegate.Scheme, delegate.Scheme, delegate.Host)
+	}
+	q := delegate.Query()
+
+	sc, err := loadBalancingConfig(cmp.Or(q.Get("lb"), RoundRobin))
+	if err != nil {
+		return nil, err
+	}
+
+	var builder resolver.Builder = staticBuilder{subchannels: subchannels}
+	if delegate.Scheme == "dns" {
+		interval := defaultRefreshInterval
+		if v := q.Get("refresh"); v != "" {
+			if interval, err = time.ParseDuration(v); err != nil {
+				return nil, fmt.Errorf("refresh: %w", err)
+			}
+			if interval < minResolveGap {
+				return nil, fmt.Errorf("refresh interval %v is below %v", interval, minResolveGap)
+			}
+		}
+		builder = dnsBuilder{interval: interval, lookup: net.DefaultResolver.LookupHost, subchannels: subchannels}
+	} else if _, err := staticAddresses(strings.TrimPrefix(delegate.Path, "/")); err != nil {
+		return nil, err
+	}
+
+	return []grpc.DialOption{
+		grpc.WithResolvers(builder),
+		grpc.WithDefaultServiceConfig(sc),
+	}, nil
+}
@@ -0,0 +1,198 @@
+/*
+Copyright 2026 Chainguard, Inc.
+SPDX-License-Identifier: Apache-2.0
+*/
+
+package options
+
+import (
+	"context"
+	"errors"
+	"net"
+	"net/url"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/google/go-cmp/cmp"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/health"
+	healthpb "google.golang.org/grpc/health/grpc_health_v1"
+	"google.golang.org/grpc/resolver"
+)
+
+func TestLoadBalancing(t *testing.T) {
+	tests := []struct {
+		lb           string
+		wantBackends int
+	}{
+		{RoundRobin, 2},
+		{LeastRequest, 2},
+		{PickFirst, 1},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.lb, func(t *testing.T) {
+			ctx := t.Context()
+
+			var counts [2]atomic.Int64
+			addrs := make([]string, len(counts))
+			for i := range counts {
+				addrs[i] = serveCounting(t, &counts[i])
+			}
+
+			reg := prometheus.NewRegistry()
+			u := url.URL{Scheme: "static", Path: "/" + strings.Join(addrs, ","), RawQuery: "lb=" + tt.lb}
+			conn, err := (&Config{Registerer: reg}).DialReady(ctx, u, time.Second)
+			if err != nil {
+				t.Fatalf("DialReady() = %v", err)
+			}
+			defer conn.Close()
+
+			// Least request picks the less loaded of two random backends, so
+			// give it enough calls to choose both.
+			client := healthpb.NewHealthClient(conn)
+			for range 20 {
+				if _, err := client.Check(ctx, &healthpb.HealthCheckRequest{}); err != nil {
+					t.Fatalf("Check() = %v", err)
+				}
+			}
+
+			backends := 0
+			for i := range counts {
+				if counts[i].Load() > 0 {
+					backends++
+				}
+			}
+			if backends != tt.wantBackends {
+				t.Errorf("backends called = %d, want %d", backends, tt.wantBackends)
+			}
+
+			if tt.lb != PickFirst {
+				subchannels, err := register(reg, newSubchannelState())
+				if err != nil {
+					t.Fatalf("register() = %v", err)
+				}
+				for _, addr := range addrs {
+					if got := testutil.ToFloat64(subchannels.WithLabelValues(conn.Target(), addr, "READY")); got != 1 {
+						t.Errorf("subchannel state of %s READY = %v, want 1", addr, got)
+					}
+				}
+			}
+		})
+	}
+}
+
+// serveCounting serves the health service on a local port, counting its calls
+// in count, and returns its address.
+func serveCounting(t *testing.T, count *atomic.Int64) string {
+	t.Helper()
+
+	lis, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("failed to listen: %v", err)
+	}
+	srv := grpc.NewServer(grpc.UnaryInterceptor(func(ctx context.Context, req any, _ *grpc.UnaryServerInfo, handler grpc.UnaryHandler) (any, error) {
+		count.Add(1)
+		return handler(ctx, req)
+	}))
+	healthpb.RegisterHealthServer(srv, health.NewServer())
+	go func() { _ = srv.Serve(lis) }()
+	t.Cleanup(srv.Stop)
+
+	return lis.Addr().String()
+}
+
+func TestDNSResolver(t *testing.T) {
+	results := make(chan []string, 3)
+	results <- []string{"10.0.0.2", "10.0.0.1"}
+	results <- nil
+	results <- []string{"10.0.0.1", "10.0.0.3"}
+	lookup := func(context.Context, string) ([]string, error) {
+		select {
+		case hosts := <-results:
+			if hosts == nil {
+				return nil, errors.New("no such host")
+			}
+			return hosts, nil
+		default:
+			return []string{"10.0.0.1", "10.0.0.3"}, nil
+		}
+	}
+
+	cc := &fakeResolverConn{states: make(chan resolver.State, 10), errs: make(chan error, 10)}
+	r, err := dnsBuilder{interval: 10 * time.Millisecond, lookup: lookup}.Build(
+		resolver.Target{URL: url.URL{Scheme: "dns", Path: "/svc.ns:8080"}}, cc, resolver.BuildOptions{})
+	if err != nil {
+		t.Fatalf("Build() = %v", err)
+	}
+	defer r.Close()
+
+	addrs := func(s resolver.State) []string {
+		var got []string
+		for _, a := range s.Addresses {
+			got = append(got, a.Addr)
+		}
+		return got
+	}
+
+	if diff := cmp.Diff([]string{"10.0.0.1:8080", "10.0.0.2:8080"}, addrs(<-cc.states)); diff != "" {
+		t.Errorf("first resolution -want,+got: %s", diff)
+	}
+	if err := <-cc.errs; err == nil {
+		t.Error("ReportError() = nil, want the lookup error")
+	}
+	if diff := cmp.Diff([]string{"10.0.0.1:8080", "10.0.0.3:8080"}, addrs(<-cc.states)); diff != "" {
+		t.Errorf("re-resolution -want,+got: %s", diff)
+	}
+}
+
+type fakeResolverConn struct {
+	resolver.ClientConn
+	states chan resolver.State
+	errs   chan error
+}
+
+func (c *fakeResolverConn) UpdateState(s resolver.State) error {
+	c.states <- s
+	return nil
+}
+
+func (c *fakeResolverConn) ReportError(err error) { c.errs <- err }
+
+func TestLoadBalancing_Errors(t *testing.T) {
+	tests := []string{
+		"static:///",
+		"static:///10.0.0.1",
+		"static:///10.0.0.1:8080?lb=random",
+		"dns://8.8.8.8/svc.ns:8080",
+		"dns:///svc.ns:8080?refresh=soon",
+		"dns:///svc.ns:8080?refresh=10ms",
+	}
+	for _, raw := range tests {
+		t.Run(raw, func(t *testing.T) {
+			u, err := url.Parse(raw)
+			if err != nil {
+				t.Fatalf("url.Parse() = %v", err)
+			}
+			c := &Config{Registerer: prometheus.NewRegistry()}
+			if _, _, err := c.GRPCOptions(*u); err == nil {
+				t.Error("GRPCOptions() = nil, want error")
+			}
+		})
+	}
+}
+
+func TestGRPCOptions_DNS(t *testing.T) {
+	u, _ := url.Parse("dns:///svc.ns:8080?lb=least_request&refresh=10s")
+	target, opts := GRPCOptions(*u)
+	if want := "dns:///svc.ns:8080"; target != want {
+		t.Errorf("target = %q, want %q", target, want)
+	}
+	if len(opts) == 0 {
+		t.Error("expected non-empty dial options")
+	}
+}
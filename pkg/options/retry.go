@@ -0,0 +1,378 @@
+/*
+Copyright 2026 Chainguard, Inc.
+SPDX-License-Identifier: Apache-2.0
+*/
+
+package options
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"math"
+	"math/rand/v2"
+	"slices"
+	"strings"
+	"sync"
+	"time"
+
+	grpc_retry "github.com/grpc-ecosystem/go-grpc-middleware/v2/interceptors/retry"
+	"github.com/prometheus/client_golang/prometheus"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+	"google.golang.org/protobuf/reflect/protoreflect"
+	"google.golang.org/protobuf/reflect/protoregistry"
+	"google.golang.org/protobuf/types/descriptorpb"
+
+	"chainguard.dev/go-grpc-kit/internal/methodmatch"
+)
+
+const (
+	defaultInitialBackoff    = 100 * time.Millisecond
+	defaultMaxBackoff        = 10 * time.Second
+	defaultBackoffMultiplier = 2
+
+	// backoffJitter is the fraction by which each backoff is randomly
+	// lengthened or shortened, so clients that failed together do not retry
+	// together.
+	backoffJitter = 0.2
+)
+
+// newRetriesTotal returns the counter of retries, which each Config registers
+// with its Registerer.
+func newRetriesTotal() *prometheus.CounterVec {
+	return prometheus.NewCounterVec(prometheus.CounterOpts{
+		Name: "grpc_client_retries_total",
+		Help: "Calls retried by the client, by method and the status code of the attempt that failed.",
+	}, []string{"method", "code"})
+}
+
+// RetryPolicy says how a call is retried. Its zero value makes no retries.
+type RetryPolicy struct {
+	// MaxAttempts is the most attempts made at a call, the first included, so
+	// 0 and 1 make no retries.
+	MaxAttempts uint
+
+	// RetryableCodes are the status codes a failed attempt is retried on. The
+	// default is codes.Unavailable and codes.ResourceExhausted.
+	RetryableCodes []codes.Code
+
+	// InitialBackoff is the wait before the first retry, and each later wait
+	// is BackoffMultiplier times the one before, up to MaxBackoff. Each wait
+	// is jittered by +/-20%, and never exceeds MaxBackoff. The defaults are
+	// 100ms, 10s and 2.
+	InitialBackoff    time.Duration
+	MaxBackoff        time.Duration
+	BackoffMultiplier float64
+
+	// PerAttemptTimeout bounds each attempt, and an attempt that times out is
+	// retried. The call's own deadline still bounds them all. The default is
+	// no per-attempt bound.
+	PerAttemptTimeout time.Duration
+}
+
+// RetryConfig says how calls made with a Config's dial options are retried.
+//
+// Retrying a call that is not idempotent may repeat its effect, so unless
+// AllMethods is set, Default applies only to methods whose proto declares an
+// idempotency_level of NO_SIDE_EFFECTS or IDEMPOTENT. Other methods are
+// retried only by a policy given them in Methods, which is taken as saying that
+// retrying them is safe. gRPC itself still transparently retries a call that
+// never reached a server.
+type RetryConfig struct {
+	// Default is the policy of idempotent methods with none in Methods. In a
+	// Config from ConfigFromEnv, as DefaultConfig is, a RetryConfig with
+	// neither Default nor Methods set is parsed from
+	// GRPC_CLIENT_RETRY_SERVICE_CONFIG, if that is set (see
+	// ParseRetryServiceConfig), and an unset Default retries as many times as
+	// GRPC_CLIENT_MAX_RETRY says, with AllMethods set.
+	Default RetryPolicy
+
+	// AllMethods applies Default to every method with no policy in Methods,
+	// idempotent or not. It is how GRPC_CLIENT_MAX_RETRY has always retried,
+	// and is kept for it; prefer giving the methods that are safe to retry a
+	// policy.
+	AllMethods bool
+
+	// Methods maps method patterns to their policy. A pattern is either a full
+	// method name, such as "/pkg.Service/Method", or a prefix ending in "*",
+	// such as "/pkg.Service/*". An exact name takes precedence over a prefix,
+	// and a longer prefix over a shorter one.
+	Methods map[string]RetryPolicy
+}
+
+// ParseRetryServiceConfig returns the RetryConfig described by the retry
+// policies of a gRPC service config, such as:
+//
+//	{"methodConfig": [{
+//	  "name": [{"service": "pkg.Service", "method": "Get"}],
+//	  "retryPolicy": {
+//	    "maxAttempts": 4,
+//	    "initialBackoff": "0.1s",
+//	    "maxBackoff": "1s",
+//	    "backoffMultiplier": 2,
+//	    "retryableStatusCodes": ["UNAVAILABLE"],
+//	    "perAttemptRecvTimeout": "2s"
+//	  }
+//	}]}
+//
+// A name with a service and no method applies to the whole service, and one
+// with neither is the Default. Other fields of the service config are
+// ignored.
+func ParseRetryServiceConfig(b []byte) (RetryConfig, error) {
+	var sc struct {
+		MethodConfig []struct {
+			Name []struct {
+				Service string `json:"service"`
+				Method  string `json:"method"`
+			} `json:"name"`
+			RetryPolicy *struct {
+				MaxAttempts           uint         `json:"maxAttempts"`
+				InitialBackoff        string       `json:"initialBackoff"`
+				MaxBackoff            string       `json:"maxBackoff"`
+				BackoffMultiplier     float64      `json:"backoffMultiplier"`
+				RetryableStatusCodes  []codes.Code `json:"retryableStatusCodes"`
+				PerAttemptRecvTimeout string       `json:"perAttemptRecvTimeout"`
+			} `json:"retryPolicy"`
+		} `json:"methodConfig"`
+	}
+	if err := json.Unmarshal(b, &sc); err != nil {
+		return RetryConfig{}, fmt.Errorf("parsing service config: %w", err)
+	}
+
+	var c RetryConfig
+	for _, mc := range sc.MethodConfig {
+		if mc.RetryPolicy == nil {
+			continue
+		}
+		rp := mc.RetryPolicy
+		p := RetryPolicy{
+			MaxAttempts:       rp.MaxAttempts,
+			RetryableCodes:    rp.RetryableStatusCodes,
+			BackoffMultiplier: rp.BackoffMultiplier,
+		}
+		for _, d := range []struct {
+			field string
+			value string
+			into  *time.Duration
+		}{
+			{"initialBackoff", rp.InitialBackoff, &p.InitialBackoff},
+			{"maxBackoff", rp.MaxBackoff, &p.MaxBackoff},
+			{"perAttemptRecvTimeout", rp.PerAttemptRecvTimeout, &p.PerAttemptTimeout},
+		} {
+			if d.value == "" {
+				continue
+			}
+			v, err := time.ParseDuration(d.value)
+			if err != nil {
+				return RetryConfig{}, fmt.Errorf("%s: %w", d.field, err)
+			}
+			*d.into = v
+		}
+
+		for _, n := range mc.Name {
+			switch {
+			case n.Service == "":
+				c.Default = p
+			case n.Method == "":
+				c.setMethod("/"+n.Service+"/*", p)
+			default:
+				c.setMethod("/"+n.Service+"/"+n.Method, p)
+			}
+		}
+	}
+	if err := c.validate(); err != nil {
+		return RetryConfig{}, err
+	}
+	return c, nil
+}
+
+func (c *RetryConfig) setMethod(pattern string, p RetryPolicy) {
+	if c.Methods == nil {
+		c.Methods = map[string]RetryPolicy{}
+	}
+	c.Methods[pattern] = p
+}
+
+// withEnv returns c, or the config in env when c is unset, with the Default's
+// MaxAttempts taken from env when it is unset. Like the retries it configured
+// before methods had policies, GRPC_CLIENT_MAX_RETRY applies to all methods.
+func (c RetryConfig) withEnv(env envStruct) (RetryConfig, error) {
+	if c.Default.MaxAttempts == 0 && len(c.Methods) == 0 && env.GrpcClientRetryServiceConfig != "" {
+		parsed, err := ParseRetryServiceConfig([]byte(env.GrpcClientRetryServiceConfig))
+		if err != nil {
+			return c, fmt.Errorf("GRPC_CLIENT_RETRY_SERVICE_CONFIG: %w", err)
+		}
+		c = parsed
+	}
+	if c.Default.MaxAttempts == 0 && env.GrpcClientMaxRetry > 0 {
+		c.Default.MaxAttempts = env.GrpcClientMaxRetry
+		c.AllMethods = true
+	}
+	return c, c.validate()
+}
+
+func (c RetryConfig) validate() error {
+	if err := c.Default.validate(); err != nil {
+		return fmt.Errorf("default retry policy: %w", err)
+	}
+	for pattern, p := range c.Methods {
+		if err := validatePattern(pattern); err != nil {
+			return fmt.Errorf("retry policy %w", err)
+		}
+		if err := p.validate(); err != nil {
+			return fmt.Errorf("retry policy for %s: %w", pattern, err)
+		}
+	}
+	return nil
+}
+
+func (p RetryPolicy) validate() error {
+	if p.InitialBackoff < 0 || p.MaxBackoff < 0 || p.PerAttemptTimeout < 0 {
+		return errors.New("negative duration")
+	}
+	if p.InitialBackoff > 0 && p.MaxBackoff > 0 && p.MaxBackoff < p.InitialBackoff {
+		return fmt.Errorf("maximum backoff %v is below initial backoff %v", p.MaxBackoff, p.InitialBackoff)
+	}
+	if p.BackoffMultiplier != 0 && p.BackoffMultiplier < 1 {
+		return fmt.Errorf("backoff multiplier %v is below 1", p.BackoffMultiplier)
+	}
+	if slices.Contains(p.RetryableCodes, codes.OK) {
+		return errors.New("OK is not a retryable code")
+	}
+	return nil
+}
+
+// validatePattern checks that pattern is a method pattern: a full method name
+// or a prefix of one ending in "*".
+func validatePattern(pattern string) error {
+	if !strings.HasPrefix(pattern, "/") {
+		return fmt.Errorf("pattern %q must start with /", pattern)
+	}
+	if i := strings.Index(pattern, "*"); i >= 0 && i != len(pattern)-1 {
+		return fmt.Errorf("pattern %q may only end in *", pattern)
+	}
+	return nil
+}
+
+// policy returns the policy of method, and whether it has one.
+func (c RetryConfig) policy(method string, idempotent func(string) bool) (RetryPolicy, bool) {
+	if p, ok := methodmatch.Best(c.Methods, method); ok {
+		return p, true
+	}
+	if c.AllMethods || idempotent(method) {
+		return c.Default, true
+	}
+	return RetryPolicy{}, false
+}
+
+// callOptions returns the retry options that apply p to a call to method,
+// counting its retries in retries.
+func (p RetryPolicy) callOptions(method string, retries *prometheus.CounterVec) []grpc.CallOption {
+	initial := p.InitialBackoff
+	if initial == 0 {
+		initial = defaultInitialBackoff
+	}
+	maxBackoff := p.MaxBackoff
+	if maxBackoff == 0 {
+		maxBackoff = max(defaultMaxBackoff, initial)
+	}
+	multiplier := p.BackoffMultiplier
+	if multiplier == 0 {
+		multiplier = defaultBackoffMultiplier
+	}
+	retryable := p.RetryableCodes
+	if len(retryable) == 0 {
+		retryable = grpc_retry.DefaultRetriableCodes
+	}
+
+	return []grpc.CallOption{
+		grpc_retry.WithMax(p.MaxAttempts),
+		grpc_retry.WithCodes(retryable...),
+		grpc_retry.WithBackoff(backoff(initial, maxBackoff, multiplier)),
+		grpc_retry.WithPerRetryTimeout(p.PerAttemptTimeout),
+		grpc_retry.WithOnRetryCallback(func(_ context.Context, _ uint, err error) {
+			retries.WithLabelValues(method, status.Code(err).String()).Inc()
+		}),
+	}
+}
+
+// backoff returns a jittered exponential backoff from initial, growing by
+// multiplier, bounded by maxBackoff.
+func backoff(initial, maxBackoff time.Duration, multiplier float64) grpc_retry.BackoffFunc {
+	return func(_ context.Context, attempt uint) time.Duration {
+		d := float64(initial) * math.Pow(multiplier, float64(attempt-1))
+		d *= 1 + backoffJitter*(rand.Float64()*2-1)
+		return time.Duration(min(d, float64(maxBackoff)))
+	}
+}
+
+// idempotentMethods caches whether methods are idempotent, by full name.
+var idempotentMethods sync.Map
+
+// isIdempotent reports whether the proto of method, a full method name such as
+// "/pkg.Service/Method", declares it idempotent.
+func isIdempotent(method string) bool {
+	if v, ok := idempotentMethods.Load(method); ok {
+		return v.(bool)
+	}
+	idempotent := declaredIdempotent(protoregistry.GlobalFiles, method)
+	idempotentMethods.Store(method, idempotent)
+	return idempotent
+}
+
+// declaredIdempotent reports whether the descriptor of method in files declares
+// it idempotent.
+func declaredIdempotent(files *protoregistry.Files, method string) bool {
+	service, name, ok := strings.Cut(strings.TrimPrefix(method, "/"), "/")
+	if !ok {
+		return false
+	}
+	d, err := files.FindDescriptorByName(protoreflect.FullName(service + "." + name))
+	if err != nil {
+		return false
+	}
+	md, ok := d.(protoreflect.MethodDescriptor)
+	if !ok {
+		return false
+	}
+	opts, ok := md.Options().(*descriptorpb.MethodOptions)
+	if !ok {
+		return false
+	}
+	switch opts.GetIdempotencyLevel() {
+	case descriptorpb.MethodOptions_NO_SIDE_EFFECTS, descriptorpb.MethodOptions_IDEMPOTENT:
+		return true
+	}
+	return false
+}
+
+// retryUnaryClientInterceptor retries unary calls by the policy c gives them,
+// counting the retries in retries. Retry options passed to a call override its
+// policy.
+func retryUnaryClientInterceptor(c RetryConfig, retries *prometheus.CounterVec) grpc.UnaryClientInterceptor {
+	retry := grpc_retry.UnaryClientInterceptor()
+	return func(ctx context.Context, method string, req, reply any, cc *grpc.ClientConn, invoker grpc.UnaryInvoker, opts ...grpc.CallOption) error {
+		p, ok := c.policy(method, isIdempotent)
+		if !ok {
+			return retry(ctx, method, req, reply, cc, invoker, opts...)
+		}
+		return retry(ctx, method, req, reply, cc, invoker, append(p.callOptions(method, retries), opts...)...)
+	}
+}
+
+// retryStreamClientInterceptor retries server-streaming calls by the policy c
+// gives them, counting the retries in retries. Streams the client sends on
+// cannot be retried, as their messages are not kept to resend.
+func retryStreamClientInterceptor(c RetryConfig, retries *prometheus.CounterVec) grpc.StreamClientInterceptor {
+	retry := grpc_retry.StreamClientInterceptor()
+	return func(ctx context.Context, desc *grpc.StreamDesc, cc *grpc.ClientConn, method string, streamer grpc.Streamer, opts ...grpc.CallOption) (grpc.ClientStream, error) {
+		p, ok := c.policy(method, isIdempotent)
+		if !ok || desc.ClientStreams {
+			return retry(ctx, desc, cc, method, streamer, opts...)
+		}
+		return retry(ctx, desc, cc, method, streamer, append(p.callOptions(method, retries), opts...)...)
+	}
+}
@@ -0,0 +1,329 @@
+/*
+Copyright 2026 Chainguard, Inc.
+SPDX-License-Identifier: Apache-2.0
+*/
+
+package options
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/google/go-cmp/cmp"
+	grpc_retry "github.com/grpc-ecosystem/go-grpc-middleware/v2/interceptors/retry"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+	"google.golang.org/protobuf/proto"
+	"google.golang.org/protobuf/reflect/protodesc"
+	"google.golang.org/protobuf/reflect/protoregistry"
+	"google.golang.org/protobuf/types/descriptorpb"
+)
+
+func TestParseRetryServiceConfig(t *testing.T) {
+	got, err := ParseRetryServiceConfig([]byte(`{
+  "loadBalancingConfig": [{"round_robin": {}}],
+  "methodConfig": [{
+    "name": [{}],
+    "retryPolicy": {"maxAttempts": 3}
+  }, {
+    "name": [{"service": "pkg.Service"}],
+    "retryPolicy": {
+      "maxAttempts": 4,
+      "initialBackoff": "0.1s",
+      "maxBackoff": "1s",
+      "backoffMultiplier": 1.5,
+      "retryableStatusCodes": ["UNAVAILABLE", "ABORTED"],
+      "perAttemptRecvTimeout": "2s"
+    }
+  }, {
+    "name": [{"service": "pkg.Service", "method": "Create"}],
+    "retryPolicy": {"maxAttempts": 1}
+  }, {
+    "name": [{"service": "pkg.Service", "method": "Get"}],
+    "timeout": "5s"
+  }]
+}`))
+	if err != nil {
+		t.Fatalf("ParseRetryServiceConfig() = %v", err)
+	}
+
+	want := RetryConfig{
+		Default: RetryPolicy{MaxAttempts: 3},
+		Methods: map[string]RetryPolicy{
+			"/pkg.Service/*": {
+				MaxAttempts:       4,
+				RetryableCodes:    []codes.Code{codes.Unavailable, codes.Aborted},
+				InitialBackoff:    100 * time.Millisecond,
+				MaxBackoff:        time.Second,
+				BackoffMultiplier: 1.5,
+				PerAttemptTimeout: 2 * time.Second,
+			},
+			"/pkg.Service/Create": {MaxAttempts: 1},
+		},
+	}
+	if diff := cmp.Diff(want, got); diff != "" {
+		t.Errorf("ParseRetryServiceConfig() -want,+got: %s", diff)
+	}
+}
+
+func TestParseRetryServiceConfig_Errors(t *testing.T) {
+	tests := []struct {
+		name   string
+		config string
+	}{
+		{"invalid JSON", `{`},
+		{"invalid duration", `{"methodConfig": [{"name": [{}], "retryPolicy": {"initialBackoff": "soon"}}]}`},
+		{"unknown code", `{"methodConfig": [{"name": [{}], "retryPolicy": {"retryableStatusCodes": ["FLAKY"]}}]}`},
+		{"OK code", `{"methodConfig": [{"name": [{}], "retryPolicy": {"retryableStatusCodes": ["OK"]}}]}`},
+		{"maximum below initial", `{"methodConfig": [{"name": [{}], "retryPolicy": {"initialBackoff": "2s", "maxBackoff": "1s"}}]}`},
+		{"multiplier below 1", `{"methodConfig": [{"name": [{"service": "pkg.Service"}], "retryPolicy": {"backoffMultiplier": 0.5}}]}`},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if _, err := ParseRetryServiceConfig([]byte(tt.config)); err == nil {
+				t.Error("ParseRetryServiceConfig() = nil, want error")
+			}
+		})
+	}
+}
+
+func TestRetryConfig_Validate(t *testing.T) {
+	tests := []struct {
+		name    string
+		pattern string
+	}{
+		{"relative", "pkg.Service/Get"},
+		{"inner wildcard", "/pkg.*/Get"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			c := RetryConfig{Methods: map[string]RetryPolicy{tt.pattern: {MaxAttempts: 2}}}
+			if err := c.validate(); err == nil {
+				t.Error("validate() = nil, want error")
+			}
+		})
+	}
+}
+
+func TestRetryConfig_Policy(t *testing.T) {
+	c := RetryConfig{
+		Default: RetryPolicy{MaxAttempts: 2},
+		Methods: map[string]RetryPolicy{
+			"/pkg.Service/*":       {MaxAttempts: 3},
+			"/pkg.Service/Batch*":  {MaxAttempts: 4},
+			"/pkg.Service/Create":  {MaxAttempts: 5},
+			"/other.Service/List*": {MaxAttempts: 6},
+		},
+	}
+	idempotent := func(method string) bool { return method == "/other.Service/Get" }
+
+	tests := []struct {
+		method string
+		want   uint
+		wantOK bool
+	}{
+		{"/pkg.Service/Create", 5, true},
+		{"/pkg.Service/BatchCreate", 4, true},
+		{"/pkg.Service/Delete", 3, true},
+		{"/other.Service/ListItems", 6, true},
+		{"/other.Service/Get", 2, true},
+		{"/other.Service/Update", 0, false},
+	}
+	for _, tt := range tests {
+		p, ok := c.policy(tt.method, idempotent)
+		if p.MaxAttempts != tt.want || ok != tt.wantOK {
+			t.Errorf("policy(%q) = %d attempts, %v; want %d, %v", tt.method, p.MaxAttempts, ok, tt.want, tt.wantOK)
+		}
+	}
+
+	// With AllMethods, the default applies to methods not declared idempotent.
+	c.AllMethods = true
+	if p, ok := c.policy("/other.Service/Update", idempotent); p.MaxAttempts != 2 || !ok {
+		t.Errorf("policy() with AllMethods = %d attempts, %v; want 2, true", p.MaxAttempts, ok)
+	}
+	if p, _ := c.policy("/pkg.Service/Create", idempotent); p.MaxAttempts != 5 {
+		t.Errorf("policy() with AllMethods = %d attempts, want the method's 5", p.MaxAttempts)
+	}
+}
+
+func TestDeclaredIdempotent(t *testing.T) {
+	method := func(name string, level descriptorpb.MethodOptions_IdempotencyLevel) *descriptorpb.MethodDescriptorProto {
+		return &descriptorpb.MethodDescriptorProto{
+			Name:       proto.String(name),
+			InputType:  proto.String(".test.Empty"),
+			OutputType: proto.String(".test.Empty"),
+			Options:    &descriptorpb.MethodOptions{IdempotencyLevel: level.Enum()},
+		}
+	}
+	fd, err := protodesc.NewFile(&descriptorpb.FileDescriptorProto{
+		Name:        proto.String("test.proto"),
+		Package:     proto.String("test"),
+		Syntax:      proto.String("proto3"),
+		MessageType: []*descriptorpb.DescriptorProto{{Name: proto.String("Empty")}},
+		Service: []*descriptorpb.ServiceDescriptorProto{{
+			Name: proto.String("Service"),
+			Method: []*descriptorpb.MethodDescriptorProto{
+				method("Get", descriptorpb.MethodOptions_NO_SIDE_EFFECTS),
+				method("Put", descriptorpb.MethodOptions_IDEMPOTENT),
+				method("Create", descriptorpb.MethodOptions_IDEMPOTENCY_UNKNOWN),
+			},
+		}},
+	}, nil)
+	if err != nil {
+		t.Fatalf("NewFile() = %v", err)
+	}
+	files := new(protoregistry.Files)
+	if err := files.RegisterFile(fd); err != nil {
+		t.Fatalf("RegisterFile() = %v", err)
+	}
+
+	tests := []struct {
+		method string
+		want   bool
+	}{
+		{"/test.Service/Get", true},
+		{"/test.Service/Put", true},
+		{"/test.Service/Create", false},
+		{"/test.Service/Missing", false},
+		{"/test.Empty/Get", false},
+		{"malformed", false},
+	}
+	for _, tt := range tests {
+		if got := declaredIdempotent(files, tt.method); got != tt.want {
+			t.Errorf("declaredIdempotent(%q) = %v, want %v", tt.method, got, tt.want)
+		}
+	}
+}
+
+func TestRetryUnaryClientInterceptor(t *testing.T) {
+	c := RetryConfig{
+		Methods: map[string]RetryPolicy{
+			"/pkg.Service/Get": {MaxAttempts: 3, InitialBackoff: time.Millisecond},
+		},
+	}
+
+	tests := []struct {
+		name         string
+		method       string
+		code         codes.Code
+		opts         []grpc.CallOption
+		wantAttempts int
+	}{
+		{"retryable code", "/pkg.Service/Get", codes.Unavailable, nil, 3},
+		{"other code", "/pkg.Service/Get", codes.InvalidArgument, nil, 1},
+		{"no policy", "/pkg.Service/Create", codes.Unavailable, nil, 1},
+		{"call option", "/pkg.Service/Get", codes.Unavailable, []grpc.CallOption{grpc_retry.Disable()}, 1},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			retries := newRetriesTotal()
+			attempts := 0
+			err := retryUnaryClientInterceptor(c, retries)(t.Context(), tt.method, nil, nil, nil,
+				func(context.Context, string, any, any, *grpc.ClientConn, ...grpc.CallOption) error {
+					attempts++
+					return status.Error(tt.code, "failed")
+				}, tt.opts...)
+			if status.Code(err) != tt.code {
+				t.Errorf("status code = %v, want %v", status.Code(err), tt.code)
+			}
+			if attempts != tt.wantAttempts {
+				t.Errorf("attempts = %d, want %d", attempts, tt.wantAttempts)
+			}
+			if got := testutil.ToFloat64(retries.WithLabelValues(tt.method, tt.code.String())); got != float64(tt.wantAttempts-1) {
+				t.Errorf("retries = %v, want %d", got, tt.wantAttempts-1)
+			}
+		})
+	}
+}
+
+func TestRetryStreamClientInterceptor_ClientStreams(t *testing.T) {
+	c := RetryConfig{Methods: map[string]RetryPolicy{"/pkg.Service/*": {MaxAttempts: 3}}}
+
+	attempts := 0
+	_, err := retryStreamClientInterceptor(c, newRetriesTotal())(t.Context(), &grpc.StreamDesc{ClientStreams: true}, nil, "/pkg.Service/Upload",
+		func(context.Context, *grpc.StreamDesc, *grpc.ClientConn, string, ...grpc.CallOption) (grpc.ClientStream, error) {
+			attempts++
+			return nil, status.Error(codes.Unavailable, "failed")
+		})
+	if status.Code(err) != codes.Unavailable {
+		t.Errorf("status code = %v, want %v", status.Code(err), codes.Unavailable)
+	}
+	if attempts != 1 {
+		t.Errorf("attempts = %d, want 1", attempts)
+	}
+}
+
+func TestBackoff(t *testing.T) {
+	b := backoff(100*time.Millisecond, time.Second, 2)
+
+	tests := []struct {
+		attempt uint
+		want    time.Duration
+	}{
+		{1, 100 * time.Millisecond},
+		{2, 200 * time.Millisecond},
+		{3, 400 * time.Millisecond},
+	}
+	for _, tt := range tests {
+		for range 20 {
+			got := b(t.Context(), tt.attempt)
+			if lo, hi := time.Duration(float64(tt.want)*0.8), time.Duration(float64(tt.want)*1.2); got < lo || got > hi {
+				t.Errorf("backoff(%d) = %v, want within [%v, %v]", tt.attempt, got, lo, hi)
+			}
+		}
+	}
+	if got := b(t.Context(), 10); got != time.Second {
+		t.Errorf("backoff(10) = %v, want the maximum %v", got, time.Second)
+	}
+}
+
+func TestRetryConfig_WithEnv(t *testing.T) {
+	serviceConfig := `{"methodConfig": [{"name": [{"service": "pkg.Service"}], "retryPolicy": {"maxAttempts": 4}}]}`
+
+	tests := []struct {
+		name string
+		c    RetryConfig
+		env  envStruct
+		want RetryConfig
+	}{{
+		name: "unset",
+		env:  envStruct{GrpcClientMaxRetry: 3},
+		want: RetryConfig{Default: RetryPolicy{MaxAttempts: 3}, AllMethods: true},
+	}, {
+		name: "service config",
+		env:  envStruct{GrpcClientMaxRetry: 3, GrpcClientRetryServiceConfig: serviceConfig},
+		want: RetryConfig{
+			Default:    RetryPolicy{MaxAttempts: 3},
+			AllMethods: true,
+			Methods:    map[string]RetryPolicy{"/pkg.Service/*": {MaxAttempts: 4}},
+		},
+	}, {
+		name: "default set",
+		c:    RetryConfig{Default: RetryPolicy{MaxAttempts: 2}},
+		env:  envStruct{GrpcClientMaxRetry: 3},
+		want: RetryConfig{Default: RetryPolicy{MaxAttempts: 2}},
+	}, {
+		name: "set",
+		c:    RetryConfig{Methods: map[string]RetryPolicy{"/pkg.Service/Get": {MaxAttempts: 2}}},
+		env:  envStruct{GrpcClientRetryServiceConfig: serviceConfig},
+		want: RetryConfig{Methods: map[string]RetryPolicy{"/pkg.Service/Get": {MaxAttempts: 2}}},
+	}}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := tt.c.withEnv(tt.env)
+			if err != nil {
+				t.Fatalf("withEnv() = %v", err)
+			}
+			if diff := cmp.Diff(tt.want, got); diff != "" {
+				t.Errorf("withEnv() -want,+got: %s", diff)
+			}
+		})
+	}
+
+	if _, err := (RetryConfig{}).withEnv(envStruct{GrpcClientRetryServiceConfig: "{"}); err == nil {
+		t.Error("withEnv() with an invalid service config = nil, want error")
+	}
+}
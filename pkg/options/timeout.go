@@ -0,0 +1,121 @@
+/*
+Copyright 2026 Chainguard, Inc.
+SPDX-License-Identifier: Apache-2.0
+*/
+
+package options
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"time"
+
+	"google.golang.org/grpc"
+
+	"chainguard.dev/go-grpc-kit/internal/methodmatch"
+)
+
+// TimeoutConfig gives calls made with a Config's dial options whose context
+// has no deadline a default one, so a caller that forgets to set a deadline
+// does not wait forever on a downstream that never answers. A deadline the
+// caller sets, shorter or longer, is kept.
+type TimeoutConfig struct {
+	// Default is the timeout of unary calls with none in Methods. When unset,
+	// unary calls without a deadline have none, unless the Config is from
+	// ConfigFromEnv, as DefaultConfig is, where it is
+	// GRPC_CLIENT_DEFAULT_TIMEOUT.
+	Default time.Duration
+
+	// Methods maps method patterns to their timeout, with the same patterns as
+	// RetryConfig.Methods. Unlike Default, they apply to streams as well, for
+	// the whole of the stream.
+	Methods map[string]time.Duration
+}
+
+// withEnv returns c, with its Default taken from env when it is unset.
+func (c TimeoutConfig) withEnv(env envStruct) (TimeoutConfig, error) {
+	if c.Default == 0 {
+		c.Default = env.GrpcClientDefaultTimeout
+	}
+	return c, c.validate()
+}
+
+func (c TimeoutConfig) validate() error {
+	if c.Default < 0 {
+		return errors.New("negative default timeout")
+	}
+	for pattern, d := range c.Methods {
+		if err := validatePattern(pattern); err != nil {
+			return fmt.Errorf("timeout %w", err)
+		}
+		if d <= 0 {
+			return fmt.Errorf("timeout for %s: %v must be positive", pattern, d)
+		}
+	}
+	return nil
+}
+
+// timeout returns the timeout of a call to method, a stream if stream is set,
+// and whether it has one.
+func (c TimeoutConfig) timeout(method string, stream bool) (time.Duration, bool) {
+	if d, ok := methodmatch.Best(c.Methods, method); ok {
+		return d, true
+	}
+	if stream || c.Default == 0 {
+		return 0, false
+	}
+	return c.Default, true
+}
+
+// timeoutUnaryClientInterceptor gives unary calls without a deadline the
+// timeout c gives them.
+func timeoutUnaryClientInterceptor(c TimeoutConfig) grpc.UnaryClientInterceptor {
+	return func(ctx context.Context, method string, req, reply any, cc *grpc.ClientConn, invoker grpc.UnaryInvoker, opts ...grpc.CallOption) error {
+		if _, ok := ctx.Deadline(); ok {
+			return invoker(ctx, method, req, reply, cc, opts...)
+		}
+		d, ok := c.timeout(method, false)
+		if !ok {
+			return invoker(ctx, method, req, reply, cc, opts...)
+		}
+		ctx, cancel := context.WithTimeout(ctx, d)
+		defer cancel()
+		return invoker(ctx, method, req, reply, cc, opts...)
+	}
+}
+
+// timeoutStreamClientInterceptor gives streams without a deadline the timeout
+// c gives them.
+func timeoutStreamClientInterceptor(c TimeoutConfig) grpc.StreamClientInterceptor {
+	return func(ctx context.Context, desc *grpc.StreamDesc, cc *grpc.ClientConn, method string, streamer grpc.Streamer, opts ...grpc.CallOption) (grpc.ClientStream, error) {
+		if _, ok := ctx.Deadline(); ok {
+			return streamer(ctx, desc, cc, method, opts...)
+		}
+		d, ok := c.timeout(method, true)
+		if !ok {
+			return streamer(ctx, desc, cc, method, opts...)
+		}
+		ctx, cancel := context.WithTimeout(ctx, d)
+		cs, err := streamer(ctx, desc, cc, method, opts...)
+		if err != nil {
+			cancel()
+			return nil, err
+		}
+		return &timeoutClientStream{ClientStream: cs, cancel: cancel}, nil
+	}
+}
+
+// timeoutClientStream releases its timeout once the stream has ended.
+type timeoutClientStream struct {
+	grpc.ClientStream
+	cancel context.CancelFunc
+}
+
+func (s *timeoutClientStream) RecvMsg(m any) error {
+	err := s.ClientStream.RecvMsg(m)
+	if err != nil {
+		s.cancel()
+	}
+	return err
+}
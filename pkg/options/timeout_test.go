@@ -0,0 +1,129 @@
+/*
+Copyright 2026 Chainguard, Inc.
+SPDX-License-Identifier: Apache-2.0
+*/
+
+package options
+
+import (
+	"context"
+	"io"
+	"testing"
+	"time"
+
+	"google.golang.org/grpc"
+)
+
+func TestTimeoutUnaryClientInterceptor(t *testing.T) {
+	c := TimeoutConfig{
+		Default: time.Second,
+		Methods: map[string]time.Duration{"/pkg.Service/Slow*": time.Hour},
+	}
+
+	tests := []struct {
+		name     string
+		config   TimeoutConfig
+		method   string
+		timeout  time.Duration // of the caller, 0 for none
+		wantLeft time.Duration // most the call may have left, 0 for no deadline
+		wantMin  time.Duration // least it must have left
+	}{
+		{"default", c, "/pkg.Service/Get", 0, time.Second, 0},
+		{"method", c, "/pkg.Service/SlowList", 0, time.Hour, time.Minute},
+		{"caller deadline kept", c, "/pkg.Service/Get", time.Minute, time.Minute, 30 * time.Second},
+		{"no default", TimeoutConfig{}, "/pkg.Service/Get", 0, 0, 0},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			ctx := t.Context()
+			if tt.timeout != 0 {
+				var cancel context.CancelFunc
+				ctx, cancel = context.WithTimeout(ctx, tt.timeout)
+				defer cancel()
+			}
+			err := timeoutUnaryClientInterceptor(tt.config)(ctx, tt.method, nil, nil, nil,
+				func(ctx context.Context, _ string, _, _ any, _ *grpc.ClientConn, _ ...grpc.CallOption) error {
+					dl, ok := ctx.Deadline()
+					switch {
+					case tt.wantLeft == 0 && ok:
+						t.Errorf("deadline = %v, want none", dl)
+					case tt.wantLeft != 0 && (!ok || time.Until(dl) > tt.wantLeft || time.Until(dl) < tt.wantMin):
+						t.Errorf("call has %v left, want between %v and %v", time.Until(dl), tt.wantMin, tt.wantLeft)
+					}
+					return nil
+				})
+			if err != nil {
+				t.Errorf("interceptor() = %v", err)
+			}
+		})
+	}
+}
+
+func TestTimeoutStreamClientInterceptor(t *testing.T) {
+	c := TimeoutConfig{
+		Default: time.Second,
+		Methods: map[string]time.Duration{"/pkg.Service/List": time.Minute},
+	}
+
+	for _, tc := range []struct {
+		method       string
+		wantDeadline bool
+	}{
+		{"/pkg.Service/List", true},
+		// The default does not apply to streams.
+		{"/pkg.Service/Watch", false},
+	} {
+		t.Run(tc.method, func(t *testing.T) {
+			var streamCtx context.Context
+			cs, err := timeoutStreamClientInterceptor(c)(t.Context(), &grpc.StreamDesc{ServerStreams: true}, nil, tc.method,
+				func(ctx context.Context, _ *grpc.StreamDesc, _ *grpc.ClientConn, _ string, _ ...grpc.CallOption) (grpc.ClientStream, error) {
+					streamCtx = ctx
+					return &eofClientStream{}, nil
+				})
+			if err != nil {
+				t.Fatalf("interceptor() = %v", err)
+			}
+			if _, ok := streamCtx.Deadline(); ok != tc.wantDeadline {
+				t.Errorf("stream has deadline = %v, want %v", ok, tc.wantDeadline)
+			}
+
+			// The timeout is released once the stream ends.
+			if err := cs.RecvMsg(nil); err != io.EOF {
+				t.Errorf("RecvMsg() = %v, want %v", err, io.EOF)
+			}
+			if tc.wantDeadline && streamCtx.Err() == nil {
+				t.Error("stream context not cancelled after the stream ended")
+			}
+		})
+	}
+}
+
+// eofClientStream is a stream that has ended.
+type eofClientStream struct {
+	grpc.ClientStream
+}
+
+func (*eofClientStream) RecvMsg(any) error { return io.EOF }
+
+func TestTimeoutConfig_WithEnv(t *testing.T) {
+	env := envStruct{GrpcClientDefaultTimeout: 30 * time.Second}
+
+	got, err := TimeoutConfig{}.withEnv(env)
+	if err != nil || got.Default != 30*time.Second {
+		t.Errorf("withEnv() = %v, %v; want the environment's default", got, err)
+	}
+	got, err = TimeoutConfig{Default: time.Second}.withEnv(env)
+	if err != nil || got.Default != time.Second {
+		t.Errorf("withEnv() = %v, %v; want the configured default", got, err)
+	}
+
+	for _, c := range []TimeoutConfig{
+		{Default: -time.Second},
+		{Methods: map[string]time.Duration{"pkg.Service/Get": time.Second}},
+		{Methods: map[string]time.Duration{"/pkg.Service/Get": 0}},
+	} {
+		if _, err := c.withEnv(envStruct{}); err == nil {
+			t.Errorf("withEnv(%v) = nil, want error", c)
+		}
+	}
+}
@@ -0,0 +1,176 @@
+/*
+Copyright 2026 Chainguard, Inc.
+SPDX-License-Identifier: Apache-2.0
+*/
+
+package options
+
+import (
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"errors"
+	"fmt"
+	"os"
+	"sync"
+
+	"google.golang.org/grpc/credentials"
+
+	kittls "chainguard.dev/go-grpc-kit/pkg/tls"
+)
+
+// TLSConfig configures the TLS connections GRPCOptions makes for the https
+// scheme. In a Config from ConfigFromEnv, as DefaultConfig is, each zero field
+// falls back to its environment variable. Unset, the server is verified
+// against the system roots, with no client certificate.
+type TLSConfig struct {
+	// RootCAs is the pool of CAs to verify servers against, in place of the
+	// system roots.
+	RootCAs *x509.CertPool
+	// RootCAFiles are PEM bundles of CAs to verify servers against, added to
+	// RootCAs (GRPC_CLIENT_TLS_ROOT_CAS, a comma-separated list).
+	RootCAFiles []string
+
+	// CertFile and KeyFile are a PEM client certificate and its key to present
+	// to servers that request one (GRPC_CLIENT_TLS_CERT_FILE and
+	// GRPC_CLIENT_TLS_KEY_FILE). They are reloaded when they change.
+	CertFile, KeyFile string
+
+	// ServerName overrides the name the server's certificate is verified
+	// against, which is otherwise the host being dialled
+	// (GRPC_CLIENT_TLS_SERVER_NAME).
+	ServerName string
+
+	// MinVersion is the minimum TLS version, tls.VersionTLS12 or
+	// tls.VersionTLS13 (GRPC_CLIENT_TLS_MIN_VERSION, "1.2" or "1.3"). The
+	// default is TLS 1.2.
+	MinVersion uint16
+}
+
+// tlsVersions maps the values of GRPC_CLIENT_TLS_MIN_VERSION to TLS versions.
+var tlsVersions = map[string]uint16{
+	"1.2": tls.VersionTLS12,
+	"1.3": tls.VersionTLS13,
+}
+
+// withEnv returns c with its zero fields taken from env.
+func (c TLSConfig) withEnv(env envStruct) (TLSConfig, error) {
+	if c.RootCAs == nil && len(c.RootCAFiles) == 0 {
+		c.RootCAFiles = env.GrpcClientTLSRootCAs
+	}
+	if c.CertFile == "" && c.KeyFile == "" {
+		c.CertFile, c.KeyFile = env.GrpcClientTLSCertFile, env.GrpcClientTLSKeyFile
+	}
+	if c.ServerName == "" {
+		c.ServerName = env.GrpcClientTLSServerName
+	}
+	if c.MinVersion == 0 && env.GrpcClientTLSMinVersion != "" {
+		v, ok := tlsVersions[env.GrpcClientTLSMinVersion]
+		if !ok {
+			return c, fmt.Errorf("GRPC_CLIENT_TLS_MIN_VERSION %q must be 1.2 or 1.3", env.GrpcClientTLSMinVersion)
+		}
+		c.MinVersion = v
+	}
+	return c, nil
+}
+
+// tlsConfig builds the client tls.Config that c describes, with its client
+// certificate from certs.
+func (c TLSConfig) tlsConfig(certs *certSources) (*tls.Config, error) {
+	cfg := &tls.Config{
+		MinVersion: tls.VersionTLS12,
+		ServerName: c.ServerName,
+		RootCAs:    c.RootCAs,
+	}
+	if c.MinVersion != 0 {
+		if c.MinVersion < tls.VersionTLS12 {
+			return nil, fmt.Errorf("minimum TLS version %s is below TLS 1.2", tls.VersionName(c.MinVersion))
+		}
+		cfg.MinVersion = c.MinVersion
+	}
+
+	if len(c.RootCAFiles) > 0 {
+		if cfg.RootCAs == nil {
+			cfg.RootCAs = x509.NewCertPool()
+		} else {
+			cfg.RootCAs = cfg.RootCAs.Clone()
+		}
+		for _, f := range c.RootCAFiles {
+			pem, err := os.ReadFile(f) //nolint:gosec // G304: the path is the caller's own configuration
+			if err != nil {
+				return nil, fmt.Errorf("reading root CAs: %w", err)
+			}
+			if !cfg.RootCAs.AppendCertsFromPEM(pem) {
+				return nil, fmt.Errorf("no certificates in %s", f)
+			}
+		}
+	}
+
+	if (c.CertFile == "") != (c.KeyFile == "") {
+		return nil, errors.New("a client certificate needs both a certificate and a key file")
+	}
+	if c.CertFile != "" {
+		src, err := certs.get(c.CertFile, c.KeyFile)
+		if err != nil {
+			return nil, fmt.Errorf("loading client certificate: %w", err)
+		}
+		cfg = src.ClientConfig(cfg)
+	}
+	return cfg, nil
+}
+
+// certSources holds a FileSource per client certificate and key file, so
+// dialling repeatedly does not start another reload loop each time. Its
+// sources reload their files until it is closed. Its zero value is ready to
+// use.
+type certSources struct {
+	mu      sync.Mutex
+	ctx     context.Context
+	cancel  context.CancelFunc
+	sources map[[2]string]*kittls.FileSource
+}
+
+// get returns the source of certFile and keyFile, starting it if need be.
+func (s *certSources) get(certFile, keyFile string) (*kittls.FileSource, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	key := [2]string{certFile, keyFile}
+	if src, ok := s.sources[key]; ok {
+		return src, nil
+	}
+	if s.sources == nil {
+		s.ctx, s.cancel = context.WithCancel(context.Background())
+		s.sources = map[[2]string]*kittls.FileSource{}
+	}
+	// The source serves every connection dialled with it, until s is closed.
+	src, err := kittls.NewFileSource(s.ctx, certFile, keyFile)
+	if err != nil {
+		return nil, err
+	}
+	s.sources[key] = src
+	return src, nil
+}
+
+// close stops the sources reloading their files, leaving the connections that
+// use them with the certificates they last loaded. Sources got after are
+// started afresh.
+func (s *certSources) close() {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if s.cancel != nil {
+		s.cancel()
+	}
+	s.ctx, s.cancel, s.sources = nil, nil, nil
+}
+
+// credentials returns the transport credentials c describes, with its client
+// certificate from certs.
+func (c TLSConfig) credentials(certs *certSources) (credentials.TransportCredentials, error) {
+	cfg, err := c.tlsConfig(certs)
+	if err != nil {
+		return nil, err
+	}
+	return credentials.NewTLS(cfg), nil
+}
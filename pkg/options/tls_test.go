@@ -0,0 +1,352 @@
+/*
+Copyright 2026 Chainguard, Inc.
+SPDX-License-Identifier: Apache-2.0
+*/
+
+package options
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/pem"
+	"math/big"
+	"net"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/google/go-cmp/cmp"
+	"github.com/prometheus/client_golang/prometheus"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/credentials"
+	"google.golang.org/grpc/health"
+	healthpb "google.golang.org/grpc/health/grpc_health_v1"
+)
+
+func TestTLSConfig_WithEnv(t *testing.T) {
+	env := envStruct{
+		GrpcClientTLSRootCAs:    []string{"/env/ca.pem"},
+		GrpcClientTLSCertFile:   "/env/tls.crt",
+		GrpcClientTLSKeyFile:    "/env/tls.key",
+		GrpcClientTLSServerName: "env.example.com",
+		GrpcClientTLSMinVersion: "1.3",
+	}
+
+	tests := []struct {
+		name    string
+		cfg     TLSConfig
+		env     envStruct
+		want    TLSConfig
+		wantErr bool
+	}{{
+		name: "defaults",
+		env:  envStruct{GrpcClientTLSMinVersion: "1.2"},
+		want: TLSConfig{MinVersion: tls.VersionTLS12},
+	}, {
+		name: "from the environment",
+		env:  env,
+		want: TLSConfig{
+			RootCAFiles: []string{"/env/ca.pem"},
+			CertFile:    "/env/tls.crt",
+			KeyFile:     "/env/tls.key",
+			ServerName:  "env.example.com",
+			MinVersion:  tls.VersionTLS13,
+		},
+	}, {
+		name: "fields override the environment",
+		cfg: TLSConfig{
+			RootCAFiles: []string{"/flag/ca.pem"},
+			CertFile:    "/flag/tls.crt",
+			KeyFile:     "/flag/tls.key",
+			ServerName:  "flag.example.com",
+			MinVersion:  tls.VersionTLS12,
+		},
+		env: env,
+		want: TLSConfig{
+			RootCAFiles: []string{"/flag/ca.pem"},
+			CertFile:    "/flag/tls.crt",
+			KeyFile:     "/flag/tls.key",
+			ServerName:  "flag.example.com",
+			MinVersion:  tls.VersionTLS12,
+		},
+	}, {
+		name:    "invalid minimum version",
+		env:     envStruct{GrpcClientTLSMinVersion: "1.1"},
+		wantErr: true,
+	}}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := tt.cfg.withEnv(tt.env)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("withEnv() = %v, wantErr %v", err, tt.wantErr)
+			}
+			if tt.wantErr {
+				return
+			}
+			if diff := cmp.Diff(tt.want, got); diff != "" {
+				t.Errorf("withEnv() -want,+got: %s", diff)
+			}
+		})
+	}
+}
+
+func TestTLSConfig_Errors(t *testing.T) {
+	dir := t.TempDir()
+	certFile, keyFile := writeTestCertificate(t, dir)
+
+	tests := []struct {
+		name string
+		cfg  TLSConfig
+	}{{
+		name: "missing root CA file",
+		cfg:  TLSConfig{RootCAFiles: []string{filepath.Join(dir, "missing.pem")}},
+	}, {
+		name: "root CA file without certificates",
+		cfg:  TLSConfig{RootCAFiles: []string{keyFile}},
+	}, {
+		name: "certificate without key",
+		cfg:  TLSConfig{CertFile: certFile},
+	}, {
+		name: "mismatched certificate and key",
+		cfg:  TLSConfig{CertFile: keyFile, KeyFile: certFile},
+	}, {
+		name: "minimum version below TLS 1.2",
+		cfg:  TLSConfig{MinVersion: tls.VersionTLS11},
+	}}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if _, err := tt.cfg.tlsConfig(&certSources{}); err == nil {
+				t.Error("tlsConfig() = nil, want error")
+			}
+		})
+	}
+}
+
+func TestDialReady_HTTPS(t *testing.T) {
+	certFile, keyFile := writeTestCertificate(t, t.TempDir())
+	addr := serveMTLS(t, certFile, keyFile)
+	u := url.URL{Scheme: "https", Host: addr}
+
+	t.Run("private CA and client certificate", func(t *testing.T) {
+		c := &Config{Registerer: prometheus.NewRegistry(), HTTPS: TLSConfig{
+			RootCAFiles: []string{certFile},
+			CertFile:    certFile,
+			KeyFile:     keyFile,
+			// The certificate is for localhost, not the address dialled.
+			ServerName: "localhost",
+		}}
+
+		conn, err := c.DialReady(t.Context(), u, 5*time.Second)
+		if err != nil {
+			t.Fatalf("DialReady: %v", err)
+		}
+		defer conn.Close()
+		if _, err := healthpb.NewHealthClient(conn).Check(t.Context(), &healthpb.HealthCheckRequest{}); err != nil {
+			t.Errorf("Check() = %v", err)
+		}
+	})
+
+	t.Run("system roots", func(t *testing.T) {
+		c := &Config{Registerer: prometheus.NewRegistry(), HTTPS: TLSConfig{ServerName: "localhost"}}
+
+		conn, err := c.DialReady(t.Context(), u, 500*time.Millisecond)
+		if err == nil {
+			conn.Close()
+			t.Fatal("DialReady() succeeded without trusting the private CA")
+		}
+	})
+
+	t.Run("invalid configuration", func(t *testing.T) {
+		c := &Config{Registerer: prometheus.NewRegistry(), HTTPS: TLSConfig{CertFile: certFile}}
+
+		if _, err := c.DialReady(t.Context(), u, time.Second); err == nil {
+			t.Error("DialReady() = nil, want error")
+		}
+		if _, _, err := c.GRPCOptions(u); err == nil {
+			t.Error("GRPCOptions() = nil, want error")
+		}
+	})
+}
+
+func TestDialReady_TLSLoadBalanced(t *testing.T) {
+	certFile, keyFile := writeTestCertificate(t, t.TempDir())
+	addrs := []string{serveMTLS(t, certFile, keyFile), serveMTLS(t, certFile, keyFile)}
+	_, port, err := net.SplitHostPort(addrs[0])
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name     string
+		delegate url.URL
+	}{{
+		// Each address is verified against its own host, 127.0.0.1.
+		name:     "static",
+		delegate: url.URL{Scheme: "static", Path: "/" + strings.Join(addrs, ","), RawQuery: "tls=true"},
+	}, {
+		// The addresses are verified against the host resolved, localhost.
+		name:     "dns",
+		delegate: url.URL{Scheme: "dns", Path: "/" + net.JoinHostPort("localhost", port), RawQuery: "tls=1"},
+	}}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			c := &Config{Registerer: prometheus.NewRegistry(), HTTPS: TLSConfig{
+				RootCAFiles: []string{certFile},
+				CertFile:    certFile,
+				KeyFile:     keyFile,
+			}}
+			defer c.Close()
+
+			conn, err := c.DialReady(t.Context(), tt.delegate, 5*time.Second)
+			if err != nil {
+				t.Fatalf("DialReady: %v", err)
+			}
+			defer conn.Close()
+			if _, err := healthpb.NewHealthClient(conn).Check(t.Context(), &healthpb.HealthCheckRequest{}); err != nil {
+				t.Errorf("Check() = %v", err)
+			}
+		})
+	}
+}
+
+func TestGRPCOptions_TLSErrors(t *testing.T) {
+	for _, delegate := range []string{
+		"http://example.com?tls=true",
+		"https://example.com?tls=false",
+		"dns:///svc.ns:8080?tls=maybe",
+	} {
+		t.Run(delegate, func(t *testing.T) {
+			u, err := url.Parse(delegate)
+			if err != nil {
+				t.Fatal(err)
+			}
+			c := &Config{Registerer: prometheus.NewRegistry()}
+			if _, _, err := c.GRPCOptions(*u); err == nil {
+				t.Error("GRPCOptions() = nil, want error")
+			}
+		})
+	}
+}
+
+func TestConfig_Close(t *testing.T) {
+	certFile, keyFile := writeTestCertificate(t, t.TempDir())
+	u := url.URL{Scheme: "https", Host: serveMTLS(t, certFile, keyFile)}
+	c := &Config{Registerer: prometheus.NewRegistry(), HTTPS: TLSConfig{
+		RootCAFiles: []string{certFile},
+		CertFile:    certFile,
+		KeyFile:     keyFile,
+		ServerName:  "localhost",
+	}}
+
+	// Connections dialled with one Config share the certificate's source.
+	for range 2 {
+		conn, err := c.DialReady(t.Context(), u, 5*time.Second)
+		if err != nil {
+			t.Fatalf("DialReady: %v", err)
+		}
+		defer conn.Close()
+	}
+	if got := len(c.certs.sources); got != 1 {
+		t.Errorf("certificate sources = %d, want 1", got)
+	}
+	ctx := c.certs.ctx
+
+	c.Close()
+	if ctx.Err() == nil {
+		t.Error("Close() left the certificate reloading")
+	}
+
+	// Dialling again reloads it again.
+	conn, err := c.DialReady(t.Context(), u, 5*time.Second)
+	if err != nil {
+		t.Fatalf("DialReady: %v", err)
+	}
+	defer conn.Close()
+	if _, err := healthpb.NewHealthClient(conn).Check(t.Context(), &healthpb.HealthCheckRequest{}); err != nil {
+		t.Errorf("Check() = %v", err)
+	}
+	c.Close()
+}
+
+// serveMTLS serves the health service on a local port with the certificate in
+// certFile, requiring clients to present a certificate it issued, and returns
+// its address.
+func serveMTLS(t *testing.T, certFile, keyFile string, opts ...grpc.ServerOption) string {
+	t.Helper()
+
+	cert, err := tls.LoadX509KeyPair(certFile, keyFile)
+	if err != nil {
+		t.Fatalf("LoadX509KeyPair() = %v", err)
+	}
+	pemBytes, err := os.ReadFile(certFile)
+	if err != nil {
+		t.Fatal(err)
+	}
+	clientCAs := x509.NewCertPool()
+	clientCAs.AppendCertsFromPEM(pemBytes)
+
+	lis, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("failed to listen: %v", err)
+	}
+	srv := grpc.NewServer(append(opts, grpc.Creds(credentials.NewTLS(&tls.Config{
+		MinVersion:   tls.VersionTLS12,
+		Certificates: []tls.Certificate{cert},
+		ClientAuth:   tls.RequireAndVerifyClientCert,
+		ClientCAs:    clientCAs,
+	})))...)
+	healthpb.RegisterHealthServer(srv, health.NewServer())
+	go func() { _ = srv.Serve(lis) }()
+	t.Cleanup(srv.Stop)
+
+	return lis.Addr().String()
+}
+
+// writeTestCertificate writes a self-signed certificate for localhost and
+// 127.0.0.1, usable by servers and clients, and its key to dir, and returns
+// their paths.
+func writeTestCertificate(t *testing.T, dir string) (string, string) {
+	t.Helper()
+
+	priv, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatalf("error generating private key: %v", err)
+	}
+	tmpl := &x509.Certificate{
+		SerialNumber:          big.NewInt(1),
+		NotBefore:             time.Now().Add(-time.Hour),
+		NotAfter:              time.Now().Add(time.Hour),
+		DNSNames:              []string{"localhost"},
+		IPAddresses:           []net.IP{net.IPv4(127, 0, 0, 1)},
+		ExtKeyUsage:           []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth, x509.ExtKeyUsageClientAuth},
+		IsCA:                  true,
+		BasicConstraintsValid: true,
+		KeyUsage:              x509.KeyUsageCertSign | x509.KeyUsageDigitalSignature,
+	}
+	raw, err := x509.CreateCertificate(rand.Reader, tmpl, tmpl, &priv.PublicKey, priv)
+	if err != nil {
+		t.Fatalf("error generating certificate: %v", err)
+	}
+	keyBytes, err := x509.MarshalPKCS8PrivateKey(priv)
+	if err != nil {
+		t.Fatalf("error marshaling key bytes: %v", err)
+	}
+
+	certFile, keyFile := filepath.Join(dir, "tls.crt"), filepath.Join(dir, "tls.key")
+	if err := os.WriteFile(certFile, pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: raw}), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(keyFile, pem.EncodeToMemory(&pem.Block{Type: "PRIVATE KEY", Bytes: keyBytes}), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	return certFile, keyFile
+}
@@ -0,0 +1,15 @@
+/*
+Copyright 2026 Chainguard, Inc.
+SPDX-License-Identifier: Apache-2.0
+*/
+
+package tls
+
+// Test helpers shared with the external tls_test package.
+var (
+	CertTemplate   = certTemplate
+	WriteKeyPair   = writeKeyPair
+	WriteKeyPairTo = writeKeyPairTo
+	PoolOf         = poolOf
+	Eventually     = eventually
+)
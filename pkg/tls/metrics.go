@@ -0,0 +1,23 @@
+/*
+Copyright 2026 Chainguard, Inc.
+SPDX-License-Identifier: Apache-2.0
+*/
+
+package tls
+
+import "github.com/prometheus/client_golang/prometheus"
+
+var (
+	certificateExpiry = prometheus.NewGaugeVec(prometheus.GaugeOpts{
+		Name: "tls_certificate_expiry_timestamp_seconds",
+		Help: "Expiry (NotAfter) of the certificate currently served from a file, as a Unix timestamp, by certificate file.",
+	}, []string{"file"})
+	certificateReloads = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Name: "tls_certificate_reloads_total",
+		Help: "Number of attempts to reload a changed certificate from its files, by certificate file and result (success or failure).",
+	}, []string{"file", "result"})
+)
+
+func init() {
+	prometheus.MustRegister(certificateExpiry, certificateReloads)
+}
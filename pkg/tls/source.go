@@ -0,0 +1,270 @@
+/*
+Copyright 2026 Chainguard, Inc.
+SPDX-License-Identifier: Apache-2.0
+*/
+
+// Package tls provides certificates for TLS servers and clients that are read
+// from files and reloaded when the files change, so certificates rotated onto
+// disk (e.g. by cert-manager) are picked up without restarting the process.
+package tls
+
+import (
+	"bytes"
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"fmt"
+	"os"
+	"sync"
+	"time"
+
+	"github.com/chainguard-dev/clog"
+)
+
+// defaultPollInterval is how often a FileSource checks its files for changes.
+const defaultPollInterval = time.Minute
+
+// FileSource provides a certificate and key, and optionally a bundle of client
+// CAs, read from PEM files. It checks the files for changes periodically and
+// reloads them, keeping the previous certificate if the new files are invalid:
+// unreadable, a certificate that does not match its key, an expired
+// certificate, or a CA bundle with no certificates. A half-written rotation
+// therefore fails validation and is retried at the next check.
+//
+// The expiry of the certificate in use is exported as the
+// tls_certificate_expiry_timestamp_seconds gauge, and reload attempts are
+// counted in tls_certificate_reloads_total.
+type FileSource struct {
+	certFile, keyFile, caFile string
+	interval                  time.Duration
+
+	mu      sync.RWMutex
+	current *keyPair
+}
+
+// keyPair is the validated contents of a FileSource's files.
+type keyPair struct {
+	certPEM, keyPEM, caPEM []byte
+
+	cert      *tls.Certificate
+	clientCAs *x509.CertPool
+}
+
+// Option configures a FileSource.
+type Option func(*FileSource)
+
+// WithPollInterval sets how often the files are checked for changes. The
+// default is one minute.
+func WithPollInterval(interval time.Duration) Option {
+	return func(s *FileSource) {
+		s.interval = interval
+	}
+}
+
+// WithClientCAFile reads the CAs that client certificates must chain to from
+// the PEM bundle at path, reloading it along with the certificate. It is used
+// by ServerConfig.
+func WithClientCAFile(path string) Option {
+	return func(s *FileSource) {
+		s.caFile = path
+	}
+}
+
+// NewFileSource reads the certificate in certFile and its key in keyFile, and
+// then checks them for changes until ctx is done. It returns an error if the
+// files are not valid when it is called.
+func NewFileSource(ctx context.Context, certFile, keyFile string, opts ...Option) (*FileSource, error) {
+	s := &FileSource{
+		certFile: certFile,
+		keyFile:  keyFile,
+		interval: defaultPollInterval,
+	}
+	for _, o := range opts {
+		o(s)
+	}
+	if s.interval <= 0 {
+		return nil, fmt.Errorf("poll interval must be positive, got %s", s.interval)
+	}
+
+	kp, _, err := s.load(nil)
+	if err != nil {
+		return nil, err
+	}
+	s.set(kp)
+
+	go s.watch(ctx)
+	return s, nil
+}
+
+// Certificate returns the certificate currently in use.
+func (s *FileSource) Certificate() *tls.Certificate {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	return s.current.cert
+}
+
+// GetCertificate returns the certificate currently in use, for use as
+// tls.Config.GetCertificate by a server.
+func (s *FileSource) GetCertificate(*tls.ClientHelloInfo) (*tls.Certificate, error) {
+	return s.Certificate(), nil
+}
+
+// GetClientCertificate returns the certificate currently in use, for use as
+// tls.Config.GetClientCertificate by a client.
+func (s *FileSource) GetClientCertificate(*tls.CertificateRequestInfo) (*tls.Certificate, error) {
+	return s.Certificate(), nil
+}
+
+// ServerConfig returns a copy of base, or of a default configuration when base
+// is nil, that serves the current certificate. With WithClientCAFile, it
+// verifies client certificates against the current CAs, through
+// GetConfigForClient, and requires them unless base sets ClientAuth. Pass the
+// result to duplex.WithTLS to serve a Duplex with it.
+func (s *FileSource) ServerConfig(base *tls.Config) *tls.Config {
+	cfg := cloneOrDefault(base)
+	cfg.Certificates = nil
+	cfg.GetCertificate = s.GetCertificate
+	if s.caFile == "" {
+		return cfg
+	}
+
+	if cfg.ClientAuth == tls.NoClientCert {
+		cfg.ClientAuth = tls.RequireAndVerifyClientCert
+	}
+	cfg.GetConfigForClient = func(*tls.ClientHelloInfo) (*tls.Config, error) {
+		s.mu.RLock()
+		clientCAs := s.current.clientCAs
+		s.mu.RUnlock()
+
+		cc := cfg.Clone()
+		cc.GetConfigForClient = nil
+		cc.ClientCAs = clientCAs
+		return cc, nil
+	}
+	return cfg
+}
+
+// ClientConfig returns a copy of base, or of a default configuration when base
+// is nil, that presents the current certificate to servers that ask for one.
+// For a gRPC client, pass it to credentials.NewTLS; dialled after the options
+// from options.GRPCOptions, it replaces the default https credentials.
+func (s *FileSource) ClientConfig(base *tls.Config) *tls.Config {
+	cfg := cloneOrDefault(base)
+	cfg.Certificates = nil
+	cfg.GetClientCertificate = s.GetClientCertificate
+	return cfg
+}
+
+func cloneOrDefault(base *tls.Config) *tls.Config {
+	if base == nil {
+		return &tls.Config{MinVersion: tls.VersionTLS12}
+	}
+	return base.Clone()
+}
+
+func (s *FileSource) set(kp *keyPair) {
+	s.mu.Lock()
+	s.current = kp
+	s.mu.Unlock()
+
+	certificateExpiry.WithLabelValues(s.certFile).Set(float64(kp.cert.Leaf.NotAfter.Unix()))
+}
+
+// watch reloads the files every poll interval until ctx is done.
+func (s *FileSource) watch(ctx context.Context) {
+	ticker := time.NewTicker(s.interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			s.reload(ctx)
+		}
+	}
+}
+
+// reload replaces the current certificate if the files have changed and are
+// valid.
+func (s *FileSource) reload(ctx context.Context) {
+	s.mu.RLock()
+	prev := s.current
+	s.mu.RUnlock()
+
+	kp, changed, err := s.load(prev)
+	if err != nil {
+		certificateReloads.WithLabelValues(s.certFile, "failure").Inc()
+		clog.FromContext(ctx).Warn("failed to reload certificate, keeping the previous one",
+			"file", s.certFile,
+			"error", err,
+		)
+		return
+	}
+	if !changed {
+		return
+	}
+
+	s.set(kp)
+	certificateReloads.WithLabelValues(s.certFile, "success").Inc()
+	clog.FromContext(ctx).Info("reloaded certificate",
+		"file", s.certFile,
+		"expiry", kp.cert.Leaf.NotAfter,
+	)
+}
+
+// load reads and validates the files. It reports whether they differ from
+// prev, returning prev unchanged if not.
+func (s *FileSource) load(prev *keyPair) (*keyPair, bool, error) {
+	certPEM, err := readFile(s.certFile)
+	if err != nil {
+		return nil, false, err
+	}
+	keyPEM, err := readFile(s.keyFile)
+	if err != nil {
+		return nil, false, err
+	}
+	var caPEM []byte
+	if s.caFile != "" {
+		if caPEM, err = readFile(s.caFile); err != nil {
+			return nil, false, err
+		}
+	}
+
+	if prev != nil && bytes.Equal(certPEM, prev.certPEM) && bytes.Equal(keyPEM, prev.keyPEM) && bytes.Equal(caPEM, prev.caPEM) {
+		return prev, false, nil
+	}
+
+	cert, err := tls.X509KeyPair(certPEM, keyPEM)
+	if err != nil {
+		return nil, false, fmt.Errorf("loading key pair from %s and %s: %w", s.certFile, s.keyFile, err)
+	}
+	if cert.Leaf == nil {
+		if cert.Leaf, err = x509.ParseCertificate(cert.Certificate[0]); err != nil {
+			return nil, false, fmt.Errorf("parsing certificate in %s: %w", s.certFile, err)
+		}
+	}
+	if expiry := cert.Leaf.NotAfter; time.Now().After(expiry) {
+		return nil, false, fmt.Errorf("certificate in %s expired at %s", s.certFile, expiry)
+	}
+
+	kp := &keyPair{
+		certPEM: certPEM,
+		keyPEM:  keyPEM,
+		caPEM:   caPEM,
+		cert:    &cert,
+	}
+	if s.caFile != "" {
+		kp.clientCAs = x509.NewCertPool()
+		if !kp.clientCAs.AppendCertsFromPEM(caPEM) {
+			return nil, false, fmt.Errorf("no certificates in %s", s.caFile)
+		}
+	}
+	return kp, true, nil
+}
+
+// readFile reads a file the caller of NewFileSource named.
+func readFile(path string) ([]byte, error) {
+	return os.ReadFile(path) //nolint:gosec // G304: the path is the caller's own configuration
+}
@@ -0,0 +1,40 @@
+/*
+Copyright 2026 Chainguard, Inc.
+SPDX-License-Identifier: Apache-2.0
+*/
+
+package tls_test
+
+import (
+	"crypto/tls"
+	"testing"
+	"time"
+
+	kittls "chainguard.dev/go-grpc-kit/pkg/tls"
+)
+
+// TestFileSource_Duplex serves a Duplex with a FileSource. It is an external
+// test, as the duplex package depends on this one through pkg/options.
+func TestFileSource_Duplex(t *testing.T) {
+	certFile, keyFile := kittls.WriteKeyPair(t, t.TempDir(), kittls.CertTemplate(1, time.Hour))
+
+	// The certificate is self-signed, so it is also the client CA, and clients
+	// present it too.
+	src, err := kittls.NewFileSource(t.Context(), certFile, keyFile,
+		kittls.WithPollInterval(10*time.Millisecond),
+		kittls.WithClientCAFile(certFile),
+	)
+	if err != nil {
+		t.Fatalf("NewFileSource() = %v", err)
+	}
+	addr := serveWithTLS(t, src.ServerConfig(nil))
+
+	callDuplex(t, addr, src.ClientConfig(&tls.Config{RootCAs: kittls.PoolOf(t, certFile)}))
+
+	kittls.WriteKeyPairTo(t, certFile, keyFile, kittls.CertTemplate(2, time.Hour))
+	kittls.Eventually(t, func() bool { return src.Certificate().Leaf.SerialNumber.Int64() == 2 })
+
+	// New connections are served, and have their client certificates
+	// verified, with the new certificate. The gateway's loopback follows it.
+	callDuplex(t, addr, src.ClientConfig(&tls.Config{RootCAs: kittls.PoolOf(t, certFile)}))
+}
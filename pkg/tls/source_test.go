@@ -0,0 +1,238 @@
+/*
+Copyright 2026 Chainguard, Inc.
+SPDX-License-Identifier: Apache-2.0
+*/
+
+package tls
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/pem"
+	"math/big"
+	"net"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus/testutil"
+)
+
+func TestFileSource_Reload(t *testing.T) {
+	certFile, keyFile := writeKeyPair(t, t.TempDir(), certTemplate(1, time.Hour))
+
+	src, err := NewFileSource(t.Context(), certFile, keyFile, WithPollInterval(10*time.Millisecond))
+	if err != nil {
+		t.Fatalf("NewFileSource() = %v", err)
+	}
+	if got := serialOf(src.Certificate()); got != 1 {
+		t.Fatalf("serial = %d, want 1", got)
+	}
+
+	successes := testutil.ToFloat64(certificateReloads.WithLabelValues(certFile, "success"))
+	next := certTemplate(2, 2*time.Hour)
+	writeKeyPairTo(t, certFile, keyFile, next)
+
+	eventually(t, func() bool { return serialOf(src.Certificate()) == 2 })
+	if got := testutil.ToFloat64(certificateReloads.WithLabelValues(certFile, "success")); got != successes+1 {
+		t.Errorf("successful reloads = %v, want %v", got, successes+1)
+	}
+	if got, want := testutil.ToFloat64(certificateExpiry.WithLabelValues(certFile)), float64(next.NotAfter.Unix()); got != want {
+		t.Errorf("expiry = %v, want %v", got, want)
+	}
+}
+
+func TestFileSource_InvalidReloadKeepsPrevious(t *testing.T) {
+	tests := []struct {
+		name  string
+		write func(t *testing.T, certFile, keyFile string)
+	}{{
+		name: "mismatched key",
+		write: func(t *testing.T, certFile, _ string) {
+			// Only the certificate has been rotated so far.
+			other, _ := writeKeyPair(t, t.TempDir(), certTemplate(2, time.Hour))
+			copyFile(t, other, certFile)
+		},
+	}, {
+		name: "expired",
+		write: func(t *testing.T, certFile, keyFile string) {
+			writeKeyPairTo(t, certFile, keyFile, certTemplate(2, -time.Hour))
+		},
+	}, {
+		name: "garbage",
+		write: func(t *testing.T, certFile, _ string) {
+			writeFile(t, certFile, []byte("not a certificate"))
+		},
+	}, {
+		name: "missing",
+		write: func(t *testing.T, certFile, _ string) {
+			if err := os.Remove(certFile); err != nil {
+				t.Fatal(err)
+			}
+		},
+	}}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			certFile, keyFile := writeKeyPair(t, t.TempDir(), certTemplate(1, time.Hour))
+			src, err := NewFileSource(t.Context(), certFile, keyFile, WithPollInterval(10*time.Millisecond))
+			if err != nil {
+				t.Fatalf("NewFileSource() = %v", err)
+			}
+
+			failures := certificateReloads.WithLabelValues(certFile, "failure")
+			tt.write(t, certFile, keyFile)
+
+			// A failed reload is retried, and counted, at every check.
+			eventually(t, func() bool { return testutil.ToFloat64(failures) >= 3 })
+			if got := serialOf(src.Certificate()); got != 1 {
+				t.Errorf("serial = %d, want the previous certificate's 1", got)
+			}
+		})
+	}
+}
+
+func TestNewFileSource_Errors(t *testing.T) {
+	dir := t.TempDir()
+	certFile, keyFile := writeKeyPair(t, dir, certTemplate(1, time.Hour))
+	expiredCert, expiredKey := writeKeyPair(t, t.TempDir(), certTemplate(1, -time.Hour))
+
+	tests := []struct {
+		name              string
+		certFile, keyFile string
+		opts              []Option
+	}{{
+		name:     "missing certificate",
+		certFile: filepath.Join(dir, "missing.crt"),
+		keyFile:  keyFile,
+	}, {
+		name:     "expired certificate",
+		certFile: expiredCert,
+		keyFile:  expiredKey,
+	}, {
+		name:     "mismatched key",
+		certFile: certFile,
+		keyFile:  expiredKey,
+	}, {
+		name:     "empty client CA bundle",
+		certFile: certFile,
+		keyFile:  keyFile,
+		opts:     []Option{WithClientCAFile(keyFile)},
+	}, {
+		name:     "zero poll interval",
+		certFile: certFile,
+		keyFile:  keyFile,
+		opts:     []Option{WithPollInterval(0)},
+	}}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if _, err := NewFileSource(t.Context(), tt.certFile, tt.keyFile, tt.opts...); err == nil {
+				t.Error("NewFileSource() = nil, want error")
+			}
+		})
+	}
+}
+
+func certTemplate(serial int64, validFor time.Duration) *x509.Certificate {
+	return &x509.Certificate{
+		SerialNumber: big.NewInt(serial),
+		NotBefore:    time.Now().Add(-time.Hour).Add(min(validFor, 0)),
+		NotAfter:     time.Now().Add(validFor).Truncate(time.Second),
+		DNSNames:     []string{"localhost"},
+		IPAddresses:  []net.IP{net.IPv4(127, 0, 0, 1)},
+		ExtKeyUsage:  []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth, x509.ExtKeyUsageClientAuth},
+	}
+}
+
+// writeKeyPair writes a self-signed certificate for tmpl and its key to dir,
+// and returns their paths.
+func writeKeyPair(t *testing.T, dir string, tmpl *x509.Certificate) (string, string) {
+	t.Helper()
+
+	certFile, keyFile := filepath.Join(dir, "tls.crt"), filepath.Join(dir, "tls.key")
+	writeKeyPairTo(t, certFile, keyFile, tmpl)
+	return certFile, keyFile
+}
+
+// writeKeyPairTo replaces certFile and keyFile with a self-signed certificate
+// for tmpl and its key.
+func writeKeyPairTo(t *testing.T, certFile, keyFile string, tmpl *x509.Certificate) {
+	t.Helper()
+
+	priv, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatalf("error generating private key: %v", err)
+	}
+	raw, err := x509.CreateCertificate(rand.Reader, tmpl, tmpl, &priv.PublicKey, priv)
+	if err != nil {
+		t.Fatalf("error generating certificate: %v", err)
+	}
+	keyBytes, err := x509.MarshalPKCS8PrivateKey(priv)
+	if err != nil {
+		t.Fatalf("error marshaling key bytes: %v", err)
+	}
+
+	// Write the key first, so the pair only validates once both are written.
+	writeFile(t, keyFile, pem.EncodeToMemory(&pem.Block{Type: "PRIVATE KEY", Bytes: keyBytes}))
+	writeFile(t, certFile, pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: raw}))
+}
+
+// writeFile replaces path with data atomically, as a rotation would.
+func writeFile(t *testing.T, path string, data []byte) {
+	t.Helper()
+
+	tmp := path + ".tmp"
+	if err := os.WriteFile(tmp, data, 0o600); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Rename(tmp, path); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func copyFile(t *testing.T, from, to string) {
+	t.Helper()
+
+	data, err := os.ReadFile(from)
+	if err != nil {
+		t.Fatal(err)
+	}
+	writeFile(t, to, data)
+}
+
+func poolOf(t *testing.T, certFile string) *x509.CertPool {
+	t.Helper()
+
+	data, err := os.ReadFile(certFile)
+	if err != nil {
+		t.Fatal(err)
+	}
+	pool := x509.NewCertPool()
+	if !pool.AppendCertsFromPEM(data) {
+		t.Fatalf("no certificates in %s", certFile)
+	}
+	return pool
+}
+
+func serialOf(cert *tls.Certificate) int64 {
+	return cert.Leaf.SerialNumber.Int64()
+}
+
+// eventually waits for cond to hold, failing the test if it does not within a
+// few seconds.
+func eventually(t *testing.T, cond func() bool) {
+	t.Helper()
+
+	deadline := time.Now().Add(5 * time.Second)
+	for !cond() {
+		if time.Now().After(deadline) {
+			t.Fatal("condition not met before the deadline")
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+}
@@ -3,7 +3,7 @@ Copyright 2022 Chainguard, Inc.
 SPDX-License-Identifier: Apache-2.0
 */
 
-package tls
+package tls_test
 
 import (
 	"bytes"
@@ -25,12 +25,12 @@ import (
 	"time"
 
 	"chainguard.dev/go-grpc-kit/pkg/duplex"
+	"chainguard.dev/go-grpc-kit/pkg/options"
 	pb "chainguard.dev/go-grpc-kit/pkg/tls/internal/proto/helloworld"
 	"golang.org/x/net/http2"
 	"golang.org/x/oauth2"
 	"google.golang.org/grpc"
 	"google.golang.org/grpc/credentials"
-	"google.golang.org/grpc/credentials/oauth"
 	"google.golang.org/grpc/metadata"
 )
 
@@ -80,7 +80,7 @@ func TestTLS(t *testing.T) {
 	conn, err := grpc.NewClient(lis.Addr().String(),
 		grpc.WithTransportCredentials(credentials.NewTLS(tlsConfig)),
 		// Send password to verify gRPC doesn't reject it.
-		grpc.WithPerRPCCredentials(oauth.TokenSource{TokenSource: oauth2.StaticTokenSource(&oauth2.Token{AccessToken: "hunter2"})}),
+		options.TokenSourceCredentials(oauth2.StaticTokenSource(&oauth2.Token{AccessToken: "hunter2"})),
 	)
 	if err != nil {
 		log.Fatalf("failed to dial: %v", err)
@@ -169,3 +169,116 @@ func (s *server) SayHello(ctx context.Context, in *pb.HelloRequest) (*pb.HelloRe
 	log.Printf("Received: %v (%v)", in.GetName(), md)
 	return &pb.HelloReply{Message: "Hello " + in.GetName()}, nil
 }
+
+func TestWithTLS(t *testing.T) {
+	tlsConfig, err := generateTLS(&x509.Certificate{
+		SerialNumber: big.NewInt(1),
+		NotAfter:     time.Now().Add(10 * time.Hour),
+		DNSNames:     []string{"localhost"},
+		IPAddresses:  []net.IP{net.IPv4(127, 0, 0, 1)},
+	})
+	if err != nil {
+		t.Fatalf("error generating certificate: %v", err)
+	}
+
+	// The loopback needs no dial options: the Duplex configures it to match.
+	addr := serveWithTLS(t, tlsConfig)
+	callDuplex(t, addr, tlsConfig)
+}
+
+func TestWithTLS_MTLS(t *testing.T) {
+	tlsConfig, err := generateTLS(&x509.Certificate{
+		SerialNumber: big.NewInt(1),
+		NotAfter:     time.Now().Add(10 * time.Hour),
+		DNSNames:     []string{"localhost"},
+		IPAddresses:  []net.IP{net.IPv4(127, 0, 0, 1)},
+		ExtKeyUsage:  []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth, x509.ExtKeyUsageClientAuth},
+	})
+	if err != nil {
+		t.Fatalf("error generating certificate: %v", err)
+	}
+	serverConfig := tlsConfig.Clone()
+	serverConfig.ClientAuth = tls.RequireAndVerifyClientCert
+	serverConfig.ClientCAs = tlsConfig.RootCAs
+
+	addr := serveWithTLS(t, serverConfig)
+
+	// A client with a certificate is served, through gRPC and the gateway.
+	callDuplex(t, addr, tlsConfig)
+
+	// A client without one is rejected.
+	anonymous := tlsConfig.Clone()
+	anonymous.Certificates = nil
+	conn, err := grpc.NewClient(addr, grpc.WithTransportCredentials(credentials.NewTLS(anonymous)))
+	if err != nil {
+		t.Fatalf("failed to dial: %v", err)
+	}
+	defer conn.Close()
+	if _, err := pb.NewGreeterClient(conn).SayHello(t.Context(), &pb.HelloRequest{Name: "world"}); err == nil {
+		t.Error("SayHello() without a client certificate succeeded, want error")
+	}
+}
+
+// serveWithTLS serves a Duplex configured WithTLS(cfg) on a local port, and
+// returns its address.
+func serveWithTLS(t *testing.T, cfg *tls.Config) string {
+	t.Helper()
+
+	lis, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("failed to listen: %v", err)
+	}
+	d, err := duplex.NewWithOptions(0, duplex.WithListener(lis), duplex.WithTLS(cfg))
+	if err != nil {
+		t.Fatalf("NewWithOptions() = %v", err)
+	}
+	pb.RegisterGreeterServer(d.Server, &server{})
+	if err := d.RegisterHandler(t.Context(), pb.RegisterGreeterHandlerFromEndpoint); err != nil {
+		t.Fatalf("error registering handler: %v", err)
+	}
+
+	served := make(chan error, 1)
+	go func() { served <- d.ListenAndServe(context.Background()) }()
+	t.Cleanup(func() {
+		if err := d.Shutdown(context.Background()); err != nil {
+			t.Errorf("Shutdown() = %v", err)
+		}
+		<-served
+	})
+	return lis.Addr().String()
+}
+
+// callDuplex calls the Greeter at addr over gRPC and through the gateway,
+// connecting with clientConfig.
+func callDuplex(t *testing.T, addr string, clientConfig *tls.Config) {
+	t.Helper()
+
+	conn, err := grpc.NewClient(addr, grpc.WithTransportCredentials(credentials.NewTLS(clientConfig)))
+	if err != nil {
+		t.Fatalf("failed to dial: %v", err)
+	}
+	defer conn.Close()
+	req := &pb.HelloRequest{Name: "world"}
+	if _, err := pb.NewGreeterClient(conn).SayHello(t.Context(), req); err != nil {
+		t.Fatalf("grpc request failed: %v", err)
+	}
+
+	httpClient := &http.Client{
+		Transport: &http2.Transport{
+			TLSClientConfig: clientConfig,
+		},
+	}
+	body, err := json.Marshal(req)
+	if err != nil {
+		t.Fatalf("failed to marshal json: %v", err)
+	}
+	httpResp, err := httpClient.Post("https://"+addr+"/v1/example/echo", "application/json", bytes.NewBuffer(body))
+	if err != nil {
+		t.Fatalf("http request failed: %v", err)
+	}
+	defer httpResp.Body.Close()
+	if httpResp.StatusCode != http.StatusOK {
+		b, _ := io.ReadAll(httpResp.Body)
+		t.Fatalf("http response: %s: %s", httpResp.Status, b)
+	}
+}